@@ -7,6 +7,8 @@ import (
 
 	"monji/internal/config"
 	"monji/internal/database"
+	"monji/internal/handlers"
+	"monji/internal/jobs"
 	"monji/internal/routes"
 )
 
@@ -28,6 +30,35 @@ func main() {
 		log.Printf("Warning: Failed to connect to MongoDB: %v", err)
 	}
 
+	// Backup storage settings.
+	handlers.BackupDir = cfg.BackupDir
+	handlers.BackupRetentionCount = cfg.BackupRetentionCount
+	handlers.RecycleRetentionDays = cfg.RecycleRetentionDays
+
+	// Document settings.
+	handlers.ETagSecret = []byte(cfg.ETagSecret)
+
+	// Change streams settings.
+	handlers.MaxWatchStreams = cfg.MaxWatchStreams
+
+	// GridFS settings.
+	handlers.MaxUploadSize = int64(cfg.MaxUploadMB) << 20
+
+	// Start the background job workers.
+	jobs.Start(context.Background(), cfg.JobWorkers)
+
+	// Start the scheduler of backup policies.
+	handlers.StartBackupScheduler(context.Background())
+
+	// Purge the expired recycle bin entries.
+	handlers.StartRecyclePurger(context.Background())
+
+	// Clean up the expired time-bound permission grants.
+	handlers.StartPermissionSweeper(context.Background())
+
+	// Watch the changes of the webhook triggers and send their deliveries.
+	handlers.StartTriggers(context.Background())
+
 	// Set up all routes.
 	router := routes.SetupRoutes(cfg)
 
@@ -0,0 +1,188 @@
+// Package backup writes and reads logical backups in the mongodump archive format,
+// so that archives produced by Monji can be restored with mongorestore
+// (`mongorestore --archive=<file> --gzip`) and the other way around.
+package backup
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc64"
+	"io"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// magicNumber starts every mongodump archive.
+const magicNumber uint32 = 0x8199e26d
+
+// formatVersion is the archive format version written by mongodump.
+const formatVersion = "0.1"
+
+// terminator ends the prelude and each namespace segment of the body.
+var terminator = []byte{0xFF, 0xFF, 0xFF, 0xFF}
+
+var crcTable = crc64.MakeTable(crc64.ECMA)
+
+// archiveHeader follows the magic number.
+type archiveHeader struct {
+	ConcurrentCollections int32  `bson:"concurrent_collections"`
+	FormatVersion         string `bson:"version"`
+	ServerVersion         string `bson:"server_version"`
+	ToolVersion           string `bson:"tool_version"`
+}
+
+// collectionMetadata describes one namespace in the archive prelude.
+// Metadata is the canonical extended JSON of a metadataDoc.
+type collectionMetadata struct {
+	Database   string `bson:"db"`
+	Collection string `bson:"collection"`
+	Metadata   string `bson:"metadata"`
+	Size       int    `bson:"size"`
+	Type       string `bson:"type"`
+}
+
+// metadataDoc is the content of a collectionMetadata.Metadata (the .metadata.json of a directory dump).
+type metadataDoc struct {
+	Options        bson.D   `bson:"options,omitempty"`
+	Indexes        []bson.D `bson:"indexes"`
+	UUID           string   `bson:"uuid,omitempty"`
+	CollectionName string   `bson:"collectionName"`
+	Type           string   `bson:"type,omitempty"`
+}
+
+// namespaceHeader starts a segment of documents of a namespace, or marks its end (EOF).
+type namespaceHeader struct {
+	Database   string `bson:"db"`
+	Collection string `bson:"collection"`
+	EOF        bool   `bson:"EOF"`
+	CRC        int64  `bson:"CRC"`
+}
+
+// archiveWriter writes an archive sequentially, one namespace at a time.
+type archiveWriter struct {
+	w   io.Writer
+	crc hash.Hash64
+	ns  *namespaceHeader
+}
+
+func (aw *archiveWriter) writePrelude(header archiveHeader, metadata []collectionMetadata) error {
+	magic := make([]byte, 4)
+	binary.LittleEndian.PutUint32(magic, magicNumber)
+	if _, err := aw.w.Write(magic); err != nil {
+		return err
+	}
+	if err := aw.writeDoc(header); err != nil {
+		return err
+	}
+	for _, m := range metadata {
+		if err := aw.writeDoc(m); err != nil {
+			return err
+		}
+	}
+	_, err := aw.w.Write(terminator)
+	return err
+}
+
+// beginNamespace starts the body of a namespace. The header is only written
+// with the first document, like mongodump does.
+func (aw *archiveWriter) beginNamespace(db, coll string) {
+	aw.ns = &namespaceHeader{Database: db, Collection: coll}
+	aw.crc = crc64.New(crcTable)
+}
+
+func (aw *archiveWriter) writeDocument(raw []byte, first bool) error {
+	if first {
+		if err := aw.writeDoc(aw.ns); err != nil {
+			return err
+		}
+	}
+	aw.crc.Write(raw)
+	_, err := aw.w.Write(raw)
+	return err
+}
+
+// endNamespace closes the current segment (if documents were written) and writes the EOF header.
+func (aw *archiveWriter) endNamespace(wroteDocuments bool) error {
+	if wroteDocuments {
+		if _, err := aw.w.Write(terminator); err != nil {
+			return err
+		}
+	}
+	eof := namespaceHeader{Database: aw.ns.Database, Collection: aw.ns.Collection, EOF: true, CRC: int64(aw.crc.Sum64())}
+	if err := aw.writeDoc(eof); err != nil {
+		return err
+	}
+	_, err := aw.w.Write(terminator)
+	aw.ns = nil
+	return err
+}
+
+func (aw *archiveWriter) writeDoc(v interface{}) error {
+	b, err := bson.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = aw.w.Write(b)
+	return err
+}
+
+// errTerminator is returned by readBlock when it reads a terminator instead of a document.
+var errTerminator = errors.New("terminator")
+
+// readBlock reads the next BSON document or terminator from r.
+func readBlock(r io.Reader) ([]byte, error) {
+	sizeBytes := make([]byte, 4)
+	if _, err := io.ReadFull(r, sizeBytes); err != nil {
+		return nil, err
+	}
+	size := int32(binary.LittleEndian.Uint32(sizeBytes))
+	if size == -1 {
+		return nil, errTerminator
+	}
+	if size < 5 || size > 48*1024*1024 {
+		return nil, fmt.Errorf("invalid BSON document size %d in archive", size)
+	}
+	doc := make([]byte, size)
+	copy(doc, sizeBytes)
+	if _, err := io.ReadFull(r, doc[4:]); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// readPrelude reads the magic number, header and namespace metadata of an archive.
+func readPrelude(r io.Reader) (*archiveHeader, []collectionMetadata, error) {
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, nil, fmt.Errorf("failed to read archive: %w", err)
+	}
+	if binary.LittleEndian.Uint32(magic) != magicNumber {
+		return nil, nil, errors.New("not a mongodump archive")
+	}
+	raw, err := readBlock(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read archive header: %w", err)
+	}
+	var header archiveHeader
+	if err := bson.Unmarshal(raw, &header); err != nil {
+		return nil, nil, fmt.Errorf("invalid archive header: %w", err)
+	}
+	var metadata []collectionMetadata
+	for {
+		raw, err := readBlock(r)
+		if err == errTerminator {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read archive prelude: %w", err)
+		}
+		var m collectionMetadata
+		if err := bson.Unmarshal(raw, &m); err != nil {
+			return nil, nil, fmt.Errorf("invalid namespace metadata: %w", err)
+		}
+		metadata = append(metadata, m)
+	}
+	return &header, metadata, nil
+}
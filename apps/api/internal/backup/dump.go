@@ -0,0 +1,184 @@
+package backup
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// toolVersion is written in the archive header.
+const toolVersion = "monji"
+
+// DumpOptions configures a dump.
+type DumpOptions struct {
+	// Collections restricts the dump to these collections (and views). Empty means all.
+	Collections []string
+	// ExcludePrefixes leaves out the collections whose name starts with one of these prefixes.
+	ExcludePrefixes []string
+	// Gzip compresses the whole archive, like `mongodump --archive --gzip`.
+	Gzip bool
+	// Progress, if set, is called before each collection is dumped.
+	Progress func(collection string, index, total int)
+}
+
+// CollectionStats reports what was dumped or restored for a namespace.
+type CollectionStats struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Documents int64  `json:"documents"`
+}
+
+// DumpResult summarizes a dump.
+type DumpResult struct {
+	Database      string            `json:"database"`
+	ServerVersion string            `json:"serverVersion"`
+	Collections   []CollectionStats `json:"collections"`
+	// Skipped lists namespaces that cannot be dumped (time-series collections).
+	Skipped []string `json:"skipped,omitempty"`
+}
+
+// Dump writes the collections and views of db to w in the mongodump archive format.
+func Dump(ctx context.Context, db *mongo.Database, w io.Writer, opts DumpOptions) (*DumpResult, error) {
+	if !opts.Gzip {
+		return dump(ctx, db, w, opts)
+	}
+	gz := gzip.NewWriter(w)
+	result, err := dump(ctx, db, gz, opts)
+	if closeErr := gz.Close(); err == nil && closeErr != nil {
+		return nil, fmt.Errorf("failed to finish gzip stream: %w", closeErr)
+	}
+	return result, err
+}
+
+func dump(ctx context.Context, db *mongo.Database, w io.Writer, opts DumpOptions) (*DumpResult, error) {
+	result := &DumpResult{Database: db.Name(), Collections: []CollectionStats{}}
+	var buildInfo struct {
+		Version string `bson:"version"`
+	}
+	if err := db.Client().Database("admin").RunCommand(ctx, bson.D{{Key: "buildInfo", Value: 1}}).Decode(&buildInfo); err == nil {
+		result.ServerVersion = buildInfo.Version
+	}
+
+	cursor, err := db.ListCollections(ctx, bson.D{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list collections: %w", err)
+	}
+	var specs []struct {
+		Name    string `bson:"name"`
+		Type    string `bson:"type"`
+		Options bson.D `bson:"options"`
+	}
+	if err := cursor.All(ctx, &specs); err != nil {
+		return nil, fmt.Errorf("failed to list collections: %w", err)
+	}
+
+	wanted := map[string]bool{}
+	for _, name := range opts.Collections {
+		wanted[name] = true
+	}
+
+	var metadata []collectionMetadata
+	for _, spec := range specs {
+		if strings.HasPrefix(spec.Name, "system.") {
+			continue
+		}
+		if len(wanted) > 0 && !wanted[spec.Name] {
+			continue
+		}
+		if hasAnyPrefix(spec.Name, opts.ExcludePrefixes) {
+			continue
+		}
+		if spec.Type == "timeseries" {
+			result.Skipped = append(result.Skipped, spec.Name)
+			continue
+		}
+		if spec.Type == "" {
+			spec.Type = "collection"
+		}
+		meta := metadataDoc{Options: spec.Options, Indexes: []bson.D{}, CollectionName: spec.Name, Type: spec.Type}
+		if spec.Type == "collection" {
+			idxCursor, err := db.Collection(spec.Name).Indexes().List(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list indexes of %s: %w", spec.Name, err)
+			}
+			if err := idxCursor.All(ctx, &meta.Indexes); err != nil {
+				return nil, fmt.Errorf("failed to list indexes of %s: %w", spec.Name, err)
+			}
+		}
+		metaJSON, err := bson.MarshalExtJSON(meta, true, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode metadata of %s: %w", spec.Name, err)
+		}
+		metadata = append(metadata, collectionMetadata{
+			Database:   db.Name(),
+			Collection: spec.Name,
+			Metadata:   string(metaJSON),
+			Type:       spec.Type,
+		})
+	}
+
+	aw := &archiveWriter{w: w}
+	header := archiveHeader{
+		ConcurrentCollections: 1,
+		FormatVersion:         formatVersion,
+		ServerVersion:         result.ServerVersion,
+		ToolVersion:           toolVersion,
+	}
+	if err := aw.writePrelude(header, metadata); err != nil {
+		return nil, fmt.Errorf("failed to write archive prelude: %w", err)
+	}
+
+	for i, m := range metadata {
+		if opts.Progress != nil {
+			opts.Progress(m.Collection, i, len(metadata))
+		}
+		stats := CollectionStats{Name: m.Collection, Type: m.Type}
+		// Views only have metadata.
+		if m.Type == "view" {
+			result.Collections = append(result.Collections, stats)
+			continue
+		}
+		n, err := dumpCollection(ctx, db.Collection(m.Collection), aw)
+		if err != nil {
+			return nil, fmt.Errorf("failed to dump %s: %w", m.Collection, err)
+		}
+		stats.Documents = n
+		result.Collections = append(result.Collections, stats)
+	}
+	return result, nil
+}
+
+func dumpCollection(ctx context.Context, coll *mongo.Collection, aw *archiveWriter) (int64, error) {
+	cursor, err := coll.Find(ctx, bson.D{})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	aw.beginNamespace(coll.Database().Name(), coll.Name())
+	var n int64
+	for cursor.Next(ctx) {
+		if err := aw.writeDocument(cursor.Current, n == 0); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := cursor.Err(); err != nil {
+		return n, err
+	}
+	return n, aw.endNamespace(n > 0)
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
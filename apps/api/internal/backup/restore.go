@@ -0,0 +1,267 @@
+package backup
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc64"
+	"io"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// insertBatchSize is the number of documents inserted per insertMany.
+const insertBatchSize = 1000
+
+// ErrCollectionExists is returned when restoring into an existing collection without Drop.
+var ErrCollectionExists = errors.New("collection already exists")
+
+// RestoreOptions configures a restore.
+type RestoreOptions struct {
+	// TargetDb restores into this database instead of the one recorded in the archive.
+	TargetDb string
+	// Collections restricts the restore to these collections (and views). Empty means all.
+	Collections []string
+	// Drop drops existing target collections before restoring them.
+	Drop bool
+	// Progress, if set, is called each time a namespace is completed.
+	Progress func(collection string, done, total int)
+}
+
+// RestoreResult summarizes a restore.
+type RestoreResult struct {
+	SourceDatabase string            `json:"sourceDatabase"`
+	Database       string            `json:"database"`
+	Collections    []CollectionStats `json:"collections"`
+}
+
+// Restore reads a mongodump archive (gzipped or not) from r and restores it with client.
+// Collections are created with their options, documents inserted, then indexes and views created.
+func Restore(ctx context.Context, client *mongo.Client, r io.Reader, opts RestoreOptions) (*RestoreResult, error) {
+	br := bufio.NewReader(r)
+	if head, err := br.Peek(2); err == nil && head[0] == 0x1f && head[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open gzip archive: %w", err)
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = br
+	}
+
+	_, prelude, err := readPrelude(r)
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := map[string]bool{}
+	for _, name := range opts.Collections {
+		wanted[name] = true
+	}
+
+	type namespace struct {
+		meta     metadataDoc
+		kind     string
+		coll     *mongo.Collection
+		crc      hash.Hash64
+		batch    []interface{}
+		restored int64
+	}
+	result := &RestoreResult{Collections: []CollectionStats{}}
+	namespaces := map[string]*namespace{}
+	var order []string
+	for _, m := range prelude {
+		if result.SourceDatabase == "" {
+			result.SourceDatabase = m.Database
+		}
+		if len(wanted) > 0 && !wanted[m.Collection] {
+			continue
+		}
+		var meta metadataDoc
+		if err := bson.UnmarshalExtJSON([]byte(m.Metadata), true, &meta); err != nil {
+			return nil, fmt.Errorf("invalid metadata for %s.%s: %w", m.Database, m.Collection, err)
+		}
+		kind := m.Type
+		if kind == "" {
+			kind = meta.Type
+		}
+		if kind == "" {
+			kind = "collection"
+		}
+		targetDb := m.Database
+		if opts.TargetDb != "" {
+			targetDb = opts.TargetDb
+		}
+		key := m.Database + "." + m.Collection
+		namespaces[key] = &namespace{meta: meta, kind: kind, coll: client.Database(targetDb).Collection(m.Collection), crc: crc64.New(crcTable)}
+		order = append(order, key)
+	}
+	result.Database = opts.TargetDb
+	if result.Database == "" {
+		result.Database = result.SourceDatabase
+	}
+
+	// Create the collections before inserting documents, so that options
+	// (validators, capped, collation...) apply.
+	for _, key := range order {
+		ns := namespaces[key]
+		if ns.kind != "collection" {
+			continue
+		}
+		if err := prepareCollection(ctx, ns.coll, ns.meta.Options, opts.Drop); err != nil {
+			return nil, err
+		}
+	}
+
+	flush := func(ns *namespace) error {
+		if len(ns.batch) == 0 {
+			return nil
+		}
+		if _, err := ns.coll.InsertMany(ctx, ns.batch, options.InsertMany().SetOrdered(false)); err != nil {
+			return fmt.Errorf("failed to insert documents into %s: %w", ns.coll.Name(), err)
+		}
+		ns.restored += int64(len(ns.batch))
+		ns.batch = ns.batch[:0]
+		return nil
+	}
+
+	// Body: segments of documents, each started by a namespace header and ended by a terminator.
+	completed := 0
+	for {
+		raw, err := readBlock(r)
+		if err == io.EOF {
+			break
+		}
+		if err == errTerminator {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive body: %w", err)
+		}
+		var header namespaceHeader
+		if err := bson.Unmarshal(raw, &header); err != nil {
+			return nil, fmt.Errorf("invalid namespace header: %w", err)
+		}
+		ns := namespaces[header.Database+"."+header.Collection]
+
+		if header.EOF {
+			if ns == nil {
+				continue
+			}
+			if err := flush(ns); err != nil {
+				return nil, err
+			}
+			if int64(ns.crc.Sum64()) != header.CRC {
+				return nil, fmt.Errorf("checksum mismatch for %s.%s, archive is corrupted", header.Database, header.Collection)
+			}
+			completed++
+			if opts.Progress != nil {
+				opts.Progress(header.Collection, completed, len(order))
+			}
+			continue
+		}
+
+		for {
+			doc, err := readBlock(r)
+			if err == errTerminator {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to read documents of %s.%s: %w", header.Database, header.Collection, err)
+			}
+			if ns == nil {
+				continue
+			}
+			ns.crc.Write(doc)
+			ns.batch = append(ns.batch, bson.Raw(doc))
+			if len(ns.batch) >= insertBatchSize {
+				if err := flush(ns); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	// Indexes, then views.
+	for _, key := range order {
+		ns := namespaces[key]
+		if ns.kind != "collection" {
+			continue
+		}
+		if err := flush(ns); err != nil {
+			return nil, err
+		}
+		if err := createIndexes(ctx, ns.coll, ns.meta.Indexes); err != nil {
+			return nil, err
+		}
+		result.Collections = append(result.Collections, CollectionStats{Name: ns.coll.Name(), Type: ns.kind, Documents: ns.restored})
+	}
+	for _, key := range order {
+		ns := namespaces[key]
+		if ns.kind != "view" {
+			continue
+		}
+		if err := prepareCollection(ctx, ns.coll, ns.meta.Options, opts.Drop); err != nil {
+			return nil, err
+		}
+		result.Collections = append(result.Collections, CollectionStats{Name: ns.coll.Name(), Type: ns.kind})
+	}
+	return result, nil
+}
+
+// prepareCollection (re)creates a collection or view with the given options.
+func prepareCollection(ctx context.Context, coll *mongo.Collection, collOptions bson.D, drop bool) error {
+	names, err := coll.Database().ListCollectionNames(ctx, bson.D{{Key: "name", Value: coll.Name()}})
+	if err != nil {
+		return fmt.Errorf("failed to inspect %s: %w", coll.Name(), err)
+	}
+	if len(names) > 0 {
+		if !drop {
+			return fmt.Errorf("%w: %s.%s", ErrCollectionExists, coll.Database().Name(), coll.Name())
+		}
+		if err := coll.Drop(ctx); err != nil {
+			return fmt.Errorf("failed to drop %s: %w", coll.Name(), err)
+		}
+	}
+	cmd := bson.D{{Key: "create", Value: coll.Name()}}
+	cmd = append(cmd, collOptions...)
+	if err := coll.Database().RunCommand(ctx, cmd).Err(); err != nil {
+		return fmt.Errorf("failed to create %s: %w", coll.Name(), err)
+	}
+	return nil
+}
+
+// createIndexes creates the indexes recorded in the archive metadata, except _id.
+func createIndexes(ctx context.Context, coll *mongo.Collection, indexes []bson.D) error {
+	var specs bson.A
+	for _, idx := range indexes {
+		spec := bson.D{}
+		isID := false
+		for _, e := range idx {
+			if e.Key == "name" && e.Value == "_id_" {
+				isID = true
+			}
+			// "ns" is rejected by recent servers.
+			if e.Key != "ns" {
+				spec = append(spec, e)
+			}
+		}
+		if !isID {
+			specs = append(specs, spec)
+		}
+	}
+	if len(specs) == 0 {
+		return nil
+	}
+	cmd := bson.D{{Key: "createIndexes", Value: coll.Name()}, {Key: "indexes", Value: specs}}
+	if err := coll.Database().RunCommand(ctx, cmd).Err(); err != nil {
+		return fmt.Errorf("failed to create indexes on %s: %w", coll.Name(), err)
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"log"
 	"os"
+	"strconv"
 
 	"github.com/joho/godotenv"
 )
@@ -33,5 +34,55 @@ func LoadConfig() (*Config, error) {
 	if cfg.JWTSecret == "" {
 		return nil, errors.New("environment variable JWT_SECRET is not set")
 	}
+
+	// Optional settings.
+	cfg.JobWorkers = 2
+	if v := os.Getenv("JOB_WORKERS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, errors.New("environment variable JOB_WORKERS must be a positive integer")
+		}
+		cfg.JobWorkers = n
+	}
+	cfg.BackupDir = os.Getenv("BACKUP_DIR")
+	if cfg.BackupDir == "" {
+		cfg.BackupDir = "backups"
+	}
+	cfg.BackupRetentionCount = 10
+	if v := os.Getenv("BACKUP_RETENTION_COUNT"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, errors.New("environment variable BACKUP_RETENTION_COUNT must be a positive integer")
+		}
+		cfg.BackupRetentionCount = n
+	}
+	cfg.RecycleRetentionDays = 7
+	if v := os.Getenv("RECYCLE_RETENTION_DAYS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, errors.New("environment variable RECYCLE_RETENTION_DAYS must be a positive integer")
+		}
+		cfg.RecycleRetentionDays = n
+	}
+	cfg.MaxWatchStreams = 3
+	if v := os.Getenv("MAX_WATCH_STREAMS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, errors.New("environment variable MAX_WATCH_STREAMS must be a positive integer")
+		}
+		cfg.MaxWatchStreams = n
+	}
+	cfg.ETagSecret = os.Getenv("ETAG_SECRET")
+	if cfg.ETagSecret == "" {
+		cfg.ETagSecret = cfg.JWTSecret
+	}
+	cfg.MaxUploadMB = 100
+	if v := os.Getenv("MAX_UPLOAD_MB"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, errors.New("environment variable MAX_UPLOAD_MB must be a positive integer")
+		}
+		cfg.MaxUploadMB = n
+	}
 	return cfg, nil
 }
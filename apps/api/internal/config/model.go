@@ -5,4 +5,17 @@ type Config struct {
 	SQLitePath string
 	MongoURI   string
 	JWTSecret  string
+	JobWorkers int
+	// BackupDir is the local directory where backup archives are written.
+	BackupDir string
+	// BackupRetentionCount is the number of on-demand backups kept per database (default 10, 0 keeps all).
+	BackupRetentionCount int
+	// RecycleRetentionDays is how long dropped collections and databases are kept (0 disables the recycle bin).
+	RecycleRetentionDays int
+	// MaxWatchStreams is the number of change streams a user may have open at once.
+	MaxWatchStreams int
+	// ETagSecret keys the ETags of documents (default JWTSecret).
+	ETagSecret string
+	// MaxUploadMB is the size limit of GridFS uploads, in megabytes.
+	MaxUploadMB int
 }
@@ -2,7 +2,11 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"log"
+	"time"
+
+	"monji/internal/models"
 
 	_ "github.com/mattn/go-sqlite3"
 	"golang.org/x/crypto/bcrypt"
@@ -35,6 +39,21 @@ func InitSQLite(path string) {
 		log.Fatalf("Failed to create users table: %v", err)
 	}
 
+	// Create roles table (named sets of capabilities, referenced by users.role).
+	createRolesTableSQL := `
+	CREATE TABLE IF NOT EXISTS roles (
+		name TEXT PRIMARY KEY,
+		description TEXT NOT NULL DEFAULT '',
+		capabilities TEXT NOT NULL DEFAULT '[]', -- JSON array
+		built_in INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME NOT NULL
+	);`
+	_, err = DB.Exec(createRolesTableSQL)
+	if err != nil {
+		log.Fatalf("Failed to create roles table: %v", err)
+	}
+	seedBuiltInRoles()
+
 	// Create environments table.
 	createEnvsTableSQL := `
 	CREATE TABLE IF NOT EXISTS environments (
@@ -47,6 +66,7 @@ func InitSQLite(path string) {
 	if err != nil {
 		log.Fatalf("Failed to create environments table: %v", err)
 	}
+	addColumnIfMissing("environments", "protection_level", "TEXT NOT NULL DEFAULT 'none'")
 
 	// Create user_env_permissions table:
 	createUserEnvPerms := `
@@ -54,7 +74,7 @@ func InitSQLite(path string) {
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		user_id INTEGER NOT NULL,
 		environment_id INTEGER NOT NULL,
-		permission TEXT NOT NULL, -- "none", "readOnly", "readAndWrite"
+		permission TEXT NOT NULL, -- "none", "readOnly", "readAndWrite", "deny"
 		UNIQUE (user_id, environment_id)
 	);
 	`
@@ -62,6 +82,10 @@ func InitSQLite(path string) {
 	if err != nil {
 		log.Fatalf("Failed to create user_env_permissions table: %v", err)
 	}
+	// Time-bound grants: the permission applies until expires_at (NULL = forever),
+	// then the row falls back to revert_permission ('' = no access).
+	addColumnIfMissing("user_env_permissions", "expires_at", "DATETIME")
+	addColumnIfMissing("user_env_permissions", "revert_permission", "TEXT NOT NULL DEFAULT ''")
 
 	// Create user_db_permissions table:
 	createUserDBPerms := `
@@ -70,7 +94,7 @@ func InitSQLite(path string) {
 		user_id INTEGER NOT NULL,
 		environment_id INTEGER NOT NULL,
 		db_name TEXT NOT NULL,
-		permission TEXT NOT NULL, -- "none", "readOnly", "readAndWrite"
+		permission TEXT NOT NULL, -- "none", "readOnly", "readAndWrite", "deny"
 		UNIQUE (user_id, environment_id, db_name)
 	);
 	`
@@ -78,6 +102,366 @@ func InitSQLite(path string) {
 	if err != nil {
 		log.Fatalf("Failed to create user_db_permissions table: %v", err)
 	}
+	addColumnIfMissing("user_db_permissions", "expires_at", "DATETIME")
+	addColumnIfMissing("user_db_permissions", "revert_permission", "TEXT NOT NULL DEFAULT ''")
+
+	// Create access_requests table (self-service requests for temporary access).
+	createAccessRequestsTableSQL := `
+	CREATE TABLE IF NOT EXISTS access_requests (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		environment_id INTEGER NOT NULL,
+		db_name TEXT NOT NULL DEFAULT '', -- '' requests environment-level access
+		permission TEXT NOT NULL, -- "readOnly", "readAndWrite"
+		duration_minutes INTEGER NOT NULL,
+		reason TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL, -- "pending", "approved", "rejected", "cancelled"
+		reviewed_by INTEGER NOT NULL DEFAULT 0,
+		review_comment TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL,
+		reviewed_at DATETIME,
+		expires_at DATETIME -- end of the granted access
+	);
+	`
+	_, err = DB.Exec(createAccessRequestsTableSQL)
+	if err != nil {
+		log.Fatalf("Failed to create access_requests table: %v", err)
+	}
+
+	// Create groups tables (teams whose members inherit the group permissions).
+	createGroupsTablesSQL := `
+	CREATE TABLE IF NOT EXISTS groups (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL UNIQUE,
+		description TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS group_members (
+		group_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		PRIMARY KEY (group_id, user_id)
+	);
+	CREATE TABLE IF NOT EXISTS group_env_permissions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		group_id INTEGER NOT NULL,
+		environment_id INTEGER NOT NULL,
+		permission TEXT NOT NULL, -- "readOnly", "readAndWrite", "deny"
+		UNIQUE (group_id, environment_id)
+	);
+	CREATE TABLE IF NOT EXISTS group_db_permissions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		group_id INTEGER NOT NULL,
+		environment_id INTEGER NOT NULL,
+		db_name TEXT NOT NULL,
+		permission TEXT NOT NULL, -- "readOnly", "readAndWrite", "deny"
+		UNIQUE (group_id, environment_id, db_name)
+	);
+	`
+	_, err = DB.Exec(createGroupsTablesSQL)
+	if err != nil {
+		log.Fatalf("Failed to create groups tables: %v", err)
+	}
+
+	// Create redaction tables (masked fields, and who may see them unmasked).
+	createRedactionTablesSQL := `
+	CREATE TABLE IF NOT EXISTS redaction_rules (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		environment_id INTEGER NOT NULL,
+		db_name TEXT NOT NULL DEFAULT '*',
+		collection TEXT NOT NULL DEFAULT '*',
+		field TEXT NOT NULL DEFAULT '',
+		value_regex TEXT NOT NULL DEFAULT '',
+		style TEXT NOT NULL, -- "hash", "partial", "remove"
+		description TEXT NOT NULL DEFAULT '',
+		created_by INTEGER NOT NULL,
+		created_at DATETIME NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS unmasked_grants (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL DEFAULT 0,
+		group_id INTEGER NOT NULL DEFAULT 0,
+		environment_id INTEGER NOT NULL,
+		db_name TEXT NOT NULL DEFAULT '*',
+		created_by INTEGER NOT NULL,
+		created_at DATETIME NOT NULL
+	);
+	`
+	_, err = DB.Exec(createRedactionTablesSQL)
+	if err != nil {
+		log.Fatalf("Failed to create redaction tables: %v", err)
+	}
+
+	// Create document_revisions table (pre-images of documents edited through Monji).
+	createDocumentRevisionsTableSQL := `
+	CREATE TABLE IF NOT EXISTS document_revisions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		environment_id INTEGER NOT NULL,
+		db_name TEXT NOT NULL,
+		collection_name TEXT NOT NULL,
+		document_id TEXT NOT NULL,
+		operation TEXT NOT NULL, -- "update", "delete", "revert"
+		document TEXT NOT NULL, -- canonical extended JSON
+		user_id INTEGER NOT NULL,
+		created_at DATETIME NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_document_revisions_document
+		ON document_revisions (environment_id, db_name, collection_name, document_id);
+	`
+	_, err = DB.Exec(createDocumentRevisionsTableSQL)
+	if err != nil {
+		log.Fatalf("Failed to create document_revisions table: %v", err)
+	}
+
+	// Create triggers tables (webhooks on change events, and their delivery log).
+	createTriggersTablesSQL := `
+	CREATE TABLE IF NOT EXISTS triggers (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		environment_id INTEGER NOT NULL,
+		db_name TEXT NOT NULL,
+		collection TEXT NOT NULL DEFAULT '',
+		operation_types TEXT NOT NULL, -- JSON array
+		filter TEXT NOT NULL DEFAULT '', -- extended JSON
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL, -- encrypted
+		enabled BOOLEAN NOT NULL DEFAULT 1,
+		resume_token TEXT NOT NULL DEFAULT '',
+		last_error TEXT NOT NULL DEFAULT '',
+		last_event_at DATETIME,
+		created_by INTEGER NOT NULL,
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS trigger_deliveries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		trigger_id INTEGER NOT NULL,
+		operation_type TEXT NOT NULL,
+		status TEXT NOT NULL, -- "pending", "delivered", "failed"
+		attempts INTEGER NOT NULL DEFAULT 0,
+		response_status INTEGER NOT NULL DEFAULT 0,
+		error TEXT NOT NULL DEFAULT '',
+		payload TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		next_attempt_at DATETIME,
+		delivered_at DATETIME
+	);
+	`
+	_, err = DB.Exec(createTriggersTablesSQL)
+	if err != nil {
+		log.Fatalf("Failed to create triggers tables: %v", err)
+	}
+
+	// Create saved_queries table (named queries shared with a team or everyone).
+	createSavedQueriesTableSQL := `
+	CREATE TABLE IF NOT EXISTS saved_queries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		environment_id INTEGER NOT NULL,
+		db_name TEXT NOT NULL,
+		collection_name TEXT NOT NULL,
+		filter TEXT NOT NULL DEFAULT '', -- extended JSON
+		sort TEXT NOT NULL DEFAULT '', -- extended JSON
+		projection TEXT NOT NULL DEFAULT '', -- extended JSON
+		pipeline TEXT NOT NULL DEFAULT '', -- extended JSON
+		visibility TEXT NOT NULL, -- "private", "team", "global"
+		group_id INTEGER NOT NULL DEFAULT 0,
+		owner_id INTEGER NOT NULL,
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL
+	);
+	`
+	_, err = DB.Exec(createSavedQueriesTableSQL)
+	if err != nil {
+		log.Fatalf("Failed to create saved_queries table: %v", err)
+	}
+
+	// Create jobs table (background operations, see internal/jobs).
+	createJobsTableSQL := `
+	CREATE TABLE IF NOT EXISTS jobs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		type TEXT NOT NULL,
+		status TEXT NOT NULL, -- "pending", "running", "succeeded", "failed", "cancelled"
+		environment_id INTEGER,
+		params TEXT,
+		progress INTEGER NOT NULL DEFAULT 0,
+		progress_message TEXT NOT NULL DEFAULT '',
+		checkpoint TEXT,
+		result TEXT,
+		error TEXT NOT NULL DEFAULT '',
+		cancel_requested INTEGER NOT NULL DEFAULT 0,
+		created_by INTEGER NOT NULL,
+		created_at DATETIME NOT NULL,
+		started_at DATETIME,
+		finished_at DATETIME
+	);
+	`
+	_, err = DB.Exec(createJobsTableSQL)
+	if err != nil {
+		log.Fatalf("Failed to create jobs table: %v", err)
+	}
+
+	// Create backups table (mongodump archives stored on local disk).
+	createBackupsTableSQL := `
+	CREATE TABLE IF NOT EXISTS backups (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		environment_id INTEGER NOT NULL,
+		db_name TEXT NOT NULL,
+		collections TEXT NOT NULL DEFAULT '[]', -- JSON array, empty means the whole database
+		path TEXT NOT NULL DEFAULT '',
+		size_bytes INTEGER NOT NULL DEFAULT 0,
+		compression TEXT NOT NULL, -- "gzip" or "none"
+		status TEXT NOT NULL, -- "pending", "completed", "failed"
+		error TEXT NOT NULL DEFAULT '',
+		job_id INTEGER NOT NULL DEFAULT 0,
+		created_by INTEGER NOT NULL,
+		created_at DATETIME NOT NULL,
+		completed_at DATETIME
+	);
+	`
+	_, err = DB.Exec(createBackupsTableSQL)
+	if err != nil {
+		log.Fatalf("Failed to create backups table: %v", err)
+	}
+	addColumnIfMissing("backups", "policy_id", "INTEGER NOT NULL DEFAULT 0")
+
+	// Create backup_policies table (scheduled backups).
+	createBackupPoliciesTableSQL := `
+	CREATE TABLE IF NOT EXISTS backup_policies (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		environment_id INTEGER NOT NULL,
+		db_name TEXT NOT NULL,
+		collections TEXT NOT NULL DEFAULT '[]', -- JSON array, empty means the whole database
+		schedule TEXT NOT NULL, -- cron expression, evaluated in UTC
+		target_path TEXT NOT NULL DEFAULT '', -- empty means BACKUP_DIR
+		retention_count INTEGER NOT NULL DEFAULT 0, -- 0 keeps all
+		retention_days INTEGER NOT NULL DEFAULT 0, -- 0 keeps all
+		compression TEXT NOT NULL, -- "gzip" or "none"
+		enabled INTEGER NOT NULL DEFAULT 1,
+		created_by INTEGER NOT NULL,
+		created_at DATETIME NOT NULL,
+		last_run_at DATETIME,
+		next_run_at DATETIME
+	);
+	`
+	_, err = DB.Exec(createBackupPoliciesTableSQL)
+	if err != nil {
+		log.Fatalf("Failed to create backup_policies table: %v", err)
+	}
+	addColumnIfMissing("backup_policies", "deleted_at", "DATETIME")
+
+	// Create backup_policy_runs table (one row per scheduled execution).
+	createBackupPolicyRunsTableSQL := `
+	CREATE TABLE IF NOT EXISTS backup_policy_runs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		policy_id INTEGER NOT NULL,
+		backup_id INTEGER NOT NULL DEFAULT 0,
+		job_id INTEGER NOT NULL DEFAULT 0,
+		status TEXT NOT NULL, -- "pending", "running", "succeeded", "failed", "skipped"
+		error TEXT NOT NULL DEFAULT '',
+		pruned INTEGER NOT NULL DEFAULT 0, -- number of old backups deleted by retention
+		started_at DATETIME NOT NULL,
+		finished_at DATETIME
+	);
+	`
+	_, err = DB.Exec(createBackupPolicyRunsTableSQL)
+	if err != nil {
+		log.Fatalf("Failed to create backup_policy_runs table: %v", err)
+	}
+
+	// Create recycle_bin table (soft-dropped collections and databases).
+	createRecycleBinTableSQL := `
+	CREATE TABLE IF NOT EXISTS recycle_bin (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		kind TEXT NOT NULL, -- "collection" or "database"
+		environment_id INTEGER NOT NULL,
+		db_name TEXT NOT NULL,
+		collection_name TEXT NOT NULL DEFAULT '',
+		collection_type TEXT NOT NULL DEFAULT '', -- "collection" or "view"
+		trash_name TEXT NOT NULL DEFAULT '', -- quarantine collection name
+		view_options TEXT NOT NULL DEFAULT '', -- extended JSON of a view definition
+		archive_path TEXT NOT NULL DEFAULT '', -- snapshot of a dropped database
+		size_bytes INTEGER NOT NULL DEFAULT 0,
+		status TEXT NOT NULL, -- "pending", "trashed", "restored", "purged", "failed"
+		error TEXT NOT NULL DEFAULT '',
+		job_id INTEGER NOT NULL DEFAULT 0,
+		dropped_by INTEGER NOT NULL,
+		dropped_at DATETIME NOT NULL,
+		expires_at DATETIME NOT NULL,
+		restored_at DATETIME,
+		purged_at DATETIME
+	);
+	`
+	_, err = DB.Exec(createRecycleBinTableSQL)
+	if err != nil {
+		log.Fatalf("Failed to create recycle_bin table: %v", err)
+	}
+
+	// Create destructive_approvals table (second-admin approval of destructive operations).
+	createApprovalsTableSQL := `
+	CREATE TABLE IF NOT EXISTS destructive_approvals (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		environment_id INTEGER NOT NULL,
+		action TEXT NOT NULL,
+		target TEXT NOT NULL,
+		requested_by INTEGER NOT NULL,
+		status TEXT NOT NULL, -- "pending", "approved", "rejected", "used"
+		reviewed_by INTEGER NOT NULL DEFAULT 0,
+		comment TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL,
+		reviewed_at DATETIME,
+		expires_at DATETIME
+	);
+	`
+	_, err = DB.Exec(createApprovalsTableSQL)
+	if err != nil {
+		log.Fatalf("Failed to create destructive_approvals table: %v", err)
+	}
+
+	// Create change_requests table (writes proposed by one user and approved by another).
+	createChangeRequestsTableSQL := `
+	CREATE TABLE IF NOT EXISTS change_requests (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		environment_id INTEGER NOT NULL,
+		db_name TEXT NOT NULL,
+		collection_name TEXT NOT NULL,
+		kind TEXT NOT NULL, -- "insertDocument", "updateDocument", "deleteDocument", "updateMany", "createIndex", "dropIndex"
+		title TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		payload TEXT NOT NULL, -- extended JSON
+		base_document TEXT NOT NULL DEFAULT '', -- extended JSON of the target document at request time
+		status TEXT NOT NULL, -- "pending", "applying", "rejected", "cancelled", "applied", "failed"
+		requested_by INTEGER NOT NULL,
+		reviewed_by INTEGER NOT NULL DEFAULT 0,
+		review_comment TEXT NOT NULL DEFAULT '',
+		result TEXT NOT NULL DEFAULT '',
+		error TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL,
+		updated_at DATETIME NOT NULL,
+		applied_at DATETIME
+	);
+	`
+	_, err = DB.Exec(createChangeRequestsTableSQL)
+	if err != nil {
+		log.Fatalf("Failed to create change_requests table: %v", err)
+	}
+
+	// Create change_request_events table (lifecycle log of change requests).
+	createChangeRequestEventsTableSQL := `
+	CREATE TABLE IF NOT EXISTS change_request_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		change_request_id INTEGER NOT NULL,
+		event TEXT NOT NULL,
+		user_id INTEGER NOT NULL,
+		details TEXT NOT NULL DEFAULT '',
+		created_at DATETIME NOT NULL
+	);
+	`
+	_, err = DB.Exec(createChangeRequestEventsTableSQL)
+	if err != nil {
+		log.Fatalf("Failed to create change_request_events table: %v", err)
+	}
 
 	// Insert default admin user if none exist.
 	var count int
@@ -100,3 +484,53 @@ func InitSQLite(path string) {
 		log.Println("Default admin user created: email=admin@example.com, password=admin")
 	}
 }
+
+// addColumnIfMissing adds a column to a table created by a previous version.
+// CREATE TABLE IF NOT EXISTS does not alter existing tables.
+func addColumnIfMissing(table, column, definition string) {
+	rows, err := DB.Query(`PRAGMA table_info(` + table + `)`)
+	if err != nil {
+		log.Fatalf("Failed to inspect %s table: %v", table, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			log.Fatalf("Failed to inspect %s table: %v", table, err)
+		}
+		if name == column {
+			return
+		}
+	}
+	rows.Close()
+	if _, err := DB.Exec(`ALTER TABLE ` + table + ` ADD COLUMN ` + column + ` ` + definition); err != nil {
+		log.Fatalf("Failed to add column %s to %s table: %v", column, table, err)
+	}
+}
+
+// seedBuiltInRoles creates or refreshes the built-in roles. "user" keeps the data-level
+// capabilities that regular users always had on the databases they are granted.
+func seedBuiltInRoles() {
+	builtIns := []struct {
+		name, description string
+		capabilities      []string
+	}{
+		{models.RoleSuperAdmin, "Full access, including managing admins", models.AllCapabilities},
+		{models.RoleAdmin, "Full access", models.AllCapabilities},
+		{models.RoleUser, "Access through grants only", []string{models.CapManageMongoUsers, models.CapDropDatabases, models.CapExportData}},
+	}
+	for _, r := range builtIns {
+		caps, _ := json.Marshal(r.capabilities)
+		_, err := DB.Exec(
+			`INSERT INTO roles (name, description, capabilities, built_in, created_at) VALUES (?, ?, ?, 1, ?)
+			 ON CONFLICT(name) DO UPDATE SET description = excluded.description,
+			        capabilities = excluded.capabilities, built_in = 1`,
+			r.name, r.description, string(caps), time.Now().UTC(),
+		)
+		if err != nil {
+			log.Fatalf("Failed to seed role %s: %v", r.name, err)
+		}
+	}
+}
@@ -0,0 +1,349 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"monji/internal/database"
+	"monji/internal/middleware"
+	"monji/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxAccessRequestDuration caps the temporary access a reviewer can approve.
+const maxAccessRequestDuration = 7 * 24 * time.Hour
+
+const accessRequestColumns = `id, user_id, environment_id, db_name, permission, duration_minutes, reason, status,
+	reviewed_by, review_comment, created_at, reviewed_at, expires_at`
+
+func scanAccessRequest(s interface{ Scan(...interface{}) error }) (*models.AccessRequest, error) {
+	var r models.AccessRequest
+	var reviewedAt, expiresAt sql.NullTime
+	err := s.Scan(&r.ID, &r.UserID, &r.EnvironmentID, &r.DBName, &r.Permission, &r.DurationMinutes, &r.Reason,
+		&r.Status, &r.ReviewedBy, &r.ReviewComment, &r.CreatedAt, &reviewedAt, &expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if reviewedAt.Valid {
+		r.ReviewedAt = &reviewedAt.Time
+	}
+	if expiresAt.Valid {
+		r.ExpiresAt = &expiresAt.Time
+	}
+	return &r, nil
+}
+
+// parseAccessDuration parses a requested access duration (e.g. "2h").
+func parseAccessDuration(s string) (time.Duration, bool) {
+	d, err := time.ParseDuration(s)
+	if err != nil || d < time.Minute || d > maxAccessRequestDuration {
+		return 0, false
+	}
+	return d.Truncate(time.Minute), true
+}
+
+// canSeeAllAccessRequests returns true if the user can review access requests or view the audit log.
+func canSeeAllAccessRequests(user models.User) bool {
+	return middleware.HasCapability(user, models.CapManageUsers) || middleware.HasCapability(user, models.CapViewAuditLog)
+}
+
+// getAccessibleAccessRequest loads the access request named by the :requestId route parameter.
+// Reviewers (manage_users) and auditors (view_audit_log) can see every request, other users only their own.
+// It writes the error response itself and returns nil on failure.
+func getAccessibleAccessRequest(c *gin.Context, currentUser models.User) *models.AccessRequest {
+	requestID, err := strconv.Atoi(c.Param("requestId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid access request ID"})
+		return nil
+	}
+	r, err := scanAccessRequest(database.DB.QueryRow(`SELECT `+accessRequestColumns+` FROM access_requests WHERE id = ?`, requestID))
+	if err == sql.ErrNoRows || (err == nil && !canSeeAllAccessRequests(currentUser) && r.UserID != currentUser.ID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Access request not found"})
+		return nil
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil
+	}
+	return r
+}
+
+// CreateAccessRequest asks the user managers for temporary access to an environment or a database
+// (db_name may be a pattern such as "tenant_*").
+// Body: { "environment_id": 1, "db_name": "app" (optional), "permission": "readOnly", "duration": "4h", "reason": "..." }
+func CreateAccessRequest(c *gin.Context) {
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+	if middleware.IsAdmin(currentUser) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Your role already has access to every environment"})
+		return
+	}
+
+	var req struct {
+		EnvironmentID int    `json:"environment_id" binding:"required"`
+		DBName        string `json:"db_name"`
+		Permission    string `json:"permission" binding:"required"`
+		Duration      string `json:"duration" binding:"required"`
+		Reason        string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Permission != "readOnly" && req.Permission != "readAndWrite" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid permission (use 'readOnly' or 'readAndWrite')"})
+		return
+	}
+	if !middleware.ValidDBNamePattern(req.DBName) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid database name pattern"})
+		return
+	}
+	duration, ok := parseAccessDuration(req.Duration)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid duration (between 1m and " + maxAccessRequestDuration.String() + ")"})
+		return
+	}
+	var envID int
+	err := database.DB.QueryRow(`SELECT id FROM environments WHERE id = ?`, req.EnvironmentID).Scan(&envID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Environment not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	res, err := database.DB.Exec(
+		`INSERT INTO access_requests (user_id, environment_id, db_name, permission, duration_minutes, reason, status, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		currentUser.ID, envID, req.DBName, req.Permission, int(duration/time.Minute), req.Reason,
+		models.AccessRequestPending, time.Now().UTC(),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	id, _ := res.LastInsertId()
+	r, err := scanAccessRequest(database.DB.QueryRow(`SELECT `+accessRequestColumns+` FROM access_requests WHERE id = ?`, id))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"accessRequest": r})
+}
+
+// ListAccessRequests lists access requests, newest first.
+// Reviewers and auditors see every request, other users only their own.
+// Query params: status (optional, default "pending"; "all" lists every status).
+func ListAccessRequests(c *gin.Context) {
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+
+	query := `SELECT ` + accessRequestColumns + ` FROM access_requests WHERE 1 = 1`
+	var params []interface{}
+	if status := c.DefaultQuery("status", models.AccessRequestPending); status != "all" {
+		query += ` AND status = ?`
+		params = append(params, status)
+	}
+	if !canSeeAllAccessRequests(currentUser) {
+		query += ` AND user_id = ?`
+		params = append(params, currentUser.ID)
+	}
+	query += ` ORDER BY id DESC`
+	rows, err := database.DB.Query(query, params...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	requests := []models.AccessRequest{}
+	for rows.Next() {
+		r, err := scanAccessRequest(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		requests = append(requests, *r)
+	}
+	c.JSON(http.StatusOK, gin.H{"accessRequests": requests})
+}
+
+// GetAccessRequest returns a single access request.
+func GetAccessRequest(c *gin.Context) {
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+	r := getAccessibleAccessRequest(c, currentUser)
+	if r == nil {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"accessRequest": r})
+}
+
+// ApproveAccessRequest approves a pending access request and grants the access until
+// it expires. Users cannot approve their own requests, nor grant access they do not hold
+// (see canGrant). A database request also grants temporary read access to the environment
+// when the user does not have it, since database access requires it.
+// Body (optional): { "duration": "2h" (overrides the requested duration), "comment": "..." }
+func ApproveAccessRequest(c *gin.Context) {
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+	if !middleware.HasCapability(currentUser, models.CapManageUsers) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Reviewing access requests requires the manage_users capability"})
+		return
+	}
+	r := getAccessibleAccessRequest(c, currentUser)
+	if r == nil {
+		return
+	}
+	if r.Status != models.AccessRequestPending {
+		c.JSON(http.StatusConflict, gin.H{"error": "Access request is already " + r.Status})
+		return
+	}
+	if r.UserID == currentUser.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You cannot approve your own access request"})
+		return
+	}
+	if !requireGrantable(c, currentUser, false, r.EnvironmentID, r.DBName, r.Permission) {
+		return
+	}
+	var req struct {
+		Duration string `json:"duration"`
+		Comment  string `json:"comment"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	duration := time.Duration(r.DurationMinutes) * time.Minute
+	if req.Duration != "" {
+		var ok bool
+		if duration, ok = parseAccessDuration(req.Duration); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid duration (between 1m and " + maxAccessRequestDuration.String() + ")"})
+			return
+		}
+	}
+
+	// A database request also grants read access to the environment, if missing.
+	grantEnvRead := false
+	if r.DBName != "" {
+		hasEnvRead, err := middleware.HasEnvPermission(models.User{ID: r.UserID}, r.EnvironmentID, "read")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		grantEnvRead = !hasEnvRead
+	}
+
+	// The approval and the grants are recorded together.
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+	now := time.Now().UTC()
+	expiresAt := now.Add(duration)
+	res, err := tx.Exec(
+		`UPDATE access_requests SET status = ?, reviewed_by = ?, review_comment = ?, reviewed_at = ?,
+		        duration_minutes = ?, expires_at = ?
+		 WHERE id = ? AND status = ?`,
+		models.AccessRequestApproved, currentUser.ID, req.Comment, now, int(duration/time.Minute), expiresAt,
+		r.ID, models.AccessRequestPending,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Access request was reviewed concurrently"})
+		return
+	}
+
+	if grantEnvRead {
+		if err := grantPermission(tx, r.UserID, r.EnvironmentID, "", "readOnly", &expiresAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to grant environment access: " + err.Error()})
+			return
+		}
+	}
+	if err := grantPermission(tx, r.UserID, r.EnvironmentID, r.DBName, r.Permission, &expiresAt); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to grant access: " + err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	r = getAccessibleAccessRequest(c, currentUser)
+	if r == nil {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"accessRequest": r})
+}
+
+// RejectAccessRequest rejects a pending access request. Body (optional): { "comment": "..." }
+func RejectAccessRequest(c *gin.Context) {
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+	if !middleware.HasCapability(currentUser, models.CapManageUsers) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Reviewing access requests requires the manage_users capability"})
+		return
+	}
+	var req struct {
+		Comment string `json:"comment"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	closeAccessRequest(c, currentUser, models.AccessRequestRejected, req.Comment)
+}
+
+// CancelAccessRequest withdraws a pending access request. Only its requester can cancel it.
+func CancelAccessRequest(c *gin.Context) {
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+	closeAccessRequest(c, currentUser, models.AccessRequestCancelled, "")
+}
+
+// closeAccessRequest moves a pending access request to a final status without granting access.
+func closeAccessRequest(c *gin.Context, currentUser models.User, status, comment string) {
+	r := getAccessibleAccessRequest(c, currentUser)
+	if r == nil {
+		return
+	}
+	if status == models.AccessRequestCancelled && r.UserID != currentUser.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the requester can cancel an access request"})
+		return
+	}
+	if r.Status != models.AccessRequestPending {
+		c.JSON(http.StatusConflict, gin.H{"error": "Access request is already " + r.Status})
+		return
+	}
+	res, err := database.DB.Exec(
+		`UPDATE access_requests SET status = ?, reviewed_by = ?, review_comment = ?, reviewed_at = ?
+		 WHERE id = ? AND status = ?`,
+		status, currentUser.ID, comment, time.Now().UTC(), r.ID, models.AccessRequestPending,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Access request was reviewed concurrently"})
+		return
+	}
+	r = getAccessibleAccessRequest(c, currentUser)
+	if r == nil {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"accessRequest": r})
+}
@@ -0,0 +1,652 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"monji/internal/backup"
+	"monji/internal/database"
+	"monji/internal/jobs"
+	"monji/internal/middleware"
+	"monji/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// BackupDir is the local directory where backup archives are written.
+// It is set from the configuration at startup.
+var BackupDir = "backups"
+
+// BackupRetentionCount is the number of on-demand backups kept per database (0 keeps all).
+var BackupRetentionCount = 10
+
+// maxPendingBackupsPerUser is the number of on-demand backups a user may have pending
+// (queued or running) at once.
+const maxPendingBackupsPerUser = 3
+
+const (
+	jobTypeBackup  = "backup"
+	jobTypeRestore = "restore"
+)
+
+func init() {
+	jobs.Register(jobTypeBackup, runBackup)
+	jobs.Register(jobTypeRestore, runRestore)
+}
+
+// backupParams are the parameters of a backup job.
+type backupParams struct {
+	BackupID int `json:"backupId"`
+}
+
+// restoreParams are the parameters of a restore job.
+type restoreParams struct {
+	BackupID      int      `json:"backupId"`
+	EnvironmentID int      `json:"environmentId"`
+	DbName        string   `json:"dbName"`
+	Collections   []string `json:"collections,omitempty"`
+	Drop          bool     `json:"drop"`
+}
+
+const backupColumns = `id, environment_id, db_name, collections, path, size_bytes, compression, status, error,
+	job_id, policy_id, created_by, created_at, completed_at`
+
+func scanBackup(s interface{ Scan(...interface{}) error }) (*models.Backup, error) {
+	var b models.Backup
+	var collections string
+	var completedAt sql.NullTime
+	err := s.Scan(&b.ID, &b.EnvironmentID, &b.DBName, &collections, &b.Path, &b.SizeBytes, &b.Compression,
+		&b.Status, &b.Error, &b.JobID, &b.PolicyID, &b.CreatedBy, &b.CreatedAt, &completedAt)
+	if err != nil {
+		return nil, err
+	}
+	b.Collections = []string{}
+	if err := json.Unmarshal([]byte(collections), &b.Collections); err != nil {
+		return nil, fmt.Errorf("invalid collections of backup %d: %w", b.ID, err)
+	}
+	if completedAt.Valid {
+		b.CompletedAt = &completedAt.Time
+	}
+	return &b, nil
+}
+
+func loadBackup(id int) (*models.Backup, error) {
+	return scanBackup(database.DB.QueryRow(`SELECT `+backupColumns+` FROM backups WHERE id = ?`, id))
+}
+
+// insertBackup records a pending backup and submits the job that writes it.
+// policyID is 0 for on-demand backups. targetDir overrides BackupDir when not empty.
+func insertBackup(envID int, dbName string, collections []string, compression string, userID int, policyID int, targetDir string) (*models.Backup, error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	id, err := recordBackup(tx, envID, dbName, collections, compression, userID, policyID, targetDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	if _, err := submitBackup(id); err != nil {
+		return nil, err
+	}
+	return loadBackup(id)
+}
+
+// recordBackup inserts a pending backup within tx, without submitting its job.
+func recordBackup(tx *sql.Tx, envID int, dbName string, collections []string, compression string, userID int, policyID int, targetDir string) (int, error) {
+	if collections == nil {
+		collections = []string{}
+	}
+	collectionsJSON, err := json.Marshal(collections)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now().UTC()
+	res, err := tx.Exec(
+		`INSERT INTO backups (environment_id, db_name, collections, compression, status, policy_id, created_by, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		envID, dbName, string(collectionsJSON), compression, models.BackupPending, policyID, userID, now,
+	)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if targetDir != "" {
+		b := &models.Backup{ID: int(id), EnvironmentID: envID, DBName: dbName, Compression: compression, CreatedAt: now}
+		if _, err := tx.Exec(`UPDATE backups SET path = ? WHERE id = ?`, backupPath(b, targetDir), id); err != nil {
+			return 0, err
+		}
+	}
+	return int(id), nil
+}
+
+// submitBackup submits the job that writes a recorded backup and returns its ID.
+// The backup is marked failed if the job cannot be submitted.
+func submitBackup(id int) (int, error) {
+	b, err := loadBackup(id)
+	if err != nil {
+		return 0, err
+	}
+	jobID, err := jobs.Submit(jobTypeBackup, b.EnvironmentID, b.CreatedBy, backupParams{BackupID: id})
+	if err != nil {
+		database.DB.Exec(`UPDATE backups SET status = ?, error = ? WHERE id = ?`, models.BackupFailed, err.Error(), id)
+		return 0, err
+	}
+	if _, err := database.DB.Exec(`UPDATE backups SET job_id = ? WHERE id = ?`, jobID, id); err != nil {
+		return 0, err
+	}
+	return jobID, nil
+}
+
+// backupPath returns where the archive of a backup is written under dir.
+func backupPath(b *models.Backup, dir string) string {
+	name := fmt.Sprintf("%s-%d-%s.archive", b.DBName, b.ID, b.CreatedAt.UTC().Format("20060102-150405"))
+	if b.Compression == "gzip" {
+		name += ".gz"
+	}
+	return filepath.Join(dir, fmt.Sprintf("env-%d", b.EnvironmentID), name)
+}
+
+// runBackup writes the archive of a pending backup.
+func runBackup(ctx context.Context, task *jobs.Task) (interface{}, error) {
+	var params backupParams
+	if err := task.DecodeParams(&params); err != nil {
+		return nil, err
+	}
+	b, err := loadBackup(params.BackupID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load backup %d: %w", params.BackupID, err)
+	}
+
+	result, err := writeBackup(ctx, task, b)
+	if err != nil {
+		database.DB.Exec(`UPDATE backups SET status = ?, error = ?, completed_at = ? WHERE id = ?`,
+			models.BackupFailed, err.Error(), time.Now().UTC(), b.ID)
+		if b.PolicyID != 0 {
+			finishBackupPolicyRun(b, err)
+		}
+		return nil, err
+	}
+	if b.PolicyID != 0 {
+		if err := finishBackupPolicyRun(b, nil); err != nil {
+			return result, fmt.Errorf("backup completed but applying the policy retention failed: %w", err)
+		}
+		return result, nil
+	}
+	if BackupRetentionCount > 0 {
+		if _, err := pruneBackups(b.EnvironmentID, b.DBName, 0, BackupRetentionCount, 0); err != nil {
+			return result, fmt.Errorf("backup completed but pruning old backups failed: %w", err)
+		}
+	}
+	return result, nil
+}
+
+func writeBackup(ctx context.Context, task *jobs.Task, b *models.Backup) (*backup.DumpResult, error) {
+	client, err := connectEnvironment(ctx, b.EnvironmentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to MongoDB: %w", err)
+	}
+	defer client.Disconnect(context.Background())
+
+	path := b.Path
+	if path == "" {
+		path = backupPath(b, BackupDir)
+	}
+	result, size, err := writeArchiveFile(ctx, client.Database(b.DBName), path, backup.DumpOptions{
+		Collections:     b.Collections,
+		ExcludePrefixes: []string{trashCollectionPrefix},
+		Gzip:            b.Compression == "gzip",
+		Progress: func(collection string, index, total int) {
+			task.SetProgress(index*100/total, "Dumping "+collection)
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	_, err = database.DB.Exec(
+		`UPDATE backups SET status = ?, path = ?, size_bytes = ?, error = '', completed_at = ? WHERE id = ?`,
+		models.BackupCompleted, path, size, time.Now().UTC(), b.ID,
+	)
+	return result, err
+}
+
+// writeArchiveFile dumps db to an archive file at path and returns the size of the file.
+// The archive is written to a temporary file first, so that an interrupted dump never looks complete.
+func writeArchiveFile(ctx context.Context, db *mongo.Database, path string, opts backup.DumpOptions) (*backup.DumpResult, int64, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return nil, 0, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	tmpPath := path + ".partial"
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o640)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create archive file: %w", err)
+	}
+
+	result, err := backup.Dump(ctx, db, f, opts)
+	if closeErr := f.Close(); err == nil && closeErr != nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return nil, 0, err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return nil, 0, fmt.Errorf("failed to finalize archive: %w", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	return result, info.Size(), nil
+}
+
+// pruneBackups deletes the oldest completed backups of a database beyond keepCount,
+// and those older than maxAge. Zero values disable the corresponding rule.
+// Only the backups of policyID are considered (0 for on-demand backups).
+// It returns the IDs of the deleted backups.
+func pruneBackups(envID int, dbName string, policyID int, keepCount int, maxAge time.Duration) ([]int, error) {
+	rows, err := database.DB.Query(
+		`SELECT `+backupColumns+` FROM backups
+		 WHERE environment_id = ? AND db_name = ? AND policy_id = ? AND status = ?
+		 ORDER BY created_at DESC, id DESC`,
+		envID, dbName, policyID, models.BackupCompleted,
+	)
+	if err != nil {
+		return nil, err
+	}
+	var list []*models.Backup
+	for rows.Next() {
+		b, err := scanBackup(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		list = append(list, b)
+	}
+	rows.Close()
+
+	var deleted []int
+	for i, b := range list {
+		tooMany := keepCount > 0 && i >= keepCount
+		tooOld := maxAge > 0 && time.Since(b.CreatedAt) > maxAge
+		if !tooMany && !tooOld {
+			continue
+		}
+		if err := deleteBackupFiles(b); err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, b.ID)
+	}
+	return deleted, nil
+}
+
+// deleteBackupFiles removes the archive of a backup and its record.
+func deleteBackupFiles(b *models.Backup) error {
+	if b.Path != "" {
+		if err := os.Remove(b.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to delete archive %s: %w", b.Path, err)
+		}
+	}
+	_, err := database.DB.Exec(`DELETE FROM backups WHERE id = ?`, b.ID)
+	return err
+}
+
+// runRestore restores a backup archive into an environment/database.
+func runRestore(ctx context.Context, task *jobs.Task) (interface{}, error) {
+	var params restoreParams
+	if err := task.DecodeParams(&params); err != nil {
+		return nil, err
+	}
+	b, err := loadBackup(params.BackupID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load backup %d: %w", params.BackupID, err)
+	}
+	if b.Status != models.BackupCompleted {
+		return nil, errors.New("backup is not completed")
+	}
+
+	f, err := os.Open(b.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer f.Close()
+
+	client, err := connectEnvironment(ctx, params.EnvironmentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to MongoDB: %w", err)
+	}
+	defer client.Disconnect(context.Background())
+
+	return backup.Restore(ctx, client, f, backup.RestoreOptions{
+		TargetDb:    params.DbName,
+		Collections: params.Collections,
+		Drop:        params.Drop,
+		Progress: func(collection string, done, total int) {
+			task.SetProgress(done*100/total, "Restored "+collection)
+		},
+	})
+}
+
+// getAccessibleBackup loads a backup and checks that the current user can read its database.
+// It writes the error response itself and returns nil on failure.
+func getAccessibleBackup(c *gin.Context, currentUser models.User) *models.Backup {
+	backupID, err := strconv.Atoi(c.Param("backupId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid backup ID"})
+		return nil
+	}
+	b, err := loadBackup(backupID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Backup not found"})
+		return nil
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil
+	}
+	hasDBRead, err := middleware.HasDBPermission(currentUser, b.EnvironmentID, b.DBName, "read")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil
+	}
+	if !hasDBRead {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Backup not found"})
+		return nil
+	}
+	return b
+}
+
+// CreateBackup starts an on-demand backup of a database (or some of its collections).
+// It requires the export_data capability and write access to the database; a user may
+// have maxPendingBackupsPerUser backups pending at once.
+// Body: { "collections": ["a", "b"], "compression": "gzip"|"none" }
+func CreateBackup(c *gin.Context) {
+	envIDStr := c.Param("id")
+	dbName := c.Param("dbName")
+	envID, err := strconv.Atoi(envIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid environment ID"})
+		return
+	}
+
+	var env models.Environment
+	row := database.DB.QueryRow(`SELECT id, name, connection_string, created_by FROM environments WHERE id = ?`, envID)
+	if err := row.Scan(&env.ID, &env.Name, &env.ConnectionString, &env.CreatedBy); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Environment not found"})
+		return
+	}
+
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+	if !middleware.HasCapability(currentUser, models.CapExportData) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Backing up databases requires the export_data capability"})
+		return
+	}
+	// Archives take server storage: creating them requires write access, not just read.
+	hasDBWrite, err := middleware.HasDBPermission(currentUser, envID, dbName, "write")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !hasDBWrite {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No permission to write in this database"})
+		return
+	}
+	var pending int
+	err = database.DB.QueryRow(
+		`SELECT COUNT(*) FROM backups WHERE created_by = ? AND policy_id = 0 AND status = ?`,
+		currentUser.ID, models.BackupPending,
+	).Scan(&pending)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if pending >= maxPendingBackupsPerUser {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": fmt.Sprintf("You already have %d backups in progress", pending)})
+		return
+	}
+
+	var req struct {
+		Collections []string `json:"collections"`
+		Compression string   `json:"compression"`
+	}
+	// The body is optional.
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if !requireVisibleCollections(c, req.Collections...) {
+		return
+	}
+	if !requireUnmaskedExport(c, currentUser, envID, dbName, req.Collections) {
+		return
+	}
+	if req.Compression == "" {
+		req.Compression = "gzip"
+	}
+	if req.Compression != "gzip" && req.Compression != "none" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "compression must be 'gzip' or 'none'"})
+		return
+	}
+
+	b, err := insertBackup(envID, dbName, req.Collections, req.Compression, currentUser.ID, 0, "")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start backup: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "Backup started",
+		"backup":  b,
+		"jobId":   b.JobID,
+	})
+}
+
+// ListBackups lists the backups of an environment, newest first.
+// Query params: db (optional) restricts the list to one database.
+func ListBackups(c *gin.Context) {
+	envIDStr := c.Param("id")
+	envID, err := strconv.Atoi(envIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid environment ID"})
+		return
+	}
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+	hasEnvRead, err := middleware.HasEnvPermission(currentUser, envID, "read")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !hasEnvRead {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No permission on environment"})
+		return
+	}
+
+	query := `SELECT ` + backupColumns + ` FROM backups WHERE environment_id = ?`
+	params := []interface{}{envID}
+	if db := c.Query("db"); db != "" {
+		query += ` AND db_name = ?`
+		params = append(params, db)
+	}
+	query += ` ORDER BY id DESC`
+	rows, err := database.DB.Query(query, params...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	backups := []models.Backup{}
+	var totalSize int64
+	for rows.Next() {
+		b, err := scanBackup(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		hasDBRead, err := middleware.HasDBPermission(currentUser, envID, b.DBName, "read")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !hasDBRead {
+			continue
+		}
+		backups = append(backups, *b)
+		totalSize += b.SizeBytes
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"backups":   backups,
+		"totalSize": totalSize,
+	})
+}
+
+// GetBackup returns a single backup.
+func GetBackup(c *gin.Context) {
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+	b := getAccessibleBackup(c, currentUser)
+	if b == nil {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"backup": b})
+}
+
+// DownloadBackup streams the archive file of a completed backup.
+// It can be restored with `mongorestore --archive=<file> [--gzip]`.
+func DownloadBackup(c *gin.Context) {
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+	if !middleware.HasCapability(currentUser, models.CapExportData) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Downloading backups requires the export_data capability"})
+		return
+	}
+	b := getAccessibleBackup(c, currentUser)
+	if b == nil {
+		return
+	}
+	if b.Status != models.BackupCompleted {
+		c.JSON(http.StatusConflict, gin.H{"error": "Backup is not completed"})
+		return
+	}
+	if !requireUnmaskedExport(c, currentUser, b.EnvironmentID, b.DBName, b.Collections) {
+		return
+	}
+	c.FileAttachment(b.Path, filepath.Base(b.Path))
+}
+
+// DeleteBackup deletes a backup and its archive.
+func DeleteBackup(c *gin.Context) {
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+	b := getAccessibleBackup(c, currentUser)
+	if b == nil {
+		return
+	}
+	hasEnvWrite, err := middleware.HasEnvPermission(currentUser, b.EnvironmentID, "write")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !hasEnvWrite {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No permission to delete backups on this environment"})
+		return
+	}
+	if b.Status == models.BackupPending {
+		c.JSON(http.StatusConflict, gin.H{"error": "Backup is still running"})
+		return
+	}
+	if err := deleteBackupFiles(b); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Backup deleted successfully"})
+}
+
+// RestoreBackup restores a backup into the same or another environment/database.
+// Body: { "environmentId": 2, "dbName": "target", "collections": [], "drop": false }
+// environmentId and dbName default to the backup's own environment and database.
+func RestoreBackup(c *gin.Context) {
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+	// A restore copies the archive as is, like a download: the target would read unmasked.
+	if !middleware.HasCapability(currentUser, models.CapExportData) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Restoring backups requires the export_data capability"})
+		return
+	}
+	b := getAccessibleBackup(c, currentUser)
+	if b == nil {
+		return
+	}
+	if b.Status != models.BackupCompleted {
+		c.JSON(http.StatusConflict, gin.H{"error": "Backup is not completed"})
+		return
+	}
+	if !requireUnmaskedExport(c, currentUser, b.EnvironmentID, b.DBName, b.Collections) {
+		return
+	}
+
+	var req restoreParams
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	req.BackupID = b.ID
+	if req.EnvironmentID == 0 {
+		req.EnvironmentID = b.EnvironmentID
+	}
+	if req.DbName == "" {
+		req.DbName = b.DBName
+	}
+
+	var exists int
+	if err := database.DB.QueryRow(`SELECT COUNT(*) FROM environments WHERE id = ?`, req.EnvironmentID).Scan(&exists); err != nil || exists == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Target environment not found"})
+		return
+	}
+	hasDBWrite, err := middleware.HasDBPermission(currentUser, req.EnvironmentID, req.DbName, "write")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !hasDBWrite {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No permission to write in the target database"})
+		return
+	}
+	if req.Drop && !requireDestructiveConfirmation(c, req.EnvironmentID, actionRestoreDrop, restoreDropTarget(req.DbName, b.ID)) {
+		return
+	}
+
+	jobID, err := jobs.Submit(jobTypeRestore, req.EnvironmentID, currentUser.ID, req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start restore: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":       "Restore started",
+		"jobId":         jobID,
+		"backupId":      b.ID,
+		"environmentId": req.EnvironmentID,
+		"dbName":        req.DbName,
+	})
+}
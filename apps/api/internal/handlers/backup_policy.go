@@ -0,0 +1,571 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"monji/internal/database"
+	"monji/internal/jobs"
+	"monji/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/robfig/cron/v3"
+)
+
+// schedulerInterval is how often due backup policies are looked for.
+// Schedules have a one-minute resolution.
+const schedulerInterval = 30 * time.Second
+
+const backupPolicyColumns = `id, name, environment_id, db_name, collections, schedule, target_path, retention_count,
+	retention_days, compression, enabled, created_by, created_at, last_run_at, next_run_at, deleted_at`
+
+const backupPolicyRunColumns = `id, policy_id, backup_id, job_id, status, error, pruned, started_at, finished_at`
+
+// backupPolicyRequest is the body of the create and update endpoints.
+type backupPolicyRequest struct {
+	Name           string   `json:"name"`
+	EnvironmentID  int      `json:"environmentId"`
+	DbName         string   `json:"dbName"`
+	Collections    []string `json:"collections"`
+	Schedule       string   `json:"schedule"`
+	TargetPath     string   `json:"targetPath"`
+	RetentionCount int      `json:"retentionCount"`
+	RetentionDays  int      `json:"retentionDays"`
+	Compression    string   `json:"compression"`
+	Enabled        *bool    `json:"enabled"`
+}
+
+func scanBackupPolicy(s interface{ Scan(...interface{}) error }) (*models.BackupPolicy, error) {
+	var p models.BackupPolicy
+	var collections string
+	var lastRunAt, nextRunAt, deletedAt sql.NullTime
+	err := s.Scan(&p.ID, &p.Name, &p.EnvironmentID, &p.DBName, &collections, &p.Schedule, &p.TargetPath,
+		&p.RetentionCount, &p.RetentionDays, &p.Compression, &p.Enabled, &p.CreatedBy, &p.CreatedAt,
+		&lastRunAt, &nextRunAt, &deletedAt)
+	if err != nil {
+		return nil, err
+	}
+	p.Collections = []string{}
+	if err := json.Unmarshal([]byte(collections), &p.Collections); err != nil {
+		return nil, fmt.Errorf("invalid collections of backup policy %d: %w", p.ID, err)
+	}
+	if lastRunAt.Valid {
+		p.LastRunAt = &lastRunAt.Time
+	}
+	if nextRunAt.Valid {
+		p.NextRunAt = &nextRunAt.Time
+	}
+	if deletedAt.Valid {
+		p.DeletedAt = &deletedAt.Time
+	}
+	return &p, nil
+}
+
+func loadBackupPolicy(id int) (*models.BackupPolicy, error) {
+	return scanBackupPolicy(database.DB.QueryRow(`SELECT `+backupPolicyColumns+` FROM backup_policies WHERE id = ?`, id))
+}
+
+func scanBackupPolicyRun(s interface{ Scan(...interface{}) error }) (*models.BackupPolicyRun, error) {
+	var r models.BackupPolicyRun
+	var finishedAt sql.NullTime
+	err := s.Scan(&r.ID, &r.PolicyID, &r.BackupID, &r.JobID, &r.Status, &r.Error, &r.Pruned, &r.StartedAt, &finishedAt)
+	if err != nil {
+		return nil, err
+	}
+	if finishedAt.Valid {
+		r.FinishedAt = &finishedAt.Time
+	}
+	return &r, nil
+}
+
+// nextPolicyRun returns the first time after from matching a cron schedule, in UTC.
+func nextPolicyRun(schedule string, from time.Time) (time.Time, error) {
+	sched, err := cron.ParseStandard(schedule)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return sched.Next(from.UTC()), nil
+}
+
+// StartBackupScheduler runs the enabled backup policies when they are due, until ctx is done.
+func StartBackupScheduler(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(schedulerInterval)
+		defer ticker.Stop()
+		for {
+			runDueBackupPolicies()
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func runDueBackupPolicies() {
+	reconcileBackupPolicyRuns()
+
+	now := time.Now().UTC()
+	rows, err := database.DB.Query(
+		`SELECT `+backupPolicyColumns+` FROM backup_policies
+		 WHERE enabled = 1 AND deleted_at IS NULL AND next_run_at IS NOT NULL AND next_run_at <= ?`, now)
+	if err != nil {
+		log.Printf("backup scheduler: failed to load due policies: %v", err)
+		return
+	}
+	var due []*models.BackupPolicy
+	for rows.Next() {
+		p, err := scanBackupPolicy(rows)
+		if err != nil {
+			log.Printf("backup scheduler: %v", err)
+			continue
+		}
+		due = append(due, p)
+	}
+	rows.Close()
+
+	for _, p := range due {
+		if _, err := startBackupPolicyRun(p); err != nil {
+			log.Printf("backup scheduler: policy %d: %v", p.ID, err)
+		}
+		next, err := nextPolicyRun(p.Schedule, now)
+		if err != nil {
+			log.Printf("backup scheduler: policy %d has an invalid schedule: %v", p.ID, err)
+			continue
+		}
+		database.DB.Exec(`UPDATE backup_policies SET next_run_at = ? WHERE id = ?`, next, p.ID)
+	}
+}
+
+// startBackupPolicyRun starts a backup for a policy and records the run.
+// The run is recorded as pending together with its backup before the backup job is
+// submitted, so the job always finds the run to report to.
+// If the previous run is still in progress, a skipped run is recorded instead.
+func startBackupPolicyRun(p *models.BackupPolicy) (*models.BackupPolicyRun, error) {
+	now := time.Now().UTC()
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var inProgress int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM backup_policy_runs WHERE policy_id = ? AND status IN (?, ?)`,
+		p.ID, models.PolicyRunPending, models.PolicyRunRunning).Scan(&inProgress); err != nil {
+		return nil, err
+	}
+
+	var res sql.Result
+	backupID := 0
+	if inProgress > 0 {
+		res, err = tx.Exec(
+			`INSERT INTO backup_policy_runs (policy_id, status, error, started_at, finished_at) VALUES (?, ?, ?, ?, ?)`,
+			p.ID, models.PolicyRunSkipped, "previous run is still in progress", now, now)
+	} else {
+		backupID, err = recordBackup(tx, p.EnvironmentID, p.DBName, p.Collections, p.Compression, p.CreatedBy, p.ID, p.TargetPath)
+		if err != nil {
+			return nil, err
+		}
+		res, err = tx.Exec(
+			`INSERT INTO backup_policy_runs (policy_id, backup_id, status, started_at) VALUES (?, ?, ?, ?)`,
+			p.ID, backupID, models.PolicyRunPending, now)
+	}
+	if err != nil {
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`UPDATE backup_policies SET last_run_at = ? WHERE id = ?`, now, p.ID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	if backupID != 0 {
+		jobID, err := submitBackup(backupID)
+		if err != nil {
+			_, err = database.DB.Exec(`UPDATE backup_policy_runs SET status = ?, error = ?, finished_at = ? WHERE id = ?`,
+				models.PolicyRunFailed, "failed to start backup: "+err.Error(), time.Now().UTC(), id)
+		} else {
+			// The job may already have finished and recorded the outcome of the run.
+			_, err = database.DB.Exec(
+				`UPDATE backup_policy_runs SET job_id = ?, status = CASE WHEN status = ? THEN ? ELSE status END WHERE id = ?`,
+				jobID, models.PolicyRunPending, models.PolicyRunRunning, id)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return scanBackupPolicyRun(database.DB.QueryRow(`SELECT `+backupPolicyRunColumns+` FROM backup_policy_runs WHERE id = ?`, id))
+}
+
+// finishBackupPolicyRun records the outcome of a policy backup and, when it succeeded,
+// deletes the backups of the policy that are beyond its retention.
+func finishBackupPolicyRun(b *models.Backup, backupErr error) error {
+	now := time.Now().UTC()
+	if backupErr != nil {
+		_, err := database.DB.Exec(`UPDATE backup_policy_runs SET status = ?, error = ?, finished_at = ? WHERE backup_id = ?`,
+			models.PolicyRunFailed, backupErr.Error(), now, b.ID)
+		return err
+	}
+
+	var pruned []int
+	var pruneErr error
+	p, err := loadBackupPolicy(b.PolicyID)
+	if err != nil && err != sql.ErrNoRows {
+		pruneErr = err
+	}
+	// Deleted policies keep their backups.
+	if p != nil && p.DeletedAt == nil {
+		maxAge := time.Duration(p.RetentionDays) * 24 * time.Hour
+		pruned, pruneErr = pruneBackups(b.EnvironmentID, b.DBName, p.ID, p.RetentionCount, maxAge)
+	}
+	status, errMsg := models.PolicyRunSucceeded, ""
+	if pruneErr != nil {
+		status, errMsg = models.PolicyRunFailed, "backup completed but retention failed: "+pruneErr.Error()
+	}
+	if _, err := database.DB.Exec(`UPDATE backup_policy_runs SET status = ?, error = ?, pruned = ?, finished_at = ? WHERE backup_id = ?`,
+		status, errMsg, len(pruned), now, b.ID); err != nil {
+		return err
+	}
+	return pruneErr
+}
+
+// pendingRunTimeout is how long a policy run may stay pending before its backup job is
+// considered never submitted (the server stopped in between).
+const pendingRunTimeout = time.Minute
+
+// reconcileBackupPolicyRuns fails the runs whose job ended without reporting back
+// (cancelled, or lost with its backup record) or was never submitted.
+func reconcileBackupPolicyRuns() {
+	now := time.Now().UTC()
+	cutoff := now.Add(-pendingRunTimeout)
+	const notSubmitted = "backup job was never submitted"
+	if _, err := database.DB.Exec(
+		`UPDATE backups SET status = ?, error = ? WHERE status = ? AND job_id = 0 AND id IN
+		 (SELECT backup_id FROM backup_policy_runs WHERE status = ? AND started_at < ?)`,
+		models.BackupFailed, notSubmitted, models.BackupPending, models.PolicyRunPending, cutoff); err != nil {
+		log.Printf("backup scheduler: failed to reconcile pending backups: %v", err)
+	}
+	if _, err := database.DB.Exec(
+		`UPDATE backup_policy_runs SET status = ?, error = ?, finished_at = ? WHERE status = ? AND started_at < ?`,
+		models.PolicyRunFailed, notSubmitted, now, models.PolicyRunPending, cutoff); err != nil {
+		log.Printf("backup scheduler: failed to reconcile pending policy runs: %v", err)
+	}
+
+	rows, err := database.DB.Query(`SELECT `+backupPolicyRunColumns+` FROM backup_policy_runs WHERE status = ?`, models.PolicyRunRunning)
+	if err != nil {
+		log.Printf("backup scheduler: failed to load running policy runs: %v", err)
+		return
+	}
+	var running []*models.BackupPolicyRun
+	for rows.Next() {
+		r, err := scanBackupPolicyRun(rows)
+		if err != nil {
+			log.Printf("backup scheduler: %v", err)
+			continue
+		}
+		running = append(running, r)
+	}
+	rows.Close()
+
+	for _, r := range running {
+		errMsg := ""
+		job, err := jobs.Get(r.JobID)
+		switch {
+		case err == jobs.ErrNotFound:
+			errMsg = "backup job not found"
+		case err != nil:
+			continue
+		case job.Status == models.JobCancelled:
+			errMsg = "backup job was cancelled"
+		case job.Finished():
+			// The job has finished but the run was not updated, e.g. the backup record was deleted.
+			if _, err := loadBackup(r.BackupID); err == sql.ErrNoRows {
+				errMsg = "backup record not found"
+			} else if job.Status == models.JobFailed {
+				errMsg = job.Error
+			}
+		}
+		if errMsg == "" {
+			continue
+		}
+		database.DB.Exec(`UPDATE backup_policy_runs SET status = ?, error = ?, finished_at = ? WHERE id = ?`,
+			models.PolicyRunFailed, errMsg, time.Now().UTC(), r.ID)
+	}
+}
+
+// validateBackupPolicy fills in defaults and checks a policy request.
+// It returns a client error message, or "" if the request is valid.
+func validateBackupPolicy(req *backupPolicyRequest) string {
+	if req.Name == "" {
+		return "name is required"
+	}
+	if req.DbName == "" {
+		return "dbName is required"
+	}
+	var exists int
+	if err := database.DB.QueryRow(`SELECT COUNT(*) FROM environments WHERE id = ?`, req.EnvironmentID).Scan(&exists); err != nil || exists == 0 {
+		return "Environment not found"
+	}
+	if _, err := cron.ParseStandard(req.Schedule); err != nil {
+		return "Invalid schedule: " + err.Error()
+	}
+	if req.Compression == "" {
+		req.Compression = "gzip"
+	}
+	if req.Compression != "gzip" && req.Compression != "none" {
+		return "compression must be 'gzip' or 'none'"
+	}
+	if req.RetentionCount < 0 || req.RetentionDays < 0 {
+		return "retentionCount and retentionDays must not be negative"
+	}
+	if req.TargetPath != "" {
+		req.TargetPath = filepath.Clean(req.TargetPath)
+	}
+	if req.Collections == nil {
+		req.Collections = []string{}
+	}
+	if err := checkVisibleCollections(req.Collections...); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// getBackupPolicyParam loads the policy named by the :policyId route parameter.
+// Deleted policies are only found when includeDeleted is set.
+// It writes the error response itself and returns nil on failure.
+func getBackupPolicyParam(c *gin.Context, includeDeleted bool) *models.BackupPolicy {
+	policyID, err := strconv.Atoi(c.Param("policyId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid policy ID"})
+		return nil
+	}
+	p, err := loadBackupPolicy(policyID)
+	if err == sql.ErrNoRows || (err == nil && p.DeletedAt != nil && !includeDeleted) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Backup policy not found"})
+		return nil
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil
+	}
+	return p
+}
+
+// ListBackupPolicies returns all backup policies.
+// Query params: environmentId (optional).
+func ListBackupPolicies(c *gin.Context) {
+	query := `SELECT ` + backupPolicyColumns + ` FROM backup_policies WHERE deleted_at IS NULL`
+	var params []interface{}
+	if envIDStr := c.Query("environmentId"); envIDStr != "" {
+		envID, err := strconv.Atoi(envIDStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid environment ID"})
+			return
+		}
+		query += ` AND environment_id = ?`
+		params = append(params, envID)
+	}
+	query += ` ORDER BY id`
+	rows, err := database.DB.Query(query, params...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	policies := []models.BackupPolicy{}
+	for rows.Next() {
+		p, err := scanBackupPolicy(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		policies = append(policies, *p)
+	}
+	c.JSON(http.StatusOK, gin.H{"policies": policies})
+}
+
+// CreateBackupPolicy creates a scheduled backup policy.
+// Body: { "name": "nightly", "environmentId": 1, "dbName": "app", "collections": [],
+// "schedule": "0 2 * * *", "targetPath": "", "retentionCount": 7, "retentionDays": 30,
+// "compression": "gzip", "enabled": true }
+// Schedules are standard 5-field cron expressions (or @daily, @every 6h...) evaluated in UTC.
+func CreateBackupPolicy(c *gin.Context) {
+	var req backupPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if msg := validateBackupPolicy(&req); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	enabled := req.Enabled == nil || *req.Enabled
+
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+	now := time.Now().UTC()
+	next, _ := nextPolicyRun(req.Schedule, now)
+	collectionsJSON, _ := json.Marshal(req.Collections)
+
+	res, err := database.DB.Exec(
+		`INSERT INTO backup_policies (name, environment_id, db_name, collections, schedule, target_path,
+		 retention_count, retention_days, compression, enabled, created_by, created_at, next_run_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		req.Name, req.EnvironmentID, req.DbName, string(collectionsJSON), req.Schedule, req.TargetPath,
+		req.RetentionCount, req.RetentionDays, req.Compression, enabled, currentUser.ID, now, next,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create backup policy: " + err.Error()})
+		return
+	}
+	id, _ := res.LastInsertId()
+	p, err := loadBackupPolicy(int(id))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"policy": p})
+}
+
+// GetBackupPolicy returns a backup policy and its most recent run.
+func GetBackupPolicy(c *gin.Context) {
+	p := getBackupPolicyParam(c, false)
+	if p == nil {
+		return
+	}
+	lastRun, err := scanBackupPolicyRun(database.DB.QueryRow(
+		`SELECT `+backupPolicyRunColumns+` FROM backup_policy_runs WHERE policy_id = ? ORDER BY id DESC LIMIT 1`, p.ID))
+	if err != nil && err != sql.ErrNoRows {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"policy": p, "lastRun": lastRun})
+}
+
+// UpdateBackupPolicy replaces the settings of a backup policy.
+// The body has the same fields as CreateBackupPolicy.
+func UpdateBackupPolicy(c *gin.Context) {
+	p := getBackupPolicyParam(c, false)
+	if p == nil {
+		return
+	}
+	var req backupPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if msg := validateBackupPolicy(&req); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	enabled := req.Enabled == nil || *req.Enabled
+	next, _ := nextPolicyRun(req.Schedule, time.Now().UTC())
+	collectionsJSON, _ := json.Marshal(req.Collections)
+
+	_, err := database.DB.Exec(
+		`UPDATE backup_policies SET name = ?, environment_id = ?, db_name = ?, collections = ?, schedule = ?,
+		 target_path = ?, retention_count = ?, retention_days = ?, compression = ?, enabled = ?, next_run_at = ?
+		 WHERE id = ?`,
+		req.Name, req.EnvironmentID, req.DbName, string(collectionsJSON), req.Schedule, req.TargetPath,
+		req.RetentionCount, req.RetentionDays, req.Compression, enabled, next, p.ID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update backup policy: " + err.Error()})
+		return
+	}
+	p, err = loadBackupPolicy(p.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"policy": p})
+}
+
+// DeleteBackupPolicy deletes a backup policy. The policy is soft-deleted: it no longer
+// runs or appears in the list, but its run history is kept (see ListBackupPolicyRuns).
+// The backups it created are kept and can still be listed, restored or deleted.
+func DeleteBackupPolicy(c *gin.Context) {
+	p := getBackupPolicyParam(c, false)
+	if p == nil {
+		return
+	}
+	if _, err := database.DB.Exec(`UPDATE backup_policies SET deleted_at = ?, enabled = 0, next_run_at = NULL WHERE id = ?`,
+		time.Now().UTC(), p.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Backup policy deleted successfully"})
+}
+
+// ListBackupPolicyRuns returns the runs of a backup policy, newest first.
+// The runs of deleted policies remain available.
+// Query params: status (optional), limit (default 50, max 500).
+func ListBackupPolicyRuns(c *gin.Context) {
+	p := getBackupPolicyParam(c, true)
+	if p == nil {
+		return
+	}
+	limit := 50
+	if limitStr := c.Query("limit"); limitStr != "" {
+		l, err := strconv.Atoi(limitStr)
+		if err != nil || l < 1 || l > 500 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 500"})
+			return
+		}
+		limit = l
+	}
+
+	query := `SELECT ` + backupPolicyRunColumns + ` FROM backup_policy_runs WHERE policy_id = ?`
+	params := []interface{}{p.ID}
+	if status := c.Query("status"); status != "" {
+		query += ` AND status = ?`
+		params = append(params, status)
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	params = append(params, limit)
+	rows, err := database.DB.Query(query, params...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	runs := []models.BackupPolicyRun{}
+	for rows.Next() {
+		r, err := scanBackupPolicyRun(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		runs = append(runs, *r)
+	}
+	c.JSON(http.StatusOK, gin.H{"runs": runs})
+}
+
+// RunBackupPolicy runs a backup policy now, outside of its schedule.
+func RunBackupPolicy(c *gin.Context) {
+	p := getBackupPolicyParam(c, false)
+	if p == nil {
+		return
+	}
+	run, err := startBackupPolicyRun(p)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to run backup policy: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"message": "Backup policy run started", "run": run})
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"monji/internal/database"
+	"monji/internal/models"
+)
+
+func TestNextPolicyRun(t *testing.T) {
+	from := time.Date(2024, 3, 9, 14, 30, 0, 0, time.UTC) // a Saturday
+	paris := time.FixedZone("Paris", 3600)
+	tests := []struct {
+		schedule string
+		from     time.Time
+		want     time.Time
+	}{
+		{"0 2 * * *", from, time.Date(2024, 3, 10, 2, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", from, time.Date(2024, 3, 9, 14, 45, 0, 0, time.UTC)},
+		{"30 14 * * *", from, time.Date(2024, 3, 10, 14, 30, 0, 0, time.UTC)},
+		{"0 0 * * 1", from, time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", from, time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"@daily", from, time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)},
+		{"@every 6h", from, from.Add(6 * time.Hour)},
+		// Schedules are evaluated in UTC whatever the zone of from.
+		{"0 2 * * *", from.In(paris), time.Date(2024, 3, 10, 2, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := nextPolicyRun(tt.schedule, tt.from)
+		if err != nil {
+			t.Errorf("nextPolicyRun(%q) error: %v", tt.schedule, err)
+			continue
+		}
+		if !got.Equal(tt.want) || got.Location() != time.UTC {
+			t.Errorf("nextPolicyRun(%q, %v) = %v, want %v", tt.schedule, tt.from, got, tt.want)
+		}
+	}
+
+	for _, schedule := range []string{"", "0 2 * *", "61 * * * *", "@sometimes"} {
+		if _, err := nextPolicyRun(schedule, from); err == nil {
+			t.Errorf("nextPolicyRun(%q) succeeded, want an error", schedule)
+		}
+	}
+}
+
+func TestPruneBackups(t *testing.T) {
+	initTestDB(t)
+	dir := t.TempDir()
+	now := time.Now().UTC()
+	day := 24 * time.Hour
+
+	type backup struct {
+		id       int
+		policyID int
+		dbName   string
+		status   string
+		age      time.Duration
+	}
+	backups := []backup{
+		{1, 1, "shop", models.BackupCompleted, 0},
+		{2, 1, "shop", models.BackupCompleted, 1 * day},
+		{3, 1, "shop", models.BackupCompleted, 2 * day},
+		{4, 1, "shop", models.BackupCompleted, 10 * day},
+		{5, 1, "shop", models.BackupFailed, 20 * day},
+		{6, 1, "other", models.BackupCompleted, 20 * day},
+		{7, 2, "shop", models.BackupCompleted, 20 * day},
+		{8, 0, "shop", models.BackupCompleted, 20 * day},
+	}
+	reset := func() {
+		if _, err := database.DB.Exec(`DELETE FROM backups`); err != nil {
+			t.Fatal(err)
+		}
+		for _, b := range backups {
+			path := filepath.Join(dir, fmt.Sprintf("%d.archive", b.id))
+			if err := os.WriteFile(path, nil, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			_, err := database.DB.Exec(
+				`INSERT INTO backups (id, environment_id, db_name, path, compression, status, policy_id, created_by, created_at)
+				 VALUES (?, 1, ?, ?, 'gzip', ?, ?, 1, ?)`,
+				b.id, b.dbName, path, b.status, b.policyID, now.Add(-b.age),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	tests := []struct {
+		name      string
+		keepCount int
+		maxAge    time.Duration
+		want      []int
+	}{
+		{"no retention", 0, 0, nil},
+		{"count", 2, 0, []int{3, 4}},
+		{"age", 0, 5 * day, []int{4}},
+		{"count and age", 3, 36 * time.Hour, []int{3, 4}},
+		{"count above the number of backups", 10, 0, nil},
+	}
+	for _, tt := range tests {
+		reset()
+		got, err := pruneBackups(1, "shop", 1, tt.keepCount, tt.maxAge)
+		if err != nil {
+			t.Errorf("%s: pruneBackups() error: %v", tt.name, err)
+			continue
+		}
+		sort.Ints(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: pruneBackups() = %v, want %v", tt.name, got, tt.want)
+		}
+		for _, id := range got {
+			var n int
+			database.DB.QueryRow(`SELECT COUNT(*) FROM backups WHERE id = ?`, id).Scan(&n)
+			if n != 0 {
+				t.Errorf("%s: backup %d still recorded", tt.name, id)
+			}
+		}
+		entries, _ := os.ReadDir(dir)
+		if len(entries) != len(backups)-len(got) {
+			t.Errorf("%s: %d archives left, want %d", tt.name, len(entries), len(backups)-len(got))
+		}
+	}
+}
@@ -0,0 +1,884 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"monji/internal/database"
+	"monji/internal/middleware"
+	"monji/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// changeRequestPayload is the extended JSON payload of a change request.
+// Which fields are used depends on the kind:
+//   - insertDocument: document
+//   - updateDocument: documentId, document (fields to $set, dot notation allowed)
+//   - deleteDocument: documentId
+//   - updateMany: filter, update (update operators)
+//   - createIndex: keys, options (unique, sparse, expireAfterSeconds...), name (optional)
+//   - dropIndex: name
+type changeRequestPayload struct {
+	DocumentID string `bson:"documentId,omitempty"`
+	Document   bson.M `bson:"document,omitempty"`
+	Filter     bson.M `bson:"filter,omitempty"`
+	Update     bson.M `bson:"update,omitempty"`
+	Keys       bson.D `bson:"keys,omitempty"`
+	Options    bson.M `bson:"options,omitempty"`
+	Name       string `bson:"name,omitempty"`
+}
+
+// sampleSize is the number of matched documents shown in the preview of an updateMany.
+const sampleSize = 5
+
+// errStaleChangeRequest is returned when the target document changed since the request was made.
+var errStaleChangeRequest = errors.New("the document changed since the change request was made")
+
+const changeRequestColumns = `id, environment_id, db_name, collection_name, kind, title, description, payload,
+	base_document, status, requested_by, reviewed_by, review_comment, result, error, created_at, updated_at, applied_at`
+
+func scanChangeRequest(s interface{ Scan(...interface{}) error }) (*models.ChangeRequest, error) {
+	var cr models.ChangeRequest
+	var payload, baseDocument, result string
+	var appliedAt sql.NullTime
+	err := s.Scan(&cr.ID, &cr.EnvironmentID, &cr.DBName, &cr.CollectionName, &cr.Kind, &cr.Title, &cr.Description,
+		&payload, &baseDocument, &cr.Status, &cr.RequestedBy, &cr.ReviewedBy, &cr.ReviewComment, &result, &cr.Error,
+		&cr.CreatedAt, &cr.UpdatedAt, &appliedAt)
+	if err != nil {
+		return nil, err
+	}
+	cr.Payload = json.RawMessage(payload)
+	if baseDocument != "" {
+		cr.BaseDocument = json.RawMessage(baseDocument)
+	}
+	if result != "" {
+		cr.Result = json.RawMessage(result)
+	}
+	if appliedAt.Valid {
+		cr.AppliedAt = &appliedAt.Time
+	}
+	return &cr, nil
+}
+
+func loadChangeRequest(id int) (*models.ChangeRequest, error) {
+	return scanChangeRequest(database.DB.QueryRow(`SELECT `+changeRequestColumns+` FROM change_requests WHERE id = ?`, id))
+}
+
+// recordChangeRequestEvent appends an event to the lifecycle log of a change request.
+func recordChangeRequestEvent(changeRequestID int, event string, userID int, details string) error {
+	_, err := database.DB.Exec(
+		`INSERT INTO change_request_events (change_request_id, event, user_id, details, created_at) VALUES (?, ?, ?, ?, ?)`,
+		changeRequestID, event, userID, details, time.Now().UTC(),
+	)
+	return err
+}
+
+// validateChangeRequestPayload checks that the payload has what its kind needs.
+// It returns a client error message, or "" if the payload is valid.
+func validateChangeRequestPayload(kind string, p *changeRequestPayload) string {
+	switch kind {
+	case models.ChangeInsertDocument:
+		if len(p.Document) == 0 {
+			return "payload.document is required"
+		}
+	case models.ChangeUpdateDocument:
+		if p.DocumentID == "" || len(p.Document) == 0 {
+			return "payload.documentId and payload.document are required"
+		}
+		if _, ok := p.Document["_id"]; ok {
+			return "_id cannot be changed"
+		}
+	case models.ChangeDeleteDocument:
+		if p.DocumentID == "" {
+			return "payload.documentId is required"
+		}
+	case models.ChangeUpdateMany:
+		if len(p.Update) == 0 {
+			return "payload.update is required"
+		}
+		for op := range p.Update {
+			if !strings.HasPrefix(op, "$") {
+				return "payload.update must only contain update operators such as $set"
+			}
+		}
+	case models.ChangeCreateIndex:
+		if len(p.Keys) == 0 {
+			return "payload.keys is required"
+		}
+	case models.ChangeDropIndex:
+		if p.Name == "" {
+			return "payload.name is required"
+		}
+		if p.Name == "_id_" {
+			return "the _id index cannot be dropped"
+		}
+	default:
+		return "kind must be one of insertDocument, updateDocument, deleteDocument, updateMany, createIndex, dropIndex"
+	}
+	return ""
+}
+
+func decodeChangeRequestPayload(cr *models.ChangeRequest) (*changeRequestPayload, error) {
+	var p changeRequestPayload
+	if err := bson.UnmarshalExtJSON(cr.Payload, false, &p); err != nil {
+		return nil, fmt.Errorf("invalid payload: %w", err)
+	}
+	return &p, nil
+}
+
+// indexSpec returns the createIndexes specification of a createIndex payload.
+// The name defaults to the one the server would generate (field_direction pairs).
+func indexSpec(p *changeRequestPayload) bson.D {
+	name := p.Name
+	if name == "" {
+		var parts []string
+		for _, k := range p.Keys {
+			parts = append(parts, fmt.Sprintf("%s_%v", k.Key, k.Value))
+		}
+		name = strings.Join(parts, "_")
+	}
+	spec := bson.D{{Key: "key", Value: p.Keys}, {Key: "name", Value: name}}
+	for k, v := range p.Options {
+		if k != "key" && k != "name" {
+			spec = append(spec, bson.E{Key: k, Value: v})
+		}
+	}
+	return spec
+}
+
+// loadTargetDocument returns the document targeted by a change request, both as
+// canonical extended JSON (stable field order) and decoded. It returns mongo.ErrNoDocuments
+// when the document does not exist.
+func loadTargetDocument(ctx context.Context, coll *mongo.Collection, docID string) (string, bson.M, error) {
+	raw, err := coll.FindOne(ctx, documentIDFilter(docID)).DecodeBytes()
+	if err != nil {
+		return "", nil, err
+	}
+	extJSON, err := bson.MarshalExtJSON(raw, true, false)
+	if err != nil {
+		return "", nil, err
+	}
+	var doc bson.M
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return "", nil, err
+	}
+	return string(extJSON), doc, nil
+}
+
+// previewChangeRequest computes what applying a change request would do, against the current data.
+// Document values are masked with redact (see documentRedactor).
+func previewChangeRequest(ctx context.Context, client *mongo.Client, cr *models.ChangeRequest, redact *redactor) (gin.H, error) {
+	p, err := decodeChangeRequestPayload(cr)
+	if err != nil {
+		return nil, err
+	}
+	coll := client.Database(cr.DBName).Collection(cr.CollectionName)
+	preview := gin.H{"kind": cr.Kind}
+
+	switch cr.Kind {
+	case models.ChangeInsertDocument:
+		changes := diffDocuments(nil, p.Document)
+		redact.Changes(changes)
+		preview["changes"] = changes
+	case models.ChangeUpdateDocument, models.ChangeDeleteDocument:
+		current, doc, err := loadTargetDocument(ctx, coll, p.DocumentID)
+		if err == mongo.ErrNoDocuments {
+			preview["documentMissing"] = true
+			preview["stale"] = true
+			return preview, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		preview["stale"] = current != string(cr.BaseDocument)
+		var changes []fieldChange
+		if cr.Kind == models.ChangeUpdateDocument {
+			changes = diffDocuments(doc, applySetFields(doc, p.Document))
+		} else {
+			changes = diffDocuments(doc, nil)
+		}
+		redact.Changes(changes)
+		preview["changes"] = changes
+	case models.ChangeUpdateMany:
+		filter := p.Filter
+		if filter == nil {
+			filter = bson.M{}
+		}
+		matched, err := coll.CountDocuments(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+		cursor, err := coll.Find(ctx, filter, options.Find().SetLimit(sampleSize))
+		if err != nil {
+			return nil, err
+		}
+		sample := []bson.M{}
+		if err := cursor.All(ctx, &sample); err != nil {
+			return nil, err
+		}
+		preview["matchedCount"] = matched
+		preview["sample"] = sample
+		if set, ok := asDocument(p.Update["$set"]); ok && len(p.Update) == 1 {
+			var changes [][]fieldChange
+			for _, doc := range sample {
+				docChanges := diffDocuments(doc, applySetFields(doc, set))
+				redact.Changes(docChanges)
+				changes = append(changes, docChanges)
+			}
+			preview["sampleChanges"] = changes
+		}
+		redact.Documents(sample)
+		redact.Update(p.Update)
+		preview["update"] = p.Update
+	case models.ChangeCreateIndex:
+		preview["index"] = indexSpec(p)
+	case models.ChangeDropIndex:
+		cursor, err := coll.Indexes().List(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var indexes []bson.M
+		if err := cursor.All(ctx, &indexes); err != nil {
+			return nil, err
+		}
+		preview["indexMissing"] = true
+		for _, idx := range indexes {
+			if idx["name"] == p.Name {
+				preview["index"] = idx
+				preview["indexMissing"] = false
+			}
+		}
+	}
+	return preview, nil
+}
+
+// redactChangeRequest masks the target document and the payload of a change request
+// as the user would read them in the collection.
+func redactChangeRequest(user models.User, cr *models.ChangeRequest) error {
+	redact, err := documentRedactor(user, cr.EnvironmentID, cr.DBName, cr.CollectionName)
+	if err != nil || redact == nil {
+		return err
+	}
+	if len(cr.BaseDocument) > 0 {
+		var doc bson.M
+		if err := bson.UnmarshalExtJSON(cr.BaseDocument, true, &doc); err != nil {
+			return err
+		}
+		redact.Document(doc)
+		if cr.BaseDocument, err = bson.MarshalExtJSON(doc, true, false); err != nil {
+			return err
+		}
+	}
+	var payload bson.M
+	if err := bson.UnmarshalExtJSON(cr.Payload, false, &payload); err != nil {
+		return err
+	}
+	if doc, ok := asDocument(payload["document"]); ok {
+		redact.Fields(doc)
+		payload["document"] = doc
+	}
+	if filter, ok := asDocument(payload["filter"]); ok {
+		redact.Filter(filter)
+		payload["filter"] = filter
+	}
+	if update, ok := asDocument(payload["update"]); ok {
+		redact.Update(update)
+		payload["update"] = update
+	}
+	cr.Payload, err = bson.MarshalExtJSON(payload, false, false)
+	return err
+}
+
+// applyChangeRequest runs the write of a change request.
+// Document changes are refused with errStaleChangeRequest if the document changed since
+// the request was made, unless force is set.
+func applyChangeRequest(ctx context.Context, client *mongo.Client, cr *models.ChangeRequest, force bool) (gin.H, error) {
+	p, err := decodeChangeRequestPayload(cr)
+	if err != nil {
+		return nil, err
+	}
+	coll := client.Database(cr.DBName).Collection(cr.CollectionName)
+	if err := checkWritableCollection(ctx, coll.Database(), cr.CollectionName); err != nil {
+		return nil, err
+	}
+
+	if (cr.Kind == models.ChangeUpdateDocument || cr.Kind == models.ChangeDeleteDocument) && !force {
+		current, _, err := loadTargetDocument(ctx, coll, p.DocumentID)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return nil, err
+		}
+		if err == mongo.ErrNoDocuments || current != string(cr.BaseDocument) {
+			return nil, errStaleChangeRequest
+		}
+	}
+
+	switch cr.Kind {
+	case models.ChangeInsertDocument:
+		res, err := coll.InsertOne(ctx, p.Document)
+		if err != nil {
+			return nil, err
+		}
+		return gin.H{"insertedId": res.InsertedID}, nil
+	case models.ChangeUpdateDocument:
+		res, err := coll.UpdateOne(ctx, documentIDFilter(p.DocumentID), bson.M{"$set": p.Document})
+		if err != nil {
+			return nil, err
+		}
+		if res.MatchedCount == 0 {
+			return nil, errors.New("document not found")
+		}
+		return gin.H{"matchedCount": res.MatchedCount, "modifiedCount": res.ModifiedCount}, nil
+	case models.ChangeDeleteDocument:
+		res, err := coll.DeleteOne(ctx, documentIDFilter(p.DocumentID))
+		if err != nil {
+			return nil, err
+		}
+		if res.DeletedCount == 0 {
+			return nil, errors.New("document not found")
+		}
+		return gin.H{"deletedCount": res.DeletedCount}, nil
+	case models.ChangeUpdateMany:
+		filter := p.Filter
+		if filter == nil {
+			filter = bson.M{}
+		}
+		res, err := coll.UpdateMany(ctx, filter, p.Update)
+		if err != nil {
+			return nil, err
+		}
+		return gin.H{"matchedCount": res.MatchedCount, "modifiedCount": res.ModifiedCount}, nil
+	case models.ChangeCreateIndex:
+		spec := indexSpec(p)
+		cmd := bson.D{{Key: "createIndexes", Value: cr.CollectionName}, {Key: "indexes", Value: bson.A{spec}}}
+		if err := coll.Database().RunCommand(ctx, cmd).Err(); err != nil {
+			return nil, err
+		}
+		return gin.H{"createdIndex": spec[1].Value}, nil
+	case models.ChangeDropIndex:
+		if _, err := coll.Indexes().DropOne(ctx, p.Name); err != nil {
+			return nil, err
+		}
+		return gin.H{"droppedIndex": p.Name}, nil
+	}
+	return nil, fmt.Errorf("unknown change request kind %q", cr.Kind)
+}
+
+// getAccessibleChangeRequest loads the change request named by the :crId route parameter
+// and checks that the current user can read its database.
+// It writes the error response itself and returns nil on failure.
+func getAccessibleChangeRequest(c *gin.Context, currentUser models.User) *models.ChangeRequest {
+	crID, err := strconv.Atoi(c.Param("crId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid change request ID"})
+		return nil
+	}
+	cr, err := loadChangeRequest(crID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Change request not found"})
+		return nil
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil
+	}
+	if cr.RequestedBy != currentUser.ID {
+		hasDBRead, err := middleware.HasDBPermission(currentUser, cr.EnvironmentID, cr.DBName, "read")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return nil
+		}
+		if !hasDBRead {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Change request not found"})
+			return nil
+		}
+	}
+	return cr
+}
+
+// CreateChangeRequest proposes a write on a collection. Any user who can read the
+// database can propose; the write is applied once another user with write permission approves it.
+// Body: { "kind": "updateDocument", "title": "...", "description": "...", "payload": {...} }
+// The payload is extended JSON, see changeRequestPayload.
+func CreateChangeRequest(c *gin.Context) {
+	envIDStr := c.Param("id")
+	dbName := c.Param("dbName")
+	collName := c.Param("collName")
+	if !requireVisibleCollections(c, collName) {
+		return
+	}
+	envID, err := strconv.Atoi(envIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid environment ID"})
+		return
+	}
+
+	var env models.Environment
+	row := database.DB.QueryRow(`SELECT id, name, connection_string, created_by FROM environments WHERE id = ?`, envID)
+	if err := row.Scan(&env.ID, &env.Name, &env.ConnectionString, &env.CreatedBy); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Environment not found"})
+		return
+	}
+
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+	hasDBRead, err := middleware.HasDBPermission(currentUser, envID, dbName, "read")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !hasDBRead {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No permission to read this database"})
+		return
+	}
+
+	var req struct {
+		Kind        string          `json:"kind"`
+		Title       string          `json:"title"`
+		Description string          `json:"description"`
+		Payload     json.RawMessage `json:"payload"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Title == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "title is required"})
+		return
+	}
+	if len(req.Payload) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "payload is required"})
+		return
+	}
+	var payload changeRequestPayload
+	if err := bson.UnmarshalExtJSON(req.Payload, false, &payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payload: " + err.Error()})
+		return
+	}
+	if msg := validateChangeRequestPayload(req.Kind, &payload); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	// Remember the target document, so that reviewers approve what they saw.
+	baseDocument := ""
+	if req.Kind == models.ChangeUpdateDocument || req.Kind == models.ChangeDeleteDocument {
+		decryptedConn, err := decrypt(env.ConnectionString)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decrypt connection string: " + err.Error()})
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+		client, err := database.ConnectMongo(ctx, decryptedConn)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to MongoDB: " + err.Error()})
+			return
+		}
+		defer client.Disconnect(ctx)
+		if !requireWritableCollection(ctx, c, client.Database(dbName), collName) {
+			return
+		}
+		var current bson.M
+		baseDocument, current, err = loadTargetDocument(ctx, client.Database(dbName).Collection(collName), payload.DocumentID)
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load document: " + err.Error()})
+			return
+		}
+		if req.Kind == models.ChangeUpdateDocument {
+			// A masked requester must not propose to write the masks over the real values.
+			redact, err := documentRedactor(currentUser, envID, dbName, collName)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			raw, err := bson.Marshal(current)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			if !redact.Writable(raw, payload.Document) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Writing masked fields requires an unmasked grant"})
+				return
+			}
+			if req.Payload, err = bson.MarshalExtJSON(payload, false, false); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
+	}
+
+	now := time.Now().UTC()
+	res, err := database.DB.Exec(
+		`INSERT INTO change_requests (environment_id, db_name, collection_name, kind, title, description, payload,
+		 base_document, status, requested_by, created_at, updated_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		envID, dbName, collName, req.Kind, req.Title, req.Description, string(req.Payload),
+		baseDocument, models.ChangeRequestPending, currentUser.ID, now, now,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create change request: " + err.Error()})
+		return
+	}
+	id, _ := res.LastInsertId()
+	recordChangeRequestEvent(int(id), "created", currentUser.ID, "")
+
+	cr, err := loadChangeRequest(int(id))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := redactChangeRequest(currentUser, cr); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"changeRequest": cr})
+}
+
+// ListChangeRequests lists the change requests the current user can see, newest first:
+// their own and those on databases they can read.
+// Query params: status (optional, default "pending"; "all" lists every status), environmentId (optional).
+func ListChangeRequests(c *gin.Context) {
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+
+	query := `SELECT ` + changeRequestColumns + ` FROM change_requests WHERE 1 = 1`
+	var params []interface{}
+	if status := c.DefaultQuery("status", models.ChangeRequestPending); status != "all" {
+		query += ` AND status = ?`
+		params = append(params, status)
+	}
+	if envIDStr := c.Query("environmentId"); envIDStr != "" {
+		envID, err := strconv.Atoi(envIDStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid environment ID"})
+			return
+		}
+		query += ` AND environment_id = ?`
+		params = append(params, envID)
+	}
+	query += ` ORDER BY id DESC`
+	rows, err := database.DB.Query(query, params...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	changeRequests := []models.ChangeRequest{}
+	for rows.Next() {
+		cr, err := scanChangeRequest(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if cr.RequestedBy != currentUser.ID {
+			hasDBRead, err := middleware.HasDBPermission(currentUser, cr.EnvironmentID, cr.DBName, "read")
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			if !hasDBRead {
+				continue
+			}
+		}
+		if err := redactChangeRequest(currentUser, cr); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		changeRequests = append(changeRequests, *cr)
+	}
+	c.JSON(http.StatusOK, gin.H{"changeRequests": changeRequests})
+}
+
+// GetChangeRequest returns a change request with its lifecycle events.
+func GetChangeRequest(c *gin.Context) {
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+	cr := getAccessibleChangeRequest(c, currentUser)
+	if cr == nil {
+		return
+	}
+
+	rows, err := database.DB.Query(
+		`SELECT id, change_request_id, event, user_id, details, created_at FROM change_request_events
+		 WHERE change_request_id = ? ORDER BY id`, cr.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	events := []models.ChangeRequestEvent{}
+	for rows.Next() {
+		var e models.ChangeRequestEvent
+		if err := rows.Scan(&e.ID, &e.ChangeRequestID, &e.Event, &e.UserID, &e.Details, &e.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		events = append(events, e)
+	}
+	if err := redactChangeRequest(currentUser, cr); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"changeRequest": cr, "events": events})
+}
+
+// GetChangeRequestDiff shows what approving a change request would do against the current data:
+// a field-level diff for document changes, matched count and sample for bulk updates,
+// and the index specification for index changes. "stale" is true when the target
+// document changed since the request was made.
+func GetChangeRequestDiff(c *gin.Context) {
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+	cr := getAccessibleChangeRequest(c, currentUser)
+	if cr == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	client, err := connectEnvironment(ctx, cr.EnvironmentID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to MongoDB: " + err.Error()})
+		return
+	}
+	defer client.Disconnect(ctx)
+	if !requireReadableCollection(ctx, c, currentUser, cr.EnvironmentID, client.Database(cr.DBName), cr.CollectionName) {
+		return
+	}
+
+	redact, err := documentRedactor(currentUser, cr.EnvironmentID, cr.DBName, cr.CollectionName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	preview, err := previewChangeRequest(ctx, client, cr, redact)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute diff: " + err.Error()})
+		return
+	}
+	if err := redactChangeRequest(currentUser, cr); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"changeRequest": cr, "diff": preview})
+}
+
+// ApproveChangeRequest approves a pending change request and applies it.
+// The reviewer must have write permission on the database and must not be the requester.
+// Body (optional): { "comment": "...", "force": false }. force applies a document change
+// even if the document changed since the request was made. Update-many requests on a
+// protected environment also need a confirmation (see requireDestructiveConfirmation).
+func ApproveChangeRequest(c *gin.Context) {
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+	cr := reviewableChangeRequest(c, currentUser)
+	if cr == nil {
+		return
+	}
+	var req struct {
+		Comment string `json:"comment"`
+		Force   bool   `json:"force"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	client, err := connectEnvironment(ctx, cr.EnvironmentID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to MongoDB: " + err.Error()})
+		return
+	}
+	defer client.Disconnect(ctx)
+
+	// An update of many documents is guarded like the other destructive operations.
+	if cr.Kind == models.ChangeUpdateMany && !requireDestructiveConfirmation(c, cr.EnvironmentID, actionUpdateMany, changeRequestTarget(cr)) {
+		return
+	}
+
+	// Claim the request before applying it, so that only one reviewer of a concurrent
+	// approval applies it.
+	res, err := database.DB.Exec(
+		`UPDATE change_requests SET status = ?, reviewed_by = ?, review_comment = ?, updated_at = ? WHERE id = ? AND status = ?`,
+		models.ChangeRequestApplying, currentUser.ID, req.Comment, time.Now().UTC(), cr.ID, models.ChangeRequestPending,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Change request was reviewed concurrently"})
+		return
+	}
+
+	result, applyErr := applyChangeRequest(ctx, client, cr, req.Force)
+	if applyErr == errStaleChangeRequest {
+		// Nothing was written: release the claim.
+		_, err := database.DB.Exec(
+			`UPDATE change_requests SET status = ?, reviewed_by = 0, review_comment = '', updated_at = ? WHERE id = ? AND status = ?`,
+			models.ChangeRequestPending, time.Now().UTC(), cr.ID, models.ChangeRequestApplying,
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		redact, _ := documentRedactor(currentUser, cr.EnvironmentID, cr.DBName, cr.CollectionName)
+		preview, _ := previewChangeRequest(ctx, client, cr, redact)
+		c.JSON(http.StatusConflict, gin.H{
+			"error": applyErr.Error() + ", review the diff again and approve with force to apply anyway",
+			"diff":  preview,
+		})
+		return
+	}
+
+	now := time.Now().UTC()
+	status, errMsg, resultJSON := models.ChangeRequestApplied, "", []byte{}
+	if applyErr != nil {
+		status, errMsg = models.ChangeRequestFailed, applyErr.Error()
+	} else {
+		resultJSON, _ = json.Marshal(result)
+	}
+	_, err = database.DB.Exec(
+		`UPDATE change_requests SET status = ?, result = ?, error = ?, updated_at = ?, applied_at = ? WHERE id = ?`,
+		status, string(resultJSON), errMsg, now, now, cr.ID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	recordChangeRequestEvent(cr.ID, "approved", currentUser.ID, req.Comment)
+	if applyErr != nil {
+		recordChangeRequestEvent(cr.ID, "failed", currentUser.ID, errMsg)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply change request: " + errMsg})
+		return
+	}
+	recordChangeRequestEvent(cr.ID, "applied", currentUser.ID, string(resultJSON))
+
+	cr, err = loadChangeRequest(cr.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := redactChangeRequest(currentUser, cr); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Change request applied", "changeRequest": cr, "result": result})
+}
+
+// RejectChangeRequest rejects a pending change request. Body (optional): { "comment": "..." }
+func RejectChangeRequest(c *gin.Context) {
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+	cr := reviewableChangeRequest(c, currentUser)
+	if cr == nil {
+		return
+	}
+	var req struct {
+		Comment string `json:"comment"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	closeChangeRequest(c, cr, models.ChangeRequestRejected, "rejected", currentUser.ID, req.Comment)
+}
+
+// CancelChangeRequest withdraws a pending change request. Only the requester can cancel it.
+func CancelChangeRequest(c *gin.Context) {
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+	cr := getAccessibleChangeRequest(c, currentUser)
+	if cr == nil {
+		return
+	}
+	if cr.RequestedBy != currentUser.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the requester can cancel a change request"})
+		return
+	}
+	if cr.Status != models.ChangeRequestPending {
+		c.JSON(http.StatusConflict, gin.H{"error": "Change request is already " + cr.Status})
+		return
+	}
+	closeChangeRequest(c, cr, models.ChangeRequestCancelled, "cancelled", currentUser.ID, "")
+}
+
+// reviewableChangeRequest loads a pending change request that the current user may review.
+// It writes the error response itself and returns nil on failure.
+func reviewableChangeRequest(c *gin.Context, currentUser models.User) *models.ChangeRequest {
+	cr := getAccessibleChangeRequest(c, currentUser)
+	if cr == nil {
+		return nil
+	}
+	if cr.RequestedBy == currentUser.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "A change request must be reviewed by another user"})
+		return nil
+	}
+	hasDBWrite, err := middleware.HasDBPermission(currentUser, cr.EnvironmentID, cr.DBName, "write")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil
+	}
+	if !hasDBWrite {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No permission to write in this database"})
+		return nil
+	}
+	if cr.Status != models.ChangeRequestPending {
+		c.JSON(http.StatusConflict, gin.H{"error": "Change request is already " + cr.Status})
+		return nil
+	}
+	return cr
+}
+
+// closeChangeRequest moves a pending change request to a final status without applying it.
+func closeChangeRequest(c *gin.Context, cr *models.ChangeRequest, status, event string, userID int, comment string) {
+	query := `UPDATE change_requests SET status = ?, updated_at = ? WHERE id = ? AND status = ?`
+	params := []interface{}{status, time.Now().UTC(), cr.ID, models.ChangeRequestPending}
+	if status == models.ChangeRequestRejected {
+		query = `UPDATE change_requests SET status = ?, reviewed_by = ?, review_comment = ?, updated_at = ? WHERE id = ? AND status = ?`
+		params = []interface{}{status, userID, comment, time.Now().UTC(), cr.ID, models.ChangeRequestPending}
+	}
+	res, err := database.DB.Exec(query, params...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Change request was reviewed concurrently"})
+		return
+	}
+	recordChangeRequestEvent(cr.ID, event, userID, comment)
+	cr, err = loadChangeRequest(cr.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	currentUserRaw, _ := c.Get("user")
+	if err := redactChangeRequest(currentUserRaw.(models.User), cr); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"changeRequest": cr})
+}
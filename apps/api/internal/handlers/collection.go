@@ -2,9 +2,11 @@ package handlers
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 
 	"monji/internal/database"
@@ -13,9 +15,17 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// GetCollections lists collections (with basic stats) in a database.
+// collStatsConcurrency is the number of collStats commands GetCollections runs at once.
+const collStatsConcurrency = 8
+
+// GetCollections lists collections (with basic stats) and views in a database,
+// with their type: collection, view or timeseries. Stats are gathered concurrently;
+// a collection whose stats fail is listed with an "error" instead.
+// Query params: namesOnly=true to list names and types only, without stats.
 // It decrypts the environment's connection string before connecting.
 func GetCollections(c *gin.Context) {
 	envIDStr := c.Param("id")
@@ -65,27 +75,30 @@ func GetCollections(c *gin.Context) {
 	}
 	defer client.Disconnect(ctx)
 
-	// List collection names.
-	collNames, err := client.Database(dbName).ListCollectionNames(ctx, bson.D{})
+	// List collections, without the soft-dropped ones.
+	namesOnly := c.Query("namesOnly") == "true"
+	listOpts := options.ListCollections().SetNameOnly(namesOnly)
+	cursor, err := client.Database(dbName).ListCollections(ctx, visibleCollectionsFilter(), listOpts)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list collections: " + err.Error()})
 		return
 	}
+	var infos []collectionInfo
+	if err := cursor.All(ctx, &infos); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list collections: " + err.Error()})
+		return
+	}
 
 	var collections []gin.H
-	for _, coll := range collNames {
-		var stats bson.M
-		if err := client.Database(dbName).RunCommand(ctx, bson.D{{Key: "collStats", Value: coll}}).Decode(&stats); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to get stats for %s: %v", coll, err)})
-			return
+	if namesOnly {
+		for _, info := range infos {
+			if info.Type == "" {
+				info.Type = "collection"
+			}
+			collections = append(collections, gin.H{"name": info.Name, "type": info.Type})
 		}
-		collections = append(collections, gin.H{
-			"name":           coll,
-			"count":          stats["count"],
-			"size":           stats["size"],
-			"storageSize":    stats["storageSize"],
-			"totalIndexSize": stats["totalIndexSize"],
-		})
+	} else {
+		collections = collectionsWithStats(ctx, client.Database(dbName), infos)
 	}
 
 	myPerm := "readAndWrite"
@@ -100,12 +113,58 @@ func GetCollections(c *gin.Context) {
 	})
 }
 
+// collectionsWithStats returns the entries of GetCollections, running collStats on up to
+// collStatsConcurrency collections at a time.
+func collectionsWithStats(ctx context.Context, db *mongo.Database, infos []collectionInfo) []gin.H {
+	collections := make([]gin.H, len(infos))
+	sem := make(chan struct{}, collStatsConcurrency)
+	var wg sync.WaitGroup
+	for i, info := range infos {
+		if info.Type == "" {
+			info.Type = "collection"
+		}
+		// Views have no storage of their own: collStats fails on them.
+		if info.Type == "view" {
+			collections[i] = gin.H{
+				"name":     info.Name,
+				"type":     info.Type,
+				"viewOn":   info.Options["viewOn"],
+				"readOnly": true,
+			}
+			continue
+		}
+		wg.Add(1)
+		go func(i int, info collectionInfo) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			entry := gin.H{"name": info.Name, "type": info.Type}
+			var stats bson.M
+			if err := db.RunCommand(ctx, bson.D{{Key: "collStats", Value: info.Name}}).Decode(&stats); err != nil {
+				entry["error"] = fmt.Sprintf("Failed to get stats: %v", err)
+			} else {
+				entry["count"] = stats["count"]
+				entry["size"] = stats["size"]
+				entry["storageSize"] = stats["storageSize"]
+				entry["totalIndexSize"] = stats["totalIndexSize"]
+			}
+			collections[i] = entry
+		}(i, info)
+	}
+	wg.Wait()
+	return collections
+}
+
 // GetCollectionDetails retrieves detailed info about a specific collection,
-// including collStats and indexes. It decrypts the connection string before use.
+// including its type and creation options, collStats and indexes.
+// It decrypts the connection string before use.
 func GetCollectionDetails(c *gin.Context) {
 	envIDStr := c.Param("id")
 	dbName := c.Param("dbName")
 	collName := c.Param("collName")
+	if !requireVisibleCollections(c, collName) {
+		return
+	}
 
 	envID, err := strconv.Atoi(envIDStr)
 	if err != nil {
@@ -151,22 +210,46 @@ func GetCollectionDetails(c *gin.Context) {
 	}
 	defer client.Disconnect(ctx)
 
-	var stats bson.M
-	if err := client.Database(dbName).RunCommand(ctx, bson.D{{Key: "collStats", Value: collName}}).Decode(&stats); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to get stats for collection %s: %v", collName, err)})
+	// The creation options (capped, time series, clustered, collation, validator...).
+	info, err := getCollectionInfo(ctx, client.Database(dbName), collName)
+	if err != nil && err != errCollectionNotFound {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read collection options: " + err.Error()})
 		return
 	}
-
-	cursor, err := client.Database(dbName).Collection(collName).Indexes().List(ctx)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list indexes: " + err.Error()})
-		return
+	collType, collOptions := "collection", json.RawMessage("{}")
+	if info != nil {
+		if info.Type != "" {
+			collType = info.Type
+		}
+		if len(info.Options) > 0 {
+			data, err := bson.MarshalExtJSON(info.Options, false, false)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode collection options: " + err.Error()})
+				return
+			}
+			collOptions = json.RawMessage(data)
+		}
 	}
+
+	// Views have neither storage nor indexes of their own.
+	var stats bson.M
 	var indexes []bson.M
-	for cursor.Next(ctx) {
-		var idx bson.M
-		if err := cursor.Decode(&idx); err == nil {
-			indexes = append(indexes, idx)
+	if collType != "view" {
+		if err := client.Database(dbName).RunCommand(ctx, bson.D{{Key: "collStats", Value: collName}}).Decode(&stats); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to get stats for collection %s: %v", collName, err)})
+			return
+		}
+
+		cursor, err := client.Database(dbName).Collection(collName).Indexes().List(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list indexes: " + err.Error()})
+			return
+		}
+		for cursor.Next(ctx) {
+			var idx bson.M
+			if err := cursor.Decode(&idx); err == nil {
+				indexes = append(indexes, idx)
+			}
 		}
 	}
 
@@ -178,13 +261,17 @@ func GetCollectionDetails(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"database":     dbName,
 		"collection":   collName,
+		"type":         collType,
+		"readOnly":     collType == "view",
+		"options":      collOptions,
 		"stats":        stats,
 		"indexes":      indexes,
 		"myPermission": myPerm,
 	})
 }
 
-// CreateCollection creates a new collection in a database.
+// CreateCollection creates a new collection in a database, with the options of
+// collectionOptionsRequest (capped, time series, clustered...).
 // It decrypts the environment's connection string before connecting.
 func CreateCollection(c *gin.Context) {
 	envIDStr := c.Param("id")
@@ -220,6 +307,7 @@ func CreateCollection(c *gin.Context) {
 
 	var req struct {
 		CollectionName string `json:"collectionName"`
+		collectionOptionsRequest
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -229,6 +317,14 @@ func CreateCollection(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "collectionName is required"})
 		return
 	}
+	if !requireVisibleCollections(c, req.CollectionName) {
+		return
+	}
+	createOpts, err := req.createOptions()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	decryptedConn, err := decrypt(env.ConnectionString)
 	if err != nil {
@@ -257,7 +353,7 @@ func CreateCollection(c *gin.Context) {
 		}
 	}
 
-	if err := client.Database(dbName).CreateCollection(ctx, req.CollectionName); err != nil {
+	if err := client.Database(dbName).CreateCollection(ctx, req.CollectionName, createOpts); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create collection: " + err.Error()})
 		return
 	}
@@ -269,12 +365,16 @@ func CreateCollection(c *gin.Context) {
 	})
 }
 
-// EditCollection renames an existing collection.
+// EditCollection renames an existing collection. The redaction rules applying to it are
+// copied to the new name (see copyRedactionRules).
 // It decrypts the connection string before connecting.
 func EditCollection(c *gin.Context) {
 	envIDStr := c.Param("id")
 	dbName := c.Param("dbName")
 	oldCollName := c.Param("collName")
+	if !requireVisibleCollections(c, oldCollName) {
+		return
+	}
 
 	envID, err := strconv.Atoi(envIDStr)
 	if err != nil {
@@ -315,6 +415,9 @@ func EditCollection(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "newCollectionName is required"})
 		return
 	}
+	if !requireVisibleCollections(c, req.NewCollectionName) {
+		return
+	}
 
 	decryptedConn, err := decrypt(env.ConnectionString)
 	if err != nil {
@@ -334,12 +437,19 @@ func EditCollection(c *gin.Context) {
 	oldNamespace := fmt.Sprintf("%s.%s", dbName, oldCollName)
 	newNamespace := fmt.Sprintf("%s.%s", dbName, req.NewCollectionName)
 
+	// The redaction rules follow the collection before it is reachable under its new name.
+	ruleCopies, err := copyRedactionRules(envID, dbName, oldCollName, req.NewCollectionName, currentUser.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to copy redaction rules: " + err.Error()})
+		return
+	}
 	cmd := bson.D{
 		{Key: "renameCollection", Value: oldNamespace},
 		{Key: "to", Value: newNamespace},
 		{Key: "dropTarget", Value: false},
 	}
 	if err := client.Database("admin").RunCommand(ctx, cmd).Err(); err != nil {
+		deleteRedactionRules(ruleCopies)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to rename collection: %v", err)})
 		return
 	}
@@ -351,12 +461,15 @@ func EditCollection(c *gin.Context) {
 	})
 }
 
-// DeleteCollection drops a collection from a database.
+// DeleteCollection moves a collection to the recycle bin (see trashCollection).
 // It decrypts the connection string before connecting.
 func DeleteCollection(c *gin.Context) {
 	envIDStr := c.Param("id")
 	dbName := c.Param("dbName")
 	collName := c.Param("collName")
+	if !requireVisibleCollections(c, collName) {
+		return
+	}
 
 	envID, err := strconv.Atoi(envIDStr)
 	if err != nil {
@@ -373,6 +486,10 @@ func DeleteCollection(c *gin.Context) {
 
 	currentUserRaw, _ := c.Get("user")
 	currentUser := currentUserRaw.(models.User)
+	if !middleware.HasCapability(currentUser, models.CapDropDatabases) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Dropping collections requires the drop_databases capability"})
+		return
+	}
 	isAdmin := middleware.IsAdmin(currentUser)
 	if !isAdmin {
 		hasDBWrite, err := middleware.HasDBPermission(currentUser, envID, dbName, "write")
@@ -401,14 +518,44 @@ func DeleteCollection(c *gin.Context) {
 	}
 	defer client.Disconnect(ctx)
 
-	if err := client.Database(dbName).Collection(collName).Drop(ctx); err != nil {
+	if !requireDestructiveConfirmation(c, envID, actionDropCollection, dbName+"."+collName) {
+		return
+	}
+
+	// Admins can bypass the recycle bin with ?permanent=true.
+	permanent := RecycleRetentionDays == 0 || (isAdmin && c.Query("permanent") == "true")
+	if permanent {
+		if err := client.Database(dbName).Collection(collName).Drop(ctx); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to drop collection: " + err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message":    "Collection deleted successfully",
+			"database":   dbName,
+			"collection": collName,
+		})
+		return
+	}
+
+	entry, err := trashCollection(ctx, client, envID, dbName, collName, currentUser.ID)
+	if err == errCollectionNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Collection not found"})
+		return
+	}
+	if err == errNotRecyclable {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error() + ", an admin can delete it with ?permanent=true"})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to drop collection: " + err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message":    "Collection deleted successfully",
-		"database":   dbName,
-		"collection": collName,
+		"message":      "Collection moved to the recycle bin",
+		"database":     dbName,
+		"collection":   collName,
+		"recycleBinId": entry.ID,
+		"expiresAt":    entry.ExpiresAt,
 	})
 }
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// timeSeriesGranularities are the granularities accepted for time series collections.
+var timeSeriesGranularities = []string{"seconds", "minutes", "hours"}
+
+// collectionOptionsRequest holds the creation options of a collection. It is embedded in
+// the bodies of CreateCollection and CreateDatabase (for the initial collection):
+//
+//	{
+//	  "capped": true, "size": 1048576, "max": 1000,
+//	  "timeseries": {"timeField": "ts", "metaField": "sensor", "granularity": "minutes", "expireAfterSeconds": 86400},
+//	  "clusteredIndex": {"name": "by_id", "expireAfterSeconds": 3600},
+//	  "collation": {"locale": "fr", "strength": 2},
+//	  "changeStreamPreAndPostImages": true,
+//	  "storageEngine": {"wiredTiger": {"configString": "block_compressor=zstd"}}
+//	}
+type collectionOptionsRequest struct {
+	Capped bool  `json:"capped"`
+	Size   int64 `json:"size"` // bytes, required for capped collections
+	Max    int64 `json:"max"`  // documents
+
+	TimeSeries *struct {
+		TimeField          string `json:"timeField"`
+		MetaField          string `json:"metaField"`
+		Granularity        string `json:"granularity"`
+		ExpireAfterSeconds int64  `json:"expireAfterSeconds"`
+	} `json:"timeseries"`
+
+	// ClusteredIndex clusters the collection by _id (the only key Mongo supports).
+	ClusteredIndex *struct {
+		Name               string `json:"name"`
+		ExpireAfterSeconds int64  `json:"expireAfterSeconds"`
+	} `json:"clusteredIndex"`
+
+	Collation                    *options.Collation `json:"collation"`
+	ChangeStreamPreAndPostImages bool               `json:"changeStreamPreAndPostImages"`
+	StorageEngine                json.RawMessage    `json:"storageEngine"` // extended JSON
+}
+
+// createOptions validates the requested options and returns them as driver options.
+func (r *collectionOptionsRequest) createOptions() (*options.CreateCollectionOptions, error) {
+	opts := options.CreateCollection()
+	if r.Capped {
+		if r.Size <= 0 {
+			return nil, errors.New("size is required for a capped collection")
+		}
+		if r.TimeSeries != nil || r.ClusteredIndex != nil {
+			return nil, errors.New("a capped collection cannot be a time series or clustered collection")
+		}
+		opts.SetCapped(true).SetSizeInBytes(r.Size)
+		if r.Max > 0 {
+			opts.SetMaxDocuments(r.Max)
+		}
+	} else if r.Size != 0 || r.Max != 0 {
+		return nil, errors.New("size and max only apply to capped collections")
+	}
+
+	if ts := r.TimeSeries; ts != nil {
+		if ts.TimeField == "" {
+			return nil, errors.New("timeseries.timeField is required")
+		}
+		if r.ClusteredIndex != nil {
+			return nil, errors.New("a time series collection cannot have a clustered index")
+		}
+		tsOpts := options.TimeSeries().SetTimeField(ts.TimeField)
+		if ts.MetaField != "" {
+			tsOpts.SetMetaField(ts.MetaField)
+		}
+		if ts.Granularity != "" {
+			if !containsString(timeSeriesGranularities, ts.Granularity) {
+				return nil, errors.New("timeseries.granularity must be seconds, minutes or hours")
+			}
+			tsOpts.SetGranularity(ts.Granularity)
+		}
+		opts.SetTimeSeriesOptions(tsOpts)
+		if ts.ExpireAfterSeconds < 0 {
+			return nil, errors.New("timeseries.expireAfterSeconds must be positive")
+		}
+		if ts.ExpireAfterSeconds > 0 {
+			opts.SetExpireAfterSeconds(ts.ExpireAfterSeconds)
+		}
+	}
+
+	if ci := r.ClusteredIndex; ci != nil {
+		clustered := bson.D{{Key: "key", Value: bson.D{{Key: "_id", Value: 1}}}, {Key: "unique", Value: true}}
+		if ci.Name != "" {
+			clustered = append(clustered, bson.E{Key: "name", Value: ci.Name})
+		}
+		opts.SetClusteredIndex(clustered)
+		if ci.ExpireAfterSeconds < 0 {
+			return nil, errors.New("clusteredIndex.expireAfterSeconds must be positive")
+		}
+		if ci.ExpireAfterSeconds > 0 {
+			opts.SetExpireAfterSeconds(ci.ExpireAfterSeconds)
+		}
+	}
+
+	if r.Collation != nil {
+		if r.Collation.Locale == "" {
+			return nil, errors.New("collation.locale is required")
+		}
+		opts.SetCollation(r.Collation)
+	}
+	if r.ChangeStreamPreAndPostImages {
+		opts.SetChangeStreamPreAndPostImages(bson.D{{Key: "enabled", Value: true}})
+	}
+	if len(r.StorageEngine) > 0 {
+		var storageEngine bson.D
+		if err := bson.UnmarshalExtJSON(r.StorageEngine, false, &storageEngine); err != nil {
+			return nil, errors.New("storageEngine must be a document: " + err.Error())
+		}
+		opts.SetStorageEngine(storageEngine)
+	}
+	return opts, nil
+}
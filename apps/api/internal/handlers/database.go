@@ -2,12 +2,12 @@ package handlers
 
 import (
 	"context"
-	"fmt"
 	"net/http"
 	"strconv"
 	"time"
 
 	"monji/internal/database"
+	"monji/internal/jobs"
 	"monji/internal/middleware"
 	"monji/internal/models"
 
@@ -72,6 +72,13 @@ func GetDatabases(c *gin.Context) {
 		return
 	}
 
+	// Load the user's database grants once rather than once per database.
+	grants, err := middleware.LoadDBGrants(currentUser, envID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
 	var resultList []map[string]interface{}
 	var totalSize float64
 
@@ -85,13 +92,8 @@ func GetDatabases(c *gin.Context) {
 			})
 			totalSize += float64(dbInfo.SizeOnDisk)
 		} else {
-			hasDbRead, err := middleware.HasDBPermission(currentUser, envID, dbInfo.Name, "read")
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return
-			}
-			if hasDbRead {
-				perm := getDbPermissionString(currentUser, envID, dbInfo.Name)
+			perm := grants.Permission(dbInfo.Name)
+			if perm == "readOnly" || perm == "readAndWrite" {
 				resultList = append(resultList, map[string]interface{}{
 					"Name":         dbInfo.Name,
 					"SizeOnDisk":   dbInfo.SizeOnDisk,
@@ -110,6 +112,7 @@ func GetDatabases(c *gin.Context) {
 }
 
 // CreateDatabase creates a new Mongo database by creating an initial collection.
+// The body may carry the options of that collection (see collectionOptionsRequest).
 func CreateDatabase(c *gin.Context) {
 	envIDStr := c.Param("id")
 	envID, err := strconv.Atoi(envIDStr)
@@ -140,6 +143,8 @@ func CreateDatabase(c *gin.Context) {
 	var req struct {
 		DbName            string `json:"dbName"`
 		InitialCollection string `json:"initialCollection"`
+		// Options of the initial collection.
+		collectionOptionsRequest
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -149,6 +154,14 @@ func CreateDatabase(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Both dbName and initialCollection are required"})
 		return
 	}
+	if !requireVisibleCollections(c, req.InitialCollection) {
+		return
+	}
+	createOpts, err := req.createOptions()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	decryptedConn, err := decrypt(env.ConnectionString)
 	if err != nil {
@@ -177,7 +190,7 @@ func CreateDatabase(c *gin.Context) {
 		}
 	}
 
-	if err := client.Database(req.DbName).CreateCollection(ctx, req.InitialCollection); err != nil {
+	if err := client.Database(req.DbName).CreateCollection(ctx, req.InitialCollection, createOpts); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create collection: " + err.Error()})
 		return
 	}
@@ -190,6 +203,9 @@ func CreateDatabase(c *gin.Context) {
 }
 
 // EditDatabase renames a database by moving all collections to a new database.
+// The request is preflighted (see preflightRenameDatabase), then the rename runs as a
+// background job; the response contains the job ID to poll.
+// Body: { "newDbName": "...", "onFailure": "rollback"|"pause", "userPasswords": {...}, "skipUsers": false }
 func EditDatabase(c *gin.Context) {
 	envIDStr := c.Param("id")
 	oldDbName := c.Param("dbName")
@@ -219,9 +235,7 @@ func EditDatabase(c *gin.Context) {
 		return
 	}
 
-	var req struct {
-		NewDbName string `json:"newDbName"`
-	}
+	var req renameDatabaseParams
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -230,14 +244,25 @@ func EditDatabase(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "newDbName is required"})
 		return
 	}
-
+	req.DbName = oldDbName
+	for _, dbName := range []string{req.DbName, req.NewDbName} {
+		hasDBWrite, err := middleware.HasDBPermission(currentUser, envID, dbName, "write")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !hasDBWrite {
+			c.JSON(http.StatusForbidden, gin.H{"error": "No permission to write in database " + dbName})
+			return
+		}
+	}
 	decryptedConn, err := decrypt(env.ConnectionString)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decrypt connection string: " + err.Error()})
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
 
 	client, err := database.ConnectMongo(ctx, decryptedConn)
@@ -247,51 +272,43 @@ func EditDatabase(c *gin.Context) {
 	}
 	defer client.Disconnect(ctx)
 
-	dbList, err := client.ListDatabaseNames(ctx, bson.M{})
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list databases: " + err.Error()})
+	// Fail fast: the job runs the same checks before touching anything.
+	plan, problems := preflightRenameDatabase(ctx, client, envID, req)
+	if len(problems) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Database cannot be renamed", "problems": problems})
 		return
 	}
-	for _, name := range dbList {
-		if name == req.NewDbName {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Target database already exists"})
-			return
-		}
-	}
-
-	collNames, err := client.Database(oldDbName).ListCollectionNames(ctx, bson.D{})
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list collections: " + err.Error()})
+	// Confirmed last, as an approval is used up by a successful check.
+	if !requireDestructiveConfirmation(c, envID, actionRenameDatabase, renameDatabaseTarget(oldDbName, req.NewDbName)) {
 		return
 	}
 
-	for _, coll := range collNames {
-		oldNamespace := fmt.Sprintf("%s.%s", oldDbName, coll)
-		newNamespace := fmt.Sprintf("%s.%s", req.NewDbName, coll)
-		cmd := bson.D{
-			{Key: "renameCollection", Value: oldNamespace},
-			{Key: "to", Value: newNamespace},
-			{Key: "dropTarget", Value: false},
-		}
-		if err := client.Database("admin").RunCommand(ctx, cmd).Err(); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to rename collection %s: %v", coll, err)})
+	// Passwords are kept encrypted in the job parameters.
+	for user, pwd := range req.UserPasswords {
+		encrypted, err := encrypt(pwd)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encrypt password: " + err.Error()})
 			return
 		}
+		req.UserPasswords[user] = encrypted
 	}
 
-	if err := client.Database(oldDbName).Drop(ctx); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to drop old database: " + err.Error()})
+	jobID, err := jobs.Submit(jobTypeRenameDatabase, envID, currentUser.ID, req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to submit rename job: " + err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message": "Database renamed successfully",
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "Database rename started",
+		"jobId":   jobID,
 		"oldName": oldDbName,
 		"newName": req.NewDbName,
+		"plan":    plan,
 	})
 }
 
-// DeleteDatabase drops a Mongo database.
+// DeleteDatabase drops a Mongo database, keeping a snapshot in the recycle bin.
 func DeleteDatabase(c *gin.Context) {
 	envIDStr := c.Param("id")
 	dbName := c.Param("dbName")
@@ -311,6 +328,10 @@ func DeleteDatabase(c *gin.Context) {
 
 	currentUserRaw, _ := c.Get("user")
 	currentUser := currentUserRaw.(models.User)
+	if !middleware.HasCapability(currentUser, models.CapDropDatabases) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Dropping databases requires the drop_databases capability"})
+		return
+	}
 	hasEnvWrite, err := middleware.HasEnvPermission(currentUser, envID, "write")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -320,6 +341,27 @@ func DeleteDatabase(c *gin.Context) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "No permission on environment"})
 		return
 	}
+	if !requireDestructiveConfirmation(c, envID, actionDropDatabase, dbName) {
+		return
+	}
+
+	// The database is snapshotted to the recycle bin, then dropped, by a background job.
+	// Users with full data access can bypass the recycle bin with ?permanent=true.
+	if RecycleRetentionDays > 0 && !(middleware.IsAdmin(currentUser) && c.Query("permanent") == "true") {
+		entry, err := trashDatabase(envID, dbName, currentUser.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start database drop: " + err.Error()})
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{
+			"message":      "Database is being moved to the recycle bin",
+			"database":     dbName,
+			"jobId":        entry.JobID,
+			"recycleBinId": entry.ID,
+			"expiresAt":    entry.ExpiresAt,
+		})
+		return
+	}
 
 	decryptedConn, err := decrypt(env.ConnectionString)
 	if err != nil {
@@ -410,7 +452,7 @@ func GetDatabaseDetails(c *gin.Context) {
 		return
 	}
 
-	collNames, err := client.Database(dbName).ListCollectionNames(ctx, bson.D{})
+	collNames, err := client.Database(dbName).ListCollectionNames(ctx, visibleCollectionsFilter())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list collections: " + err.Error()})
 		return
@@ -0,0 +1,844 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"monji/internal/database"
+	"monji/internal/jobs"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const jobTypeRenameDatabase = "renameDatabase"
+
+func init() {
+	jobs.Register(jobTypeRenameDatabase, runRenameDatabase)
+}
+
+// Failure modes of a database rename.
+const (
+	// renameOnFailureRollback moves everything back to the source database.
+	renameOnFailureRollback = "rollback"
+	// renameOnFailurePause keeps the partial state so the job can be retried (resumed).
+	renameOnFailurePause = "pause"
+)
+
+// Phases recorded in the rename checkpoint.
+const (
+	renamePhaseCollections = "collections"
+	renamePhaseViews       = "views"
+	renamePhaseUsers       = "users"
+	renamePhaseCleanup     = "cleanup"
+	renamePhaseRollback    = "rollback"
+)
+
+// renameDatabaseParams are the parameters of a renameDatabase job.
+type renameDatabaseParams struct {
+	DbName    string `json:"dbName"`
+	NewDbName string `json:"newDbName"`
+	OnFailure string `json:"onFailure,omitempty"` // "rollback" (default) or "pause"
+	// UserPasswords holds the passwords of the users defined in the source database,
+	// which must be recreated in the target database (Mongo never returns passwords).
+	// They are stored encrypted in the job parameters.
+	UserPasswords map[string]string `json:"userPasswords,omitempty"`
+	// SkipUsers renames the database without carrying over its Mongo users.
+	SkipUsers bool `json:"skipUsers,omitempty"`
+}
+
+// collectionInfo is the subset of a listCollections entry used by Monji.
+type collectionInfo struct {
+	Name    string `bson:"name" json:"name"`
+	Type    string `bson:"type" json:"type"`
+	Options bson.M `bson:"options" json:"options,omitempty"`
+}
+
+// listCollectionInfos returns the listCollections entries of a database.
+func listCollectionInfos(ctx context.Context, db *mongo.Database) ([]collectionInfo, error) {
+	cursor, err := db.ListCollections(ctx, bson.D{})
+	if err != nil {
+		return nil, err
+	}
+	var infos []collectionInfo
+	if err := cursor.All(ctx, &infos); err != nil {
+		return nil, err
+	}
+	return infos, nil
+}
+
+// getCollectionInfo returns the listCollections entry of one collection, or
+// errCollectionNotFound.
+func getCollectionInfo(ctx context.Context, db *mongo.Database, name string) (*collectionInfo, error) {
+	cursor, err := db.ListCollections(ctx, bson.D{{Key: "name", Value: name}})
+	if err != nil {
+		return nil, err
+	}
+	var infos []collectionInfo
+	if err := cursor.All(ctx, &infos); err != nil {
+		return nil, err
+	}
+	if len(infos) == 0 {
+		return nil, errCollectionNotFound
+	}
+	return &infos[0], nil
+}
+
+// mongoUserRole is a role assignment of a Mongo user.
+type mongoUserRole struct {
+	Role string `bson:"role" json:"role"`
+	Db   string `bson:"db" json:"db"`
+}
+
+// renameUser describes a Mongo user affected by the rename.
+type renameUser struct {
+	User       string          `json:"user"`
+	Db         string          `json:"db"` // authentication database
+	Roles      []mongoUserRole `json:"roles"`
+	CustomData bson.M          `json:"customData,omitempty"`
+}
+
+// renameView describes a view to recreate in the target database.
+type renameView struct {
+	Name   string `json:"name"`
+	ViewOn string `json:"viewOn"`
+	// Pipeline is kept as canonical extended JSON so that typed values
+	// (ObjectIds, dates...) survive the JSON checkpoint.
+	Pipeline  string `json:"pipeline"`
+	Collation bson.M `json:"collation,omitempty"`
+}
+
+// renamePlan is computed by the preflight and stored in the job checkpoint.
+type renamePlan struct {
+	Collections []string     `json:"collections"`
+	Views       []renameView `json:"views"`
+	// LocalUsers are defined in the source database and are recreated in the target.
+	LocalUsers []renameUser `json:"localUsers"`
+	// ExternalUsers are defined elsewhere but hold roles on the source database.
+	ExternalUsers []renameUser `json:"externalUsers"`
+}
+
+// renameCheckpoint is the persisted progress of a rename.
+type renameCheckpoint struct {
+	Phase         string     `json:"phase"`
+	Plan          renamePlan `json:"plan"`
+	Moved         []string   `json:"moved"`
+	CreatedViews  []string   `json:"createdViews"`
+	CreatedUsers  []string   `json:"createdUsers"`
+	GrantedUsers  []string   `json:"grantedUsers"` // "user@db"
+	FailureReason string     `json:"failureReason,omitempty"`
+	// MovedSettings lists, by table, the Monji settings moved to the new name
+	// (see moveDatabaseReferences). It is saved in the same transaction as the move.
+	MovedSettings map[string][]int64 `json:"movedSettings,omitempty"`
+}
+
+// validDatabaseName reports whether name can be used for a new database: MongoDB refuses
+// some characters and long names, and system databases are reserved.
+func validDatabaseName(name string) bool {
+	switch name {
+	case "", "admin", "local", "config":
+		return false
+	}
+	return !strings.ContainsAny(name, "/\\. \"$*<>:|?") && len(name) < 64
+}
+
+// preflightRenameDatabase checks that a rename can be performed and returns its plan.
+// It performs no write.
+func preflightRenameDatabase(ctx context.Context, client *mongo.Client, envID int, params renameDatabaseParams) (*renamePlan, []string) {
+	var problems []string
+	if params.DbName == "" || params.NewDbName == "" {
+		return nil, []string{"dbName and newDbName are required"}
+	}
+	if params.DbName == params.NewDbName {
+		return nil, []string{"newDbName must differ from the current name"}
+	}
+	if !validDatabaseName(params.NewDbName) {
+		return nil, []string{"newDbName is not a valid database name"}
+	}
+	switch params.DbName {
+	case "admin", "local", "config":
+		return nil, []string{"system databases cannot be renamed"}
+	}
+	if params.OnFailure != "" && params.OnFailure != renameOnFailureRollback && params.OnFailure != renameOnFailurePause {
+		return nil, []string{"onFailure must be 'rollback' or 'pause'"}
+	}
+
+	dbList, err := client.ListDatabaseNames(ctx, bson.M{})
+	if err != nil {
+		return nil, []string{"failed to list databases: " + err.Error()}
+	}
+	sourceExists := false
+	for _, name := range dbList {
+		if name == params.DbName {
+			sourceExists = true
+		}
+	}
+	if !sourceExists {
+		return nil, []string{"source database does not exist"}
+	}
+
+	// The target must be empty: a database only exists while it holds collections.
+	targetColls, err := client.Database(params.NewDbName).ListCollectionNames(ctx, bson.D{})
+	if err != nil {
+		return nil, []string{"failed to inspect target database: " + err.Error()}
+	}
+	if len(targetColls) > 0 {
+		problems = append(problems, "target database already exists and is not empty")
+	}
+
+	infos, err := listCollectionInfos(ctx, client.Database(params.DbName))
+	if err != nil {
+		return nil, []string{"failed to list collections: " + err.Error()}
+	}
+	plan := &renamePlan{Collections: []string{}, Views: []renameView{}}
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name, "system.") {
+			continue
+		}
+		switch info.Type {
+		case "view":
+			view := renameView{Name: info.Name}
+			view.ViewOn, _ = info.Options["viewOn"].(string)
+			pipeline, err := bson.MarshalExtJSON(bson.D{{Key: "pipeline", Value: info.Options["pipeline"]}}, true, false)
+			if err != nil {
+				return nil, []string{fmt.Sprintf("failed to read pipeline of view %s: %v", info.Name, err)}
+			}
+			view.Pipeline = string(pipeline)
+			if collation, ok := info.Options["collation"].(bson.M); ok {
+				view.Collation = collation
+			}
+			plan.Views = append(plan.Views, view)
+		case "timeseries":
+			problems = append(problems, fmt.Sprintf("time-series collection %s cannot be moved to another database", info.Name))
+		default:
+			plan.Collections = append(plan.Collections, info.Name)
+		}
+	}
+
+	if !params.SkipUsers {
+		users, userProblems := planRenameUsers(ctx, client, params)
+		problems = append(problems, userProblems...)
+		for _, u := range users {
+			if u.Db == params.DbName {
+				plan.LocalUsers = append(plan.LocalUsers, u)
+			} else {
+				plan.ExternalUsers = append(plan.ExternalUsers, u)
+			}
+		}
+	}
+
+	problems = append(problems, checkRenamePrivileges(ctx, client, params, plan)...)
+	problems = append(problems, checkRenameGrants(envID, params)...)
+	if len(problems) > 0 {
+		return nil, problems
+	}
+	return plan, nil
+}
+
+// planRenameUsers lists the Mongo users defined in, or holding roles on, the source database.
+func planRenameUsers(ctx context.Context, client *mongo.Client, params renameDatabaseParams) ([]renameUser, []string) {
+	var problems []string
+
+	var rolesInfo struct {
+		Roles []bson.M `bson:"roles"`
+	}
+	if err := client.Database(params.DbName).RunCommand(ctx, bson.D{{Key: "rolesInfo", Value: 1}}).Decode(&rolesInfo); err == nil && len(rolesInfo.Roles) > 0 {
+		problems = append(problems, "the source database defines custom roles, which cannot be carried over (use skipUsers to ignore Mongo users)")
+	}
+
+	type userInfo struct {
+		User       string          `bson:"user"`
+		Db         string          `bson:"db"`
+		Roles      []mongoUserRole `bson:"roles"`
+		CustomData bson.M          `bson:"customData"`
+	}
+	var result struct {
+		Users []userInfo `bson:"users"`
+	}
+	// Users of every database, when the connection is allowed to see them.
+	err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "usersInfo", Value: bson.M{"forAllDBs": true}}}).Decode(&result)
+	if err != nil {
+		// Fall back to the users defined in the source database only.
+		if err := client.Database(params.DbName).RunCommand(ctx, bson.D{{Key: "usersInfo", Value: 1}}).Decode(&result); err != nil {
+			return nil, []string{"failed to list Mongo users: " + err.Error()}
+		}
+	}
+
+	var users []renameUser
+	for _, u := range result.Users {
+		holdsRole := false
+		for _, r := range u.Roles {
+			if r.Db == params.DbName {
+				holdsRole = true
+			}
+		}
+		if u.Db != params.DbName && !holdsRole {
+			continue
+		}
+		if u.Db == params.DbName {
+			if _, ok := params.UserPasswords[u.User]; !ok {
+				problems = append(problems, fmt.Sprintf("password required in userPasswords to recreate Mongo user %s", u.User))
+			}
+		}
+		users = append(users, renameUser{User: u.User, Db: u.Db, Roles: u.Roles, CustomData: u.CustomData})
+	}
+	return users, problems
+}
+
+// checkRenamePrivileges verifies, when access control is enabled, that the connection
+// holds the privileges needed to move the database.
+func checkRenamePrivileges(ctx context.Context, client *mongo.Client, params renameDatabaseParams, plan *renamePlan) []string {
+	type privilege struct {
+		Resource struct {
+			Db          *string `bson:"db"`
+			Collection  *string `bson:"collection"`
+			AnyResource bool    `bson:"anyResource"`
+		} `bson:"resource"`
+		Actions []string `bson:"actions"`
+	}
+	var status struct {
+		AuthInfo struct {
+			AuthenticatedUsers          []bson.M    `bson:"authenticatedUsers"`
+			AuthenticatedUserPrivileges []privilege `bson:"authenticatedUserPrivileges"`
+		} `bson:"authInfo"`
+	}
+	cmd := bson.D{{Key: "connectionStatus", Value: 1}, {Key: "showPrivileges", Value: true}}
+	if err := client.Database("admin").RunCommand(ctx, cmd).Decode(&status); err != nil {
+		return []string{"failed to check privileges: " + err.Error()}
+	}
+	// No authenticated user: access control is disabled.
+	if len(status.AuthInfo.AuthenticatedUsers) == 0 {
+		return nil
+	}
+
+	allowed := func(db, action string) bool {
+		for _, p := range status.AuthInfo.AuthenticatedUserPrivileges {
+			r := p.Resource
+			coversDb := r.AnyResource ||
+				(r.Db != nil && (*r.Db == db || *r.Db == "") && r.Collection != nil && *r.Collection == "")
+			if !coversDb {
+				continue
+			}
+			for _, a := range p.Actions {
+				if a == action {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	required := map[string][]string{
+		params.DbName:    {"find", "listCollections", "dropCollection", "dropDatabase"},
+		params.NewDbName: {"insert", "createCollection", "createIndex"},
+	}
+	if len(plan.LocalUsers) > 0 {
+		required[params.DbName] = append(required[params.DbName], "dropUser")
+		required[params.NewDbName] = append(required[params.NewDbName], "createUser", "grantRole")
+	}
+	if len(plan.ExternalUsers) > 0 {
+		required[params.NewDbName] = append(required[params.NewDbName], "grantRole")
+		required[params.DbName] = append(required[params.DbName], "revokeRole")
+	}
+
+	var problems []string
+	for db, actions := range required {
+		for _, action := range actions {
+			if !allowed(db, action) {
+				problems = append(problems, fmt.Sprintf("connection lacks the %s privilege on database %s", action, db))
+			}
+		}
+	}
+	return problems
+}
+
+// runRenameDatabase moves a database under a new name.
+//
+// Collections are moved with renameCollection, views are recreated, collection options
+// (validators) are checked, and database-scoped Mongo users are recreated or re-granted.
+// Progress is checkpointed after each step so the job resumes after a restart.
+// On failure everything is moved back, unless onFailure is "pause", in which case the
+// job can be retried to resume from its checkpoint.
+func runRenameDatabase(ctx context.Context, task *jobs.Task) (interface{}, error) {
+	var params renameDatabaseParams
+	if err := task.DecodeParams(&params); err != nil {
+		return nil, err
+	}
+	if params.OnFailure == "" {
+		params.OnFailure = renameOnFailureRollback
+	}
+	for user, encrypted := range params.UserPasswords {
+		pwd, err := decrypt(encrypted)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt password of Mongo user %s: %w", user, err)
+		}
+		params.UserPasswords[user] = pwd
+	}
+
+	client, err := connectEnvironment(ctx, task.Job.EnvironmentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to MongoDB: %w", err)
+	}
+	defer client.Disconnect(context.Background())
+
+	var cp renameCheckpoint
+	resumed, err := task.LoadCheckpoint(&cp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load checkpoint: %w", err)
+	}
+	if !resumed || cp.Phase == "" {
+		task.SetProgress(0, "Preflight checks")
+		plan, problems := preflightRenameDatabase(ctx, client, task.Job.EnvironmentID, params)
+		if len(problems) > 0 {
+			return map[string]interface{}{"problems": problems}, errors.New("preflight failed: " + strings.Join(problems, "; "))
+		}
+		cp = renameCheckpoint{Phase: renamePhaseCollections, Plan: *plan}
+		if err := task.SaveCheckpoint(cp); err != nil {
+			return nil, fmt.Errorf("failed to save checkpoint: %w", err)
+		}
+	}
+
+	if cp.Phase == renamePhaseRollback {
+		// A previous rollback was interrupted.
+		return nil, rollbackRename(client, task, params, &cp, errors.New(cp.FailureReason))
+	}
+
+	if err := applyRename(ctx, client, task, params, &cp); err != nil {
+		if params.OnFailure == renameOnFailurePause {
+			return renameSummary(params, &cp), fmt.Errorf("rename paused, retry the job to resume: %w", err)
+		}
+		return nil, rollbackRename(client, task, params, &cp, err)
+	}
+
+	return renameSummary(params, &cp), nil
+}
+
+func renameSummary(params renameDatabaseParams, cp *renameCheckpoint) map[string]interface{} {
+	return map[string]interface{}{
+		"oldName":      params.DbName,
+		"newName":      params.NewDbName,
+		"phase":        cp.Phase,
+		"collections":  cp.Moved,
+		"views":        cp.CreatedViews,
+		"createdUsers": cp.CreatedUsers,
+		"grantedUsers": cp.GrantedUsers,
+	}
+}
+
+// applyRename performs (or resumes) the rename phases.
+func applyRename(ctx context.Context, client *mongo.Client, task *jobs.Task, params renameDatabaseParams, cp *renameCheckpoint) error {
+	save := func() error {
+		if err := task.SaveCheckpoint(cp); err != nil {
+			return fmt.Errorf("failed to save checkpoint: %w", err)
+		}
+		return nil
+	}
+	steps := len(cp.Plan.Collections) + len(cp.Plan.Views) + len(cp.Plan.LocalUsers) + len(cp.Plan.ExternalUsers) + 1
+	done := func() int {
+		return (len(cp.Moved) + len(cp.CreatedViews) + len(cp.CreatedUsers) + len(cp.GrantedUsers)) * 100 / steps
+	}
+
+	sourceDb := client.Database(params.DbName)
+	targetDb := client.Database(params.NewDbName)
+
+	if cp.Phase == renamePhaseCollections {
+		sourceInfos, err := listCollectionInfos(ctx, sourceDb)
+		if err != nil {
+			return fmt.Errorf("failed to list source collections: %w", err)
+		}
+		sourceOptions := map[string]bson.M{}
+		for _, info := range sourceInfos {
+			sourceOptions[info.Name] = info.Options
+		}
+
+		for _, coll := range cp.Plan.Collections {
+			if containsString(cp.Moved, coll) {
+				continue
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			task.SetProgress(done(), fmt.Sprintf("Moving collection %s", coll))
+
+			opts, inSource := sourceOptions[coll]
+			if inSource {
+				cmd := bson.D{
+					{Key: "renameCollection", Value: params.DbName + "." + coll},
+					{Key: "to", Value: params.NewDbName + "." + coll},
+					{Key: "dropTarget", Value: false},
+				}
+				if err := client.Database("admin").RunCommand(ctx, cmd).Err(); err != nil {
+					return fmt.Errorf("failed to move collection %s: %w", coll, err)
+				}
+				if err := ensureCollectionValidator(ctx, targetDb, coll, opts); err != nil {
+					return err
+				}
+			}
+			// If the collection is no longer in the source, it was moved before an interruption.
+			cp.Moved = append(cp.Moved, coll)
+			if err := save(); err != nil {
+				return err
+			}
+		}
+		cp.Phase = renamePhaseViews
+		if err := save(); err != nil {
+			return err
+		}
+	}
+
+	if cp.Phase == renamePhaseViews {
+		for _, view := range cp.Plan.Views {
+			if containsString(cp.CreatedViews, view.Name) {
+				continue
+			}
+			task.SetProgress(done(), fmt.Sprintf("Recreating view %s", view.Name))
+			var pipeline struct {
+				Pipeline bson.A `bson:"pipeline"`
+			}
+			if err := bson.UnmarshalExtJSON([]byte(view.Pipeline), true, &pipeline); err != nil {
+				return fmt.Errorf("failed to decode pipeline of view %s: %w", view.Name, err)
+			}
+			if pipeline.Pipeline == nil {
+				pipeline.Pipeline = bson.A{}
+			}
+			cmd := bson.D{
+				{Key: "create", Value: view.Name},
+				{Key: "viewOn", Value: view.ViewOn},
+				{Key: "pipeline", Value: pipeline.Pipeline},
+			}
+			if view.Collation != nil {
+				cmd = append(cmd, bson.E{Key: "collation", Value: view.Collation})
+			}
+			if err := targetDb.RunCommand(ctx, cmd).Err(); err != nil && !isNamespaceExists(err) {
+				return fmt.Errorf("failed to recreate view %s: %w", view.Name, err)
+			}
+			cp.CreatedViews = append(cp.CreatedViews, view.Name)
+			if err := save(); err != nil {
+				return err
+			}
+		}
+		cp.Phase = renamePhaseUsers
+		if err := save(); err != nil {
+			return err
+		}
+	}
+
+	if cp.Phase == renamePhaseUsers {
+		for _, u := range cp.Plan.LocalUsers {
+			if containsString(cp.CreatedUsers, u.User) {
+				continue
+			}
+			task.SetProgress(done(), fmt.Sprintf("Recreating Mongo user %s", u.User))
+			cmd := bson.D{
+				{Key: "createUser", Value: u.User},
+				{Key: "pwd", Value: params.UserPasswords[u.User]},
+				{Key: "roles", Value: remapRoles(u.Roles, params.DbName, params.NewDbName)},
+			}
+			if u.CustomData != nil {
+				cmd = append(cmd, bson.E{Key: "customData", Value: u.CustomData})
+			}
+			if err := targetDb.RunCommand(ctx, cmd).Err(); err != nil && !isDuplicateUser(err) {
+				return fmt.Errorf("failed to recreate Mongo user %s: %w", u.User, err)
+			}
+			cp.CreatedUsers = append(cp.CreatedUsers, u.User)
+			if err := save(); err != nil {
+				return err
+			}
+		}
+		for _, u := range cp.Plan.ExternalUsers {
+			key := u.User + "@" + u.Db
+			if containsString(cp.GrantedUsers, key) {
+				continue
+			}
+			task.SetProgress(done(), fmt.Sprintf("Granting roles on %s to %s", params.NewDbName, key))
+			cmd := bson.D{
+				{Key: "grantRolesToUser", Value: u.User},
+				{Key: "roles", Value: rolesOnDb(u.Roles, params.DbName, params.NewDbName)},
+			}
+			if err := client.Database(u.Db).RunCommand(ctx, cmd).Err(); err != nil {
+				return fmt.Errorf("failed to grant roles to Mongo user %s: %w", key, err)
+			}
+			cp.GrantedUsers = append(cp.GrantedUsers, key)
+			if err := save(); err != nil {
+				return err
+			}
+		}
+
+		// Monji permissions, redaction and saved objects follow the database. The move
+		// and the end of this phase are saved together.
+		next := *cp
+		err := moveDatabaseReferences(task.Job.EnvironmentID, params.DbName, params.NewDbName, nil,
+			func(tx *sql.Tx, moved map[string][]int64) error {
+				next.MovedSettings = moved
+				next.Phase = renamePhaseCleanup
+				return task.SaveCheckpointTx(tx, next)
+			})
+		if err != nil {
+			return fmt.Errorf("failed to move Monji settings of the database: %w", err)
+		}
+		*cp = next
+	}
+
+	// From here on the target is complete: cleanup errors are reported but never rolled back.
+	task.SetProgress(99, "Removing the old database")
+	for _, u := range cp.Plan.ExternalUsers {
+		cmd := bson.D{
+			{Key: "revokeRolesFromUser", Value: u.User},
+			{Key: "roles", Value: rolesOnDb(u.Roles, params.DbName, params.DbName)},
+		}
+		if err := client.Database(u.Db).RunCommand(ctx, cmd).Err(); err != nil {
+			return fmt.Errorf("failed to revoke old roles of Mongo user %s@%s: %w", u.User, u.Db, err)
+		}
+	}
+	if len(cp.Plan.LocalUsers) > 0 {
+		if err := sourceDb.RunCommand(ctx, bson.D{{Key: "dropAllUsersFromDatabase", Value: 1}}).Err(); err != nil {
+			return fmt.Errorf("failed to drop old Mongo users: %w", err)
+		}
+	}
+	if err := sourceDb.Drop(ctx); err != nil {
+		return fmt.Errorf("failed to drop old database: %w", err)
+	}
+	return nil
+}
+
+// rollbackRename undoes a partial rename and returns the original failure.
+// It runs with its own context so that it also completes after a cancellation.
+func rollbackRename(client *mongo.Client, task *jobs.Task, params renameDatabaseParams, cp *renameCheckpoint, cause error) error {
+	if cp.Phase == renamePhaseCleanup {
+		// The target is complete, moving back would lose the cleanup already done.
+		return fmt.Errorf("rename completed but cleanup failed: %w", cause)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	cp.Phase = renamePhaseRollback
+	cp.FailureReason = cause.Error()
+	_ = task.SaveCheckpoint(cp)
+	task.SetProgress(0, "Rolling back: "+cause.Error())
+
+	var rollbackErrs []string
+	targetDb := client.Database(params.NewDbName)
+	for _, u := range cp.GrantedUsers {
+		parts := strings.SplitN(u, "@", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		for _, ext := range cp.Plan.ExternalUsers {
+			if ext.User != parts[0] || ext.Db != parts[1] {
+				continue
+			}
+			cmd := bson.D{
+				{Key: "revokeRolesFromUser", Value: ext.User},
+				{Key: "roles", Value: rolesOnDb(ext.Roles, params.DbName, params.NewDbName)},
+			}
+			if err := client.Database(ext.Db).RunCommand(ctx, cmd).Err(); err != nil {
+				rollbackErrs = append(rollbackErrs, fmt.Sprintf("revoke roles of %s: %v", u, err))
+			}
+		}
+	}
+	for _, user := range cp.CreatedUsers {
+		if err := targetDb.RunCommand(ctx, bson.D{{Key: "dropUser", Value: user}}).Err(); err != nil {
+			rollbackErrs = append(rollbackErrs, fmt.Sprintf("drop user %s: %v", user, err))
+		}
+	}
+	for _, view := range cp.CreatedViews {
+		if err := targetDb.Collection(view).Drop(ctx); err != nil {
+			rollbackErrs = append(rollbackErrs, fmt.Sprintf("drop view %s: %v", view, err))
+		}
+	}
+	if cp.MovedSettings != nil {
+		next := *cp
+		next.MovedSettings = nil
+		err := moveDatabaseReferences(task.Job.EnvironmentID, params.NewDbName, params.DbName, cp.MovedSettings,
+			func(tx *sql.Tx, _ map[string][]int64) error { return task.SaveCheckpointTx(tx, next) })
+		if err != nil {
+			rollbackErrs = append(rollbackErrs, "move back Monji settings: "+err.Error())
+		} else {
+			*cp = next
+		}
+	}
+
+	// Move back every collection found in the target, including one renamed
+	// right before an interruption and not yet recorded.
+	targetColls, err := targetDb.ListCollectionNames(ctx, bson.D{})
+	if err != nil {
+		rollbackErrs = append(rollbackErrs, "list target collections: "+err.Error())
+	}
+	for _, coll := range targetColls {
+		if !containsString(cp.Plan.Collections, coll) {
+			continue
+		}
+		cmd := bson.D{
+			{Key: "renameCollection", Value: params.NewDbName + "." + coll},
+			{Key: "to", Value: params.DbName + "." + coll},
+			{Key: "dropTarget", Value: false},
+		}
+		if err := client.Database("admin").RunCommand(ctx, cmd).Err(); err != nil {
+			rollbackErrs = append(rollbackErrs, fmt.Sprintf("move back %s: %v", coll, err))
+		}
+	}
+
+	if len(rollbackErrs) > 0 {
+		// Keep the checkpoint so a retry finishes the rollback.
+		return fmt.Errorf("%v; rollback incomplete: %s", cause, strings.Join(rollbackErrs, "; "))
+	}
+	_ = task.SaveCheckpoint(renameCheckpoint{})
+	return fmt.Errorf("%v; changes rolled back", cause)
+}
+
+// databaseReferenceTables hold Monji settings scoped to a database by its name.
+var databaseReferenceTables = []string{
+	"user_db_permissions", "group_db_permissions", "redaction_rules", "unmasked_grants", "saved_queries", "triggers",
+}
+
+// checkRenameGrants reports the Monji grants that would conflict once moved to the new
+// name: a user or group can hold a single grant per database.
+func checkRenameGrants(envID int, params renameDatabaseParams) []string {
+	var problems []string
+	for _, g := range []struct{ table, column, kind string }{
+		{"user_db_permissions", "user_id", "users"},
+		{"group_db_permissions", "group_id", "groups"},
+	} {
+		var n int
+		err := database.DB.QueryRow(
+			`SELECT COUNT(*) FROM `+g.table+` o JOIN `+g.table+` n ON n.`+g.column+` = o.`+g.column+`
+			 WHERE o.environment_id = ? AND n.environment_id = ? AND o.db_name = ? AND n.db_name = ?`,
+			envID, envID, params.DbName, params.NewDbName,
+		).Scan(&n)
+		if err != nil {
+			problems = append(problems, "failed to check Monji grants: "+err.Error())
+		} else if n > 0 {
+			problems = append(problems, fmt.Sprintf("%d %s already have a Monji grant on both databases, remove one of them first", n, g.kind))
+		}
+	}
+	return problems
+}
+
+// moveDatabaseReferences points the Monji settings of a database to its new name, in one
+// transaction. Only the rows listed in ids are moved, or every row of the database when ids
+// is nil. record is called in the transaction with the IDs of the moved rows.
+// Moved triggers restart from the current time, as the resume token of the old database's
+// change stream cannot be used on the new one.
+func moveDatabaseReferences(envID int, from, to string, ids map[string][]int64, record func(tx *sql.Tx, moved map[string][]int64) error) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	moved := map[string][]int64{}
+	for _, table := range databaseReferenceTables {
+		if ids == nil {
+			rows, err := tx.Query(`SELECT id FROM `+table+` WHERE environment_id = ? AND db_name = ?`, envID, from)
+			if err != nil {
+				return fmt.Errorf("%s: %w", table, err)
+			}
+			for rows.Next() {
+				var id int64
+				if err := rows.Scan(&id); err != nil {
+					rows.Close()
+					return fmt.Errorf("%s: %w", table, err)
+				}
+				moved[table] = append(moved[table], id)
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return fmt.Errorf("%s: %w", table, err)
+			}
+		} else {
+			moved[table] = ids[table]
+		}
+		for _, id := range moved[table] {
+			if _, err := tx.Exec(`UPDATE `+table+` SET db_name = ? WHERE id = ? AND db_name = ?`, to, id, from); err != nil {
+				return fmt.Errorf("%s: %w", table, err)
+			}
+			if table == "triggers" {
+				if _, err := tx.Exec(`UPDATE triggers SET resume_token = '', updated_at = ? WHERE id = ?`, time.Now().UTC(), id); err != nil {
+					return fmt.Errorf("%s: %w", table, err)
+				}
+			}
+		}
+	}
+	if err := record(tx, moved); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ensureCollectionValidator re-applies the source validator if the moved collection lost it.
+func ensureCollectionValidator(ctx context.Context, db *mongo.Database, coll string, sourceOptions bson.M) error {
+	validator, ok := sourceOptions["validator"]
+	if !ok {
+		return nil
+	}
+	infos, err := listCollectionInfos(ctx, db)
+	if err != nil {
+		return fmt.Errorf("failed to verify options of %s: %w", coll, err)
+	}
+	for _, info := range infos {
+		if info.Name != coll {
+			continue
+		}
+		if _, ok := info.Options["validator"]; ok {
+			return nil
+		}
+	}
+	cmd := bson.D{{Key: "collMod", Value: coll}, {Key: "validator", Value: validator}}
+	if level, ok := sourceOptions["validationLevel"]; ok {
+		cmd = append(cmd, bson.E{Key: "validationLevel", Value: level})
+	}
+	if action, ok := sourceOptions["validationAction"]; ok {
+		cmd = append(cmd, bson.E{Key: "validationAction", Value: action})
+	}
+	if err := db.RunCommand(ctx, cmd).Err(); err != nil {
+		return fmt.Errorf("failed to restore validator of %s: %w", coll, err)
+	}
+	return nil
+}
+
+// remapRoles returns roles with every reference to from replaced by to.
+func remapRoles(roles []mongoUserRole, from, to string) []mongoUserRole {
+	out := make([]mongoUserRole, 0, len(roles))
+	for _, r := range roles {
+		if r.Db == from {
+			r.Db = to
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// rolesOnDb returns the roles held on db, rewritten to target db onDb.
+func rolesOnDb(roles []mongoUserRole, db, onDb string) []mongoUserRole {
+	out := []mongoUserRole{}
+	for _, r := range roles {
+		if r.Db == db {
+			out = append(out, mongoUserRole{Role: r.Role, Db: onDb})
+		}
+	}
+	return out
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// isNamespaceExists returns true for the NamespaceExists (48) server error.
+func isNamespaceExists(err error) bool {
+	var cmdErr mongo.CommandError
+	return errors.As(err, &cmdErr) && cmdErr.Code == 48
+}
+
+// isDuplicateUser returns true when createUser failed because the user exists (51003).
+func isDuplicateUser(err error) bool {
+	var cmdErr mongo.CommandError
+	return errors.As(err, &cmdErr) && cmdErr.Code == 51003
+}
@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"monji/internal/database"
+	"monji/internal/jobs"
+	"monji/internal/models"
+)
+
+func TestRolesOnDb(t *testing.T) {
+	roles := []mongoUserRole{
+		{Role: "read", Db: "shop"},
+		{Role: "readWrite", Db: "other"},
+		{Role: "dbAdmin", Db: "shop"},
+	}
+	tests := []struct {
+		db, onDb string
+		want     []mongoUserRole
+	}{
+		{"shop", "store", []mongoUserRole{{Role: "read", Db: "store"}, {Role: "dbAdmin", Db: "store"}}},
+		{"shop", "shop", []mongoUserRole{{Role: "read", Db: "shop"}, {Role: "dbAdmin", Db: "shop"}}},
+		{"missing", "store", []mongoUserRole{}},
+	}
+	for _, tt := range tests {
+		if got := rolesOnDb(roles, tt.db, tt.onDb); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("rolesOnDb(%q, %q) = %v, want %v", tt.db, tt.onDb, got, tt.want)
+		}
+	}
+
+	remapped := remapRoles(roles, "shop", "store")
+	want := []mongoUserRole{{Role: "read", Db: "store"}, {Role: "readWrite", Db: "other"}, {Role: "dbAdmin", Db: "store"}}
+	if !reflect.DeepEqual(remapped, want) {
+		t.Errorf("remapRoles() = %v, want %v", remapped, want)
+	}
+}
+
+func TestValidDatabaseName(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"shop", true},
+		{"tenant_0001", true},
+		{"", false},
+		{"admin", false},
+		{"config", false},
+		{"local", false},
+		{"a.b", false},
+		{"a b", false},
+		{"a$b", false},
+		{"a/b", false},
+		{strings.Repeat("a", 63), true},
+		{strings.Repeat("a", 64), false},
+	}
+	for _, tt := range tests {
+		if got := validDatabaseName(tt.name); got != tt.want {
+			t.Errorf("validDatabaseName(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// initTestDB opens a fresh SQLite database for the test.
+func initTestDB(t *testing.T) {
+	t.Helper()
+	database.InitSQLite(filepath.Join(t.TempDir(), "monji.db"))
+	t.Cleanup(func() { database.DB.Close() })
+}
+
+func dbNamesByID(t *testing.T, table string) map[int64]string {
+	t.Helper()
+	rows, err := database.DB.Query(`SELECT id, db_name FROM ` + table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	names := map[int64]string{}
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			t.Fatal(err)
+		}
+		names[id] = name
+	}
+	return names
+}
+
+// TestRenameCheckpointSettings follows the Monji settings through the end of the users
+// phase, which moves them and records the move in the checkpoint in one transaction,
+// and through a rollback.
+func TestRenameCheckpointSettings(t *testing.T) {
+	initTestDB(t)
+	now := time.Now().UTC()
+	for _, q := range []string{
+		`INSERT INTO user_db_permissions (id, user_id, environment_id, db_name, permission) VALUES (1, 2, 1, 'shop', 'readOnly')`,
+		`INSERT INTO user_db_permissions (id, user_id, environment_id, db_name, permission) VALUES (2, 3, 1, 'store', 'readAndWrite')`,
+		`INSERT INTO user_db_permissions (id, user_id, environment_id, db_name, permission) VALUES (3, 2, 2, 'shop', 'readOnly')`,
+	} {
+		if _, err := database.DB.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := database.DB.Exec(
+		`INSERT INTO triggers (id, name, environment_id, db_name, operation_types, url, secret, resume_token, created_by, created_at, updated_at)
+		 VALUES (1, 't', 1, 'shop', '[]', 'http://example.com', '', 'token', 1, ?, ?)`, now, now,
+	); err != nil {
+		t.Fatal(err)
+	}
+	res, err := database.DB.Exec(
+		`INSERT INTO jobs (type, status, environment_id, created_by, created_at) VALUES (?, ?, 1, 1, ?)`,
+		jobTypeRenameDatabase, models.JobRunning, now,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobID, _ := res.LastInsertId()
+	task := &jobs.Task{Job: models.Job{ID: int(jobID), EnvironmentID: 1}}
+	cp := renameCheckpoint{Phase: renamePhaseUsers, Moved: []string{"orders"}}
+	if err := task.SaveCheckpoint(cp); err != nil {
+		t.Fatal(err)
+	}
+	loadCheckpoint := func() renameCheckpoint {
+		var loaded renameCheckpoint
+		if _, err := task.LoadCheckpoint(&loaded); err != nil {
+			t.Fatal(err)
+		}
+		return loaded
+	}
+
+	// A failure to record the move undoes it.
+	err = moveDatabaseReferences(1, "shop", "store", nil, func(tx *sql.Tx, _ map[string][]int64) error {
+		return errors.New("checkpoint failed")
+	})
+	if err == nil {
+		t.Fatal("moveDatabaseReferences() succeeded although record failed")
+	}
+	if got := dbNamesByID(t, "user_db_permissions")[1]; got != "shop" {
+		t.Errorf("after a failed record, grant 1 is on %q, want shop", got)
+	}
+	if got := loadCheckpoint().Phase; got != renamePhaseUsers {
+		t.Errorf("after a failed record, phase = %q, want %q", got, renamePhaseUsers)
+	}
+
+	// The move and the cleanup phase are saved together.
+	next := cp
+	err = moveDatabaseReferences(1, "shop", "store", nil, func(tx *sql.Tx, moved map[string][]int64) error {
+		next.MovedSettings = moved
+		next.Phase = renamePhaseCleanup
+		return task.SaveCheckpointTx(tx, next)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := loadCheckpoint()
+	if saved.Phase != renamePhaseCleanup {
+		t.Errorf("phase = %q, want %q", saved.Phase, renamePhaseCleanup)
+	}
+	wantMoved := map[string][]int64{"user_db_permissions": {1}, "triggers": {1}}
+	if !reflect.DeepEqual(saved.MovedSettings, wantMoved) {
+		t.Errorf("MovedSettings = %v, want %v", saved.MovedSettings, wantMoved)
+	}
+	if got, want := dbNamesByID(t, "user_db_permissions"), map[int64]string{1: "store", 2: "store", 3: "shop"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("grants after the move = %v, want %v", got, want)
+	}
+	var token string
+	if err := database.DB.QueryRow(`SELECT resume_token FROM triggers WHERE id = 1`).Scan(&token); err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		t.Errorf("moved trigger kept its resume token %q", token)
+	}
+
+	// Moving back only touches the recorded rows: grant 2 was already on the target.
+	err = moveDatabaseReferences(1, "store", "shop", saved.MovedSettings, func(tx *sql.Tx, _ map[string][]int64) error {
+		saved.MovedSettings = nil
+		saved.Phase = renamePhaseRollback
+		return task.SaveCheckpointTx(tx, saved)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := dbNamesByID(t, "user_db_permissions"), map[int64]string{1: "shop", 2: "store", 3: "shop"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("grants after moving back = %v, want %v", got, want)
+	}
+	if got := loadCheckpoint(); got.Phase != renamePhaseRollback || got.MovedSettings != nil {
+		t.Errorf("checkpoint after moving back = %+v, want the rollback phase without moved settings", got)
+	}
+}
+
+func TestRollbackRenameAfterCleanup(t *testing.T) {
+	cp := &renameCheckpoint{Phase: renamePhaseCleanup, MovedSettings: map[string][]int64{"triggers": {1}}}
+	err := rollbackRename(nil, nil, renameDatabaseParams{DbName: "shop", NewDbName: "store"}, cp, errors.New("drop failed"))
+	if err == nil || !strings.Contains(err.Error(), "cleanup failed") {
+		t.Errorf("rollbackRename() = %v, want a cleanup failure", err)
+	}
+	if cp.Phase != renamePhaseCleanup || cp.MovedSettings == nil {
+		t.Errorf("rollbackRename() changed a completed rename: %+v", cp)
+	}
+}
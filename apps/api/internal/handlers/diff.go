@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Field change operations reported by diffDocuments.
+const (
+	diffAdded   = "added"
+	diffRemoved = "removed"
+	diffChanged = "changed"
+)
+
+// fieldChange is one difference between two versions of a document.
+// Path uses dot notation for embedded documents; arrays are compared as a whole.
+type fieldChange struct {
+	Path   string      `json:"path"`
+	Op     string      `json:"op"` // "added", "removed" or "changed"
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// diffDocuments returns the field-level differences between before and after, sorted by path.
+// Either document may be nil (insert or delete).
+func diffDocuments(before, after bson.M) []fieldChange {
+	changes := []fieldChange{}
+	diffInto(&changes, "", before, after)
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+func diffInto(changes *[]fieldChange, prefix string, before, after bson.M) {
+	for key, b := range before {
+		path := prefix + key
+		a, ok := after[key]
+		if !ok {
+			*changes = append(*changes, fieldChange{Path: path, Op: diffRemoved, Before: b})
+			continue
+		}
+		bDoc, bIsDoc := asDocument(b)
+		aDoc, aIsDoc := asDocument(a)
+		if bIsDoc && aIsDoc {
+			diffInto(changes, path+".", bDoc, aDoc)
+			continue
+		}
+		if !reflect.DeepEqual(b, a) {
+			*changes = append(*changes, fieldChange{Path: path, Op: diffChanged, Before: b, After: a})
+		}
+	}
+	for key, a := range after {
+		if _, ok := before[key]; !ok {
+			*changes = append(*changes, fieldChange{Path: prefix + key, Op: diffAdded, After: a})
+		}
+	}
+}
+
+// asDocument returns v as a bson.M if it is an embedded document.
+func asDocument(v interface{}) (bson.M, bool) {
+	switch d := v.(type) {
+	case bson.M:
+		return d, true
+	case map[string]interface{}:
+		return bson.M(d), true
+	case bson.D:
+		return d.Map(), true
+	}
+	return nil, false
+}
+
+// applySetFields returns a copy of doc with the fields of a $set applied.
+// Keys may use dot notation to reach into embedded documents.
+func applySetFields(doc bson.M, fields bson.M) bson.M {
+	out := copyDocument(doc)
+	for key, value := range fields {
+		parts := strings.Split(key, ".")
+		target := out
+		for _, part := range parts[:len(parts)-1] {
+			next, ok := asDocument(target[part])
+			if !ok {
+				next = bson.M{}
+			} else {
+				next = copyDocument(next)
+			}
+			target[part] = next
+			target = next
+		}
+		target[parts[len(parts)-1]] = value
+	}
+	return out
+}
+
+// copyDocument returns a shallow copy of doc.
+func copyDocument(doc bson.M) bson.M {
+	out := bson.M{}
+	for k, v := range doc {
+		out[k] = v
+	}
+	return out
+}
+
+// documentIDFilter returns the _id filter for a document ID taken from a URL:
+// an ObjectID when it is a valid hex ObjectID, the raw string otherwise.
+func documentIDFilter(docIDStr string) bson.M {
+	if objID, err := primitive.ObjectIDFromHex(docIDStr); err == nil {
+		return bson.M{"_id": objID}
+	}
+	return bson.M{"_id": docIDStr}
+}
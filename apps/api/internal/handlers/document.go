@@ -2,6 +2,11 @@ package handlers
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 	"time"
@@ -13,29 +18,151 @@ import (
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // getDbPermissionString returns the DB-level permission for the given user.
 func getDbPermissionString(user models.User, envID int, dbName string) string {
-	if middleware.IsAdmin(user) {
-		return "readAndWrite"
-	}
-	row := database.DB.QueryRow(
-		`SELECT permission FROM user_db_permissions WHERE user_id = ? AND environment_id = ? AND db_name = ?`,
-		user.ID, envID, dbName,
-	)
-	var perm string
-	if err := row.Scan(&perm); err != nil {
+	perm, err := middleware.DBPermission(user, envID, dbName)
+	if err != nil || perm == middleware.PermissionDeny {
 		return "none"
 	}
 	return perm
 }
 
+// Modes of UpdateDocument.
+const (
+	updateModeSet       = "set"
+	updateModeReplace   = "replace"
+	updateModeOperators = "update"
+)
+
+// updateOperators are the operators accepted in an update document.
+var updateOperators = map[string]bool{
+	"$set": true, "$unset": true, "$inc": true, "$mul": true, "$rename": true, "$min": true,
+	"$max": true, "$currentDate": true, "$push": true, "$pull": true, "$pullAll": true,
+	"$addToSet": true, "$pop": true, "$bit": true,
+}
+
+// updatePipelineStages are the stages an update pipeline may use.
+var updatePipelineStages = map[string]bool{
+	"$addFields": true, "$set": true, "$project": true, "$unset": true,
+	"$replaceRoot": true, "$replaceWith": true,
+}
+
+// validateRawUpdate checks the body of an "update" mode UpdateDocument: an update
+// document made only of update operators, or a pipeline of single-stage documents.
+func validateRawUpdate(body interface{}) (interface{}, error) {
+	switch u := body.(type) {
+	case map[string]interface{}:
+		if len(u) == 0 {
+			return nil, errors.New("the update document is empty")
+		}
+		for op, args := range u {
+			if !updateOperators[op] {
+				return nil, fmt.Errorf("unsupported update operator %q", op)
+			}
+			if _, ok := args.(map[string]interface{}); !ok {
+				return nil, fmt.Errorf("the argument of %s must be a document", op)
+			}
+		}
+		return bson.M(u), nil
+	case []interface{}:
+		if len(u) == 0 {
+			return nil, errors.New("the update pipeline is empty")
+		}
+		pipeline := bson.A{}
+		for i, stage := range u {
+			doc, ok := stage.(map[string]interface{})
+			if !ok || len(doc) != 1 {
+				return nil, fmt.Errorf("pipeline stage %d must be a document with a single stage", i)
+			}
+			for name := range doc {
+				if !updatePipelineStages[name] {
+					return nil, fmt.Errorf("stage %s cannot be used in an update pipeline", name)
+				}
+			}
+			pipeline = append(pipeline, bson.M(doc))
+		}
+		return pipeline, nil
+	}
+	return nil, errors.New("the update must be a document of update operators or a pipeline")
+}
+
+// ETagSecret keys the entity tags of documents and the hashes of masked values, so that
+// a masked user cannot check guesses of the masked values against either.
+var ETagSecret []byte
+
+// documentETag returns the entity tag of a document: an HMAC of its raw BSON, so any
+// change to the document, including field order, changes it.
+func documentETag(raw bson.Raw) string {
+	mac := hmac.New(sha256.New, ETagSecret)
+	mac.Write(raw)
+	return `"` + hex.EncodeToString(mac.Sum(nil)) + `"`
+}
+
+// unchangedFilter matches a document only while it is still exactly raw, for writes
+// conditional on the version the client read.
+func unchangedFilter(raw bson.Raw) bson.M {
+	return bson.M{
+		"_id":   raw.Lookup("_id"),
+		"$expr": bson.M{"$eq": bson.A{"$$ROOT", bson.M{"$literal": raw}}},
+	}
+}
+
+// loadIfMatch loads the document targeted by a conditional write and checks it against
+// the If-Match header ("*" matches any version). It writes the error response itself
+// and returns nil on failure: 428 without If-Match, 404 if the document does not exist
+// and 412 with the current document if it changed.
+func loadIfMatch(ctx context.Context, c *gin.Context, coll *mongo.Collection, filter bson.M, redact *redactor) bson.Raw {
+	ifMatch := c.GetHeader("If-Match")
+	if ifMatch == "" {
+		c.JSON(http.StatusPreconditionRequired, gin.H{"error": "The If-Match header is required, with the ETag returned when reading the document"})
+		return nil
+	}
+	raw, err := coll.FindOne(ctx, filter).DecodeBytes()
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return nil
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch document: " + err.Error()})
+		return nil
+	}
+	if ifMatch != "*" && ifMatch != documentETag(raw) {
+		respondDocumentChanged(c, raw, redact)
+		return nil
+	}
+	return raw
+}
+
+// respondDocumentChanged answers a conditional write whose document changed since it
+// was read, with the current version of the document.
+func respondDocumentChanged(c *gin.Context, current bson.Raw, redact *redactor) {
+	var doc bson.M
+	if err := bson.Unmarshal(current, &doc); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	redact.Document(doc)
+	etag := documentETag(current)
+	c.Header("ETag", etag)
+	c.JSON(http.StatusPreconditionFailed, gin.H{
+		"error":    "The document was modified since it was read",
+		"etag":     etag,
+		"document": doc,
+	})
+}
+
 // GetDocuments retrieves all documents from a collection and attaches "myPermission" to each.
 func GetDocuments(c *gin.Context) {
 	envIDStr := c.Param("id")
 	dbName := c.Param("dbName")
 	collName := c.Param("collName")
+	if !requireVisibleCollections(c, collName) {
+		return
+	}
 	envID, err := strconv.Atoi(envIDStr)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid environment ID"})
@@ -74,6 +201,9 @@ func GetDocuments(c *gin.Context) {
 		return
 	}
 	defer client.Disconnect(ctx)
+	if !requireReadableCollection(ctx, c, currentUser, envID, client.Database(dbName), collName) {
+		return
+	}
 	cursor, err := client.Database(dbName).Collection(collName).Find(ctx, bson.M{})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch documents: " + err.Error()})
@@ -85,6 +215,12 @@ func GetDocuments(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode documents: " + err.Error()})
 		return
 	}
+	redact, err := documentRedactor(currentUser, envID, dbName, collName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	redact.Documents(documents)
 	myPerm := "readAndWrite"
 	if !isAdmin {
 		myPerm = getDbPermissionString(currentUser, envID, dbName)
@@ -100,11 +236,16 @@ func GetDocuments(c *gin.Context) {
 }
 
 // GetDocument fetches a single document from a collection and attaches "myPermission".
+// Its ETag (also in the "etag" field) is the version to send in If-Match when updating
+// or deleting it.
 func GetDocument(c *gin.Context) {
 	envIDStr := c.Param("id")
 	dbName := c.Param("dbName")
 	collName := c.Param("collName")
 	docIDStr := c.Param("docID")
+	if !requireVisibleCollections(c, collName) {
+		return
+	}
 	envID, err := strconv.Atoi(envIDStr)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid environment ID"})
@@ -150,20 +291,37 @@ func GetDocument(c *gin.Context) {
 		return
 	}
 	defer client.Disconnect(ctx)
-	var result bson.M
-	if err := client.Database(dbName).Collection(collName).FindOne(ctx, filter).Decode(&result); err != nil {
+	if !requireReadableCollection(ctx, c, currentUser, envID, client.Database(dbName), collName) {
+		return
+	}
+	raw, err := client.Database(dbName).Collection(collName).FindOne(ctx, filter).DecodeBytes()
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
 		return
 	}
+	var result bson.M
+	if err := bson.Unmarshal(raw, &result); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode document: " + err.Error()})
+		return
+	}
+	redact, err := documentRedactor(currentUser, envID, dbName, collName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	redact.Document(result)
 	myPerm := "readAndWrite"
 	if !isAdmin {
 		myPerm = getDbPermissionString(currentUser, envID, dbName)
 	}
 	result["myPermission"] = myPerm
+	etag := documentETag(raw)
+	c.Header("ETag", etag)
 	c.JSON(http.StatusOK, gin.H{
 		"database":   dbName,
 		"collection": collName,
 		"document":   result,
+		"etag":       etag,
 	})
 }
 
@@ -173,6 +331,9 @@ func CreateDocument(c *gin.Context) {
 	envIDStr := c.Param("id")
 	dbName := c.Param("dbName")
 	collName := c.Param("collName")
+	if !requireVisibleCollections(c, collName) {
+		return
+	}
 	envID, err := strconv.Atoi(envIDStr)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid environment ID"})
@@ -216,6 +377,9 @@ func CreateDocument(c *gin.Context) {
 		return
 	}
 	defer client.Disconnect(ctx)
+	if !requireWritableCollection(ctx, c, client.Database(dbName), collName) {
+		return
+	}
 	res, err := client.Database(dbName).Collection(collName).InsertOne(ctx, doc)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to insert document: " + err.Error()})
@@ -227,12 +391,28 @@ func CreateDocument(c *gin.Context) {
 	})
 }
 
-// UpdateDocument updates a document by its _id.
+// UpdateDocument updates a document by its _id, recording the previous version in the
+// document's history (see GetDocumentHistory). The "mode" query parameter selects
+// how the body is applied:
+//   - "set" (default): the body's fields are $set, other fields are left as is;
+//   - "replace": the body replaces the whole document, which keeps its _id;
+//   - "update": the body is an update document ({"$unset": {"a": ""}, "$push": ...})
+//     or an aggregation pipeline ([{"$set": ...}, {"$unset": ...}]).
+//
+// The If-Match header must carry the ETag returned by GetDocument: the update only
+// applies if the document is unchanged since, otherwise it fails with 412 and the
+// current document.
+//
+// Users reading the collection masked may only use the "set" mode, and may not write
+// masked fields; masked values sent back unchanged are left out of the update.
 func UpdateDocument(c *gin.Context) {
 	envIDStr := c.Param("id")
 	dbName := c.Param("dbName")
 	collName := c.Param("collName")
 	docIDStr := c.Param("docID")
+	if !requireVisibleCollections(c, collName) {
+		return
+	}
 	envID, err := strconv.Atoi(envIDStr)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid environment ID"})
@@ -251,12 +431,8 @@ func UpdateDocument(c *gin.Context) {
 	} else {
 		filter = bson.M{"_id": objID}
 	}
-	var updateData bson.M
-	if err := c.ShouldBindJSON(&updateData); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-	delete(updateData, "_id")
+	// The permission is checked before the body is even parsed: whatever the mode,
+	// readOnly users never get to send an update.
 	currentUserRaw, _ := c.Get("user")
 	currentUser := currentUserRaw.(models.User)
 	isAdmin := middleware.IsAdmin(currentUser)
@@ -271,6 +447,35 @@ func UpdateDocument(c *gin.Context) {
 			return
 		}
 	}
+	mode := c.DefaultQuery("mode", updateModeSet)
+	var update interface{}
+	switch mode {
+	case updateModeSet, updateModeReplace:
+		var fields bson.M
+		if err := c.ShouldBindJSON(&fields); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		delete(fields, "_id")
+		if mode == updateModeSet {
+			update = bson.M{"$set": fields}
+		} else {
+			update = fields
+		}
+	case updateModeOperators:
+		var body interface{}
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if update, err = validateRawUpdate(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mode must be 'set', 'replace' or 'update'"})
+		return
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
 	decryptedConn, err := decrypt(env.ConnectionString)
@@ -284,24 +489,79 @@ func UpdateDocument(c *gin.Context) {
 		return
 	}
 	defer client.Disconnect(ctx)
-	res, err := client.Database(dbName).Collection(collName).UpdateOne(ctx, filter, bson.M{"$set": updateData})
+	if !requireWritableCollection(ctx, c, client.Database(dbName), collName) {
+		return
+	}
+	redact, err := documentRedactor(currentUser, envID, dbName, collName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if redact != nil && mode != updateModeSet {
+		// A replacement or an update operator can rewrite masked fields without naming them.
+		c.JSON(http.StatusForbidden, gin.H{"error": "This collection has redacted fields; the " + mode + " mode requires an unmasked grant"})
+		return
+	}
+	coll := client.Database(dbName).Collection(collName)
+	current := loadIfMatch(ctx, c, coll, filter, redact)
+	if current == nil {
+		return
+	}
+	if mode == updateModeSet && !redact.Writable(current, update.(bson.M)["$set"].(bson.M)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Writing masked fields requires an unmasked grant"})
+		return
+	}
+	var updated bson.Raw
+	if mode == updateModeReplace {
+		// The replacement has no _id, so the document keeps its own.
+		updated, err = coll.FindOneAndReplace(ctx, unchangedFilter(current), update,
+			options.FindOneAndReplace().SetReturnDocument(options.After)).DecodeBytes()
+	} else {
+		updated, err = coll.FindOneAndUpdate(ctx, unchangedFilter(current), update,
+			options.FindOneAndUpdate().SetReturnDocument(options.After)).DecodeBytes()
+	}
+	if err == mongo.ErrNoDocuments {
+		// Changed (or deleted) between the check and the write.
+		if current, err = coll.FindOne(ctx, filter).DecodeBytes(); err == nil {
+			respondDocumentChanged(c, current, redact)
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update document: " + err.Error()})
 		return
 	}
+	etag := documentETag(updated)
+	var modified int64
+	if etag != documentETag(current) {
+		modified = 1
+		if err := recordDocumentRevision(envID, dbName, collName, docIDStr, models.RevisionUpdate, current, currentUser.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Document updated but recording its history failed: " + err.Error()})
+			return
+		}
+	}
+	c.Header("ETag", etag)
 	c.JSON(http.StatusOK, gin.H{
 		"message":       "Document updated successfully",
-		"matchedCount":  res.MatchedCount,
-		"modifiedCount": res.ModifiedCount,
+		"matchedCount":  1,
+		"modifiedCount": modified,
+		"etag":          etag,
 	})
 }
 
-// DeleteDocument deletes a document by its _id.
+// DeleteDocument deletes a document by its _id. The deleted version is recorded in
+// the document's history, from which it can be restored.
+// Like UpdateDocument, it requires the document's ETag in the If-Match header.
 func DeleteDocument(c *gin.Context) {
 	envIDStr := c.Param("id")
 	dbName := c.Param("dbName")
 	collName := c.Param("collName")
 	docIDStr := c.Param("docID")
+	if !requireVisibleCollections(c, collName) {
+		return
+	}
 	envID, err := strconv.Atoi(envIDStr)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid environment ID"})
@@ -347,11 +607,37 @@ func DeleteDocument(c *gin.Context) {
 		return
 	}
 	defer client.Disconnect(ctx)
-	res, err := client.Database(dbName).Collection(collName).DeleteOne(ctx, filter)
+	if !requireWritableCollection(ctx, c, client.Database(dbName), collName) {
+		return
+	}
+	redact, err := documentRedactor(currentUser, envID, dbName, collName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	coll := client.Database(dbName).Collection(collName)
+	current := loadIfMatch(ctx, c, coll, filter, redact)
+	if current == nil {
+		return
+	}
+	res, err := coll.DeleteOne(ctx, unchangedFilter(current))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete document: " + err.Error()})
 		return
 	}
+	if res.DeletedCount == 0 {
+		// Changed (or deleted) between the check and the write.
+		if current, err = coll.FindOne(ctx, filter).DecodeBytes(); err == nil {
+			respondDocumentChanged(c, current, redact)
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return
+	}
+	if err := recordDocumentRevision(envID, dbName, collName, docIDStr, models.RevisionDelete, current, currentUser.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Document deleted but recording its history failed: " + err.Error()})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"message":      "Document deleted successfully",
 		"deletedCount": res.DeletedCount,
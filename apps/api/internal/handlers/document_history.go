@@ -0,0 +1,357 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"monji/internal/database"
+	"monji/internal/middleware"
+	"monji/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxDocumentRevisions is the number of revisions kept per document; older ones are pruned.
+const maxDocumentRevisions = 50
+
+const documentRevisionColumns = `id, environment_id, db_name, collection_name, document_id, operation, user_id, created_at`
+
+// revisionEntry is a revision in a document's history, with what changed from it to
+// the next version (the next revision, or the current document for the latest one).
+type revisionEntry struct {
+	models.DocumentRevision
+	Changes []fieldChange `json:"changes"`
+}
+
+// recordDocumentRevision stores the version of a document replaced (or deleted) by a write,
+// and prunes the document's oldest revisions beyond maxDocumentRevisions.
+func recordDocumentRevision(envID int, dbName, collName, docID, operation string, preImage bson.Raw, userID int) error {
+	extJSON, err := bson.MarshalExtJSON(preImage, true, false)
+	if err != nil {
+		return err
+	}
+	if _, err := database.DB.Exec(
+		`INSERT INTO document_revisions (environment_id, db_name, collection_name, document_id, operation,
+		        document, user_id, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		envID, dbName, collName, docID, operation, string(extJSON), userID, time.Now().UTC(),
+	); err != nil {
+		return err
+	}
+	_, err = database.DB.Exec(
+		`DELETE FROM document_revisions
+		 WHERE environment_id = ? AND db_name = ? AND collection_name = ? AND document_id = ?
+		   AND id NOT IN (SELECT id FROM document_revisions
+		                  WHERE environment_id = ? AND db_name = ? AND collection_name = ? AND document_id = ?
+		                  ORDER BY id DESC LIMIT ?)`,
+		envID, dbName, collName, docID, envID, dbName, collName, docID, maxDocumentRevisions,
+	)
+	return err
+}
+
+// revisionDocument decodes the stored version of a revision.
+func revisionDocument(extJSON string) (bson.Raw, bson.M, error) {
+	var d bson.D
+	if err := bson.UnmarshalExtJSON([]byte(extJSON), true, &d); err != nil {
+		return nil, nil, err
+	}
+	raw, err := bson.Marshal(d)
+	if err != nil {
+		return nil, nil, err
+	}
+	var doc bson.M
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, nil, err
+	}
+	return raw, doc, nil
+}
+
+// documentHistoryTarget is the document named by the route of a history endpoint.
+type documentHistoryTarget struct {
+	envID    int
+	dbName   string
+	collName string
+	docID    string
+	user     models.User
+}
+
+// getDocumentHistoryTarget parses the route of a history endpoint and checks the user
+// has the required ("read" or "write") permission on the database.
+// It writes the error response itself and returns nil on failure.
+func getDocumentHistoryTarget(c *gin.Context, required string) *documentHistoryTarget {
+	envID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid environment ID"})
+		return nil
+	}
+	currentUserRaw, _ := c.Get("user")
+	t := &documentHistoryTarget{
+		envID:    envID,
+		dbName:   c.Param("dbName"),
+		collName: c.Param("collName"),
+		docID:    c.Param("docID"),
+		user:     currentUserRaw.(models.User),
+	}
+	if !requireVisibleCollections(c, t.collName) {
+		return nil
+	}
+	allowed, err := middleware.HasDBPermission(t.user, envID, t.dbName, required)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No permission to " + required + " documents in this DB"})
+		return nil
+	}
+	return t
+}
+
+// getRevisionParam loads the revision named by the :revisionId route parameter, which
+// must belong to the target document. It writes the error response itself and returns
+// nil on failure.
+func getRevisionParam(c *gin.Context, t *documentHistoryTarget) (*models.DocumentRevision, string) {
+	revisionID, err := strconv.Atoi(c.Param("revisionId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid revision ID"})
+		return nil, ""
+	}
+	var extJSON string
+	row := database.DB.QueryRow(
+		`SELECT `+documentRevisionColumns+`, document FROM document_revisions
+		 WHERE id = ? AND environment_id = ? AND db_name = ? AND collection_name = ? AND document_id = ?`,
+		revisionID, t.envID, t.dbName, t.collName, t.docID,
+	)
+	var r models.DocumentRevision
+	err = row.Scan(&r.ID, &r.EnvironmentID, &r.DBName, &r.CollectionName, &r.DocumentID, &r.Operation,
+		&r.UserID, &r.CreatedAt, &extJSON)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+		return nil, ""
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, ""
+	}
+	return &r, extJSON
+}
+
+// loadCurrentDocument returns the current version of a document, or nil if it does not exist.
+func loadCurrentDocument(ctx context.Context, coll *mongo.Collection, docID string) (bson.Raw, bson.M, error) {
+	raw, err := coll.FindOne(ctx, documentIDFilter(docID)).DecodeBytes()
+	if err == mongo.ErrNoDocuments {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	var doc bson.M
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, nil, err
+	}
+	return raw, doc, nil
+}
+
+// GetDocumentHistory lists the revisions of a document, newest first, each with the
+// field-level changes that turned it into the next version.
+// Only writes made through Monji (UpdateDocument, DeleteDocument, reverts) are recorded.
+func GetDocumentHistory(c *gin.Context) {
+	t := getDocumentHistoryTarget(c, "read")
+	if t == nil {
+		return
+	}
+	rows, err := database.DB.Query(
+		`SELECT `+documentRevisionColumns+`, document FROM document_revisions
+		 WHERE environment_id = ? AND db_name = ? AND collection_name = ? AND document_id = ?
+		 ORDER BY id DESC`,
+		t.envID, t.dbName, t.collName, t.docID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	revisions := []revisionEntry{}
+	docs := []bson.M{}
+	for rows.Next() {
+		var r models.DocumentRevision
+		var extJSON string
+		if err := rows.Scan(&r.ID, &r.EnvironmentID, &r.DBName, &r.CollectionName, &r.DocumentID, &r.Operation,
+			&r.UserID, &r.CreatedAt, &extJSON); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		_, doc, err := revisionDocument(extJSON)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		revisions = append(revisions, revisionEntry{DocumentRevision: r})
+		docs = append(docs, doc)
+	}
+	rows.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	client, err := connectEnvironment(ctx, t.envID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to MongoDB: " + err.Error()})
+		return
+	}
+	defer client.Disconnect(ctx)
+	currentRaw, current, err := loadCurrentDocument(ctx, client.Database(t.dbName).Collection(t.collName), t.docID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch document: " + err.Error()})
+		return
+	}
+	redact, err := documentRedactor(t.user, t.envID, t.dbName, t.collName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	next := current
+	for i := range revisions {
+		revisions[i].Changes = diffDocuments(docs[i], next)
+		redact.Changes(revisions[i].Changes)
+		next = docs[i]
+	}
+	response := gin.H{
+		"documentId": t.docID,
+		"exists":     currentRaw != nil,
+		"revisions":  revisions,
+	}
+	if currentRaw != nil {
+		response["etag"] = documentETag(currentRaw)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// GetDocumentRevision returns a revision of a document, with the changes from it to
+// the current document.
+func GetDocumentRevision(c *gin.Context) {
+	t := getDocumentHistoryTarget(c, "read")
+	if t == nil {
+		return
+	}
+	r, extJSON := getRevisionParam(c, t)
+	if r == nil {
+		return
+	}
+	_, doc, err := revisionDocument(extJSON)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	client, err := connectEnvironment(ctx, t.envID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to MongoDB: " + err.Error()})
+		return
+	}
+	defer client.Disconnect(ctx)
+	_, current, err := loadCurrentDocument(ctx, client.Database(t.dbName).Collection(t.collName), t.docID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch document: " + err.Error()})
+		return
+	}
+	redact, err := documentRedactor(t.user, t.envID, t.dbName, t.collName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	changes := diffDocuments(doc, current)
+	redact.Changes(changes)
+	redact.Document(doc)
+	c.JSON(http.StatusOK, gin.H{
+		"revision":         r,
+		"document":         doc,
+		"changesToCurrent": changes,
+	})
+}
+
+// RevertDocument restores a document to a revision. If the document still exists, the
+// If-Match header must carry its current ETag (see UpdateDocument) and the version
+// being replaced is itself recorded as a revision; a deleted document is re-inserted.
+func RevertDocument(c *gin.Context) {
+	t := getDocumentHistoryTarget(c, "write")
+	if t == nil {
+		return
+	}
+	r, extJSON := getRevisionParam(c, t)
+	if r == nil {
+		return
+	}
+	revisionRaw, _, err := revisionDocument(extJSON)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	client, err := connectEnvironment(ctx, t.envID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to MongoDB: " + err.Error()})
+		return
+	}
+	defer client.Disconnect(ctx)
+	coll := client.Database(t.dbName).Collection(t.collName)
+	if !requireWritableCollection(ctx, c, coll.Database(), t.collName) {
+		return
+	}
+	filter := documentIDFilter(t.docID)
+
+	current, err := coll.FindOne(ctx, filter).DecodeBytes()
+	if err == mongo.ErrNoDocuments {
+		if _, err := coll.InsertOne(ctx, revisionRaw); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore document: " + err.Error()})
+			return
+		}
+		etag := documentETag(revisionRaw)
+		c.Header("ETag", etag)
+		c.JSON(http.StatusOK, gin.H{"message": "Document restored", "revision": r, "etag": etag})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch document: " + err.Error()})
+		return
+	}
+	redact, err := documentRedactor(t.user, t.envID, t.dbName, t.collName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if current = loadIfMatch(ctx, c, coll, filter, redact); current == nil {
+		return
+	}
+	reverted, err := coll.FindOneAndReplace(ctx, unchangedFilter(current), revisionRaw,
+		options.FindOneAndReplace().SetReturnDocument(options.After)).DecodeBytes()
+	if err == mongo.ErrNoDocuments {
+		if current, err = coll.FindOne(ctx, filter).DecodeBytes(); err == nil {
+			respondDocumentChanged(c, current, redact)
+			return
+		}
+		c.JSON(http.StatusConflict, gin.H{"error": "The document was deleted while reverting, retry"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revert document: " + err.Error()})
+		return
+	}
+	if err := recordDocumentRevision(t.envID, t.dbName, t.collName, t.docID, models.RevisionRevert, current, t.user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Document reverted but recording its history failed: " + err.Error()})
+		return
+	}
+	etag := documentETag(reverted)
+	c.Header("ETag", etag)
+	c.JSON(http.StatusOK, gin.H{"message": "Document reverted", "revision": r, "etag": etag})
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"monji/internal/database"
+	"monji/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestDiffDocuments(t *testing.T) {
+	tests := []struct {
+		name          string
+		before, after bson.M
+		want          []fieldChange
+	}{
+		{"identical", bson.M{"a": int32(1)}, bson.M{"a": int32(1)}, []fieldChange{}},
+		{"insert", nil, bson.M{"a": "x"}, []fieldChange{{Path: "a", Op: diffAdded, After: "x"}}},
+		{"delete", bson.M{"a": "x"}, nil, []fieldChange{{Path: "a", Op: diffRemoved, Before: "x"}}},
+		{
+			"fields",
+			bson.M{"name": "Jane", "age": int32(30), "city": "Paris"},
+			bson.M{"name": "Jane", "age": int32(31), "email": "jane@example.com"},
+			[]fieldChange{
+				{Path: "age", Op: diffChanged, Before: int32(30), After: int32(31)},
+				{Path: "city", Op: diffRemoved, Before: "Paris"},
+				{Path: "email", Op: diffAdded, After: "jane@example.com"},
+			},
+		},
+		{
+			"embedded documents",
+			bson.M{"address": bson.M{"city": "Paris", "zip": "75001"}},
+			bson.M{"address": bson.D{{Key: "city", Value: "Lyon"}, {Key: "zip", Value: "75001"}}},
+			[]fieldChange{{Path: "address.city", Op: diffChanged, Before: "Paris", After: "Lyon"}},
+		},
+		{
+			"arrays as a whole",
+			bson.M{"tags": bson.A{"a", "b"}},
+			bson.M{"tags": bson.A{"a", "c"}},
+			[]fieldChange{{Path: "tags", Op: diffChanged, Before: bson.A{"a", "b"}, After: bson.A{"a", "c"}}},
+		},
+		{
+			"type change",
+			bson.M{"n": int32(1)},
+			bson.M{"n": int64(1)},
+			[]fieldChange{{Path: "n", Op: diffChanged, Before: int32(1), After: int64(1)}},
+		},
+	}
+	for _, tt := range tests {
+		if got := diffDocuments(tt.before, tt.after); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: diffDocuments() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestRevisionDocument checks that a revision restores the exact stored version: a
+// revert writes it back as it was, types and field order included.
+func TestRevisionDocument(t *testing.T) {
+	id := primitive.NewObjectID()
+	when := primitive.NewDateTimeFromTime(time.Date(2024, 3, 9, 14, 30, 0, 0, time.UTC))
+	original, err := bson.Marshal(bson.D{
+		{Key: "_id", Value: id},
+		{Key: "name", Value: "Jane"},
+		{Key: "count", Value: int32(3)},
+		{Key: "total", Value: int64(1) << 40},
+		{Key: "price", Value: primitive.NewDecimal128(0, 1999)},
+		{Key: "createdAt", Value: when},
+		{Key: "tags", Value: bson.A{"a", bson.D{{Key: "z", Value: 1.5}, {Key: "a", Value: nil}}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	extJSON, err := bson.MarshalExtJSON(bson.Raw(original), true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, doc, err := revisionDocument(string(extJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(raw, original) {
+		t.Errorf("revisionDocument() = %v, want %v", raw, bson.Raw(original))
+	}
+	if documentETag(raw) != documentETag(original) {
+		t.Errorf("the restored version has another ETag than the original")
+	}
+	if doc["_id"] != id || doc["count"] != int32(3) || doc["createdAt"] != when {
+		t.Errorf("revisionDocument() decoded %v", doc)
+	}
+
+	if _, _, err := revisionDocument("not json"); err == nil {
+		t.Errorf("revisionDocument() decoded invalid extended JSON")
+	}
+}
+
+func TestRecordDocumentRevisionPrunes(t *testing.T) {
+	initTestDB(t)
+	record := func(docID string, n int) {
+		t.Helper()
+		raw, err := bson.Marshal(bson.D{{Key: "_id", Value: docID}, {Key: "n", Value: int32(n)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := recordDocumentRevision(1, "shop", "orders", docID, models.RevisionUpdate, raw, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < maxDocumentRevisions+2; i++ {
+		record("a", i)
+	}
+	record("b", 0)
+
+	rows, err := database.DB.Query(
+		`SELECT document FROM document_revisions WHERE document_id = 'a' ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var kept []int32
+	for rows.Next() {
+		var extJSON string
+		if err := rows.Scan(&extJSON); err != nil {
+			t.Fatal(err)
+		}
+		_, doc, err := revisionDocument(extJSON)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kept = append(kept, doc["n"].(int32))
+	}
+	if len(kept) != maxDocumentRevisions || kept[0] != 2 || kept[len(kept)-1] != maxDocumentRevisions+1 {
+		t.Errorf("kept revisions %v, want the last %d", kept, maxDocumentRevisions)
+	}
+
+	var n int
+	database.DB.QueryRow(`SELECT COUNT(*) FROM document_revisions WHERE document_id = 'b'`).Scan(&n)
+	if n != 1 {
+		t.Errorf("%d revisions of another document, want 1", n)
+	}
+}
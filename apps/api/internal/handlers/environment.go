@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
@@ -17,6 +18,7 @@ import (
 	"monji/internal/models"
 
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // encryptionKey must be 16, 24, or 32 bytes long.
@@ -104,31 +106,52 @@ func maskConnectionString(conn string) string {
 // getEnvPermissionString returns the environment-level permission for the given user.
 // If the user is admin/superadmin, it returns "readAndWrite".
 func getEnvPermissionString(user models.User, envID int) string {
-	if middleware.IsAdmin(user) {
-		return "readAndWrite"
-	}
-	row := database.DB.QueryRow(
-		`SELECT permission FROM user_env_permissions WHERE user_id = ? AND environment_id = ?`,
-		user.ID, envID,
-	)
-	var perm string
-	if err := row.Scan(&perm); err != nil {
+	perm, err := middleware.EnvPermission(user, envID)
+	if err != nil || perm == middleware.PermissionDeny {
 		return "none"
 	}
 	return perm
 }
 
+// canManageEnvironment returns true if the user can update or delete an environment:
+// with the manage_environments capability or write access to the environment.
+func canManageEnvironment(user models.User, envID int) (bool, error) {
+	if middleware.HasCapability(user, models.CapManageEnvironments) {
+		return true, nil
+	}
+	return middleware.HasEnvPermission(user, envID, "write")
+}
+
+// connectEnvironment loads an environment, decrypts its connection string and connects to it.
+// It is used outside of HTTP handlers (e.g. background jobs); the caller must disconnect the client.
+func connectEnvironment(ctx context.Context, envID int) (*mongo.Client, error) {
+	var connString string
+	row := database.DB.QueryRow(`SELECT connection_string FROM environments WHERE id = ?`, envID)
+	if err := row.Scan(&connString); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.New("environment not found")
+		}
+		return nil, err
+	}
+	decryptedConn, err := decrypt(connString)
+	if err != nil {
+		return nil, errors.New("failed to decrypt connection string: " + err.Error())
+	}
+	return database.ConnectMongo(ctx, decryptedConn)
+}
+
 // CreateEnvironment encrypts the connection string before storing it.
 func CreateEnvironment(c *gin.Context) {
 	currentUserRaw, _ := c.Get("user")
 	currentUser := currentUserRaw.(models.User)
-	if !middleware.IsAdmin(currentUser) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Only admin or superadmin can create environments"})
+	if !middleware.HasCapability(currentUser, models.CapManageEnvironments) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Creating environments requires the manage_environments capability"})
 		return
 	}
 	var req struct {
 		Name             string `json:"name"`
 		ConnectionString string `json:"connection_string"`
+		ProtectionLevel  string `json:"protection_level"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -138,17 +161,24 @@ func CreateEnvironment(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing environment name or connection string"})
 		return
 	}
+	if req.ProtectionLevel == "" {
+		req.ProtectionLevel = models.ProtectionNone
+	}
+	if !validProtectionLevel(req.ProtectionLevel) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "protection_level must be 'none', 'confirm' or 'approval'"})
+		return
+	}
 	encryptedConn, err := encrypt(req.ConnectionString)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encrypt connection string: " + err.Error()})
 		return
 	}
-	stmt, err := database.DB.Prepare(`INSERT INTO environments (name, connection_string, created_by) VALUES (?,?,?)`)
+	stmt, err := database.DB.Prepare(`INSERT INTO environments (name, connection_string, created_by, protection_level) VALUES (?,?,?,?)`)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to prepare statement"})
 		return
 	}
-	res, err := stmt.Exec(req.Name, encryptedConn, currentUser.ID)
+	res, err := stmt.Exec(req.Name, encryptedConn, currentUser.ID, req.ProtectionLevel)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -163,6 +193,7 @@ func CreateEnvironment(c *gin.Context) {
 		Name:             req.Name,
 		ConnectionString: encryptedConn,
 		CreatedBy:        currentUser.ID,
+		ProtectionLevel:  req.ProtectionLevel,
 	}
 	// POST returns the stored (encrypted) value.
 	c.JSON(http.StatusOK, gin.H{"environment": env})
@@ -174,7 +205,7 @@ func ListEnvironments(c *gin.Context) {
 	currentUserRaw, _ := c.Get("user")
 	currentUser := currentUserRaw.(models.User)
 	if middleware.IsAdmin(currentUser) {
-		rows, err := database.DB.Query(`SELECT id, name, connection_string, created_by FROM environments`)
+		rows, err := database.DB.Query(`SELECT id, name, connection_string, created_by, protection_level FROM environments`)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
@@ -183,7 +214,7 @@ func ListEnvironments(c *gin.Context) {
 		var envs []gin.H
 		for rows.Next() {
 			var e models.Environment
-			if err := rows.Scan(&e.ID, &e.Name, &e.ConnectionString, &e.CreatedBy); err != nil {
+			if err := rows.Scan(&e.ID, &e.Name, &e.ConnectionString, &e.CreatedBy, &e.ProtectionLevel); err != nil {
 				if err == sql.ErrNoRows {
 					break
 				}
@@ -201,20 +232,20 @@ func ListEnvironments(c *gin.Context) {
 				"name":              e.Name,
 				"connection_string": maskedConn,
 				"created_by":        e.CreatedBy,
+				"protection_level":  e.ProtectionLevel,
 				"myPermission":      "readAndWrite",
 			})
 		}
 		c.JSON(http.StatusOK, gin.H{"environments": envs})
 		return
 	}
-	query := `
-	SELECT e.id, e.name, e.connection_string, e.created_by, p.permission
-	  FROM environments e
-	  JOIN user_env_permissions p ON e.id = p.environment_id
-	 WHERE p.user_id = ?
-	   AND (p.permission = 'readOnly' OR p.permission = 'readAndWrite');
-	`
-	rows, err := database.DB.Query(query, currentUser.ID)
+	// Permissions come from the user's grants and their groups' grants, loaded once.
+	perms, err := middleware.EnvPermissions(currentUser)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	rows, err := database.DB.Query(`SELECT id, name, connection_string, created_by, protection_level FROM environments`)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -223,11 +254,14 @@ func ListEnvironments(c *gin.Context) {
 	var envs []gin.H
 	for rows.Next() {
 		var e models.Environment
-		var perm string
-		if err := rows.Scan(&e.ID, &e.Name, &e.ConnectionString, &e.CreatedBy, &perm); err != nil {
+		if err := rows.Scan(&e.ID, &e.Name, &e.ConnectionString, &e.CreatedBy, &e.ProtectionLevel); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		perm := perms[e.ID]
+		if perm != "readOnly" && perm != "readAndWrite" {
+			continue
+		}
 		decryptedConn, err := decrypt(e.ConnectionString)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decrypt connection string: " + err.Error()})
@@ -239,6 +273,7 @@ func ListEnvironments(c *gin.Context) {
 			"name":              e.Name,
 			"connection_string": maskedConn,
 			"created_by":        e.CreatedBy,
+			"protection_level":  e.ProtectionLevel,
 			"myPermission":      perm,
 		})
 	}
@@ -257,8 +292,8 @@ func GetEnvironment(c *gin.Context) {
 	currentUserRaw, _ := c.Get("user")
 	currentUser := currentUserRaw.(models.User)
 	var e models.Environment
-	row := database.DB.QueryRow("SELECT id, name, connection_string, created_by FROM environments WHERE id = ?", id)
-	if err := row.Scan(&e.ID, &e.Name, &e.ConnectionString, &e.CreatedBy); err != nil {
+	row := database.DB.QueryRow("SELECT id, name, connection_string, created_by, protection_level FROM environments WHERE id = ?", id)
+	if err := row.Scan(&e.ID, &e.Name, &e.ConnectionString, &e.CreatedBy, &e.ProtectionLevel); err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Environment not found"})
 		return
 	}
@@ -279,6 +314,7 @@ func GetEnvironment(c *gin.Context) {
 			"name":              e.Name,
 			"connection_string": maskedConn,
 			"created_by":        e.CreatedBy,
+			"protection_level":  e.ProtectionLevel,
 		},
 		"myPermission": myPerm,
 	})
@@ -295,7 +331,7 @@ func UpdateEnvironment(c *gin.Context) {
 	}
 	currentUserRaw, _ := c.Get("user")
 	currentUser := currentUserRaw.(models.User)
-	hasWrite, err := middleware.HasEnvPermission(currentUser, id, "write")
+	hasWrite, err := canManageEnvironment(currentUser, id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -307,15 +343,26 @@ func UpdateEnvironment(c *gin.Context) {
 	var req struct {
 		Name             string `json:"name"`
 		ConnectionString string `json:"connection_string"`
+		ProtectionLevel  string `json:"protection_level"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if req.Name == "" && req.ConnectionString == "" {
+	if req.Name == "" && req.ConnectionString == "" && req.ProtectionLevel == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "No update parameters provided"})
 		return
 	}
+	if req.ProtectionLevel != "" {
+		if !middleware.HasCapability(currentUser, models.CapManageEnvironments) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Changing the protection level requires the manage_environments capability"})
+			return
+		}
+		if !validProtectionLevel(req.ProtectionLevel) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "protection_level must be 'none', 'confirm' or 'approval'"})
+			return
+		}
+	}
 	query := "UPDATE environments SET "
 	var params []interface{}
 	var updates []string
@@ -332,7 +379,11 @@ func UpdateEnvironment(c *gin.Context) {
 		updates = append(updates, "connection_string = ?")
 		params = append(params, encryptedConn)
 	}
-	query += strings.Join(updates, ", WHERE id = ?")
+	if req.ProtectionLevel != "" {
+		updates = append(updates, "protection_level = ?")
+		params = append(params, req.ProtectionLevel)
+	}
+	query += strings.Join(updates, ", ") + " WHERE id = ?"
 	params = append(params, id)
 	res, err := database.DB.Exec(query, params...)
 	if err != nil {
@@ -349,8 +400,8 @@ func UpdateEnvironment(c *gin.Context) {
 		return
 	}
 	var e models.Environment
-	row := database.DB.QueryRow("SELECT id, name, connection_string, created_by FROM environments WHERE id = ?", id)
-	if err := row.Scan(&e.ID, &e.Name, &e.ConnectionString, &e.CreatedBy); err != nil {
+	row := database.DB.QueryRow("SELECT id, name, connection_string, created_by, protection_level FROM environments WHERE id = ?", id)
+	if err := row.Scan(&e.ID, &e.Name, &e.ConnectionString, &e.CreatedBy, &e.ProtectionLevel); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -365,6 +416,7 @@ func UpdateEnvironment(c *gin.Context) {
 		"name":              e.Name,
 		"connection_string": maskedConn,
 		"created_by":        e.CreatedBy,
+		"protection_level":  e.ProtectionLevel,
 	}})
 }
 
@@ -378,7 +430,7 @@ func DeleteEnvironment(c *gin.Context) {
 	}
 	currentUserRaw, _ := c.Get("user")
 	currentUser := currentUserRaw.(models.User)
-	hasWrite, err := middleware.HasEnvPermission(currentUser, id, "write")
+	hasWrite, err := canManageEnvironment(currentUser, id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -387,6 +439,14 @@ func DeleteEnvironment(c *gin.Context) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "No permission to delete this environment"})
 		return
 	}
+	var envName string
+	if err := database.DB.QueryRow(`SELECT name FROM environments WHERE id = ?`, id).Scan(&envName); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Environment not found"})
+		return
+	}
+	if !requireDestructiveConfirmation(c, id, actionDeleteEnvironment, envName) {
+		return
+	}
 	res, err := database.DB.Exec("DELETE FROM environments WHERE id = ?", id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -0,0 +1,437 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"monji/internal/middleware"
+	"monji/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MaxUploadSize is the size limit of GridFS uploads, in bytes.
+// It is set from the configuration at startup.
+var MaxUploadSize int64 = 100 << 20
+
+// defaultGridFSFilesLimit is the number of files listed when no limit is given.
+const defaultGridFSFilesLimit = 100
+
+// A GridFS bucket "fs" stores its files in "fs.files" and their content in "fs.chunks".
+const (
+	gridFSFilesSuffix  = ".files"
+	gridFSChunksSuffix = ".chunks"
+)
+
+// gridFSRequest is what the GridFS handlers share: the target bucket, once the user's
+// permission on the database is checked.
+type gridFSRequest struct {
+	envID  int
+	dbName string
+	bucket string
+	user   models.User
+}
+
+// getGridFSRequest reads the environment, database and bucket params and checks the user's
+// permission ("read" or "write") on the database.
+// It writes the error response itself and returns nil on failure.
+func getGridFSRequest(c *gin.Context, permission string) *gridFSRequest {
+	envID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid environment ID"})
+		return nil
+	}
+	r := &gridFSRequest{envID: envID, dbName: c.Param("dbName"), bucket: c.Param("bucket")}
+	if r.bucket == "" || strings.Contains(r.bucket, "$") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid bucket name"})
+		return nil
+	}
+	if !requireVisibleCollections(c, r.bucket+gridFSFilesSuffix) {
+		return nil
+	}
+	currentUserRaw, _ := c.Get("user")
+	r.user = currentUserRaw.(models.User)
+	allowed, err := middleware.HasDBPermission(r.user, envID, r.dbName, permission)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil
+	}
+	if !allowed {
+		if permission == "write" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "No permission to write in this database"})
+		} else {
+			c.JSON(http.StatusForbidden, gin.H{"error": "No permission to read this database"})
+		}
+		return nil
+	}
+	return r
+}
+
+// gridFSFileID returns the _id of a file from its URL form: an ObjectId in hex, or a string.
+func gridFSFileID(s string) interface{} {
+	return documentIDFilter(s)["_id"]
+}
+
+// ListGridFSBuckets lists the GridFS buckets of a database, i.e. the "<bucket>.files"
+// collections that have a "<bucket>.chunks" collection, with their number of files.
+func ListGridFSBuckets(c *gin.Context) {
+	envID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid environment ID"})
+		return
+	}
+	dbName := c.Param("dbName")
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+	hasDBRead, err := middleware.HasDBPermission(currentUser, envID, dbName, "read")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !hasDBRead {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No permission to read this database"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	client, err := connectEnvironment(ctx, envID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to MongoDB: " + err.Error()})
+		return
+	}
+	defer client.Disconnect(ctx)
+
+	db := client.Database(dbName)
+	names, err := db.ListCollectionNames(ctx, visibleCollectionsFilter())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list collections: " + err.Error()})
+		return
+	}
+	buckets := []gin.H{}
+	for _, name := range names {
+		if !strings.HasSuffix(name, gridFSFilesSuffix) {
+			continue
+		}
+		bucket := strings.TrimSuffix(name, gridFSFilesSuffix)
+		if !containsString(names, bucket+gridFSChunksSuffix) {
+			continue
+		}
+		files, err := db.Collection(name).EstimatedDocumentCount(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count files: " + err.Error()})
+			return
+		}
+		buckets = append(buckets, gin.H{"name": bucket, "files": files})
+	}
+	c.JSON(http.StatusOK, gin.H{"database": dbName, "buckets": buckets})
+}
+
+// ListGridFSFiles lists the files of a bucket, newest first, with their metadata (masked
+// like documents of the "<bucket>.files" collection are).
+// Query params: filename (prefix, refused when file names are masked), limit (default 100), skip.
+func ListGridFSFiles(c *gin.Context) {
+	r := getGridFSRequest(c, "read")
+	if r == nil {
+		return
+	}
+	filter := bson.M{}
+	if prefix := c.Query("filename"); prefix != "" {
+		filter["filename"] = primitive.Regex{Pattern: "^" + regexp.QuoteMeta(prefix)}
+	}
+	limit, skip := int64(defaultGridFSFilesLimit), int64(0)
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+		limit = n
+	}
+	if v := c.Query("skip"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "skip must be a non-negative integer"})
+			return
+		}
+		skip = n
+	}
+	redact, err := documentRedactor(r.user, r.envID, r.dbName, r.bucket+gridFSFilesSuffix)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !redact.Filterable(filter, nil) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "File names are redacted; filtering on them requires an unmasked grant"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	client, err := connectEnvironment(ctx, r.envID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to MongoDB: " + err.Error()})
+		return
+	}
+	defer client.Disconnect(ctx)
+
+	coll := client.Database(r.dbName).Collection(r.bucket + gridFSFilesSuffix)
+	total, err := coll.CountDocuments(ctx, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count files: " + err.Error()})
+		return
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "uploadDate", Value: -1}}).SetSkip(skip).SetLimit(limit)
+	cursor, err := coll.Find(ctx, filter, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list files: " + err.Error()})
+		return
+	}
+	files := []bson.M{}
+	if err := cursor.All(ctx, &files); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode files: " + err.Error()})
+		return
+	}
+	redact.Documents(files)
+	c.JSON(http.StatusOK, gin.H{
+		"database": r.dbName,
+		"bucket":   r.bucket,
+		"total":    total,
+		"files":    files,
+	})
+}
+
+// DownloadGridFSFile streams the content of a file. Its content type is the one recorded
+// at upload (metadata.contentType, or the legacy contentType field), else guessed from the
+// file name. The content cannot be masked, so redaction rules on "<bucket>.chunks" require
+// an unmasked grant, as exports do. The file name and content type are masked like the
+// "<bucket>.files" documents are; a masked content type is sent as application/octet-stream.
+func DownloadGridFSFile(c *gin.Context) {
+	r := getGridFSRequest(c, "read")
+	if r == nil {
+		return
+	}
+	if !requireUnmaskedExport(c, r.user, r.envID, r.dbName, []string{r.bucket + gridFSChunksSuffix}) {
+		return
+	}
+	redact, err := documentRedactor(r.user, r.envID, r.dbName, r.bucket+gridFSFilesSuffix)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	fileID := gridFSFileID(c.Param("fileId"))
+
+	// The request context ends when the client disconnects.
+	ctx := c.Request.Context()
+	connectCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+	client, err := connectEnvironment(connectCtx, r.envID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to MongoDB: " + err.Error()})
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	db := client.Database(r.dbName)
+	filesRaw, err := db.Collection(r.bucket+gridFSFilesSuffix).FindOne(ctx, bson.M{"_id": fileID}).DecodeBytes()
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load file: " + err.Error()})
+		return
+	}
+	bucket, err := gridfs.NewBucket(db, options.GridFSBucket().SetName(r.bucket))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	stream, err := bucket.OpenDownloadStream(fileID)
+	if err == gridfs.ErrFileNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open file: " + err.Error()})
+		return
+	}
+	defer stream.Close()
+
+	filename, contentType, err := gridFSFileHeaders(filesRaw, redact)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode file: " + err.Error()})
+		return
+	}
+	if filename == "" {
+		filename = c.Param("fileId")
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Length", strconv.FormatInt(stream.GetFile().Length, 10))
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filepath.Base(filename)}))
+	c.Status(http.StatusOK)
+	// Once the headers are sent, a failure can only cut the response short.
+	io.Copy(c.Writer, stream)
+}
+
+// gridFSFileHeaders returns the name and content type of a file, from its "<bucket>.files"
+// document masked with redact. The name is empty if it is removed.
+func gridFSFileHeaders(filesRaw bson.Raw, redact *redactor) (string, string, error) {
+	var doc, masked bson.M
+	if err := bson.Unmarshal(filesRaw, &doc); err != nil {
+		return "", "", err
+	}
+	if err := bson.Unmarshal(filesRaw, &masked); err != nil {
+		return "", "", err
+	}
+	redact.Document(masked)
+	contentTypeOf := func(d bson.M) string {
+		if metadata, ok := asDocument(d["metadata"]); ok {
+			if ct, _ := metadata["contentType"].(string); ct != "" {
+				return ct
+			}
+		}
+		ct, _ := d["contentType"].(string)
+		return ct
+	}
+	filename, _ := masked["filename"].(string)
+	contentType := contentTypeOf(masked)
+	if contentType != contentTypeOf(doc) {
+		contentType = "application/octet-stream"
+	}
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(filename))
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return filename, contentType, nil
+}
+
+// UploadGridFSFile stores a file in a bucket, creating the bucket if needed.
+// The body is multipart/form-data with:
+//   - file: the content (at most MaxUploadSize bytes);
+//   - filename: optional, defaults to the uploaded file's name;
+//   - metadata: optional extended JSON document stored with the file.
+//
+// The uploaded part's content type is kept in metadata.contentType.
+func UploadGridFSFile(c *gin.Context) {
+	r := getGridFSRequest(c, "write")
+	if r == nil {
+		return
+	}
+	if c.Request.ContentLength > MaxUploadSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "File exceeds the upload limit of " + strconv.FormatInt(MaxUploadSize>>20, 10) + " MB"})
+		return
+	}
+	// Allow for the multipart envelope around the file.
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, MaxUploadSize+1<<20)
+	header, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file is required: " + err.Error()})
+		return
+	}
+	if header.Size > MaxUploadSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "File exceeds the upload limit of " + strconv.FormatInt(MaxUploadSize>>20, 10) + " MB"})
+		return
+	}
+	filename := c.PostForm("filename")
+	if filename == "" {
+		filename = header.Filename
+	}
+	metadata := bson.D{}
+	if m := c.PostForm("metadata"); m != "" {
+		if err := bson.UnmarshalExtJSON([]byte(m), false, &metadata); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "metadata must be a document: " + err.Error()})
+			return
+		}
+	}
+	if contentType := header.Header.Get("Content-Type"); contentType != "" {
+		if _, ok := metadata.Map()["contentType"]; !ok {
+			metadata = append(metadata, bson.E{Key: "contentType", Value: contentType})
+		}
+	}
+	content, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer content.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	client, err := connectEnvironment(ctx, r.envID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to MongoDB: " + err.Error()})
+		return
+	}
+	defer client.Disconnect(context.Background())
+
+	bucket, err := gridfs.NewBucket(client.Database(r.dbName), options.GridFSBucket().SetName(r.bucket))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := bucket.SetWriteDeadline(time.Now().Add(10 * time.Minute)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	fileID, err := bucket.UploadFromStream(filename, content, options.GridFSUpload().SetMetadata(metadata))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload file: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "File uploaded successfully",
+		"bucket":   r.bucket,
+		"fileId":   fileID,
+		"filename": filename,
+		"length":   header.Size,
+	})
+}
+
+// DeleteGridFSFile deletes a file and its chunks.
+func DeleteGridFSFile(c *gin.Context) {
+	r := getGridFSRequest(c, "write")
+	if r == nil {
+		return
+	}
+	fileID := gridFSFileID(c.Param("fileId"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	client, err := connectEnvironment(ctx, r.envID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to MongoDB: " + err.Error()})
+		return
+	}
+	defer client.Disconnect(ctx)
+
+	bucket, err := gridfs.NewBucket(client.Database(r.dbName), options.GridFSBucket().SetName(r.bucket))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := bucket.DeleteContext(ctx, fileID); err != nil {
+		if errors.Is(err, gridfs.ErrFileNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete file: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "File deleted successfully"})
+}
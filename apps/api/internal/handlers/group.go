@@ -0,0 +1,540 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"monji/internal/database"
+	"monji/internal/middleware"
+	"monji/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+const groupColumns = `g.id, g.name, g.description, g.created_at,
+	(SELECT COUNT(*) FROM group_members m WHERE m.group_id = g.id)`
+
+func scanGroup(s interface{ Scan(...interface{}) error }) (*models.Group, error) {
+	var g models.Group
+	if err := s.Scan(&g.ID, &g.Name, &g.Description, &g.CreatedAt, &g.MemberCount); err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+// getGroupParam loads the group named by the :groupId route parameter.
+// It writes the error response itself and returns nil on failure.
+func getGroupParam(c *gin.Context) *models.Group {
+	groupID, err := strconv.Atoi(c.Param("groupId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return nil
+	}
+	g, err := scanGroup(database.DB.QueryRow(`SELECT `+groupColumns+` FROM groups g WHERE g.id = ?`, groupID))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Group not found"})
+		return nil
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil
+	}
+	return g
+}
+
+// addGroupMembers adds existing users to a group; users already in it are left as is.
+// It returns the IDs that do not match any user.
+func addGroupMembers(groupID int, userIDs []int) ([]int, error) {
+	unknown := []int{}
+	for _, userID := range userIDs {
+		var id int
+		err := database.DB.QueryRow(`SELECT id FROM users WHERE id = ?`, userID).Scan(&id)
+		if err == sql.ErrNoRows {
+			unknown = append(unknown, userID)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if _, err := database.DB.Exec(
+			`INSERT OR IGNORE INTO group_members (group_id, user_id) VALUES (?, ?)`, groupID, userID,
+		); err != nil {
+			return nil, err
+		}
+	}
+	return unknown, nil
+}
+
+// isGroupMember returns true if the user belongs to the group.
+func isGroupMember(userID, groupID int) (bool, error) {
+	var n int
+	err := database.DB.QueryRow(`SELECT COUNT(*) FROM group_members WHERE group_id = ? AND user_id = ?`, groupID, userID).Scan(&n)
+	return n > 0, err
+}
+
+// canGrantGroup returns "" if user may give the grants of a group to new members, or
+// the reason why not: joining a group gives its grants (see canGrant), and its unmasked
+// grants, which only users with the view_unmasked capability may give.
+func canGrantGroup(user models.User, groupID int) (string, error) {
+	type grant struct {
+		envID      int
+		dbName     string
+		permission string
+	}
+	rows, err := database.DB.Query(
+		`SELECT environment_id, '', permission FROM group_env_permissions WHERE group_id = ?
+		 UNION ALL
+		 SELECT environment_id, db_name, permission FROM group_db_permissions WHERE group_id = ?`,
+		groupID, groupID,
+	)
+	if err != nil {
+		return "", err
+	}
+	var grants []grant
+	for rows.Next() {
+		var g grant
+		if err := rows.Scan(&g.envID, &g.dbName, &g.permission); err != nil {
+			rows.Close()
+			return "", err
+		}
+		grants = append(grants, g)
+	}
+	rows.Close()
+	for _, g := range grants {
+		if reason, err := canGrant(user, g.envID, g.dbName, g.permission); err != nil || reason != "" {
+			return reason, err
+		}
+	}
+
+	if middleware.HasCapability(user, models.CapViewUnmasked) {
+		return "", nil
+	}
+	var unmasked int
+	if err := database.DB.QueryRow(`SELECT COUNT(*) FROM unmasked_grants WHERE group_id = ?`, groupID).Scan(&unmasked); err != nil {
+		return "", err
+	}
+	if unmasked > 0 {
+		return "This group has unmasked grants, which you cannot give", nil
+	}
+	return "", nil
+}
+
+// requireGroupJoinable refuses to add the current user to a group, or to add members
+// to a group whose grants the current user cannot give (see canGrantGroup).
+// It writes the error response itself and returns false when the members are refused.
+func requireGroupJoinable(c *gin.Context, user models.User, groupID int, userIDs []int) bool {
+	for _, userID := range userIDs {
+		if userID == user.ID {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You cannot add yourself to a group"})
+			return false
+		}
+	}
+	reason, err := canGrantGroup(user, groupID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if reason != "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": reason})
+		return false
+	}
+	return true
+}
+
+// requireGroupGrantable refuses a group grant that the current user could not give
+// directly (see requireGrantable); a grant to one of their own groups is a self-grant.
+// It writes the error response itself and returns false when the grant is refused.
+func requireGroupGrantable(c *gin.Context, groupID, envID int, dbName, permission string) bool {
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+	member, err := isGroupMember(currentUser.ID, groupID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	return requireGrantable(c, currentUser, member, envID, dbName, permission)
+}
+
+// ListGroups lists every group with its member count.
+func ListGroups(c *gin.Context) {
+	rows, err := database.DB.Query(`SELECT ` + groupColumns + ` FROM groups g ORDER BY g.name`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	groups := []models.Group{}
+	for rows.Next() {
+		g, err := scanGroup(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		groups = append(groups, *g)
+	}
+	c.JSON(http.StatusOK, gin.H{"groups": groups})
+}
+
+// CreateGroup creates a group, optionally with its first members.
+// Body: { "name": "data-team", "description": "...", "user_ids": [2, 3] }
+func CreateGroup(c *gin.Context) {
+	var req struct {
+		Name        string `json:"name" binding:"required"`
+		Description string `json:"description"`
+		UserIDs     []int  `json:"user_ids"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Group name is required"})
+		return
+	}
+	currentUserRaw, _ := c.Get("user")
+	// A new group has no grants yet: only membership of the current user is refused.
+	if !requireGroupJoinable(c, currentUserRaw.(models.User), 0, req.UserIDs) {
+		return
+	}
+
+	res, err := database.DB.Exec(
+		`INSERT INTO groups (name, description, created_at) VALUES (?, ?, ?)`,
+		req.Name, req.Description, time.Now().UTC(),
+	)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			c.JSON(http.StatusConflict, gin.H{"error": "A group with this name already exists"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	id, _ := res.LastInsertId()
+	unknown, err := addGroupMembers(int(id), req.UserIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	g, err := scanGroup(database.DB.QueryRow(`SELECT `+groupColumns+` FROM groups g WHERE g.id = ?`, id))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"group": g, "unknownUserIds": unknown})
+}
+
+// GetGroup returns a group with its members and permissions.
+func GetGroup(c *gin.Context) {
+	g := getGroupParam(c)
+	if g == nil {
+		return
+	}
+
+	members := []models.User{}
+	rows, err := database.DB.Query(`
+		SELECT u.id, u.first_name, u.last_name, u.email, u.company, u.role
+		  FROM users u
+		  JOIN group_members m ON m.user_id = u.id
+		 WHERE m.group_id = ?
+		 ORDER BY u.id`, g.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var u models.User
+		var company sql.NullString
+		if err := rows.Scan(&u.ID, &u.FirstName, &u.LastName, &u.Email, &company, &u.Role); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		u.Company = company.String
+		members = append(members, u)
+	}
+
+	envPerms := []models.GroupEnvPermission{}
+	envRows, err := database.DB.Query(`
+		SELECT e.id, e.name, p.permission
+		  FROM group_env_permissions p
+		  JOIN environments e ON e.id = p.environment_id
+		 WHERE p.group_id = ?
+		 ORDER BY e.id`, g.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer envRows.Close()
+	for envRows.Next() {
+		var p models.GroupEnvPermission
+		if err := envRows.Scan(&p.EnvironmentID, &p.EnvironmentName, &p.Permission); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		envPerms = append(envPerms, p)
+	}
+
+	dbPerms := []models.GroupDBPermission{}
+	dbRows, err := database.DB.Query(`
+		SELECT e.id, e.name, p.db_name, p.permission
+		  FROM group_db_permissions p
+		  JOIN environments e ON e.id = p.environment_id
+		 WHERE p.group_id = ?
+		 ORDER BY e.id, p.db_name`, g.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer dbRows.Close()
+	for dbRows.Next() {
+		var p models.GroupDBPermission
+		if err := dbRows.Scan(&p.EnvironmentID, &p.EnvironmentName, &p.DBName, &p.Permission); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		dbPerms = append(dbPerms, p)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"group":   g,
+		"members": members,
+		"permissions": gin.H{
+			"environments": envPerms,
+			"databases":    dbPerms,
+		},
+	})
+}
+
+// UpdateGroup renames a group or changes its description.
+// Body: { "name": "...", "description": "..." } (both optional)
+func UpdateGroup(c *gin.Context) {
+	g := getGroupParam(c)
+	if g == nil {
+		return
+	}
+	var req struct {
+		Name        *string `json:"name"`
+		Description *string `json:"description"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Name != nil {
+		g.Name = strings.TrimSpace(*req.Name)
+		if g.Name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Group name is required"})
+			return
+		}
+	}
+	if req.Description != nil {
+		g.Description = *req.Description
+	}
+	if _, err := database.DB.Exec(
+		`UPDATE groups SET name = ?, description = ? WHERE id = ?`, g.Name, g.Description, g.ID,
+	); err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			c.JSON(http.StatusConflict, gin.H{"error": "A group with this name already exists"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"group": g})
+}
+
+// DeleteGroup deletes a group along with its memberships and permissions.
+// Queries shared with the group become private to their owners.
+func DeleteGroup(c *gin.Context) {
+	g := getGroupParam(c)
+	if g == nil {
+		return
+	}
+	for _, table := range []string{"group_members", "group_env_permissions", "group_db_permissions", "unmasked_grants"} {
+		if _, err := database.DB.Exec(`DELETE FROM `+table+` WHERE group_id = ?`, g.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	// The group's queries go back to their owners.
+	if _, err := database.DB.Exec(
+		`UPDATE saved_queries SET visibility = ?, group_id = 0 WHERE visibility = ? AND group_id = ?`,
+		models.QueryPrivate, models.QueryTeam, g.ID,
+	); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := database.DB.Exec(`DELETE FROM groups WHERE id = ?`, g.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Group deleted successfully"})
+}
+
+// AddGroupMembers adds users to a group.
+// Body: { "user_ids": [2, 3, 4] }
+func AddGroupMembers(c *gin.Context) {
+	g := getGroupParam(c)
+	if g == nil {
+		return
+	}
+	var req struct {
+		UserIDs []int `json:"user_ids" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	currentUserRaw, _ := c.Get("user")
+	if !requireGroupJoinable(c, currentUserRaw.(models.User), g.ID, req.UserIDs) {
+		return
+	}
+	unknown, err := addGroupMembers(g.ID, req.UserIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Group members added",
+		"groupId":        g.ID,
+		"unknownUserIds": unknown,
+	})
+}
+
+// RemoveGroupMember removes a user from a group.
+func RemoveGroupMember(c *gin.Context) {
+	g := getGroupParam(c)
+	if g == nil {
+		return
+	}
+	userID, err := strconv.Atoi(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid userId"})
+		return
+	}
+	res, err := database.DB.Exec(`DELETE FROM group_members WHERE group_id = ? AND user_id = ?`, g.ID, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User is not a member of this group"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Group member removed"})
+}
+
+// SetGroupEnvironmentPermission sets or updates a group's permission on a given environment.
+// Endpoint: POST /groups/:groupId/environments/:envId/permissions
+// Body: { "permission": "readOnly" } or "readAndWrite" or "deny" or "none"
+func SetGroupEnvironmentPermission(c *gin.Context) {
+	g := getGroupParam(c)
+	if g == nil {
+		return
+	}
+	envID, err := strconv.Atoi(c.Param("envId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid envId"})
+		return
+	}
+	var body struct {
+		Permission string `json:"permission"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !validGrantPermission(body.Permission) && body.Permission != "none" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid permission (use 'none', 'readOnly', 'readAndWrite' or 'deny')"})
+		return
+	}
+	if !requireGroupGrantable(c, g.ID, envID, "", body.Permission) {
+		return
+	}
+
+	if body.Permission == "none" {
+		_, err = database.DB.Exec(`DELETE FROM group_env_permissions WHERE group_id = ? AND environment_id = ?`, g.ID, envID)
+	} else {
+		_, err = database.DB.Exec(`
+			INSERT INTO group_env_permissions (group_id, environment_id, permission)
+			VALUES (?, ?, ?)
+			ON CONFLICT(group_id, environment_id)
+			DO UPDATE SET permission=excluded.permission
+		`, g.ID, envID, body.Permission)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set group environment permission: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "Group environment permission set successfully",
+		"groupId":       g.ID,
+		"environmentId": envID,
+		"permission":    body.Permission,
+	})
+}
+
+// SetGroupDBPermission sets or updates a group's permission on a specific database in a given environment.
+// Endpoint: POST /groups/:groupId/environments/:envId/databases/:dbName/permissions
+// dbName may be a glob pattern, as for SetUserDBPermission.
+// Body: { "permission": "readOnly" } or "readAndWrite" or "deny" or "none"
+func SetGroupDBPermission(c *gin.Context) {
+	g := getGroupParam(c)
+	if g == nil {
+		return
+	}
+	envID, err := strconv.Atoi(c.Param("envId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid envId"})
+		return
+	}
+	dbName := c.Param("dbName")
+	var body struct {
+		Permission string `json:"permission"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !middleware.ValidDBNamePattern(dbName) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid database name pattern"})
+		return
+	}
+	if !validGrantPermission(body.Permission) && body.Permission != "none" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid permission (use 'none', 'readOnly', 'readAndWrite' or 'deny')"})
+		return
+	}
+	if !requireGroupGrantable(c, g.ID, envID, dbName, body.Permission) {
+		return
+	}
+
+	if body.Permission == "none" {
+		_, err = database.DB.Exec(
+			`DELETE FROM group_db_permissions WHERE group_id = ? AND environment_id = ? AND db_name = ?`,
+			g.ID, envID, dbName,
+		)
+	} else {
+		_, err = database.DB.Exec(`
+			INSERT INTO group_db_permissions (group_id, environment_id, db_name, permission)
+			VALUES (?, ?, ?, ?)
+			ON CONFLICT(group_id, environment_id, db_name)
+			DO UPDATE SET permission=excluded.permission
+		`, g.ID, envID, dbName, body.Permission)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set group DB permission: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "Group database permission set successfully",
+		"groupId":       g.ID,
+		"environmentId": envID,
+		"dbName":        dbName,
+		"permission":    body.Permission,
+	})
+}
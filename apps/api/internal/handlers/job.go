@@ -0,0 +1,290 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"monji/internal/jobs"
+	"monji/internal/middleware"
+	"monji/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getAccessibleJob loads a job and checks that the current user may see it.
+// Users with the view_audit_log capability can see every job, other users only the jobs they submitted.
+// It writes the error response itself and returns nil on failure.
+func getAccessibleJob(c *gin.Context) *models.Job {
+	jobID, err := strconv.Atoi(c.Param("jobId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
+		return nil
+	}
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+
+	job, err := jobs.Get(jobID)
+	if err == jobs.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return nil
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil
+	}
+	if !middleware.HasCapability(currentUser, models.CapViewAuditLog) && job.CreatedBy != currentUser.ID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return nil
+	}
+	return job
+}
+
+// secretJobParams are the job parameters never returned to clients, e.g. the encrypted
+// passwords of the Mongo users recreated by a database rename.
+var secretJobParams = []string{"userPasswords"}
+
+// redactJobParams removes the secret parameters of a job before it is returned.
+func redactJobParams(job *models.Job) error {
+	if len(job.Params) == 0 {
+		return nil
+	}
+	var params map[string]json.RawMessage
+	if err := json.Unmarshal(job.Params, &params); err != nil {
+		// Not an object: no named parameter to remove.
+		return nil
+	}
+	redacted := false
+	for _, key := range secretJobParams {
+		if _, ok := params[key]; ok {
+			delete(params, key)
+			redacted = true
+		}
+	}
+	if !redacted {
+		return nil
+	}
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	job.Params = raw
+	return nil
+}
+
+// ListJobs returns the most recent jobs.
+// Users with the view_audit_log capability see every job, other users only their own.
+// Query params: status (optional), limit (default 50, max 500).
+func ListJobs(c *gin.Context) {
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+
+	limit := 50
+	if limitStr := c.Query("limit"); limitStr != "" {
+		l, err := strconv.Atoi(limitStr)
+		if err != nil || l < 1 || l > 500 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 500"})
+			return
+		}
+		limit = l
+	}
+
+	userID := currentUser.ID
+	if middleware.HasCapability(currentUser, models.CapViewAuditLog) {
+		userID = 0
+	}
+	list, err := jobs.List(userID, c.Query("status"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for i := range list {
+		if err := redactJobParams(&list[i]); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"jobs": list})
+}
+
+// GetJob returns the status, progress and result of a job. Secret parameters are left out.
+func GetJob(c *gin.Context) {
+	job := getAccessibleJob(c)
+	if job == nil {
+		return
+	}
+	if err := redactJobParams(job); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"job": job})
+}
+
+// CancelJob requests the cancellation of a pending or running job.
+func CancelJob(c *gin.Context) {
+	job := getAccessibleJob(c)
+	if job == nil {
+		return
+	}
+	if err := jobs.Cancel(job.ID); err != nil {
+		if err == jobs.ErrFinished {
+			c.JSON(http.StatusConflict, gin.H{"error": "Job already finished"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "Cancellation requested",
+		"jobId":   job.ID,
+	})
+}
+
+// RetryJob puts a failed or cancelled job back in the queue.
+// Jobs that checkpoint their progress (e.g. database renames) resume where they stopped.
+// Destructive jobs on a protected environment need a confirmation, as when submitted.
+func RetryJob(c *gin.Context) {
+	job := getAccessibleJob(c)
+	if job == nil {
+		return
+	}
+	currentUserRaw, _ := c.Get("user")
+	allowed, err := checkRetryPermission(currentUserRaw.(models.User), job)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No permission to run this job"})
+		return
+	}
+	// Checked before the confirmation, which uses up an approval.
+	if job.Status != models.JobFailed && job.Status != models.JobCancelled {
+		c.JSON(http.StatusConflict, gin.H{"error": jobs.ErrNotRetryable.Error()})
+		return
+	}
+	if !requireRetryConfirmation(c, job) {
+		return
+	}
+	if err := jobs.Retry(job.ID); err != nil {
+		if err == jobs.ErrNotRetryable {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "Job requeued",
+		"jobId":   job.ID,
+	})
+}
+
+// requireRetryConfirmation guards the retry of a destructive job on a protected environment,
+// as its submission was (see requireDestructiveConfirmation).
+// It writes the error response itself and returns false when the job must not be retried.
+func requireRetryConfirmation(c *gin.Context, job *models.Job) bool {
+	var envID int
+	var action, target string
+	switch job.Type {
+	case jobTypeRenameDatabase:
+		var params renameDatabaseParams
+		if err := json.Unmarshal(job.Params, &params); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return false
+		}
+		envID, action, target = job.EnvironmentID, actionRenameDatabase, renameDatabaseTarget(params.DbName, params.NewDbName)
+	case jobTypeRestore:
+		var params restoreParams
+		if err := json.Unmarshal(job.Params, &params); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return false
+		}
+		if !params.Drop {
+			return true
+		}
+		envID, action, target = params.EnvironmentID, actionRestoreDrop, restoreDropTarget(params.DbName, params.BackupID)
+	case jobTypeRecycleDatabase:
+		var params recycleParams
+		if err := json.Unmarshal(job.Params, &params); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return false
+		}
+		e, err := loadRecycleEntry(params.EntryID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return false
+		}
+		envID, action, target = e.EnvironmentID, actionDropDatabase, e.DBName
+	default:
+		return true
+	}
+	return requireDestructiveConfirmation(c, envID, action, target)
+}
+
+// checkRetryPermission re-runs the submit-time permission checks of a job for the user
+// retrying it: grants may have been revoked since the job was submitted, and the user
+// retrying may not be the one who submitted it. Jobs of other types cannot be retried.
+func checkRetryPermission(user models.User, job *models.Job) (bool, error) {
+	switch job.Type {
+	case jobTypeRenameDatabase:
+		var params renameDatabaseParams
+		if err := json.Unmarshal(job.Params, &params); err != nil {
+			return false, err
+		}
+		hasEnvWrite, err := middleware.HasEnvPermission(user, job.EnvironmentID, "write")
+		if err != nil || !hasEnvWrite {
+			return false, err
+		}
+		for _, dbName := range []string{params.DbName, params.NewDbName} {
+			hasDBWrite, err := middleware.HasDBPermission(user, job.EnvironmentID, dbName, "write")
+			if err != nil || !hasDBWrite {
+				return false, err
+			}
+		}
+		return true, nil
+	case jobTypeBackup:
+		if !middleware.HasCapability(user, models.CapExportData) {
+			return false, nil
+		}
+		var params backupParams
+		if err := json.Unmarshal(job.Params, &params); err != nil {
+			return false, err
+		}
+		b, err := loadBackup(params.BackupID)
+		if err != nil {
+			return false, err
+		}
+		allowed, err := canExportUnmasked(user, b.EnvironmentID, b.DBName, b.Collections)
+		if err != nil || !allowed {
+			return false, err
+		}
+		return middleware.HasDBPermission(user, b.EnvironmentID, b.DBName, "write")
+	case jobTypeRestore:
+		if !middleware.HasCapability(user, models.CapExportData) {
+			return false, nil
+		}
+		var params restoreParams
+		if err := json.Unmarshal(job.Params, &params); err != nil {
+			return false, err
+		}
+		b, err := loadBackup(params.BackupID)
+		if err != nil {
+			return false, err
+		}
+		allowed, err := canExportUnmasked(user, b.EnvironmentID, b.DBName, b.Collections)
+		if err != nil || !allowed {
+			return false, err
+		}
+		return middleware.HasDBPermission(user, params.EnvironmentID, params.DbName, "write")
+	case jobTypeRecycleDatabase:
+		if !middleware.HasCapability(user, models.CapDropDatabases) {
+			return false, nil
+		}
+		return middleware.HasEnvPermission(user, job.EnvironmentID, "write")
+	case jobTypeRestoreRecycleDatabase:
+		// Like the recycle bin routes.
+		return middleware.HasCapability(user, models.CapManageEnvironments), nil
+	}
+	return false, nil
+}
@@ -53,6 +53,10 @@ func CreateMongoUser(c *gin.Context) {
 		return
 	}
 
+	if !middleware.HasCapability(currentUser, models.CapManageMongoUsers) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Managing MongoDB users requires the manage_mongo_users capability"})
+		return
+	}
 	isAdmin := middleware.IsAdmin(currentUser)
 	if !isAdmin {
 		hasDBWrite, err := middleware.HasDBPermission(currentUser, envID, dbName, "write")
@@ -156,6 +160,10 @@ func ListMongoUsers(c *gin.Context) {
 		return
 	}
 
+	if !middleware.HasCapability(currentUser, models.CapManageMongoUsers) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Managing MongoDB users requires the manage_mongo_users capability"})
+		return
+	}
 	isAdmin := middleware.IsAdmin(currentUser)
 	if !isAdmin {
 		hasDBRead, err := middleware.HasDBPermission(currentUser, envID, dbName, "read")
@@ -233,6 +241,10 @@ func GetMongoUser(c *gin.Context) {
 		return
 	}
 
+	if !middleware.HasCapability(currentUser, models.CapManageMongoUsers) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Managing MongoDB users requires the manage_mongo_users capability"})
+		return
+	}
 	isAdmin := middleware.IsAdmin(currentUser)
 	if !isAdmin {
 		hasDBRead, err := middleware.HasDBPermission(currentUser, envID, dbName, "read")
@@ -310,6 +322,10 @@ func EditMongoUser(c *gin.Context) {
 		return
 	}
 
+	if !middleware.HasCapability(currentUser, models.CapManageMongoUsers) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Managing MongoDB users requires the manage_mongo_users capability"})
+		return
+	}
 	isAdmin := middleware.IsAdmin(currentUser)
 	if !isAdmin {
 		hasDBWrite, err := middleware.HasDBPermission(currentUser, envID, dbName, "write")
@@ -422,6 +438,10 @@ func DeleteMongoUser(c *gin.Context) {
 		return
 	}
 
+	if !middleware.HasCapability(currentUser, models.CapManageMongoUsers) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Managing MongoDB users requires the manage_mongo_users capability"})
+		return
+	}
 	isAdmin := middleware.IsAdmin(currentUser)
 	if !isAdmin {
 		hasDBWrite, err := middleware.HasDBPermission(currentUser, envID, dbName, "write")
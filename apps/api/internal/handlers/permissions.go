@@ -1,19 +1,175 @@
 package handlers
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
 	"monji/internal/database"
+	"monji/internal/middleware"
+	"monji/internal/models"
 )
 
+// permissionSweepInterval is how often expired time-bound grants are cleaned up.
+const permissionSweepInterval = time.Minute
+
+// validGrantPermission returns true for a permission that can be stored in a grant.
+// "deny" overrides the grants of the user's groups (see middleware.ResolvePermission).
+func validGrantPermission(permission string) bool {
+	return permission == "readOnly" || permission == "readAndWrite" || permission == middleware.PermissionDeny
+}
+
+// grantsAccess returns true if a grant permission gives access: denials and removals
+// ("none") only restrict it.
+func grantsAccess(permission string) bool {
+	return permission == "readOnly" || permission == "readAndWrite"
+}
+
+// canGrant returns "" if user may grant permission on an environment (dbName == "") or on
+// a database, or the reason why not. Users without full_data_access may only grant the
+// access they hold themselves, on exact database names: a pattern may match databases
+// they cannot access.
+func canGrant(user models.User, envID int, dbName, permission string) (string, error) {
+	if !grantsAccess(permission) || middleware.IsAdmin(user) {
+		return "", nil
+	}
+	required := "read"
+	if permission == "readAndWrite" {
+		required = "write"
+	}
+	if dbName == "" {
+		ok, err := middleware.HasEnvPermission(user, envID, required)
+		if err != nil || ok {
+			return "", err
+		}
+		return "You cannot grant more access to this environment than you hold", nil
+	}
+	if middleware.IsDBNamePattern(dbName) {
+		return "Granting access by database pattern requires the full_data_access capability", nil
+	}
+	ok, err := middleware.HasDBPermission(user, envID, dbName, required)
+	if err != nil || ok {
+		return "", err
+	}
+	return "You cannot grant more access to this database than you hold", nil
+}
+
+// requireGrantable refuses a grant that would let a user manager raise their own access
+// (self is true when the grant applies to them, directly or through a group) or give
+// access they do not hold (see canGrant).
+// It writes the error response itself and returns false when the grant is refused.
+func requireGrantable(c *gin.Context, user models.User, self bool, envID int, dbName, permission string) bool {
+	if self && grantsAccess(permission) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You cannot grant access to yourself"})
+		return false
+	}
+	reason, err := canGrant(user, envID, dbName, permission)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if reason != "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": reason})
+		return false
+	}
+	return true
+}
+
+// grantExpiry reads the optional expiry of a grant: either an absolute "expires_at"
+// (RFC 3339) or a "duration" from now (e.g. "4h"). It returns nil for a permanent grant.
+func grantExpiry(expiresAt *time.Time, duration string) (*time.Time, error) {
+	if expiresAt != nil && duration != "" {
+		return nil, errors.New("use either expires_at or duration, not both")
+	}
+	if duration != "" {
+		d, err := time.ParseDuration(duration)
+		if err != nil || d <= 0 {
+			return nil, errors.New("invalid duration (use e.g. '30m' or '4h')")
+		}
+		t := time.Now().UTC().Add(d)
+		return &t, nil
+	}
+	if expiresAt != nil {
+		if !expiresAt.After(time.Now()) {
+			return nil, errors.New("expires_at must be in the future")
+		}
+		t := expiresAt.UTC()
+		return &t, nil
+	}
+	return nil, nil
+}
+
+// sqlExecer is implemented by *sql.DB and *sql.Tx.
+type sqlExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// grantPermission upserts a user's permission on an environment (dbName == "") or on
+// one of its databases, through db (the database or a transaction). A nil expiresAt
+// makes the grant permanent. A time-bound grant remembers the permanent permission it
+// replaces and falls back to it when it expires; granting over another time-bound grant
+// keeps the original fallback.
+func grantPermission(db sqlExecer, userID, envID int, dbName, permission string, expiresAt *time.Time) error {
+	table, where, params := "user_env_permissions", "user_id = ? AND environment_id = ?", []interface{}{userID, envID}
+	if dbName != "" {
+		table, where = "user_db_permissions", where+" AND db_name = ?"
+		params = append(params, dbName)
+	}
+
+	revert := ""
+	if expiresAt != nil {
+		var current, currentRevert string
+		var currentExpiry sql.NullTime
+		err := db.QueryRow(
+			`SELECT permission, expires_at, revert_permission FROM `+table+` WHERE `+where, params...,
+		).Scan(&current, &currentExpiry, &currentRevert)
+		switch {
+		case err == sql.ErrNoRows:
+		case err != nil:
+			return err
+		case currentExpiry.Valid:
+			revert = currentRevert
+		default:
+			revert = current
+		}
+	}
+
+	var expiry interface{}
+	if expiresAt != nil {
+		expiry = *expiresAt
+	}
+	if dbName == "" {
+		_, err := db.Exec(`
+			INSERT INTO user_env_permissions (user_id, environment_id, permission, expires_at, revert_permission)
+			VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT(user_id, environment_id)
+			DO UPDATE SET permission=excluded.permission, expires_at=excluded.expires_at,
+			              revert_permission=excluded.revert_permission
+		`, userID, envID, permission, expiry, revert)
+		return err
+	}
+	_, err := db.Exec(`
+		INSERT INTO user_db_permissions (user_id, environment_id, db_name, permission, expires_at, revert_permission)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(user_id, environment_id, db_name)
+		DO UPDATE SET permission=excluded.permission, expires_at=excluded.expires_at,
+		              revert_permission=excluded.revert_permission
+	`, userID, envID, dbName, permission, expiry, revert)
+	return err
+}
+
 // SetUserEnvironmentPermission sets or updates a user's permission on a given environment.
 // Endpoint: POST /users/:userId/environments/:envId/permissions
-// Body: { "permission": "readOnly" } or "readAndWrite" or "none"
+// Body: { "permission": "readOnly" } or "readAndWrite" or "deny" or "none"
+// Optional: "expires_at" (RFC 3339) or "duration" (e.g. "4h") for a time-bound grant.
 func SetUserEnvironmentPermission(c *gin.Context) {
-	// user must be admin or superadmin (enforced by AdminMiddleware)
 	userIdStr := c.Param("userId")
 	envIdStr := c.Param("envId")
 
@@ -29,7 +185,9 @@ func SetUserEnvironmentPermission(c *gin.Context) {
 	}
 
 	var body struct {
-		Permission string `json:"permission"`
+		Permission string     `json:"permission"`
+		ExpiresAt  *time.Time `json:"expires_at"`
+		Duration   string     `json:"duration"`
 	}
 	if err := c.ShouldBindJSON(&body); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -37,13 +195,27 @@ func SetUserEnvironmentPermission(c *gin.Context) {
 	}
 
 	// Validate permission
-	if body.Permission != "none" && body.Permission != "readOnly" && body.Permission != "readAndWrite" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid permission (use 'none', 'readOnly' or 'readAndWrite')"})
+	if !validGrantPermission(body.Permission) && body.Permission != "none" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid permission (use 'none', 'readOnly', 'readAndWrite' or 'deny')"})
+		return
+	}
+	expiresAt, err := grantExpiry(body.ExpiresAt, body.Duration)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+	if !requireGrantable(c, currentUser, userID == currentUser.ID, envID, "", body.Permission) {
 		return
 	}
 
 	// If permission == "none", we can just remove the row from user_env_permissions
 	if body.Permission == "none" {
+		if expiresAt != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Permission 'none' cannot be time-bound"})
+			return
+		}
 		res, err := database.DB.Exec(
 			`DELETE FROM user_env_permissions WHERE user_id = ? AND environment_id = ?`,
 			userID, envID,
@@ -63,18 +235,7 @@ func SetUserEnvironmentPermission(c *gin.Context) {
 	}
 
 	// Otherwise, upsert the row
-	stmt, err := database.DB.Prepare(`
-		INSERT INTO user_env_permissions (user_id, environment_id, permission)
-		VALUES (?, ?, ?)
-		ON CONFLICT(user_id, environment_id)
-		DO UPDATE SET permission=excluded.permission
-	`)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to prepare upsert statement"})
-		return
-	}
-	_, err = stmt.Exec(userID, envID, body.Permission)
-	if err != nil {
+	if err := grantPermission(database.DB, userID, envID, "", body.Permission, expiresAt); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upsert environment permission: " + err.Error()})
 		return
 	}
@@ -84,12 +245,16 @@ func SetUserEnvironmentPermission(c *gin.Context) {
 		"user_id":       userID,
 		"environmentId": envID,
 		"permission":    body.Permission,
+		"expires_at":    expiresAt,
 	})
 }
 
 // SetUserDBPermission sets or updates a user's permission on a specific database in a given environment.
+// dbName may be a glob pattern (e.g. "tenant_*") granting the permission on every matching database;
+// a grant on an exact name takes precedence over patterns, and a longer pattern over a shorter one.
 // Endpoint: POST /users/:userId/environments/:envId/databases/:dbName/permissions
-// Body: { "permission": "readOnly" } or "readAndWrite" or "none"
+// Body: { "permission": "readOnly" } or "readAndWrite" or "deny" or "none"
+// Optional: "expires_at" (RFC 3339) or "duration" (e.g. "4h") for a time-bound grant.
 func SetUserDBPermission(c *gin.Context) {
 	userIdStr := c.Param("userId")
 	envIdStr := c.Param("envId")
@@ -107,7 +272,9 @@ func SetUserDBPermission(c *gin.Context) {
 	}
 
 	var body struct {
-		Permission string `json:"permission"`
+		Permission string     `json:"permission"`
+		ExpiresAt  *time.Time `json:"expires_at"`
+		Duration   string     `json:"duration"`
 	}
 	if err := c.ShouldBindJSON(&body); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -115,13 +282,31 @@ func SetUserDBPermission(c *gin.Context) {
 	}
 
 	// Validate permission
-	if body.Permission != "none" && body.Permission != "readOnly" && body.Permission != "readAndWrite" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid permission (use 'none', 'readOnly' or 'readAndWrite')"})
+	if !middleware.ValidDBNamePattern(dbName) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid database name pattern"})
+		return
+	}
+	if !validGrantPermission(body.Permission) && body.Permission != "none" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid permission (use 'none', 'readOnly', 'readAndWrite' or 'deny')"})
+		return
+	}
+	expiresAt, err := grantExpiry(body.ExpiresAt, body.Duration)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+	if !requireGrantable(c, currentUser, userID == currentUser.ID, envID, dbName, body.Permission) {
 		return
 	}
 
 	// If permission == "none", remove row
 	if body.Permission == "none" {
+		if expiresAt != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Permission 'none' cannot be time-bound"})
+			return
+		}
 		res, err := database.DB.Exec(
 			`DELETE FROM user_db_permissions WHERE user_id = ? AND environment_id = ? AND db_name = ?`,
 			userID, envID, dbName,
@@ -142,18 +327,7 @@ func SetUserDBPermission(c *gin.Context) {
 	}
 
 	// Otherwise, upsert the row
-	stmt, err := database.DB.Prepare(`
-		INSERT INTO user_db_permissions (user_id, environment_id, db_name, permission)
-		VALUES (?, ?, ?, ?)
-		ON CONFLICT(user_id, environment_id, db_name)
-		DO UPDATE SET permission=excluded.permission
-	`)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to prepare upsert statement"})
-		return
-	}
-	_, err = stmt.Exec(userID, envID, dbName, body.Permission)
-	if err != nil {
+	if err := grantPermission(database.DB, userID, envID, dbName, body.Permission, expiresAt); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upsert DB permission: " + err.Error()})
 		return
 	}
@@ -164,5 +338,104 @@ func SetUserDBPermission(c *gin.Context) {
 		"environmentId": envID,
 		"dbName":        dbName,
 		"permission":    body.Permission,
+		"expires_at":    expiresAt,
 	})
 }
+
+// StartPermissionSweeper periodically cleans up expired time-bound grants until ctx
+// is cancelled. Expired grants already give no access (see middleware.EffectivePermissionSQL);
+// the sweeper restores the permission they replaced, or deletes them.
+func StartPermissionSweeper(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(permissionSweepInterval)
+		defer ticker.Stop()
+		for {
+			sweepExpiredPermissions()
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func sweepExpiredPermissions() {
+	now := time.Now().UTC()
+	for _, table := range []string{"user_env_permissions", "user_db_permissions"} {
+		if _, err := database.DB.Exec(
+			`UPDATE `+table+` SET permission = revert_permission, expires_at = NULL, revert_permission = ''
+			 WHERE expires_at <= ? AND revert_permission != ''`, now,
+		); err != nil {
+			log.Printf("permissions: failed to revert expired grants in %s: %v", table, err)
+			continue
+		}
+		if _, err := database.DB.Exec(`DELETE FROM `+table+` WHERE expires_at <= ?`, now); err != nil {
+			log.Printf("permissions: failed to delete expired grants in %s: %v", table, err)
+		}
+	}
+}
+
+// ExplainUserPermission returns a user's effective permission on an environment,
+// a database or a collection, along with the rules that produced it.
+// Endpoint: GET /users/:id/permissions/explain?environmentId=1&dbName=app&collection=orders
+// dbName and collection are optional; collection requires dbName.
+func ExplainUserPermission(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	envID, err := strconv.Atoi(c.Query("environmentId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or missing environmentId"})
+		return
+	}
+	dbName := c.Query("dbName")
+	collName := c.Query("collection")
+	if collName != "" && dbName == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "collection requires dbName"})
+		return
+	}
+
+	var user models.User
+	err = database.DB.QueryRow(`SELECT id, role FROM users WHERE id = ?`, userID).Scan(&user.ID, &user.Role)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	user.Capabilities, err = middleware.RoleCapabilities(user.Role)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var envName string
+	err = database.DB.QueryRow(`SELECT name FROM environments WHERE id = ?`, envID).Scan(&envName)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Environment not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	explanation, err := middleware.ExplainPermission(user, envID, dbName, collName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if dbName != "" {
+		rules, err := loadRedactionRules(envID, dbName, collName)
+		if err == nil {
+			err = middleware.ExplainRedaction(user, explanation, len(rules))
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"explanation": explanation})
+}
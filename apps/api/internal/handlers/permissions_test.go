@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGrantExpiry(t *testing.T) {
+	future := time.Now().Add(time.Hour)
+	past := time.Now().Add(-time.Hour)
+	tests := []struct {
+		name      string
+		expiresAt *time.Time
+		duration  string
+		wantErr   bool
+		wantNil   bool
+		wantAbout time.Duration // expected time from now
+	}{
+		{name: "permanent", wantNil: true},
+		{name: "duration", duration: "4h", wantAbout: 4 * time.Hour},
+		{name: "expires_at", expiresAt: &future, wantAbout: time.Hour},
+		{name: "both", expiresAt: &future, duration: "4h", wantErr: true},
+		{name: "invalid duration", duration: "soon", wantErr: true},
+		{name: "zero duration", duration: "0s", wantErr: true},
+		{name: "negative duration", duration: "-1h", wantErr: true},
+		{name: "expires_at in the past", expiresAt: &past, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := grantExpiry(tt.expiresAt, tt.duration)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: grantExpiry() = %v, want an error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: grantExpiry() error: %v", tt.name, err)
+			continue
+		}
+		if tt.wantNil {
+			if got != nil {
+				t.Errorf("%s: grantExpiry() = %v, want nil", tt.name, got)
+			}
+			continue
+		}
+		if got == nil {
+			t.Errorf("%s: grantExpiry() = nil, want a time", tt.name)
+			continue
+		}
+		if got.Location() != time.UTC {
+			t.Errorf("%s: grantExpiry() = %v, want UTC", tt.name, got)
+		}
+		if d := time.Until(*got) - tt.wantAbout; d > time.Second || d < -time.Second {
+			t.Errorf("%s: grantExpiry() = %v, want about %v from now", tt.name, got, tt.wantAbout)
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"monji/internal/database"
+	"monji/internal/middleware"
+	"monji/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// approvalValidity is how long an approved destructive operation can be run.
+const approvalValidity = time.Hour
+
+// Destructive actions guarded by the environment protection level.
+const (
+	actionDropDatabase      = "dropDatabase"
+	actionDropCollection    = "dropCollection"
+	actionRenameDatabase    = "renameDatabase"
+	actionDeleteEnvironment = "deleteEnvironment"
+	actionRestoreDrop       = "restoreWithDrop"
+	actionPurgeRecycleBin   = "purgeRecycleBin"
+	actionUpdateMany        = "updateMany"
+)
+
+// renameDatabaseTarget is the target of a database rename. It names both databases, so
+// that a confirmation or approval of a rename does not apply to another new name.
+func renameDatabaseTarget(oldName, newName string) string {
+	return oldName + " -> " + newName
+}
+
+// restoreDropTarget is the target of a restore that drops the existing collections. It
+// names the backup, so that an approval does not apply to restoring another backup.
+func restoreDropTarget(dbName string, backupID int) string {
+	return fmt.Sprintf("%s <- backup %d", dbName, backupID)
+}
+
+// changeRequestTarget is the target of applying a change request: its collection and ID.
+func changeRequestTarget(cr *models.ChangeRequest) string {
+	return fmt.Sprintf("%s.%s (change request %d)", cr.DBName, cr.CollectionName, cr.ID)
+}
+
+// validProtectionLevel returns true for a known environment protection level.
+func validProtectionLevel(level string) bool {
+	return level == models.ProtectionNone || level == models.ProtectionConfirm || level == models.ProtectionApproval
+}
+
+// getProtectionLevel returns the protection level of an environment.
+func getProtectionLevel(envID int) (string, error) {
+	var level string
+	err := database.DB.QueryRow(`SELECT protection_level FROM environments WHERE id = ?`, envID).Scan(&level)
+	return level, err
+}
+
+// requireDestructiveConfirmation guards a destructive operation on a protected environment.
+// On "confirm" and "approval" environments the caller must name the target in the
+// "confirm" query parameter or the X-Monji-Confirm header. The target identifies the
+// whole operation (see renameDatabaseTarget and restoreDropTarget). On "approval" environments
+// the caller must also present, in the X-Monji-Approval header, an approval granted by
+// another user with the manage_environments capability; without one, an approval request is
+// created and its ID returned.
+// It writes the error response itself and returns false when the operation must not run.
+func requireDestructiveConfirmation(c *gin.Context, envID int, action, target string) bool {
+	level, err := getProtectionLevel(envID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if level == models.ProtectionNone || level == "" {
+		return true
+	}
+
+	confirm := c.Query("confirm")
+	if confirm == "" {
+		confirm = c.GetHeader("X-Monji-Confirm")
+	}
+	if confirm != target {
+		c.JSON(http.StatusPreconditionRequired, gin.H{
+			"error":                "This environment is protected: confirm the operation by naming its target",
+			"protectionLevel":      level,
+			"action":               action,
+			"confirmationRequired": target,
+		})
+		return false
+	}
+	if level != models.ProtectionApproval {
+		return true
+	}
+
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+
+	if approvalIDStr := c.GetHeader("X-Monji-Approval"); approvalIDStr != "" {
+		approvalID, err := strconv.Atoi(approvalIDStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid approval ID"})
+			return false
+		}
+		// Consume the approval atomically so that it is used only once.
+		res, err := database.DB.Exec(
+			`UPDATE destructive_approvals SET status = ?
+			 WHERE id = ? AND environment_id = ? AND action = ? AND target = ? AND requested_by = ?
+			   AND status = ? AND expires_at > ?`,
+			models.ApprovalUsed, approvalID, envID, action, target, currentUser.ID,
+			models.ApprovalApproved, time.Now().UTC(),
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return false
+		}
+		if n, _ := res.RowsAffected(); n == 1 {
+			return true
+		}
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":      "Approval is not valid for this operation: it must be approved, unused, unexpired and requested by you for the same target",
+			"approvalId": approvalID,
+		})
+		return false
+	}
+
+	// Reuse a pending request for the same operation rather than piling them up.
+	var approvalID int64
+	err = database.DB.QueryRow(
+		`SELECT id FROM destructive_approvals
+		 WHERE environment_id = ? AND action = ? AND target = ? AND requested_by = ? AND status = ?`,
+		envID, action, target, currentUser.ID, models.ApprovalPending,
+	).Scan(&approvalID)
+	if err == sql.ErrNoRows {
+		var res sql.Result
+		res, err = database.DB.Exec(
+			`INSERT INTO destructive_approvals (environment_id, action, target, requested_by, status, created_at)
+			 VALUES (?, ?, ?, ?, ?, ?)`,
+			envID, action, target, currentUser.ID, models.ApprovalPending, time.Now().UTC(),
+		)
+		if err == nil {
+			approvalID, err = res.LastInsertId()
+		}
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	c.JSON(http.StatusPreconditionRequired, gin.H{
+		"error":           "This environment requires another user to approve the operation; retry with the X-Monji-Approval header once approved",
+		"protectionLevel": level,
+		"action":          action,
+		"target":          target,
+		"approvalId":      approvalID,
+	})
+	return false
+}
+
+const approvalColumns = `id, environment_id, action, target, requested_by, status, reviewed_by, comment,
+	created_at, reviewed_at, expires_at`
+
+func scanApproval(s interface{ Scan(...interface{}) error }) (*models.DestructiveApproval, error) {
+	var a models.DestructiveApproval
+	var reviewedAt, expiresAt sql.NullTime
+	err := s.Scan(&a.ID, &a.EnvironmentID, &a.Action, &a.Target, &a.RequestedBy, &a.Status, &a.ReviewedBy,
+		&a.Comment, &a.CreatedAt, &reviewedAt, &expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if reviewedAt.Valid {
+		a.ReviewedAt = &reviewedAt.Time
+	}
+	if expiresAt.Valid {
+		a.ExpiresAt = &expiresAt.Time
+	}
+	return &a, nil
+}
+
+// canSeeAllApprovals returns true if the user can review approvals or view the audit log.
+func canSeeAllApprovals(user models.User) bool {
+	return middleware.HasCapability(user, models.CapManageEnvironments) || middleware.HasCapability(user, models.CapViewAuditLog)
+}
+
+// getAccessibleApproval loads the approval named by the :approvalId route parameter.
+// Reviewers (manage_environments) and auditors (view_audit_log) can see every approval,
+// other users only their own requests.
+// It writes the error response itself and returns nil on failure.
+func getAccessibleApproval(c *gin.Context, currentUser models.User) *models.DestructiveApproval {
+	approvalID, err := strconv.Atoi(c.Param("approvalId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid approval ID"})
+		return nil
+	}
+	a, err := scanApproval(database.DB.QueryRow(`SELECT `+approvalColumns+` FROM destructive_approvals WHERE id = ?`, approvalID))
+	if err == sql.ErrNoRows || (err == nil && !canSeeAllApprovals(currentUser) && a.RequestedBy != currentUser.ID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Approval not found"})
+		return nil
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil
+	}
+	return a
+}
+
+// ListApprovals lists destructive operation approvals, newest first.
+// Reviewers and auditors see every request, other users only their own.
+// Query params: status (optional, default "pending"; "all" lists every status).
+func ListApprovals(c *gin.Context) {
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+
+	query := `SELECT ` + approvalColumns + ` FROM destructive_approvals WHERE 1 = 1`
+	var params []interface{}
+	if status := c.DefaultQuery("status", models.ApprovalPending); status != "all" {
+		query += ` AND status = ?`
+		params = append(params, status)
+	}
+	if !canSeeAllApprovals(currentUser) {
+		query += ` AND requested_by = ?`
+		params = append(params, currentUser.ID)
+	}
+	query += ` ORDER BY id DESC`
+	rows, err := database.DB.Query(query, params...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	approvals := []models.DestructiveApproval{}
+	for rows.Next() {
+		a, err := scanApproval(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		approvals = append(approvals, *a)
+	}
+	c.JSON(http.StatusOK, gin.H{"approvals": approvals})
+}
+
+// GetApproval returns a single approval request.
+func GetApproval(c *gin.Context) {
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+	a := getAccessibleApproval(c, currentUser)
+	if a == nil {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"approval": a})
+}
+
+// ApproveApproval approves a pending destructive operation. The approver must be an
+// user other than the requester, with the manage_environments capability. Body (optional): { "comment": "..." }
+func ApproveApproval(c *gin.Context) {
+	reviewApproval(c, models.ApprovalApproved)
+}
+
+// RejectApproval rejects a pending destructive operation. Body (optional): { "comment": "..." }
+func RejectApproval(c *gin.Context) {
+	reviewApproval(c, models.ApprovalRejected)
+}
+
+func reviewApproval(c *gin.Context, status string) {
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+	if !middleware.HasCapability(currentUser, models.CapManageEnvironments) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Reviewing approvals requires the manage_environments capability"})
+		return
+	}
+	a := getAccessibleApproval(c, currentUser)
+	if a == nil {
+		return
+	}
+	if a.RequestedBy == currentUser.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "An approval must be reviewed by another user"})
+		return
+	}
+	if a.Status != models.ApprovalPending {
+		c.JSON(http.StatusConflict, gin.H{"error": "Approval is already " + a.Status})
+		return
+	}
+	var req struct {
+		Comment string `json:"comment"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	now := time.Now().UTC()
+	var expiresAt interface{}
+	if status == models.ApprovalApproved {
+		expiresAt = now.Add(approvalValidity)
+	}
+	res, err := database.DB.Exec(
+		`UPDATE destructive_approvals SET status = ?, reviewed_by = ?, comment = ?, reviewed_at = ?, expires_at = ?
+		 WHERE id = ? AND status = ?`,
+		status, currentUser.ID, req.Comment, now, expiresAt, a.ID, models.ApprovalPending,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Approval was reviewed concurrently"})
+		return
+	}
+	a = getAccessibleApproval(c, currentUser)
+	if a == nil {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"approval": a})
+}
@@ -0,0 +1,656 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"monji/internal/backup"
+	"monji/internal/database"
+	"monji/internal/jobs"
+	"monji/internal/middleware"
+	"monji/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// RecycleRetentionDays is how long dropped collections and databases are kept
+// in the recycle bin before being purged. 0 disables the recycle bin: drops are immediate.
+var RecycleRetentionDays = 7
+
+// trashCollectionPrefix prefixes the quarantine name of soft-dropped collections.
+// Such collections are hidden from the collection listings and change streams, and
+// refused by the data handlers (see requireVisibleCollections).
+const trashCollectionPrefix = "monji_trash."
+
+// purgeInterval is how often expired recycle bin entries are purged.
+const purgeInterval = time.Hour
+
+const (
+	jobTypeRecycleDatabase        = "recycleDatabase"
+	jobTypeRestoreRecycleDatabase = "restoreRecycledDatabase"
+)
+
+func init() {
+	jobs.Register(jobTypeRecycleDatabase, runRecycleDatabase)
+	jobs.Register(jobTypeRestoreRecycleDatabase, runRestoreRecycledDatabase)
+}
+
+// errNotRecyclable is returned for namespaces that cannot be moved to the recycle bin.
+var errNotRecyclable = errors.New("time-series collections cannot be moved to the recycle bin")
+
+// errCollectionNotFound is returned when soft-dropping a collection that does not exist.
+var errCollectionNotFound = errors.New("collection not found")
+
+// recycleParams are the parameters of the recycle bin jobs.
+type recycleParams struct {
+	EntryID int `json:"entryId"`
+	// DbName is the database to restore into (restore job only).
+	DbName string `json:"dbName,omitempty"`
+}
+
+const recycleColumns = `id, kind, environment_id, db_name, collection_name, collection_type, trash_name, view_options,
+	archive_path, size_bytes, status, error, job_id, dropped_by, dropped_at, expires_at, restored_at, purged_at`
+
+func scanRecycleEntry(s interface{ Scan(...interface{}) error }) (*models.RecycleBinEntry, error) {
+	var e models.RecycleBinEntry
+	var restoredAt, purgedAt sql.NullTime
+	err := s.Scan(&e.ID, &e.Kind, &e.EnvironmentID, &e.DBName, &e.CollectionName, &e.CollectionType, &e.TrashName,
+		&e.ViewOptions, &e.ArchivePath, &e.SizeBytes, &e.Status, &e.Error, &e.JobID, &e.DroppedBy, &e.DroppedAt,
+		&e.ExpiresAt, &restoredAt, &purgedAt)
+	if err != nil {
+		return nil, err
+	}
+	if restoredAt.Valid {
+		e.RestoredAt = &restoredAt.Time
+	}
+	if purgedAt.Valid {
+		e.PurgedAt = &purgedAt.Time
+	}
+	return &e, nil
+}
+
+func loadRecycleEntry(id int) (*models.RecycleBinEntry, error) {
+	return scanRecycleEntry(database.DB.QueryRow(`SELECT `+recycleColumns+` FROM recycle_bin WHERE id = ?`, id))
+}
+
+// visibleCollectionsFilter is a listCollections filter that hides soft-dropped collections.
+func visibleCollectionsFilter() bson.D {
+	return bson.D{{Key: "name", Value: bson.D{{Key: "$not", Value: primitive.Regex{Pattern: `^monji_trash\.`}}}}}
+}
+
+// errTrashCollection is returned for collection names in the quarantine namespace of
+// soft-dropped collections.
+var errTrashCollection = errors.New("collection names starting with " + trashCollectionPrefix + " are reserved for the recycle bin")
+
+// checkVisibleCollections returns errTrashCollection if one of names is in the quarantine
+// namespace: soft-dropped collections are only reachable through the recycle bin, and such
+// names cannot be created. Empty names are accepted.
+func checkVisibleCollections(names ...string) error {
+	for _, name := range names {
+		if strings.HasPrefix(name, trashCollectionPrefix) {
+			return errTrashCollection
+		}
+	}
+	return nil
+}
+
+// requireVisibleCollections refuses collection names in the quarantine namespace
+// (see checkVisibleCollections).
+// It writes the error response itself and returns false when a name is refused.
+func requireVisibleCollections(c *gin.Context, names ...string) bool {
+	if checkVisibleCollections(names...) != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Collection names starting with " + trashCollectionPrefix + " are reserved for the recycle bin"})
+		return false
+	}
+	return true
+}
+
+// visibleChangesStage is a change stream stage that hides the events of soft-dropped
+// collections, for streams on a whole database.
+func visibleChangesStage() bson.D {
+	return bson.D{{Key: "$match", Value: bson.D{{Key: "ns.coll", Value: bson.D{{Key: "$not", Value: primitive.Regex{Pattern: `^monji_trash\.`}}}}}}}
+}
+
+// trashCollection soft-drops a collection: it is renamed into a quarantine namespace
+// of the same database. Views are dropped and their definition recorded.
+func trashCollection(ctx context.Context, client *mongo.Client, envID int, dbName, collName string, userID int) (*models.RecycleBinEntry, error) {
+	db := client.Database(dbName)
+	cursor, err := db.ListCollections(ctx, bson.D{{Key: "name", Value: collName}})
+	if err != nil {
+		return nil, err
+	}
+	var infos []struct {
+		Type    string `bson:"type"`
+		Options bson.D `bson:"options"`
+	}
+	if err := cursor.All(ctx, &infos); err != nil {
+		return nil, err
+	}
+	if len(infos) == 0 {
+		return nil, errCollectionNotFound
+	}
+	info := infos[0]
+	if info.Type == "timeseries" {
+		return nil, errNotRecyclable
+	}
+	if info.Type == "" {
+		info.Type = "collection"
+	}
+	viewOptions := ""
+	if info.Type == "view" {
+		optionsJSON, err := bson.MarshalExtJSON(bson.D{{Key: "options", Value: info.Options}}, true, false)
+		if err != nil {
+			return nil, err
+		}
+		viewOptions = string(optionsJSON)
+	}
+
+	now := time.Now().UTC()
+	res, err := database.DB.Exec(
+		`INSERT INTO recycle_bin (kind, environment_id, db_name, collection_name, collection_type, view_options,
+		 status, dropped_by, dropped_at, expires_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		models.RecycleCollection, envID, dbName, collName, info.Type, viewOptions, models.RecyclePending,
+		userID, now, now.AddDate(0, 0, RecycleRetentionDays),
+	)
+	if err != nil {
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	trashName := ""
+	if info.Type == "view" {
+		err = db.Collection(collName).Drop(ctx)
+	} else {
+		trashName = fmt.Sprintf("%s%d.%s", trashCollectionPrefix, id, collName)
+		err = client.Database("admin").RunCommand(ctx, bson.D{
+			{Key: "renameCollection", Value: dbName + "." + collName},
+			{Key: "to", Value: dbName + "." + trashName},
+			{Key: "dropTarget", Value: false},
+		}).Err()
+	}
+	if err != nil {
+		database.DB.Exec(`DELETE FROM recycle_bin WHERE id = ?`, id)
+		return nil, err
+	}
+	if _, err := database.DB.Exec(`UPDATE recycle_bin SET status = ?, trash_name = ? WHERE id = ?`,
+		models.RecycleTrashed, trashName, id); err != nil {
+		return nil, err
+	}
+	return loadRecycleEntry(int(id))
+}
+
+// trashDatabase records a soft-dropped database and submits the job that
+// snapshots and then drops it.
+func trashDatabase(envID int, dbName string, userID int) (*models.RecycleBinEntry, error) {
+	now := time.Now().UTC()
+	res, err := database.DB.Exec(
+		`INSERT INTO recycle_bin (kind, environment_id, db_name, status, dropped_by, dropped_at, expires_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		models.RecycleDatabase, envID, dbName, models.RecyclePending, userID, now, now.AddDate(0, 0, RecycleRetentionDays),
+	)
+	if err != nil {
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	jobID, err := jobs.Submit(jobTypeRecycleDatabase, envID, userID, recycleParams{EntryID: int(id)})
+	if err != nil {
+		database.DB.Exec(`UPDATE recycle_bin SET status = ?, error = ? WHERE id = ?`, models.RecycleFailed, err.Error(), id)
+		return nil, err
+	}
+	if _, err := database.DB.Exec(`UPDATE recycle_bin SET job_id = ? WHERE id = ?`, jobID, id); err != nil {
+		return nil, err
+	}
+	return loadRecycleEntry(int(id))
+}
+
+// runRecycleDatabase snapshots a database to an archive, then drops it.
+// If the snapshot fails or is incomplete (time-series collections cannot be dumped) the
+// database is left untouched. Soft-dropped collections are not snapshotted: their entries
+// are purged with the database.
+func runRecycleDatabase(ctx context.Context, task *jobs.Task) (interface{}, error) {
+	var params recycleParams
+	if err := task.DecodeParams(&params); err != nil {
+		return nil, err
+	}
+	e, err := loadRecycleEntry(params.EntryID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load recycle bin entry %d: %w", params.EntryID, err)
+	}
+
+	fail := func(err error) (interface{}, error) {
+		database.DB.Exec(`UPDATE recycle_bin SET status = ?, error = ? WHERE id = ?`, models.RecycleFailed, err.Error(), e.ID)
+		return nil, err
+	}
+
+	client, err := connectEnvironment(ctx, e.EnvironmentID)
+	if err != nil {
+		return fail(fmt.Errorf("failed to connect to MongoDB: %w", err))
+	}
+	defer client.Disconnect(context.Background())
+
+	path := filepath.Join(BackupDir, "recycle", fmt.Sprintf("env-%d", e.EnvironmentID), fmt.Sprintf("%s-%d.archive.gz", e.DBName, e.ID))
+	result, size, err := writeArchiveFile(ctx, client.Database(e.DBName), path, backup.DumpOptions{
+		ExcludePrefixes: []string{trashCollectionPrefix},
+		Gzip:            true,
+		Progress: func(collection string, index, total int) {
+			task.SetProgress(index*90/total, "Snapshotting "+collection)
+		},
+	})
+	if err != nil {
+		return fail(fmt.Errorf("failed to snapshot database: %w", err))
+	}
+	if len(result.Skipped) > 0 {
+		os.Remove(path)
+		return fail(fmt.Errorf("cannot snapshot %s: the database is not dropped", strings.Join(result.Skipped, ", ")))
+	}
+	if _, err := database.DB.Exec(`UPDATE recycle_bin SET archive_path = ?, size_bytes = ? WHERE id = ?`, path, size, e.ID); err != nil {
+		return nil, err
+	}
+
+	task.SetProgress(95, "Dropping database")
+	if err := client.Database(e.DBName).Drop(ctx); err != nil {
+		return fail(fmt.Errorf("failed to drop database: %w", err))
+	}
+	if _, err := database.DB.Exec(`UPDATE recycle_bin SET status = ? WHERE id = ?`, models.RecycleTrashed, e.ID); err != nil {
+		return nil, err
+	}
+	_, err = database.DB.Exec(
+		`UPDATE recycle_bin SET status = ?, purged_at = ? WHERE kind = ? AND environment_id = ? AND db_name = ? AND status = ?`,
+		models.RecyclePurged, time.Now().UTC(), models.RecycleCollection, e.EnvironmentID, e.DBName, models.RecycleTrashed)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// restoreTrashedCollection moves a soft-dropped collection (or view) back under name.
+func restoreTrashedCollection(ctx context.Context, client *mongo.Client, e *models.RecycleBinEntry, name string) error {
+	db := client.Database(e.DBName)
+	if e.CollectionType == "view" {
+		var def struct {
+			Options bson.D `bson:"options"`
+		}
+		if err := bson.UnmarshalExtJSON([]byte(e.ViewOptions), true, &def); err != nil {
+			return fmt.Errorf("invalid view definition: %w", err)
+		}
+		return prepareView(ctx, db.Collection(name), def.Options)
+	}
+	err := client.Database("admin").RunCommand(ctx, bson.D{
+		{Key: "renameCollection", Value: e.DBName + "." + e.TrashName},
+		{Key: "to", Value: e.DBName + "." + name},
+		{Key: "dropTarget", Value: false},
+	}).Err()
+	if isNamespaceExists(err) {
+		return fmt.Errorf("%w: %s.%s", backup.ErrCollectionExists, e.DBName, name)
+	}
+	return err
+}
+
+// prepareView recreates a view from its listCollections options.
+func prepareView(ctx context.Context, coll *mongo.Collection, viewOptions bson.D) error {
+	names, err := coll.Database().ListCollectionNames(ctx, bson.D{{Key: "name", Value: coll.Name()}})
+	if err != nil {
+		return err
+	}
+	if len(names) > 0 {
+		return fmt.Errorf("%w: %s.%s", backup.ErrCollectionExists, coll.Database().Name(), coll.Name())
+	}
+	cmd := bson.D{{Key: "create", Value: coll.Name()}}
+	cmd = append(cmd, viewOptions...)
+	return coll.Database().RunCommand(ctx, cmd).Err()
+}
+
+// runRestoreRecycledDatabase restores the snapshot of a soft-dropped database.
+func runRestoreRecycledDatabase(ctx context.Context, task *jobs.Task) (interface{}, error) {
+	var params recycleParams
+	if err := task.DecodeParams(&params); err != nil {
+		return nil, err
+	}
+	e, err := loadRecycleEntry(params.EntryID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load recycle bin entry %d: %w", params.EntryID, err)
+	}
+	// The entry is claimed by RestoreRecycleBinEntry; a retried job claims it again.
+	if e.Status == models.RecycleTrashed {
+		res, err := database.DB.Exec(`UPDATE recycle_bin SET status = ? WHERE id = ? AND status = ?`,
+			models.RecycleRestoring, e.ID, models.RecycleTrashed)
+		if err != nil {
+			return nil, err
+		}
+		if n, _ := res.RowsAffected(); n == 1 {
+			e.Status = models.RecycleRestoring
+		}
+	}
+	if e.Status != models.RecycleRestoring {
+		return nil, fmt.Errorf("recycle bin entry is %s", e.Status)
+	}
+	fail := func(err error) (interface{}, error) {
+		releaseRecycleEntry(e.ID)
+		return nil, err
+	}
+
+	f, err := os.Open(e.ArchivePath)
+	if err != nil {
+		return fail(fmt.Errorf("failed to open snapshot: %w", err))
+	}
+	defer f.Close()
+
+	client, err := connectEnvironment(ctx, e.EnvironmentID)
+	if err != nil {
+		return fail(fmt.Errorf("failed to connect to MongoDB: %w", err))
+	}
+	defer client.Disconnect(context.Background())
+
+	result, err := backup.Restore(ctx, client, f, backup.RestoreOptions{
+		TargetDb: params.DbName,
+		Progress: func(collection string, done, total int) {
+			task.SetProgress(done*100/total, "Restored "+collection)
+		},
+	})
+	if err != nil {
+		return fail(err)
+	}
+	f.Close()
+	os.Remove(e.ArchivePath)
+	_, err = database.DB.Exec(`UPDATE recycle_bin SET status = ?, archive_path = '', restored_at = ? WHERE id = ?`,
+		models.RecycleRestored, time.Now().UTC(), e.ID)
+	return result, err
+}
+
+// purgeRecycleEntry permanently deletes what a recycle bin entry holds.
+func purgeRecycleEntry(ctx context.Context, e *models.RecycleBinEntry) error {
+	if e.Kind == models.RecycleCollection && e.TrashName != "" && e.Status == models.RecycleTrashed {
+		client, err := connectEnvironment(ctx, e.EnvironmentID)
+		if err != nil {
+			return fmt.Errorf("failed to connect to MongoDB: %w", err)
+		}
+		defer client.Disconnect(context.Background())
+		if err := client.Database(e.DBName).Collection(e.TrashName).Drop(ctx); err != nil {
+			return fmt.Errorf("failed to drop %s: %w", e.TrashName, err)
+		}
+	}
+	if e.ArchivePath != "" {
+		if err := os.Remove(e.ArchivePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to delete snapshot %s: %w", e.ArchivePath, err)
+		}
+	}
+	_, err := database.DB.Exec(`UPDATE recycle_bin SET status = ?, archive_path = '', purged_at = ? WHERE id = ?`,
+		models.RecyclePurged, time.Now().UTC(), e.ID)
+	return err
+}
+
+// StartRecyclePurger purges the expired recycle bin entries periodically, until ctx is done.
+func StartRecyclePurger(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(purgeInterval)
+		defer ticker.Stop()
+		for {
+			purgeExpiredRecycleEntries(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func purgeExpiredRecycleEntries(ctx context.Context) {
+	rows, err := database.DB.Query(`SELECT `+recycleColumns+` FROM recycle_bin WHERE status = ? AND expires_at <= ?`,
+		models.RecycleTrashed, time.Now().UTC())
+	if err != nil {
+		log.Printf("recycle bin: failed to load expired entries: %v", err)
+		return
+	}
+	var expired []*models.RecycleBinEntry
+	for rows.Next() {
+		e, err := scanRecycleEntry(rows)
+		if err != nil {
+			log.Printf("recycle bin: %v", err)
+			continue
+		}
+		expired = append(expired, e)
+	}
+	rows.Close()
+
+	for _, e := range expired {
+		purgeCtx, cancel := context.WithTimeout(ctx, time.Minute)
+		if err := purgeRecycleEntry(purgeCtx, e); err != nil {
+			log.Printf("recycle bin: failed to purge entry %d: %v", e.ID, err)
+		}
+		cancel()
+	}
+}
+
+// getRecycleEntryParam loads the entry named by the :entryId route parameter.
+// It writes the error response itself and returns nil on failure.
+func getRecycleEntryParam(c *gin.Context) *models.RecycleBinEntry {
+	entryID, err := strconv.Atoi(c.Param("entryId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recycle bin entry ID"})
+		return nil
+	}
+	e, err := loadRecycleEntry(entryID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Recycle bin entry not found"})
+		return nil
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil
+	}
+	return e
+}
+
+// ListRecycleBin lists the recycle bin entries, newest first.
+// Query params: environmentId, status (optional). By default only restorable entries are listed.
+func ListRecycleBin(c *gin.Context) {
+	query := `SELECT ` + recycleColumns + ` FROM recycle_bin WHERE status = ?`
+	status := c.DefaultQuery("status", models.RecycleTrashed)
+	params := []interface{}{status}
+	if status == "all" {
+		query = `SELECT ` + recycleColumns + ` FROM recycle_bin WHERE 1 = 1`
+		params = nil
+	}
+	if envIDStr := c.Query("environmentId"); envIDStr != "" {
+		envID, err := strconv.Atoi(envIDStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid environment ID"})
+			return
+		}
+		query += ` AND environment_id = ?`
+		params = append(params, envID)
+	}
+	query += ` ORDER BY id DESC`
+	rows, err := database.DB.Query(query, params...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	entries := []models.RecycleBinEntry{}
+	for rows.Next() {
+		e, err := scanRecycleEntry(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		entries = append(entries, *e)
+	}
+	c.JSON(http.StatusOK, gin.H{"entries": entries})
+}
+
+// GetRecycleBinEntry returns a single recycle bin entry.
+func GetRecycleBinEntry(c *gin.Context) {
+	e := getRecycleEntryParam(c)
+	if e == nil {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"entry": e})
+}
+
+// RestoreRecycleBinEntry restores a soft-dropped collection or database.
+// Body (optional): { "name": "..." } restores under another collection or database name.
+// Collections are restored immediately; databases are restored by a background job.
+// The entry is claimed first, so that a concurrent restore of it is refused.
+func RestoreRecycleBinEntry(c *gin.Context) {
+	e := getRecycleEntryParam(c)
+	if e == nil {
+		return
+	}
+	if e.Status != models.RecycleTrashed {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only trashed entries can be restored, this one is " + e.Status})
+		return
+	}
+	var req struct {
+		Name string `json:"name"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+
+	if e.Kind == models.RecycleDatabase {
+		if req.Name == "" {
+			req.Name = e.DBName
+		}
+		if !validDatabaseName(req.Name) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "name is not a valid database name"})
+			return
+		}
+		if !claimRecycleEntry(c, e) {
+			return
+		}
+		jobID, err := jobs.Submit(jobTypeRestoreRecycleDatabase, e.EnvironmentID, currentUser.ID, recycleParams{EntryID: e.ID, DbName: req.Name})
+		if err != nil {
+			releaseRecycleEntry(e.ID)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start restore: " + err.Error()})
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{
+			"message":  "Database restore started",
+			"jobId":    jobID,
+			"database": req.Name,
+		})
+		return
+	}
+
+	if req.Name == "" {
+		req.Name = e.CollectionName
+	}
+	if !requireVisibleCollections(c, req.Name) {
+		return
+	}
+	if !claimRecycleEntry(c, e) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	client, err := connectEnvironment(ctx, e.EnvironmentID)
+	if err != nil {
+		releaseRecycleEntry(e.ID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to MongoDB: " + err.Error()})
+		return
+	}
+	defer client.Disconnect(ctx)
+
+	if err := restoreTrashedCollection(ctx, client, e, req.Name); err != nil {
+		releaseRecycleEntry(e.ID)
+		if errors.Is(err, backup.ErrCollectionExists) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore collection: " + err.Error()})
+		return
+	}
+	if _, err := database.DB.Exec(`UPDATE recycle_bin SET status = ?, restored_at = ? WHERE id = ?`,
+		models.RecycleRestored, time.Now().UTC(), e.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Collection restored successfully",
+		"database":   e.DBName,
+		"collection": req.Name,
+	})
+}
+
+// claimRecycleEntry marks a trashed entry as being restored, so that concurrent restores
+// and purges of the same entry are refused.
+// It writes the error response itself and returns false when the entry cannot be claimed.
+func claimRecycleEntry(c *gin.Context, e *models.RecycleBinEntry) bool {
+	res, err := database.DB.Exec(`UPDATE recycle_bin SET status = ? WHERE id = ? AND status = ?`,
+		models.RecycleRestoring, e.ID, models.RecycleTrashed)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "The entry is already being restored"})
+		return false
+	}
+	e.Status = models.RecycleRestoring
+	return true
+}
+
+// releaseRecycleEntry puts back an entry whose restore failed.
+func releaseRecycleEntry(id int) {
+	database.DB.Exec(`UPDATE recycle_bin SET status = ? WHERE id = ? AND status = ?`,
+		models.RecycleTrashed, id, models.RecycleRestoring)
+}
+
+// PurgeRecycleBinEntry permanently deletes a soft-dropped collection or database snapshot.
+func PurgeRecycleBinEntry(c *gin.Context) {
+	currentUserRaw, _ := c.Get("user")
+	if !middleware.HasCapability(currentUserRaw.(models.User), models.CapDropDatabases) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Purging the recycle bin requires the drop_databases capability"})
+		return
+	}
+	e := getRecycleEntryParam(c)
+	if e == nil {
+		return
+	}
+	if e.Status != models.RecycleTrashed && e.Status != models.RecycleFailed {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only trashed or failed entries can be purged, this one is " + e.Status})
+		return
+	}
+	target := e.DBName
+	if e.Kind == models.RecycleCollection {
+		target += "." + e.CollectionName
+	}
+	if !requireDestructiveConfirmation(c, e.EnvironmentID, actionPurgeRecycleBin, target) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := purgeRecycleEntry(ctx, e); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Recycle bin entry purged successfully"})
+}
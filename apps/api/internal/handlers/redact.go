@@ -0,0 +1,496 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"monji/internal/database"
+	"monji/internal/middleware"
+	"monji/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// compiledRule is a redaction rule ready to be matched against document paths.
+type compiledRule struct {
+	field []string // path segments (glob patterns); empty matches string values anywhere
+	regex *regexp.Regexp
+	style string
+}
+
+// redactor masks documents with the redaction rules of a collection.
+// A nil redactor leaves documents untouched.
+type redactor struct {
+	rules []compiledRule
+}
+
+// loadRedactionRules returns the rules of an environment matching a database and
+// collection. An empty collName matches the rules of every collection.
+func loadRedactionRules(envID int, dbName, collName string) ([]models.RedactionRule, error) {
+	rows, err := database.DB.Query(
+		`SELECT `+redactionRuleColumns+` FROM redaction_rules WHERE environment_id = ? ORDER BY id`, envID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rules := []models.RedactionRule{}
+	for rows.Next() {
+		r, err := scanRedactionRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		if ok, _ := path.Match(r.DBName, dbName); !ok {
+			continue
+		}
+		if ok, _ := path.Match(r.Collection, collName); !ok && collName != "" {
+			continue
+		}
+		rules = append(rules, *r)
+	}
+	return rules, rows.Err()
+}
+
+// documentRedactor returns the redactor applying to what the user reads from a collection,
+// or nil if nothing is masked for them.
+func documentRedactor(user models.User, envID int, dbName, collName string) (*redactor, error) {
+	rules, err := loadRedactionRules(envID, dbName, collName)
+	if err != nil || len(rules) == 0 {
+		return nil, err
+	}
+	unmasked, err := middleware.CanViewUnmasked(user, envID, dbName)
+	if err != nil || unmasked {
+		return nil, err
+	}
+	r := &redactor{}
+	for _, rule := range rules {
+		cr := compiledRule{style: rule.Style}
+		if rule.Field != "" {
+			cr.field = strings.Split(rule.Field, ".")
+		}
+		if rule.ValueRegex != "" {
+			// Rules are validated when saved.
+			if cr.regex, err = regexp.Compile(rule.ValueRegex); err != nil {
+				return nil, err
+			}
+		}
+		r.rules = append(r.rules, cr)
+	}
+	return r, nil
+}
+
+// Document masks a document in place.
+func (r *redactor) Document(doc bson.M) {
+	if r == nil {
+		return
+	}
+	r.document(nil, doc)
+}
+
+// Documents masks documents in place.
+func (r *redactor) Documents(docs []bson.M) {
+	for _, doc := range docs {
+		r.Document(doc)
+	}
+}
+
+// Changes masks the values of field changes, as they would be masked in the documents.
+// A change whose value is removed by a rule shows no value.
+func (r *redactor) Changes(changes []fieldChange) {
+	if r == nil {
+		return
+	}
+	for i := range changes {
+		p := strings.Split(changes[i].Path, ".")
+		if changes[i].Before != nil {
+			changes[i].Before, _ = r.value(p, changes[i].Before)
+		}
+		if changes[i].After != nil {
+			changes[i].After, _ = r.value(p, changes[i].After)
+		}
+	}
+}
+
+// Fields masks in place the values of a document whose keys may use dot notation,
+// such as the fields of a $set.
+func (r *redactor) Fields(fields bson.M) {
+	if r == nil {
+		return
+	}
+	for key, v := range fields {
+		if masked, remove := r.value(strings.Split(key, "."), v); remove {
+			delete(fields, key)
+		} else {
+			fields[key] = masked
+		}
+	}
+}
+
+// Update masks in place the field values of update operators.
+func (r *redactor) Update(update bson.M) {
+	if r == nil {
+		return
+	}
+	for op, v := range update {
+		if fields, ok := asDocument(v); ok {
+			r.Fields(fields)
+			update[op] = fields
+		}
+	}
+}
+
+// Filter masks in place the values compared in a query filter, including in
+// $and, $or and $nor clauses.
+func (r *redactor) Filter(filter bson.M) {
+	if r == nil {
+		return
+	}
+	for key, v := range filter {
+		switch {
+		case key == "$and" || key == "$or" || key == "$nor":
+			clauses, ok := v.(bson.A)
+			if !ok {
+				continue
+			}
+			for i, clause := range clauses {
+				if doc, ok := asDocument(clause); ok {
+					r.Filter(doc)
+					clauses[i] = doc
+				}
+			}
+		case strings.HasPrefix(key, "$"):
+			continue
+		default:
+			if masked, remove := r.value(strings.Split(key, "."), v); remove {
+				delete(filter, key)
+			} else {
+				filter[key] = masked
+			}
+		}
+	}
+}
+
+// Writable reports whether the fields of a $set leave the masked values of the current
+// document alone. Fields sending back a masked value unchanged, as the user read it,
+// are dropped from fields first.
+func (r *redactor) Writable(current bson.Raw, fields bson.M) bool {
+	if r == nil {
+		return true
+	}
+	for key, v := range fields {
+		p := strings.Split(key, ".")
+		old, found := lookupPath(current, p)
+		masked, removed := old, false
+		if found {
+			masked, removed = r.value(p, old)
+		}
+		touched := found && (removed || !sameValue(masked, old))
+		for _, rule := range r.rules {
+			if rule.field != nil && overlapsFieldPath(rule.field, p) {
+				touched = true
+			}
+		}
+		if !touched {
+			continue
+		}
+		if found && !removed && sameValue(masked, v) {
+			delete(fields, key)
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// lookupPath returns the value of a document at a dot-notation path. Values are decoded
+// as documents read by users are, so that they are masked the same.
+func lookupPath(doc bson.Raw, p []string) (interface{}, bool) {
+	var m bson.M
+	if err := bson.Unmarshal(doc, &m); err != nil {
+		return nil, false
+	}
+	var v interface{} = m
+	for _, seg := range p {
+		switch val := v.(type) {
+		case bson.M:
+			next, ok := val[seg]
+			if !ok {
+				return nil, false
+			}
+			v = next
+		case bson.A:
+			i, err := strconv.Atoi(seg)
+			if err != nil || i < 0 || i >= len(val) {
+				return nil, false
+			}
+			v = val[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// sameValue compares values as they read in JSON, so that a value decoded from a
+// request body equals the one it was encoded from.
+func sameValue(a, b interface{}) bool {
+	var norm [2]interface{}
+	for i, v := range []interface{}{a, b} {
+		ext, err := bson.MarshalExtJSON(bson.M{"v": v}, false, false)
+		if err != nil {
+			return false
+		}
+		if err := json.Unmarshal(ext, &norm[i]); err != nil {
+			return false
+		}
+	}
+	return reflect.DeepEqual(norm[0], norm[1])
+}
+
+// MasksPath returns true if values at a document path, or inside it, may be masked.
+func (r *redactor) MasksPath(p []string) bool {
+	if r == nil {
+		return false
+	}
+	for _, rule := range r.rules {
+		// Value rules mask strings anywhere.
+		if rule.field == nil || overlapsFieldPath(rule.field, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// MasksWhole returns true if the value at a document path is masked as a whole, by a
+// rule on the path or on a field containing it.
+func (r *redactor) MasksWhole(p []string) bool {
+	if r == nil {
+		return false
+	}
+	for _, rule := range r.rules {
+		if rule.field != nil && rule.regex == nil && matchFieldPath(rule.field, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// MayRemove returns true if the value at a document path may be removed from the
+// documents, hiding whether it exists.
+func (r *redactor) MayRemove(p []string) bool {
+	if r == nil {
+		return false
+	}
+	for _, rule := range r.rules {
+		if rule.style == models.RedactRemove && (rule.field == nil || matchFieldPath(rule.field, p)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Filterable reports whether a query filter only compares fields that are not masked,
+// so that what it matches reveals nothing of the masked values. Operators evaluated on
+// whole documents, such as $expr or $where, are not filterable. docPath maps a filter
+// key to the document path it compares, or returns false for keys that are not document
+// data; nil compares keys as they are.
+func (r *redactor) Filterable(filter interface{}, docPath func(key string) ([]string, bool)) bool {
+	if r == nil {
+		return true
+	}
+	doc, ok := asDocument(filter)
+	if !ok {
+		return filter == nil
+	}
+	for key, v := range doc {
+		switch {
+		case key == "$and" || key == "$or" || key == "$nor":
+			clauses, ok := v.(bson.A)
+			if !ok {
+				return false
+			}
+			for _, clause := range clauses {
+				if !r.Filterable(clause, docPath) {
+					return false
+				}
+			}
+		case key == "$comment":
+		case strings.HasPrefix(key, "$"):
+			return false
+		default:
+			p := strings.Split(key, ".")
+			if docPath != nil {
+				if p, ok = docPath(key); !ok {
+					continue
+				}
+			}
+			if r.MasksPath(p) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// value masks the value found at a path. It returns true if the value must be removed.
+// Array elements share the path of their array, as in MongoDB queries.
+func (r *redactor) value(p []string, v interface{}) (interface{}, bool) {
+	for _, rule := range r.rules {
+		if rule.field == nil || !matchFieldPath(rule.field, p) {
+			continue
+		}
+		if rule.regex == nil {
+			if rule.style == models.RedactRemove {
+				return nil, true
+			}
+			return maskValue(v, rule.style), false
+		}
+		if s, ok := v.(string); ok && rule.regex.MatchString(s) {
+			if rule.style == models.RedactRemove {
+				return nil, true
+			}
+			v = maskMatches(s, rule.regex, rule.style)
+		}
+	}
+
+	switch val := v.(type) {
+	case bson.M:
+		r.document(p, val)
+		return val, false
+	case map[string]interface{}:
+		r.document(p, bson.M(val))
+		return val, false
+	case bson.D:
+		out := bson.D{}
+		for _, e := range val {
+			if masked, remove := r.value(append(p[:len(p):len(p)], e.Key), e.Value); !remove {
+				out = append(out, bson.E{Key: e.Key, Value: masked})
+			}
+		}
+		return out, false
+	case bson.A:
+		return bson.A(r.array(p, val)), false
+	case []interface{}:
+		return r.array(p, val), false
+	case string:
+		for _, rule := range r.rules {
+			if rule.field != nil || !rule.regex.MatchString(val) {
+				continue
+			}
+			if rule.style == models.RedactRemove {
+				return nil, true
+			}
+			val = maskMatches(val, rule.regex, rule.style)
+		}
+		return val, false
+	}
+	return v, false
+}
+
+func (r *redactor) document(p []string, doc bson.M) {
+	for key, v := range doc {
+		if masked, remove := r.value(append(p[:len(p):len(p)], key), v); remove {
+			delete(doc, key)
+		} else {
+			doc[key] = masked
+		}
+	}
+}
+
+func (r *redactor) array(p []string, values []interface{}) []interface{} {
+	out := make([]interface{}, 0, len(values))
+	for _, v := range values {
+		if masked, remove := r.value(p, v); !remove {
+			out = append(out, masked)
+		}
+	}
+	return out
+}
+
+// matchFieldPath returns true if a document path is the rule's path or inside it.
+// Array indexes and positional operators ("cards.0.number", "cards.$.number") are
+// skipped where the rule does not name them, as in MongoDB queries.
+func matchFieldPath(rule, p []string) bool {
+	if len(rule) == 0 {
+		return true
+	}
+	if len(p) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(rule[0], p[0]); ok && matchFieldPath(rule[1:], p[1:]) {
+		return true
+	}
+	return isArrayIndex(p[0]) && matchFieldPath(rule, p[1:])
+}
+
+// overlapsFieldPath returns true if a document path is the rule's path, is inside it
+// or contains it.
+func overlapsFieldPath(rule, p []string) bool {
+	if matchFieldPath(rule, p) {
+		return true
+	}
+	i := 0
+	for _, seg := range p {
+		if isArrayIndex(seg) {
+			continue
+		}
+		if i == len(rule) {
+			return false
+		}
+		if ok, _ := path.Match(rule[i], seg); !ok {
+			return false
+		}
+		i++
+	}
+	return true
+}
+
+// isArrayIndex returns true for the path segments addressing array elements.
+func isArrayIndex(seg string) bool {
+	_, err := strconv.Atoi(seg)
+	return err == nil || strings.HasPrefix(seg, "$")
+}
+
+// maskValue masks a whole value. Non-string values are masked as their string form.
+func maskValue(v interface{}, style string) string {
+	s, ok := v.(string)
+	if !ok {
+		if oid, isOID := v.(primitive.ObjectID); isOID {
+			s = oid.Hex()
+		} else {
+			s = fmt.Sprint(v)
+		}
+	}
+	return maskString(s, style)
+}
+
+// maskMatches masks only the parts of s matching re.
+func maskMatches(s string, re *regexp.Regexp, style string) string {
+	return re.ReplaceAllStringFunc(s, func(m string) string { return maskString(m, style) })
+}
+
+// maskString masks s in the given style. Hashes are keyed with the server secret, so a
+// masked user cannot recover short values by hashing guesses.
+func maskString(s, style string) string {
+	if style == models.RedactHash {
+		mac := hmac.New(sha256.New, ETagSecret)
+		mac.Write([]byte(s))
+		return "hmac:" + hex.EncodeToString(mac.Sum(nil))[:16]
+	}
+	// Partial: keep up to a quarter of the characters, at most 4, at each end.
+	runes := []rune(s)
+	keep := len(runes) / 4
+	if keep > 4 {
+		keep = 4
+	}
+	return string(runes[:keep]) + strings.Repeat("*", len(runes)-2*keep) + string(runes[len(runes)-keep:])
+}
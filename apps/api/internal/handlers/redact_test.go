@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"monji/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// testRedactor builds a redactor from rules written as "field", "field~regex" or "~regex".
+func testRedactor(style string, rules ...string) *redactor {
+	r := &redactor{}
+	for _, rule := range rules {
+		cr := compiledRule{style: style}
+		field, regex, _ := strings.Cut(rule, "~")
+		if field != "" {
+			cr.field = strings.Split(field, ".")
+		}
+		if regex != "" {
+			cr.regex = regexp.MustCompile(regex)
+		}
+		r.rules = append(r.rules, cr)
+	}
+	return r
+}
+
+func TestMaskString(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", ""},
+		{"abc", "***"},
+		{"abcd", "a**d"},
+		{"jane@example.com", "jane********.com"},
+		{"a-very-long-secret-value", "a-ve****************alue"},
+		{"éèêë", "é**ë"},
+	}
+	for _, tt := range tests {
+		if got := maskString(tt.in, models.RedactPartial); got != tt.want {
+			t.Errorf("maskString(%q, partial) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	a, b := maskString("secret", models.RedactHash), maskString("secret", models.RedactHash)
+	if a != b {
+		t.Errorf("maskString(hash) is not deterministic: %q != %q", a, b)
+	}
+	if !strings.HasPrefix(a, "hmac:") || len(a) != len("hmac:")+16 {
+		t.Errorf("maskString(hash) = %q, want hmac: and 16 hex digits", a)
+	}
+	if a == maskString("secret2", models.RedactHash) {
+		t.Errorf("maskString(hash) gives the same digest for different values")
+	}
+}
+
+func TestRedactorDocument(t *testing.T) {
+	var nilRedactor *redactor
+	doc := bson.M{"email": "jane@example.com"}
+	nilRedactor.Document(doc)
+	if doc["email"] != "jane@example.com" {
+		t.Errorf("nil redactor masked %v", doc)
+	}
+
+	tests := []struct {
+		name string
+		r    *redactor
+		doc  bson.M
+		want bson.M
+	}{
+		{
+			name: "field",
+			r:    testRedactor(models.RedactPartial, "email"),
+			doc:  bson.M{"email": "jane@example.com", "name": "Jane"},
+			want: bson.M{"email": "jane********.com", "name": "Jane"},
+		},
+		{
+			name: "nested field",
+			r:    testRedactor(models.RedactRemove, "profile.ssn"),
+			doc:  bson.M{"profile": bson.M{"ssn": "123-45-6789", "city": "Paris"}, "ssn": "kept"},
+			want: bson.M{"profile": bson.M{"city": "Paris"}, "ssn": "kept"},
+		},
+		{
+			name: "whole subdocument",
+			r:    testRedactor(models.RedactRemove, "profile"),
+			doc:  bson.M{"profile": bson.M{"ssn": "123-45-6789"}, "name": "Jane"},
+			want: bson.M{"name": "Jane"},
+		},
+		{
+			name: "glob segment",
+			r:    testRedactor(models.RedactRemove, "*.token"),
+			doc:  bson.M{"github": bson.M{"token": "t1", "login": "jane"}, "token": "top"},
+			want: bson.M{"github": bson.M{"login": "jane"}, "token": "top"},
+		},
+		{
+			name: "non-string value",
+			r:    testRedactor(models.RedactPartial, "pin"),
+			doc:  bson.M{"pin": int32(12345678)},
+			want: bson.M{"pin": "12****78"},
+		},
+		{
+			name: "value regex anywhere",
+			r:    testRedactor(models.RedactPartial, `~\d{4}-\d{4}`),
+			doc:  bson.M{"note": "card 1234-5678 on file", "n": bson.M{"c": "9999-0000"}},
+			want: bson.M{"note": "card 12*****78 on file", "n": bson.M{"c": "99*****00"}},
+		},
+		{
+			name: "value regex on a field",
+			r:    testRedactor(models.RedactRemove, `note~secret`),
+			doc:  bson.M{"note": "top secret", "other": "secret"},
+			want: bson.M{"other": "secret"},
+		},
+	}
+	for _, tt := range tests {
+		tt.r.Document(tt.doc)
+		if !reflect.DeepEqual(tt.doc, tt.want) {
+			t.Errorf("%s: Document() = %v, want %v", tt.name, tt.doc, tt.want)
+		}
+	}
+}
+
+func TestMatchFieldPath(t *testing.T) {
+	tests := []struct {
+		rule, path string
+		want       bool
+	}{
+		{"email", "email", true},
+		{"email", "email.domain", true},
+		{"email", "emails", false},
+		{"profile.email", "profile", false},
+		{"cards.number", "cards.0.number", true},
+		{"cards.number", "cards.$.number", true},
+		{"cards.number", "cards.$[].number", true},
+		{"cards.number", "cards.$[elem].number", true},
+		{"cards.number", "cards.0.1.number", true},
+		{"cards.number", "cards.0.expiry", false},
+		{"cards.0.number", "cards.0.number", true},
+		{"cards.0.number", "cards.1.number", false},
+		{"*.token", "github.token", true},
+		{"*.token", "token", false},
+		{"users.*.ssn", "users.3.profile.ssn", true},
+	}
+	for _, tt := range tests {
+		if got := matchFieldPath(strings.Split(tt.rule, "."), strings.Split(tt.path, ".")); got != tt.want {
+			t.Errorf("matchFieldPath(%q, %q) = %v, want %v", tt.rule, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestOverlapsFieldPath(t *testing.T) {
+	tests := []struct {
+		rule, path string
+		want       bool
+	}{
+		{"profile.ssn", "profile", true},
+		{"profile.ssn", "profile.ssn.last4", true},
+		{"cards.number", "cards", true},
+		{"cards.number", "cards.0", true},
+		{"cards.number", "cards.0.number", true},
+		{"cards.number", "cards.0.expiry", false},
+		{"profile.ssn", "name", false},
+	}
+	for _, tt := range tests {
+		if got := overlapsFieldPath(strings.Split(tt.rule, "."), strings.Split(tt.path, ".")); got != tt.want {
+			t.Errorf("overlapsFieldPath(%q, %q) = %v, want %v", tt.rule, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestRedactorArrays(t *testing.T) {
+	r := testRedactor(models.RedactRemove, "cards.number")
+	doc := bson.M{"cards": bson.A{
+		bson.M{"number": "4111", "expiry": "12/30"},
+		bson.A{bson.M{"number": "5500"}},
+		bson.D{{Key: "number", Value: "3400"}, {Key: "brand", Value: "amex"}},
+	}}
+	r.Document(doc)
+	want := bson.M{"cards": bson.A{
+		bson.M{"expiry": "12/30"},
+		bson.A{bson.M{}},
+		bson.D{{Key: "brand", Value: "amex"}},
+	}}
+	if !reflect.DeepEqual(doc, want) {
+		t.Errorf("Document() = %v, want %v", doc, want)
+	}
+
+	fields := bson.M{"cards.0.number": "4111", "cards.$.number": "5500", "cards.0.expiry": "12/30"}
+	r.Fields(fields)
+	if want := (bson.M{"cards.0.expiry": "12/30"}); !reflect.DeepEqual(fields, want) {
+		t.Errorf("Fields() = %v, want %v", fields, want)
+	}
+}
+
+func TestRedactorFilterable(t *testing.T) {
+	r := testRedactor(models.RedactPartial, "cards.number")
+	tests := []struct {
+		name   string
+		filter bson.M
+		want   bool
+	}{
+		{"unmasked field", bson.M{"name": "Jane"}, true},
+		{"masked field", bson.M{"cards.number": "4111"}, false},
+		{"through an index", bson.M{"cards.0.number": "4111"}, false},
+		{"containing field", bson.M{"cards": bson.M{"$elemMatch": bson.M{"number": "4111"}}}, false},
+		{"in $or", bson.M{"$or": bson.A{bson.M{"name": "Jane"}, bson.M{"cards.number": "4111"}}}, false},
+		{"$and of unmasked fields", bson.M{"$and": bson.A{bson.M{"name": "Jane"}, bson.M{"cards.expiry": "12/30"}}}, true},
+		{"$expr", bson.M{"$expr": bson.M{"$eq": bson.A{"$name", "Jane"}}}, false},
+		{"$comment", bson.M{"$comment": "audit", "name": "Jane"}, true},
+	}
+	for _, tt := range tests {
+		if got := r.Filterable(tt.filter, nil); got != tt.want {
+			t.Errorf("%s: Filterable(%v) = %v, want %v", tt.name, tt.filter, got, tt.want)
+		}
+	}
+	if !(*redactor)(nil).Filterable(bson.M{"cards.number": "4111"}, nil) {
+		t.Errorf("nil redactor: Filterable() = false, want true")
+	}
+}
@@ -0,0 +1,466 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"monji/internal/database"
+	"monji/internal/middleware"
+	"monji/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+const redactionRuleColumns = `id, environment_id, db_name, collection, field, value_regex, style,
+	description, created_by, created_at`
+
+func scanRedactionRule(s interface{ Scan(...interface{}) error }) (*models.RedactionRule, error) {
+	var r models.RedactionRule
+	if err := s.Scan(&r.ID, &r.EnvironmentID, &r.DBName, &r.Collection, &r.Field, &r.ValueRegex, &r.Style,
+		&r.Description, &r.CreatedBy, &r.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+const unmaskedGrantColumns = `id, user_id, group_id, environment_id, db_name, created_by, created_at`
+
+func scanUnmaskedGrant(s interface{ Scan(...interface{}) error }) (*models.UnmaskedGrant, error) {
+	var g models.UnmaskedGrant
+	if err := s.Scan(&g.ID, &g.UserID, &g.GroupID, &g.EnvironmentID, &g.DBName, &g.CreatedBy, &g.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+// redactionRuleRequest is the body of CreateRedactionRule and UpdateRedactionRule.
+type redactionRuleRequest struct {
+	EnvironmentID int    `json:"environment_id" binding:"required"`
+	DBName        string `json:"db_name"`
+	Collection    string `json:"collection"`
+	Field         string `json:"field"`
+	ValueRegex    string `json:"value_regex"`
+	Style         string `json:"style" binding:"required"`
+	Description   string `json:"description"`
+}
+
+// validate fills the defaults of a rule and returns an error message if it is invalid.
+func (req *redactionRuleRequest) validate() string {
+	var id int
+	if err := database.DB.QueryRow(`SELECT id FROM environments WHERE id = ?`, req.EnvironmentID).Scan(&id); err != nil {
+		return "Environment not found"
+	}
+	if req.DBName == "" {
+		req.DBName = "*"
+	}
+	if req.Collection == "" {
+		req.Collection = "*"
+	}
+	if !middleware.ValidDBNamePattern(req.DBName) || !middleware.ValidDBNamePattern(req.Collection) {
+		return "Invalid database or collection pattern"
+	}
+	if req.Field == "" && req.ValueRegex == "" {
+		return "A rule needs a field, a value_regex, or both"
+	}
+	if req.Field != "" {
+		for _, segment := range strings.Split(req.Field, ".") {
+			if _, err := path.Match(segment, ""); segment == "" || err != nil {
+				return "Invalid field path"
+			}
+		}
+	}
+	if req.ValueRegex != "" {
+		if _, err := regexp.Compile(req.ValueRegex); err != nil {
+			return "Invalid value_regex: " + err.Error()
+		}
+	}
+	switch req.Style {
+	case models.RedactHash, models.RedactPartial, models.RedactRemove:
+	default:
+		return "style must be 'hash', 'partial' or 'remove'"
+	}
+	return ""
+}
+
+// getRedactionRuleParam loads the rule named by the :ruleId route parameter.
+// It writes the error response itself and returns nil on failure.
+func getRedactionRuleParam(c *gin.Context) *models.RedactionRule {
+	ruleID, err := strconv.Atoi(c.Param("ruleId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule ID"})
+		return nil
+	}
+	r, err := scanRedactionRule(database.DB.QueryRow(`SELECT `+redactionRuleColumns+` FROM redaction_rules WHERE id = ?`, ruleID))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Redaction rule not found"})
+		return nil
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil
+	}
+	return r
+}
+
+// ListRedactionRules lists the redaction rules.
+// Query params: environmentId (optional) restricts the list to one environment.
+func ListRedactionRules(c *gin.Context) {
+	query := `SELECT ` + redactionRuleColumns + ` FROM redaction_rules`
+	params := []interface{}{}
+	if envIDStr := c.Query("environmentId"); envIDStr != "" {
+		envID, err := strconv.Atoi(envIDStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid environment ID"})
+			return
+		}
+		query += ` WHERE environment_id = ?`
+		params = append(params, envID)
+	}
+	query += ` ORDER BY environment_id, id`
+	rows, err := database.DB.Query(query, params...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	rules := []models.RedactionRule{}
+	for rows.Next() {
+		r, err := scanRedactionRule(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		rules = append(rules, *r)
+	}
+	c.JSON(http.StatusOK, gin.H{"rules": rules})
+}
+
+// CreateRedactionRule adds a redaction rule.
+// Body: { "environment_id": 1, "db_name": "shop_*", "collection": "customers",
+//
+//	"field": "cards.number", "value_regex": "", "style": "partial", "description": "..." }
+func CreateRedactionRule(c *gin.Context) {
+	var req redactionRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if msg := req.validate(); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+
+	res, err := database.DB.Exec(
+		`INSERT INTO redaction_rules (environment_id, db_name, collection, field, value_regex, style,
+		        description, created_by, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		req.EnvironmentID, req.DBName, req.Collection, req.Field, req.ValueRegex, req.Style,
+		req.Description, currentUser.ID, time.Now().UTC(),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	id, _ := res.LastInsertId()
+	r, err := scanRedactionRule(database.DB.QueryRow(`SELECT `+redactionRuleColumns+` FROM redaction_rules WHERE id = ?`, id))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"rule": r})
+}
+
+// GetRedactionRule returns a single redaction rule.
+func GetRedactionRule(c *gin.Context) {
+	r := getRedactionRuleParam(c)
+	if r == nil {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"rule": r})
+}
+
+// UpdateRedactionRule replaces a redaction rule. It takes the same body as CreateRedactionRule.
+func UpdateRedactionRule(c *gin.Context) {
+	r := getRedactionRuleParam(c)
+	if r == nil {
+		return
+	}
+	var req redactionRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if msg := req.validate(); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	_, err := database.DB.Exec(
+		`UPDATE redaction_rules SET environment_id = ?, db_name = ?, collection = ?, field = ?,
+		        value_regex = ?, style = ?, description = ?
+		 WHERE id = ?`,
+		req.EnvironmentID, req.DBName, req.Collection, req.Field, req.ValueRegex, req.Style, req.Description, r.ID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	r, err = scanRedactionRule(database.DB.QueryRow(`SELECT `+redactionRuleColumns+` FROM redaction_rules WHERE id = ?`, r.ID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"rule": r})
+}
+
+// DeleteRedactionRule deletes a redaction rule.
+func DeleteRedactionRule(c *gin.Context) {
+	r := getRedactionRuleParam(c)
+	if r == nil {
+		return
+	}
+	if _, err := database.DB.Exec(`DELETE FROM redaction_rules WHERE id = ?`, r.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Redaction rule deleted successfully"})
+}
+
+// ListUnmaskedGrants lists the unmasked grants.
+// Query params: userId or groupId (optional) restricts the list to one user or group.
+func ListUnmaskedGrants(c *gin.Context) {
+	query := `SELECT ` + unmaskedGrantColumns + ` FROM unmasked_grants`
+	params := []interface{}{}
+	for param, column := range map[string]string{"userId": "user_id", "groupId": "group_id"} {
+		if s := c.Query(param); s != "" {
+			id, err := strconv.Atoi(s)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param})
+				return
+			}
+			if len(params) == 0 {
+				query += ` WHERE `
+			} else {
+				query += ` AND `
+			}
+			query += column + ` = ?`
+			params = append(params, id)
+		}
+	}
+	query += ` ORDER BY id`
+	rows, err := database.DB.Query(query, params...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	grants := []models.UnmaskedGrant{}
+	for rows.Next() {
+		g, err := scanUnmaskedGrant(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		grants = append(grants, *g)
+	}
+	c.JSON(http.StatusOK, gin.H{"grants": grants})
+}
+
+// CreateUnmaskedGrant lets a user, or a group's members, see a database unmasked.
+// Only users who see data unmasked themselves (view_unmasked capability) can give it,
+// and never to themselves.
+// Body: { "user_id": 3 } or { "group_id": 2 }, with { "environment_id": 1, "db_name": "shop_*" }
+// db_name defaults to "*" (every database of the environment).
+func CreateUnmaskedGrant(c *gin.Context) {
+	var req struct {
+		UserID        int    `json:"user_id"`
+		GroupID       int    `json:"group_id"`
+		EnvironmentID int    `json:"environment_id" binding:"required"`
+		DBName        string `json:"db_name"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if (req.UserID == 0) == (req.GroupID == 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Provide either user_id or group_id"})
+		return
+	}
+	if req.DBName == "" {
+		req.DBName = "*"
+	}
+	if !middleware.ValidDBNamePattern(req.DBName) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid database name pattern"})
+		return
+	}
+	var id int
+	if err := database.DB.QueryRow(`SELECT id FROM environments WHERE id = ?`, req.EnvironmentID).Scan(&id); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Environment not found"})
+		return
+	}
+	if req.UserID != 0 {
+		if err := database.DB.QueryRow(`SELECT id FROM users WHERE id = ?`, req.UserID).Scan(&id); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "User not found"})
+			return
+		}
+	} else if err := database.DB.QueryRow(`SELECT id FROM groups WHERE id = ?`, req.GroupID).Scan(&id); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Group not found"})
+		return
+	}
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+	self := req.UserID == currentUser.ID
+	if req.GroupID != 0 {
+		member, err := isGroupMember(currentUser.ID, req.GroupID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		self = member
+	}
+	if self {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You cannot grant unmasked access to yourself"})
+		return
+	}
+	if !middleware.HasCapability(currentUser, models.CapViewUnmasked) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Giving unmasked grants requires the view_unmasked capability"})
+		return
+	}
+
+	res, err := database.DB.Exec(
+		`INSERT INTO unmasked_grants (user_id, group_id, environment_id, db_name, created_by, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?)`,
+		req.UserID, req.GroupID, req.EnvironmentID, req.DBName, currentUser.ID, time.Now().UTC(),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	grantID, _ := res.LastInsertId()
+	g, err := scanUnmaskedGrant(database.DB.QueryRow(`SELECT `+unmaskedGrantColumns+` FROM unmasked_grants WHERE id = ?`, grantID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"grant": g})
+}
+
+// DeleteUnmaskedGrant revokes an unmasked grant.
+func DeleteUnmaskedGrant(c *gin.Context) {
+	grantID, err := strconv.Atoi(c.Param("grantId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid grant ID"})
+		return
+	}
+	res, err := database.DB.Exec(`DELETE FROM unmasked_grants WHERE id = ?`, grantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unmasked grant not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Unmasked grant revoked"})
+}
+
+// hasRedactionRules returns true if redaction rules apply to one of the collections of a
+// database. An empty collections list stands for the whole database.
+func hasRedactionRules(envID int, dbName string, collections []string) (bool, error) {
+	rules, err := loadRedactionRules(envID, dbName, "")
+	if err != nil {
+		return false, err
+	}
+	for _, r := range rules {
+		if len(collections) == 0 {
+			return true, nil
+		}
+		for _, coll := range collections {
+			if ok, _ := path.Match(r.Collection, coll); ok {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// copyRedactionRules copies the rules applying to a collection so that they apply to its
+// new name too, scoped to its database: rules match collections by name, and a rename
+// must not leave the data unmasked. It returns the IDs of the copies, for undoing them
+// with deleteRedactionRules if the rename fails.
+func copyRedactionRules(envID int, dbName, oldName, newName string, userID int) ([]int64, error) {
+	rules, err := loadRedactionRules(envID, dbName, oldName)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	var ids []int64
+	now := time.Now().UTC()
+	for _, r := range rules {
+		if ok, _ := path.Match(r.Collection, newName); ok {
+			continue
+		}
+		res, err := tx.Exec(
+			`INSERT INTO redaction_rules (environment_id, db_name, collection, field, value_regex, style,
+			        description, created_by, created_at)
+			 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			envID, dbName, newName, r.Field, r.ValueRegex, r.Style,
+			"Copy of rule "+strconv.Itoa(r.ID)+" for "+oldName+" renamed to "+newName, userID, now,
+		)
+		if err != nil {
+			return nil, err
+		}
+		id, _ := res.LastInsertId()
+		ids = append(ids, id)
+	}
+	return ids, tx.Commit()
+}
+
+// deleteRedactionRules deletes rules by ID.
+func deleteRedactionRules(ids []int64) error {
+	for _, id := range ids {
+		if _, err := database.DB.Exec(`DELETE FROM redaction_rules WHERE id = ?`, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// canExportUnmasked reports whether the user may export collections: archives cannot be
+// redacted, so collections that redaction rules apply to need an unmasked grant.
+// An empty collections list stands for the whole database.
+func canExportUnmasked(user models.User, envID int, dbName string, collections []string) (bool, error) {
+	redacted, err := hasRedactionRules(envID, dbName, collections)
+	if err != nil || !redacted {
+		return err == nil, err
+	}
+	return middleware.CanViewUnmasked(user, envID, dbName)
+}
+
+// requireUnmaskedExport refuses an export of collections that redaction rules apply to
+// (see canExportUnmasked).
+// It writes the error response itself and returns false when the export is refused.
+func requireUnmaskedExport(c *gin.Context, user models.User, envID int, dbName string, collections []string) bool {
+	allowed, err := canExportUnmasked(user, envID, dbName, collections)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This data has redacted fields; exporting it requires an unmasked grant"})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,261 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"monji/internal/database"
+	"monji/internal/middleware"
+	"monji/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+const roleColumns = `name, description, capabilities, built_in, created_at`
+
+func scanRole(s interface{ Scan(...interface{}) error }) (*models.Role, error) {
+	var r models.Role
+	var capsJSON string
+	if err := s.Scan(&r.Name, &r.Description, &capsJSON, &r.BuiltIn, &r.CreatedAt); err != nil {
+		return nil, err
+	}
+	r.Capabilities = []string{}
+	if err := json.Unmarshal([]byte(capsJSON), &r.Capabilities); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// loadRole loads a role by name; it returns sql.ErrNoRows for an unknown role.
+func loadRole(name string) (*models.Role, error) {
+	return scanRole(database.DB.QueryRow(`SELECT `+roleColumns+` FROM roles WHERE name = ?`, name))
+}
+
+// loadUserRole loads the role of an existing user. A role that no longer exists
+// is returned without capabilities.
+func loadUserRole(name string) (*models.Role, error) {
+	r, err := loadRole(name)
+	if err == sql.ErrNoRows {
+		return &models.Role{Name: name, Capabilities: []string{}}, nil
+	}
+	return r, err
+}
+
+// canHandleRole returns true if the user may assign a role, edit it, or manage the users
+// holding it: the superadmin role is reserved to superadmins, and other roles must not
+// hold capabilities that the user lacks, so that nobody can escalate their own privileges.
+func canHandleRole(user models.User, role *models.Role) bool {
+	if middleware.IsSuperAdmin(user) {
+		return true
+	}
+	if role.Name == models.RoleSuperAdmin {
+		return false
+	}
+	for _, c := range role.Capabilities {
+		if !middleware.HasCapability(user, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// validateCapabilities checks capability names and removes duplicates.
+func validateCapabilities(caps []string) ([]string, bool) {
+	out := []string{}
+	seen := map[string]bool{}
+	for _, c := range caps {
+		known := false
+		for _, k := range models.AllCapabilities {
+			if c == k {
+				known = true
+			}
+		}
+		if !known {
+			return nil, false
+		}
+		if !seen[c] {
+			seen[c] = true
+			out = append(out, c)
+		}
+	}
+	return out, true
+}
+
+// getRoleParam loads the role named by the :name route parameter.
+// It writes the error response itself and returns nil on failure.
+func getRoleParam(c *gin.Context) *models.Role {
+	r, err := loadRole(c.Param("name"))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+		return nil
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil
+	}
+	return r
+}
+
+// ListRoles lists every role, along with the capabilities a role can be composed of.
+func ListRoles(c *gin.Context) {
+	rows, err := database.DB.Query(`SELECT ` + roleColumns + ` FROM roles ORDER BY built_in DESC, name`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	roles := []models.Role{}
+	for rows.Next() {
+		r, err := scanRole(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		roles = append(roles, *r)
+	}
+	c.JSON(http.StatusOK, gin.H{"roles": roles, "capabilities": models.AllCapabilities})
+}
+
+// GetRole returns a single role.
+func GetRole(c *gin.Context) {
+	r := getRoleParam(c)
+	if r == nil {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"role": r})
+}
+
+// CreateRole creates a custom role. The caller can only grant capabilities they have.
+// Body: { "name": "support", "description": "...", "capabilities": ["manage_mongo_users", "view_audit_log"] }
+func CreateRole(c *gin.Context) {
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+
+	var req struct {
+		Name         string   `json:"name" binding:"required"`
+		Description  string   `json:"description"`
+		Capabilities []string `json:"capabilities"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Role name is required"})
+		return
+	}
+	caps, ok := validateCapabilities(req.Capabilities)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown capability", "capabilities": models.AllCapabilities})
+		return
+	}
+	role := &models.Role{Name: req.Name, Description: req.Description, Capabilities: caps, CreatedAt: time.Now().UTC()}
+	if !canHandleRole(currentUser, role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You cannot grant capabilities that you do not have"})
+		return
+	}
+
+	capsJSON, _ := json.Marshal(caps)
+	_, err := database.DB.Exec(
+		`INSERT INTO roles (name, description, capabilities, built_in, created_at) VALUES (?, ?, ?, 0, ?)`,
+		role.Name, role.Description, string(capsJSON), role.CreatedAt,
+	)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			c.JSON(http.StatusConflict, gin.H{"error": "A role with this name already exists"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"role": role})
+}
+
+// UpdateRole changes the description or the capabilities of a custom role.
+// Body: { "description": "...", "capabilities": [...] } (both optional)
+func UpdateRole(c *gin.Context) {
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+	r := getRoleParam(c)
+	if r == nil {
+		return
+	}
+	if r.BuiltIn {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Built-in roles cannot be changed"})
+		return
+	}
+	if !canHandleRole(currentUser, r) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This role has capabilities that you do not have"})
+		return
+	}
+
+	var req struct {
+		Description  *string   `json:"description"`
+		Capabilities *[]string `json:"capabilities"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Description != nil {
+		r.Description = *req.Description
+	}
+	if req.Capabilities != nil {
+		caps, ok := validateCapabilities(*req.Capabilities)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown capability", "capabilities": models.AllCapabilities})
+			return
+		}
+		r.Capabilities = caps
+		if !canHandleRole(currentUser, r) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You cannot grant capabilities that you do not have"})
+			return
+		}
+	}
+
+	capsJSON, _ := json.Marshal(r.Capabilities)
+	if _, err := database.DB.Exec(
+		`UPDATE roles SET description = ?, capabilities = ? WHERE name = ?`, r.Description, string(capsJSON), r.Name,
+	); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"role": r})
+}
+
+// DeleteRole deletes a custom role that no user holds.
+func DeleteRole(c *gin.Context) {
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+	r := getRoleParam(c)
+	if r == nil {
+		return
+	}
+	if r.BuiltIn {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Built-in roles cannot be deleted"})
+		return
+	}
+	if !canHandleRole(currentUser, r) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This role has capabilities that you do not have"})
+		return
+	}
+	var users int
+	if err := database.DB.QueryRow(`SELECT COUNT(*) FROM users WHERE role = ?`, r.Name).Scan(&users); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if users > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Role is assigned to users", "users": users})
+		return
+	}
+	if _, err := database.DB.Exec(`DELETE FROM roles WHERE name = ?`, r.Name); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Role deleted successfully"})
+}
@@ -0,0 +1,546 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"monji/internal/database"
+	"monji/internal/middleware"
+	"monji/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const savedQueryColumns = `id, name, description, environment_id, db_name, collection_name, filter, sort,
+	projection, pipeline, visibility, group_id, owner_id, created_at, updated_at`
+
+const (
+	defaultSavedQueryLimit = 100
+	maxSavedQueryLimit     = 1000
+)
+
+// savedQueryRequest is the body of the create and update endpoints.
+type savedQueryRequest struct {
+	Name           string          `json:"name"`
+	Description    string          `json:"description"`
+	EnvironmentID  int             `json:"environmentId"`
+	DbName         string          `json:"dbName"`
+	CollectionName string          `json:"collectionName"`
+	Filter         json.RawMessage `json:"filter"`
+	Sort           json.RawMessage `json:"sort"`
+	Projection     json.RawMessage `json:"projection"`
+	Pipeline       json.RawMessage `json:"pipeline"`
+	Visibility     string          `json:"visibility"`
+	GroupID        int             `json:"groupId"`
+}
+
+func scanSavedQuery(s interface{ Scan(...interface{}) error }) (*models.SavedQuery, error) {
+	var q models.SavedQuery
+	var filter, sort, projection, pipeline string
+	err := s.Scan(&q.ID, &q.Name, &q.Description, &q.EnvironmentID, &q.DBName, &q.CollectionName, &filter, &sort,
+		&projection, &pipeline, &q.Visibility, &q.GroupID, &q.OwnerID, &q.CreatedAt, &q.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range []struct {
+		value string
+		field *json.RawMessage
+	}{{filter, &q.Filter}, {sort, &q.Sort}, {projection, &q.Projection}, {pipeline, &q.Pipeline}} {
+		if f.value != "" {
+			*f.field = json.RawMessage(f.value)
+		}
+	}
+	return &q, nil
+}
+
+// canSeeSavedQuery returns true if the query is the user's, global, or shared with one of
+// their groups.
+func canSeeSavedQuery(user models.User, q *models.SavedQuery) (bool, error) {
+	switch q.Visibility {
+	case models.QueryGlobal:
+		return true, nil
+	case models.QueryTeam:
+		if q.OwnerID == user.ID {
+			return true, nil
+		}
+		return isGroupMember(user.ID, q.GroupID)
+	default:
+		return q.OwnerID == user.ID, nil
+	}
+}
+
+// canEditSavedQuery returns true if the user may update or delete the query: its owner,
+// or an admin for a shared query.
+func canEditSavedQuery(user models.User, q *models.SavedQuery) bool {
+	return q.OwnerID == user.ID || (q.Visibility != models.QueryPrivate && middleware.IsAdmin(user))
+}
+
+// optionalDocument returns nil for an omitted or null extended JSON field.
+func optionalDocument(raw json.RawMessage) json.RawMessage {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	return raw
+}
+
+// savedQueryFilterable reports whether the filter and sort of a query only read fields
+// that redact does not mask, so that which documents it returns, and in which order,
+// reveals nothing of the masked values.
+func savedQueryFilterable(redact *redactor, filter, sort bson.D) bool {
+	if !redact.Filterable(filter, nil) {
+		return false
+	}
+	for _, e := range sort {
+		if redact.MasksPath(strings.Split(e.Key, ".")) {
+			return false
+		}
+	}
+	return true
+}
+
+// validateSavedQuery fills in defaults and checks a saved query request.
+// It returns a client error message, or "" if the request is valid.
+func validateSavedQuery(req *savedQueryRequest, user models.User) string {
+	if req.Name == "" {
+		return "name is required"
+	}
+	if req.DbName == "" || req.CollectionName == "" {
+		return "Both dbName and collectionName are required"
+	}
+	if err := checkVisibleCollections(req.CollectionName); err != nil {
+		return err.Error()
+	}
+	var exists int
+	if err := database.DB.QueryRow(`SELECT COUNT(*) FROM environments WHERE id = ?`, req.EnvironmentID).Scan(&exists); err != nil || exists == 0 {
+		return "Environment not found"
+	}
+
+	req.Filter = optionalDocument(req.Filter)
+	req.Sort = optionalDocument(req.Sort)
+	req.Projection = optionalDocument(req.Projection)
+	req.Pipeline = optionalDocument(req.Pipeline)
+	if req.Pipeline != nil {
+		if req.Filter != nil || req.Sort != nil || req.Projection != nil {
+			return "A query has either a pipeline or a filter, sort and projection"
+		}
+		pipeline, err := parsePipeline(req.Pipeline)
+		if err != nil {
+			return err.Error()
+		}
+		if err := checkVisibleCollections(pipelineSources(pipeline)...); err != nil {
+			return err.Error()
+		}
+	}
+	var filter, sort bson.D
+	for _, f := range []struct {
+		name string
+		raw  json.RawMessage
+	}{{"filter", req.Filter}, {"sort", req.Sort}, {"projection", req.Projection}} {
+		if f.raw == nil {
+			continue
+		}
+		var doc bson.D
+		if err := bson.UnmarshalExtJSON(f.raw, false, &doc); err != nil {
+			return "Invalid " + f.name + ": " + err.Error()
+		}
+		switch f.name {
+		case "filter":
+			filter = doc
+			continue
+		case "sort":
+			sort = doc
+			continue
+		}
+		// Projection expressions could copy a redacted field under another name.
+		for _, e := range doc {
+			switch e.Value.(type) {
+			case int32, int64, float64, bool:
+			default:
+				return "projection values must be 0 or 1"
+			}
+		}
+	}
+	redact, err := documentRedactor(user, req.EnvironmentID, req.DbName, req.CollectionName)
+	if err != nil {
+		return err.Error()
+	}
+	if !savedQueryFilterable(redact, filter, sort) {
+		return "The filter and sort cannot use redacted fields without an unmasked grant"
+	}
+
+	switch req.Visibility {
+	case "":
+		req.Visibility = models.QueryPrivate
+		fallthrough
+	case models.QueryPrivate, models.QueryGlobal:
+		req.GroupID = 0
+	case models.QueryTeam:
+		if req.GroupID == 0 {
+			return "groupId is required for a team query"
+		}
+		member, err := isGroupMember(user.ID, req.GroupID)
+		if err != nil || (!member && !middleware.IsAdmin(user)) {
+			return "You can only share a query with a group you belong to"
+		}
+	default:
+		return "visibility must be 'private', 'team' or 'global'"
+	}
+	return ""
+}
+
+// getSavedQueryParam loads the saved query named by the :queryId route parameter, if the
+// user can see it.
+// It writes the error response itself and returns nil on failure.
+func getSavedQueryParam(c *gin.Context, user models.User) *models.SavedQuery {
+	queryID, err := strconv.Atoi(c.Param("queryId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query ID"})
+		return nil
+	}
+	q, err := scanSavedQuery(database.DB.QueryRow(`SELECT `+savedQueryColumns+` FROM saved_queries WHERE id = ?`, queryID))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Saved query not found"})
+		return nil
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil
+	}
+	visible, err := canSeeSavedQuery(user, q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil
+	}
+	if !visible {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Saved query not found"})
+		return nil
+	}
+	return q
+}
+
+// ListSavedQueries returns the queries the user can see: their own, those shared with
+// their groups, and global ones.
+// Query params: environmentId, dbName, collection (optional).
+func ListSavedQueries(c *gin.Context) {
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+	query := `SELECT ` + savedQueryColumns + ` FROM saved_queries
+		WHERE (owner_id = ? OR visibility = ?
+		       OR (visibility = ? AND group_id IN (SELECT group_id FROM group_members WHERE user_id = ?)))`
+	params := []interface{}{currentUser.ID, models.QueryGlobal, models.QueryTeam, currentUser.ID}
+	if envIDStr := c.Query("environmentId"); envIDStr != "" {
+		envID, err := strconv.Atoi(envIDStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid environment ID"})
+			return
+		}
+		query += ` AND environment_id = ?`
+		params = append(params, envID)
+	}
+	if dbName := c.Query("dbName"); dbName != "" {
+		query += ` AND db_name = ?`
+		params = append(params, dbName)
+	}
+	if collName := c.Query("collection"); collName != "" {
+		query += ` AND collection_name = ?`
+		params = append(params, collName)
+	}
+	query += ` ORDER BY name, id`
+	rows, err := database.DB.Query(query, params...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	queries := []models.SavedQuery{}
+	for rows.Next() {
+		q, err := scanSavedQuery(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		queries = append(queries, *q)
+	}
+	c.JSON(http.StatusOK, gin.H{"queries": queries})
+}
+
+// CreateSavedQuery saves a query owned by the user, who must be able to read its collection.
+// Body: { "name": "unpaid orders", "description": "", "environmentId": 1, "dbName": "shop",
+// "collectionName": "orders", "filter": {"status": "unpaid"}, "sort": {"createdAt": -1},
+// "projection": {"items": 0}, "visibility": "team", "groupId": 2 }
+// A "pipeline" can be given instead of filter, sort and projection.
+func CreateSavedQuery(c *gin.Context) {
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+	var req savedQueryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if msg := validateSavedQuery(&req, currentUser); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	hasDBRead, err := middleware.HasDBPermission(currentUser, req.EnvironmentID, req.DbName, "read")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !hasDBRead {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No permission to read this database"})
+		return
+	}
+
+	now := time.Now().UTC()
+	res, err := database.DB.Exec(
+		`INSERT INTO saved_queries (name, description, environment_id, db_name, collection_name, filter, sort,
+		 projection, pipeline, visibility, group_id, owner_id, created_at, updated_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		req.Name, req.Description, req.EnvironmentID, req.DbName, req.CollectionName, string(req.Filter),
+		string(req.Sort), string(req.Projection), string(req.Pipeline), req.Visibility, req.GroupID,
+		currentUser.ID, now, now,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save query: " + err.Error()})
+		return
+	}
+	id, _ := res.LastInsertId()
+	q, err := scanSavedQuery(database.DB.QueryRow(`SELECT `+savedQueryColumns+` FROM saved_queries WHERE id = ?`, id))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"query": q})
+}
+
+// GetSavedQuery returns a saved query the user can see.
+func GetSavedQuery(c *gin.Context) {
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+	q := getSavedQueryParam(c, currentUser)
+	if q == nil {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"query": q, "editable": canEditSavedQuery(currentUser, q)})
+}
+
+// UpdateSavedQuery replaces a saved query. Only its owner, or an admin for a shared query,
+// can update it. The body has the same fields as CreateSavedQuery.
+func UpdateSavedQuery(c *gin.Context) {
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+	q := getSavedQueryParam(c, currentUser)
+	if q == nil {
+		return
+	}
+	if !canEditSavedQuery(currentUser, q) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the owner of this query can change it"})
+		return
+	}
+	var req savedQueryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if msg := validateSavedQuery(&req, currentUser); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	hasDBRead, err := middleware.HasDBPermission(currentUser, req.EnvironmentID, req.DbName, "read")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !hasDBRead {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No permission to read this database"})
+		return
+	}
+
+	_, err = database.DB.Exec(
+		`UPDATE saved_queries SET name = ?, description = ?, environment_id = ?, db_name = ?, collection_name = ?,
+		 filter = ?, sort = ?, projection = ?, pipeline = ?, visibility = ?, group_id = ?, updated_at = ?
+		 WHERE id = ?`,
+		req.Name, req.Description, req.EnvironmentID, req.DbName, req.CollectionName, string(req.Filter),
+		string(req.Sort), string(req.Projection), string(req.Pipeline), req.Visibility, req.GroupID,
+		time.Now().UTC(), q.ID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update query: " + err.Error()})
+		return
+	}
+	q, err = scanSavedQuery(database.DB.QueryRow(`SELECT `+savedQueryColumns+` FROM saved_queries WHERE id = ?`, q.ID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"query": q})
+}
+
+// DeleteSavedQuery deletes a saved query. Only its owner, or an admin for a shared query,
+// can delete it.
+func DeleteSavedQuery(c *gin.Context) {
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+	q := getSavedQueryParam(c, currentUser)
+	if q == nil {
+		return
+	}
+	if !canEditSavedQuery(currentUser, q) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the owner of this query can delete it"})
+		return
+	}
+	if _, err := database.DB.Exec(`DELETE FROM saved_queries WHERE id = ?`, q.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Saved query deleted"})
+}
+
+// RunSavedQuery runs a saved query with the caller's own permissions: they must be able to
+// read its collection now, whoever saved it. Documents are masked like GetDocuments does.
+// A pipeline reading collections with redacted fields, directly or through views, requires
+// an unmasked grant, since its stages can reshape fields beyond the reach of the rules.
+// Query params: limit (default 100, at most 1000), skip (find queries only).
+func RunSavedQuery(c *gin.Context) {
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+	q := getSavedQueryParam(c, currentUser)
+	if q == nil {
+		return
+	}
+	hasDBRead, err := middleware.HasDBPermission(currentUser, q.EnvironmentID, q.DBName, "read")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !hasDBRead {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No permission to read this database"})
+		return
+	}
+	limit, skip := int64(defaultSavedQueryLimit), int64(0)
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 1 || n > maxSavedQueryLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and " + strconv.Itoa(maxSavedQueryLimit)})
+			return
+		}
+		limit = n
+	}
+	if v := c.Query("skip"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "skip must be a non-negative integer"})
+			return
+		}
+		skip = n
+	}
+
+	var pipeline bson.A
+	if q.Pipeline != nil {
+		if pipeline, err = parsePipeline(q.Pipeline); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	var filter, sort, projection bson.D
+	for _, f := range []struct {
+		raw json.RawMessage
+		doc *bson.D
+	}{{q.Filter, &filter}, {q.Sort, &sort}, {q.Projection, &projection}} {
+		if f.raw == nil {
+			continue
+		}
+		if err := bson.UnmarshalExtJSON(f.raw, false, f.doc); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid saved query: " + err.Error()})
+			return
+		}
+	}
+	if filter == nil {
+		filter = bson.D{}
+	}
+	redact, err := documentRedactor(currentUser, q.EnvironmentID, q.DBName, q.CollectionName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	// The query may have been saved by someone who reads the collection unmasked.
+	if !savedQueryFilterable(redact, filter, sort) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This query filters or sorts on redacted fields; running it requires an unmasked grant"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	client, err := connectEnvironment(ctx, q.EnvironmentID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to MongoDB: " + err.Error()})
+		return
+	}
+	defer client.Disconnect(ctx)
+
+	db := client.Database(q.DBName)
+	if pipeline != nil {
+		// Views among the sources are followed down to the collections they read.
+		sources, err := viewBaseCollections(ctx, db, append(pipelineSources(pipeline), q.CollectionName))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read collection options: " + err.Error()})
+			return
+		}
+		redacted, err := hasRedactionRules(q.EnvironmentID, q.DBName, sources)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if redacted {
+			unmasked, err := middleware.CanViewUnmasked(currentUser, q.EnvironmentID, q.DBName)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			if !unmasked {
+				c.JSON(http.StatusForbidden, gin.H{"error": "This pipeline reads collections with redacted fields; running it requires an unmasked grant"})
+				return
+			}
+		}
+	} else if !requireReadableCollection(ctx, c, currentUser, q.EnvironmentID, db, q.CollectionName) {
+		return
+	}
+	coll := db.Collection(q.CollectionName)
+	var cursor *mongo.Cursor
+	if pipeline != nil {
+		pipeline = append(pipeline, bson.D{{Key: "$limit", Value: limit}})
+		cursor, err = coll.Aggregate(ctx, pipeline)
+	} else {
+		opts := options.Find().SetSkip(skip).SetLimit(limit)
+		if sort != nil {
+			opts.SetSort(sort)
+		}
+		if projection != nil {
+			opts.SetProjection(projection)
+		}
+		cursor, err = coll.Find(ctx, filter, opts)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to run query: " + err.Error()})
+		return
+	}
+	documents := []bson.M{}
+	if err := cursor.All(ctx, &documents); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode documents: " + err.Error()})
+		return
+	}
+	redact.Documents(documents)
+	c.JSON(http.StatusOK, gin.H{
+		"query":     q,
+		"documents": documents,
+	})
+}
@@ -0,0 +1,358 @@
+package handlers
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"monji/internal/middleware"
+	"monji/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	defaultSchemaSampleSize = 1000
+	maxSchemaSampleSize     = 10000
+	// kmvSize is the number of hashes kept to estimate the cardinality of a field.
+	kmvSize = 256
+	// maxSchemaExamples is the number of distinct example values reported per field.
+	maxSchemaExamples = 3
+	// maxExampleLength truncates long example strings.
+	maxExampleLength = 100
+)
+
+// arrayElementSuffix marks the path of array elements: "tags[]" are the elements of "tags",
+// "items[].price" the price of the documents in "items".
+const arrayElementSuffix = "[]"
+
+// bsonTypeNames are the $type aliases of BSON types.
+var bsonTypeNames = map[bsontype.Type]string{
+	bsontype.Double:           "double",
+	bsontype.String:           "string",
+	bsontype.EmbeddedDocument: "object",
+	bsontype.Array:            "array",
+	bsontype.Binary:           "binData",
+	bsontype.Undefined:        "undefined",
+	bsontype.ObjectID:         "objectId",
+	bsontype.Boolean:          "bool",
+	bsontype.DateTime:         "date",
+	bsontype.Null:             "null",
+	bsontype.Regex:            "regex",
+	bsontype.DBPointer:        "dbPointer",
+	bsontype.JavaScript:       "javascript",
+	bsontype.Symbol:           "symbol",
+	bsontype.CodeWithScope:    "javascriptWithScope",
+	bsontype.Int32:            "int",
+	bsontype.Timestamp:        "timestamp",
+	bsontype.Int64:            "long",
+	bsontype.Decimal128:       "decimal",
+	bsontype.MinKey:           "minKey",
+	bsontype.MaxKey:           "maxKey",
+}
+
+// kmv estimates the number of distinct values of a field with the k minimum values of
+// their 64-bit hashes: with k hashes, the kth smallest h estimates (k-1) / (h / 2^64).
+type kmv struct {
+	hashes []uint64 // sorted, distinct
+}
+
+func (s *kmv) add(h uint64) {
+	i := sort.Search(len(s.hashes), func(i int) bool { return s.hashes[i] >= h })
+	if i < len(s.hashes) && s.hashes[i] == h {
+		return
+	}
+	if len(s.hashes) == kmvSize {
+		if i == kmvSize {
+			return
+		}
+		s.hashes = s.hashes[:kmvSize-1]
+	}
+	s.hashes = append(s.hashes, 0)
+	copy(s.hashes[i+1:], s.hashes[i:])
+	s.hashes[i] = h
+}
+
+func (s *kmv) estimate() int64 {
+	if len(s.hashes) < kmvSize {
+		return int64(len(s.hashes)) // every distinct value was kept
+	}
+	kth := float64(s.hashes[kmvSize-1]) / math.Pow(2, 64)
+	return int64(float64(kmvSize-1) / kth)
+}
+
+// fieldStats is what the schema analysis reports about one field path.
+type fieldStats struct {
+	Path        string         `json:"path"`
+	Presence    float64        `json:"presence"` // percentage of sampled documents having the field
+	Count       int            `json:"count"`    // values seen, array elements included
+	Types       map[string]int `json:"types"`
+	Cardinality int64          `json:"cardinality"` // estimated number of distinct values
+	Min         interface{}    `json:"min,omitempty"`
+	Max         interface{}    `json:"max,omitempty"`
+	MinDate     interface{}    `json:"minDate,omitempty"`
+	MaxDate     interface{}    `json:"maxDate,omitempty"`
+	Examples    []interface{}  `json:"examples"`
+
+	docs          int // sampled documents having the field
+	lastDoc       int // index of the last document counted in docs
+	distinct      kmv
+	minNum        float64
+	maxNum        float64
+	hasNum        bool
+	minDate       int64
+	maxDate       int64
+	hasDate       bool
+	exampleSeen   map[uint64]bool
+	exampleValues []bson.RawValue
+}
+
+// schemaAnalysis accumulates the field statistics of sampled documents.
+type schemaAnalysis struct {
+	sampled int
+	fields  map[string]*fieldStats
+}
+
+func newSchemaAnalysis() *schemaAnalysis {
+	return &schemaAnalysis{fields: map[string]*fieldStats{}}
+}
+
+func (a *schemaAnalysis) addDocument(doc bson.Raw) error {
+	a.sampled++
+	return a.addFields("", doc)
+}
+
+func (a *schemaAnalysis) addFields(prefix string, doc bson.Raw) error {
+	elems, err := doc.Elements()
+	if err != nil {
+		return err
+	}
+	for _, e := range elems {
+		if err := a.addValue(prefix+e.Key(), e.Value()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *schemaAnalysis) addValue(path string, v bson.RawValue) error {
+	f := a.fields[path]
+	if f == nil {
+		f = &fieldStats{Path: path, Types: map[string]int{}, exampleSeen: map[uint64]bool{}}
+		a.fields[path] = f
+	}
+	f.Count++
+	if f.lastDoc != a.sampled {
+		f.docs++
+		f.lastDoc = a.sampled
+	}
+	typeName, ok := bsonTypeNames[v.Type]
+	if !ok {
+		typeName = v.Type.String()
+	}
+	f.Types[typeName]++
+
+	h := fnv.New64a()
+	h.Write([]byte{byte(v.Type)})
+	h.Write(v.Value)
+	sum := h.Sum64()
+	f.distinct.add(sum)
+
+	switch v.Type {
+	case bsontype.Double, bsontype.Int32, bsontype.Int64:
+		var n float64
+		switch v.Type {
+		case bsontype.Double:
+			n = v.Double()
+		case bsontype.Int32:
+			n = float64(v.Int32())
+		default:
+			n = float64(v.Int64())
+		}
+		if !f.hasNum || n < f.minNum {
+			f.minNum = n
+		}
+		if !f.hasNum || n > f.maxNum {
+			f.maxNum = n
+		}
+		f.hasNum = true
+	case bsontype.DateTime:
+		d := v.DateTime()
+		if !f.hasDate || d < f.minDate {
+			f.minDate = d
+		}
+		if !f.hasDate || d > f.maxDate {
+			f.maxDate = d
+		}
+		f.hasDate = true
+	case bsontype.EmbeddedDocument:
+		return a.addFields(path+".", v.Document())
+	case bsontype.Array:
+		values, err := v.Array().Values()
+		if err != nil {
+			return err
+		}
+		for _, elem := range values {
+			if err := a.addValue(path+arrayElementSuffix, elem); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if len(f.exampleValues) < maxSchemaExamples && !f.exampleSeen[sum] {
+		f.exampleSeen[sum] = true
+		f.exampleValues = append(f.exampleValues, v)
+	}
+	return nil
+}
+
+// results returns the statistics of every field, sorted by path, with example and
+// min/max values masked by redact. Fields inside a redacted field or array are masked
+// like it (see matchFieldPath).
+func (a *schemaAnalysis) results(redact *redactor) []fieldStats {
+	fields := make([]fieldStats, 0, len(a.fields))
+	for _, f := range a.fields {
+		if a.sampled > 0 {
+			f.Presence = math.Round(float64(f.docs)*10000/float64(a.sampled)) / 100
+		}
+		f.Cardinality = f.distinct.estimate()
+		segments := strings.Split(strings.ReplaceAll(f.Path, arrayElementSuffix, ""), ".")
+		mask := func(v interface{}) interface{} {
+			if redact == nil {
+				return v
+			}
+			masked, removed := redact.value(segments, v)
+			if removed {
+				return nil
+			}
+			return masked
+		}
+		if f.hasNum {
+			f.Min, f.Max = mask(f.minNum), mask(f.maxNum)
+		}
+		if f.hasDate {
+			f.MinDate = mask(time.UnixMilli(f.minDate).UTC())
+			f.MaxDate = mask(time.UnixMilli(f.maxDate).UTC())
+		}
+		f.Examples = []interface{}{}
+		for _, raw := range f.exampleValues {
+			var v interface{}
+			if err := raw.Unmarshal(&v); err != nil {
+				continue
+			}
+			// Masked before truncating, for value rules to see the whole string.
+			if v = mask(v); v == nil {
+				continue
+			}
+			if s, ok := v.(string); ok && len([]rune(s)) > maxExampleLength {
+				v = string([]rune(s)[:maxExampleLength]) + "…"
+			}
+			f.Examples = append(f.Examples, v)
+		}
+		fields = append(fields, *f)
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Path < fields[j].Path })
+	return fields
+}
+
+// inferSchema analyses a random sample of a collection's documents.
+func inferSchema(ctx context.Context, coll *mongo.Collection, sampleSize int) (*schemaAnalysis, error) {
+	cursor, err := coll.Aggregate(ctx, mongo.Pipeline{{{Key: "$sample", Value: bson.M{"size": sampleSize}}}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	a := newSchemaAnalysis()
+	for cursor.Next(ctx) {
+		if err := a.addDocument(cursor.Current); err != nil {
+			return nil, err
+		}
+	}
+	return a, cursor.Err()
+}
+
+// parseSampleSize reads the sampleSize query param.
+// It writes the error response itself and returns 0 on failure.
+func parseSampleSize(c *gin.Context) int {
+	sampleSize := defaultSchemaSampleSize
+	if v := c.Query("sampleSize"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxSchemaSampleSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "sampleSize must be between 1 and " + strconv.Itoa(maxSchemaSampleSize)})
+			return 0
+		}
+		sampleSize = n
+	}
+	return sampleSize
+}
+
+// GetCollectionSchema infers the shape of a collection from a random sample of its documents
+// ($sample). Each field path is reported with its type distribution, presence percentage,
+// estimated cardinality, min/max for numbers and dates, and a few example values (masked
+// like documents are, see redaction rules). Embedded document fields use dot notation and
+// array elements a "[]" suffix: "items[].price".
+// Query params: sampleSize (default 1000, at most 10000).
+func GetCollectionSchema(c *gin.Context) {
+	envID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid environment ID"})
+		return
+	}
+	dbName := c.Param("dbName")
+	collName := c.Param("collName")
+	if !requireVisibleCollections(c, collName) {
+		return
+	}
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+	hasDBRead, err := middleware.HasDBPermission(currentUser, envID, dbName, "read")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !hasDBRead {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No permission to read this collection"})
+		return
+	}
+	sampleSize := parseSampleSize(c)
+	if sampleSize == 0 {
+		return
+	}
+	redact, err := documentRedactor(currentUser, envID, dbName, collName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	client, err := connectEnvironment(ctx, envID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to MongoDB: " + err.Error()})
+		return
+	}
+	defer client.Disconnect(ctx)
+	if !requireReadableCollection(ctx, c, currentUser, envID, client.Database(dbName), collName) {
+		return
+	}
+
+	analysis, err := inferSchema(ctx, client.Database(dbName).Collection(collName), sampleSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to analyse documents: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"database":   dbName,
+		"collection": collName,
+		"sampleSize": analysis.sampled,
+		"fields":     analysis.results(redact),
+	})
+}
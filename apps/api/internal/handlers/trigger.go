@@ -0,0 +1,464 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"monji/internal/database"
+	"monji/internal/middleware"
+	"monji/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const triggerColumns = `id, name, environment_id, db_name, collection, operation_types, filter, url, enabled,
+	last_error, last_event_at, created_by, created_at, updated_at`
+
+const triggerDeliveryColumns = `id, trigger_id, operation_type, status, attempts, response_status, error,
+	payload, created_at, next_attempt_at, delivered_at`
+
+// triggerOperationTypes are the change events a trigger can be notified of.
+var triggerOperationTypes = []string{"insert", "update", "replace", "delete"}
+
+// triggerRequest is the body of the create and update endpoints.
+type triggerRequest struct {
+	Name           string          `json:"name"`
+	EnvironmentID  int             `json:"environmentId"`
+	DbName         string          `json:"dbName"`
+	Collection     string          `json:"collection"`
+	OperationTypes []string        `json:"operationTypes"`
+	Filter         json.RawMessage `json:"filter"`
+	URL            string          `json:"url"`
+	Secret         string          `json:"secret"`
+	Enabled        *bool           `json:"enabled"`
+}
+
+func scanTrigger(s interface{ Scan(...interface{}) error }) (*models.Trigger, error) {
+	var t models.Trigger
+	var operationTypes, filter string
+	var lastEventAt sql.NullTime
+	err := s.Scan(&t.ID, &t.Name, &t.EnvironmentID, &t.DBName, &t.Collection, &operationTypes, &filter, &t.URL,
+		&t.Enabled, &t.LastError, &lastEventAt, &t.CreatedBy, &t.CreatedAt, &t.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(operationTypes), &t.OperationTypes); err != nil {
+		return nil, fmt.Errorf("invalid operation types of trigger %d: %w", t.ID, err)
+	}
+	if filter != "" {
+		t.Filter = json.RawMessage(filter)
+	}
+	if lastEventAt.Valid {
+		t.LastEventAt = &lastEventAt.Time
+	}
+	return &t, nil
+}
+
+func loadTrigger(id int) (*models.Trigger, error) {
+	return scanTrigger(database.DB.QueryRow(`SELECT `+triggerColumns+` FROM triggers WHERE id = ?`, id))
+}
+
+func scanTriggerDelivery(s interface{ Scan(...interface{}) error }) (*models.TriggerDelivery, error) {
+	var d models.TriggerDelivery
+	var payload string
+	var nextAttemptAt, deliveredAt sql.NullTime
+	err := s.Scan(&d.ID, &d.TriggerID, &d.OperationType, &d.Status, &d.Attempts, &d.ResponseStatus, &d.Error,
+		&payload, &d.CreatedAt, &nextAttemptAt, &deliveredAt)
+	if err != nil {
+		return nil, err
+	}
+	d.Payload = json.RawMessage(payload)
+	if nextAttemptAt.Valid {
+		d.NextAttemptAt = &nextAttemptAt.Time
+	}
+	if deliveredAt.Valid {
+		d.DeliveredAt = &deliveredAt.Time
+	}
+	return &d, nil
+}
+
+// validateTrigger fills in defaults and checks a trigger request.
+// It returns a client error message, or "" if the request is valid.
+func validateTrigger(req *triggerRequest) string {
+	if req.Name == "" {
+		return "name is required"
+	}
+	if req.DbName == "" {
+		return "dbName is required"
+	}
+	if err := checkVisibleCollections(req.Collection); err != nil {
+		return err.Error()
+	}
+	var exists int
+	if err := database.DB.QueryRow(`SELECT COUNT(*) FROM environments WHERE id = ?`, req.EnvironmentID).Scan(&exists); err != nil || exists == 0 {
+		return "Environment not found"
+	}
+	if len(req.OperationTypes) == 0 {
+		req.OperationTypes = triggerOperationTypes
+	}
+	for _, op := range req.OperationTypes {
+		if !containsString(triggerOperationTypes, op) {
+			return "operationTypes must be among 'insert', 'update', 'replace' and 'delete'"
+		}
+	}
+	if len(req.Filter) > 0 && string(req.Filter) != "null" {
+		var filter bson.D
+		if err := bson.UnmarshalExtJSON(req.Filter, false, &filter); err != nil {
+			return "Invalid filter: " + err.Error()
+		}
+	} else {
+		req.Filter = nil
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "url must be an http(s) URL"
+	}
+	return ""
+}
+
+// getTriggerParam loads the trigger named by the :triggerId route parameter.
+// It writes the error response itself and returns nil on failure.
+func getTriggerParam(c *gin.Context) *models.Trigger {
+	triggerID, err := strconv.Atoi(c.Param("triggerId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid trigger ID"})
+		return nil
+	}
+	t, err := loadTrigger(triggerID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Trigger not found"})
+		return nil
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil
+	}
+	return t
+}
+
+// requireTriggerSource checks that the user may receive the changes of what a trigger
+// watches: they must read the database, and see it unmasked if redaction rules apply,
+// since webhook payloads carry whole documents.
+// It writes the error response itself and returns false when refused.
+func requireTriggerSource(c *gin.Context, user models.User, req *triggerRequest) bool {
+	hasDBRead, err := middleware.HasDBPermission(user, req.EnvironmentID, req.DbName, "read")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if !hasDBRead {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No permission to read this database"})
+		return false
+	}
+	var collections []string
+	if req.Collection != "" {
+		collections = []string{req.Collection}
+	}
+	redacted, err := hasRedactionRules(req.EnvironmentID, req.DbName, collections)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if !redacted {
+		return true
+	}
+	unmasked, err := middleware.CanViewUnmasked(user, req.EnvironmentID, req.DbName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if !unmasked {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This data has redacted fields; sending its changes to a webhook requires an unmasked grant"})
+		return false
+	}
+	return true
+}
+
+// ListTriggers returns all triggers.
+// Query params: environmentId (optional).
+func ListTriggers(c *gin.Context) {
+	query := `SELECT ` + triggerColumns + ` FROM triggers`
+	var params []interface{}
+	if envIDStr := c.Query("environmentId"); envIDStr != "" {
+		envID, err := strconv.Atoi(envIDStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid environment ID"})
+			return
+		}
+		query += ` WHERE environment_id = ?`
+		params = append(params, envID)
+	}
+	query += ` ORDER BY id`
+	rows, err := database.DB.Query(query, params...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	triggers := []models.Trigger{}
+	for rows.Next() {
+		t, err := scanTrigger(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		triggers = append(triggers, *t)
+	}
+	c.JSON(http.StatusOK, gin.H{"triggers": triggers})
+}
+
+// CreateTrigger creates a webhook trigger.
+// Body: { "name": "orders-to-crm", "environmentId": 1, "dbName": "shop", "collection": "orders",
+// "operationTypes": ["insert", "update"], "filter": {"fullDocument.status": "paid"},
+// "url": "https://example.com/hooks/orders", "secret": "", "enabled": true }
+// An empty collection watches the whole database. Without a secret, one is generated;
+// the secret is only returned by this endpoint. The user must read the database, unmasked
+// if redaction rules apply (see requireTriggerSource).
+func CreateTrigger(c *gin.Context) {
+	var req triggerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if msg := validateTrigger(&req); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+	if !requireTriggerSource(c, currentUser, &req) {
+		return
+	}
+	enabled := req.Enabled == nil || *req.Enabled
+	if req.Secret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate secret: " + err.Error()})
+			return
+		}
+		req.Secret = hex.EncodeToString(b)
+	}
+	encryptedSecret, err := encrypt(req.Secret)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encrypt secret: " + err.Error()})
+		return
+	}
+
+	now := time.Now().UTC()
+	operationTypesJSON, _ := json.Marshal(req.OperationTypes)
+
+	res, err := database.DB.Exec(
+		`INSERT INTO triggers (name, environment_id, db_name, collection, operation_types, filter, url, secret,
+		 enabled, created_by, created_at, updated_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		req.Name, req.EnvironmentID, req.DbName, req.Collection, string(operationTypesJSON), string(req.Filter),
+		req.URL, encryptedSecret, enabled, currentUser.ID, now, now,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create trigger: " + err.Error()})
+		return
+	}
+	id, _ := res.LastInsertId()
+	t, err := loadTrigger(int(id))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"trigger": t, "secret": req.Secret})
+}
+
+// GetTrigger returns a trigger with the number of deliveries in each status.
+func GetTrigger(c *gin.Context) {
+	t := getTriggerParam(c)
+	if t == nil {
+		return
+	}
+	rows, err := database.DB.Query(`SELECT status, COUNT(*) FROM trigger_deliveries WHERE trigger_id = ? GROUP BY status`, t.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+	counts := map[string]int{models.DeliveryPending: 0, models.DeliveryDelivered: 0, models.DeliveryFailed: 0}
+	for rows.Next() {
+		var status string
+		var n int
+		if err := rows.Scan(&status, &n); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		counts[status] = n
+	}
+	c.JSON(http.StatusOK, gin.H{"trigger": t, "deliveries": counts})
+}
+
+// UpdateTrigger replaces the settings of a trigger.
+// The body has the same fields as CreateTrigger; an empty secret keeps the current one.
+// Watching another environment, database or collection starts from the current changes.
+func UpdateTrigger(c *gin.Context) {
+	t := getTriggerParam(c)
+	if t == nil {
+		return
+	}
+	var req triggerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if msg := validateTrigger(&req); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	currentUserRaw, _ := c.Get("user")
+	if !requireTriggerSource(c, currentUserRaw.(models.User), &req) {
+		return
+	}
+	enabled := req.Enabled == nil || *req.Enabled
+	operationTypesJSON, _ := json.Marshal(req.OperationTypes)
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(
+		`UPDATE triggers SET name = ?, environment_id = ?, db_name = ?, collection = ?, operation_types = ?,
+		 filter = ?, url = ?, enabled = ?, last_error = '', updated_at = ?
+		 WHERE id = ?`,
+		req.Name, req.EnvironmentID, req.DbName, req.Collection, string(operationTypesJSON), string(req.Filter),
+		req.URL, enabled, time.Now().UTC(), t.ID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update trigger: " + err.Error()})
+		return
+	}
+	if req.Secret != "" {
+		encryptedSecret, err := encrypt(req.Secret)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encrypt secret: " + err.Error()})
+			return
+		}
+		if _, err := tx.Exec(`UPDATE triggers SET secret = ? WHERE id = ?`, encryptedSecret, t.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if req.EnvironmentID != t.EnvironmentID || req.DbName != t.DBName || req.Collection != t.Collection {
+		if _, err := tx.Exec(`UPDATE triggers SET resume_token = '' WHERE id = ?`, t.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	t, err = loadTrigger(t.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"trigger": t})
+}
+
+// DeleteTrigger deletes a trigger and its delivery log. Pending deliveries are dropped.
+func DeleteTrigger(c *gin.Context) {
+	t := getTriggerParam(c)
+	if t == nil {
+		return
+	}
+	if _, err := database.DB.Exec(`DELETE FROM trigger_deliveries WHERE trigger_id = ?`, t.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := database.DB.Exec(`DELETE FROM triggers WHERE id = ?`, t.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Trigger deleted successfully"})
+}
+
+// ListTriggerDeliveries returns the delivery log of a trigger, newest first.
+// Query params: status (optional), limit (default 100).
+func ListTriggerDeliveries(c *gin.Context) {
+	t := getTriggerParam(c)
+	if t == nil {
+		return
+	}
+	query := `SELECT ` + triggerDeliveryColumns + ` FROM trigger_deliveries WHERE trigger_id = ?`
+	params := []interface{}{t.ID}
+	if status := c.Query("status"); status != "" {
+		query += ` AND status = ?`
+		params = append(params, status)
+	}
+	limit := 100
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+		limit = n
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	params = append(params, limit)
+	rows, err := database.DB.Query(query, params...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	deliveries := []models.TriggerDelivery{}
+	for rows.Next() {
+		d, err := scanTriggerDelivery(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		deliveries = append(deliveries, *d)
+	}
+	c.JSON(http.StatusOK, gin.H{"deliveries": deliveries})
+}
+
+// RedeliverTriggerDelivery queues a delivery again, with a fresh set of attempts.
+func RedeliverTriggerDelivery(c *gin.Context) {
+	t := getTriggerParam(c)
+	if t == nil {
+		return
+	}
+	deliveryID, err := strconv.Atoi(c.Param("deliveryId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid delivery ID"})
+		return
+	}
+	res, err := database.DB.Exec(
+		`UPDATE trigger_deliveries SET status = ?, attempts = 0, error = '', next_attempt_at = ?
+		 WHERE id = ? AND trigger_id = ?`,
+		models.DeliveryPending, time.Now().UTC(), deliveryID, t.ID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Delivery not found"})
+		return
+	}
+	d, err := scanTriggerDelivery(database.DB.QueryRow(`SELECT `+triggerDeliveryColumns+` FROM trigger_deliveries WHERE id = ?`, deliveryID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"delivery": d})
+}
@@ -0,0 +1,334 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"monji/internal/database"
+	"monji/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// triggerInterval is how often the watchers are synced with the triggers, and how
+	// often due deliveries are looked for.
+	triggerInterval = 5 * time.Second
+	// triggerRetryDelay is how long a watcher waits before reopening a failed change stream.
+	triggerRetryDelay = 30 * time.Second
+	// maxDeliveryAttempts is the number of times a delivery is tried before it is marked failed.
+	// Attempts are spaced by deliveryBackoff, doubled after each failure.
+	maxDeliveryAttempts = 6
+	deliveryBackoff     = 10 * time.Second
+	// deliveryLogRetention is how long delivered and failed deliveries are kept.
+	deliveryLogRetention = 30 * 24 * time.Hour
+)
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// errTriggerUpdated is returned by a watcher whose trigger was updated or deleted: the
+// watcher of the new version takes over.
+var errTriggerUpdated = errors.New("the trigger was updated")
+
+// triggerWatcher is the goroutine watching the change stream of a trigger.
+type triggerWatcher struct {
+	updatedAt time.Time // the trigger version it runs
+	cancel    context.CancelFunc
+}
+
+var (
+	triggerWatchersMu sync.Mutex
+	triggerWatchers   = map[int]*triggerWatcher{}
+)
+
+// StartTriggers runs a watcher for every enabled trigger and sends their deliveries,
+// until ctx is done. Watchers resume from the last event they recorded, so no change
+// is missed across restarts as long as it is still in the oplog.
+// Deliveries are sent on their own goroutine, so that slow webhooks do not hold up
+// starting and stopping watchers.
+func StartTriggers(ctx context.Context) {
+	runEvery := func(f func()) {
+		ticker := time.NewTicker(triggerInterval)
+		defer ticker.Stop()
+		for {
+			f()
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}
+	go runEvery(func() { syncTriggerWatchers(ctx) })
+	go runEvery(sendDueDeliveries)
+}
+
+// syncTriggerWatchers starts the watchers of new or updated enabled triggers, and stops
+// those of disabled, updated or deleted ones.
+func syncTriggerWatchers(ctx context.Context) {
+	rows, err := database.DB.Query(`SELECT ` + triggerColumns + ` FROM triggers WHERE enabled = 1`)
+	if err != nil {
+		log.Printf("triggers: failed to load triggers: %v", err)
+		return
+	}
+	enabled := map[int]*models.Trigger{}
+	for rows.Next() {
+		t, err := scanTrigger(rows)
+		if err != nil {
+			log.Printf("triggers: %v", err)
+			continue
+		}
+		enabled[t.ID] = t
+	}
+	rows.Close()
+
+	triggerWatchersMu.Lock()
+	defer triggerWatchersMu.Unlock()
+	for id, w := range triggerWatchers {
+		if t, ok := enabled[id]; !ok || !t.UpdatedAt.Equal(w.updatedAt) {
+			w.cancel()
+			delete(triggerWatchers, id)
+		}
+	}
+	for id, t := range enabled {
+		if _, ok := triggerWatchers[id]; ok {
+			continue
+		}
+		watchCtx, cancel := context.WithCancel(ctx)
+		triggerWatchers[id] = &triggerWatcher{updatedAt: t.UpdatedAt, cancel: cancel}
+		go runTriggerWatcher(watchCtx, t)
+	}
+}
+
+// runTriggerWatcher watches a trigger's changes until ctx is done, reopening the change
+// stream after triggerRetryDelay when it fails. The last failure is shown on the trigger.
+func runTriggerWatcher(ctx context.Context, t *models.Trigger) {
+	for {
+		err := watchTrigger(ctx, t)
+		if ctx.Err() != nil || errors.Is(err, errTriggerUpdated) {
+			return
+		}
+		if err != nil {
+			database.DB.Exec(`UPDATE triggers SET last_error = ? WHERE id = ?`, err.Error(), t.ID)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(triggerRetryDelay):
+		}
+	}
+}
+
+// watchTrigger opens the change stream of a trigger and queues a delivery for each of its
+// events, recording the event's resume token with it.
+func watchTrigger(ctx context.Context, t *models.Trigger) error {
+	var resumeToken string
+	if err := database.DB.QueryRow(`SELECT resume_token FROM triggers WHERE id = ?`, t.ID).Scan(&resumeToken); err != nil {
+		return err
+	}
+	match := bson.D{{Key: "operationType", Value: bson.M{"$in": t.OperationTypes}}}
+	if len(t.Filter) > 0 {
+		var filter bson.D
+		if err := bson.UnmarshalExtJSON(t.Filter, false, &filter); err != nil {
+			return fmt.Errorf("invalid filter: %w", err)
+		}
+		match = append(match, filter...)
+	}
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: match}}}
+	if t.Collection == "" {
+		pipeline = append(mongo.Pipeline{visibleChangesStage()}, pipeline...)
+	}
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	if resumeToken != "" {
+		var token bson.M
+		if err := bson.UnmarshalExtJSON([]byte(resumeToken), false, &token); err != nil {
+			return fmt.Errorf("invalid resume token: %w", err)
+		}
+		opts.SetResumeAfter(token)
+	}
+
+	connectCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+	client, err := connectEnvironment(connectCtx, t.EnvironmentID)
+	if err != nil {
+		return fmt.Errorf("failed to connect to MongoDB: %w", err)
+	}
+	defer client.Disconnect(context.Background())
+	var stream *mongo.ChangeStream
+	if t.Collection != "" {
+		stream, err = client.Database(t.DBName).Collection(t.Collection).Watch(ctx, pipeline, opts)
+	} else {
+		stream, err = client.Database(t.DBName).Watch(ctx, pipeline, opts)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open change stream: %w", err)
+	}
+	defer stream.Close(context.Background())
+	database.DB.Exec(`UPDATE triggers SET last_error = '' WHERE id = ?`, t.ID)
+
+	for stream.Next(ctx) {
+		var event bson.M
+		if err := stream.Decode(&event); err != nil {
+			return fmt.Errorf("failed to decode change event: %w", err)
+		}
+		token, err := bson.MarshalExtJSON(stream.ResumeToken(), false, false)
+		if err != nil {
+			return fmt.Errorf("failed to encode resume token: %w", err)
+		}
+		if err := queueDelivery(t, event, string(token)); err != nil {
+			return fmt.Errorf("failed to queue delivery: %w", err)
+		}
+	}
+	return stream.Err()
+}
+
+// queueDelivery records a pending delivery of a change event and the event's resume
+// token, in one transaction so that a restart neither skips nor repeats the event.
+// It returns errTriggerUpdated, recording nothing, if the trigger is no longer the
+// version t the watcher runs: its resume token may have been reset by UpdateTrigger.
+func queueDelivery(t *models.Trigger, event bson.M, resumeToken string) error {
+	eventJSON, err := bson.MarshalExtJSON(event, false, false)
+	if err != nil {
+		return err
+	}
+	operationType, _ := event["operationType"].(string)
+	payload, err := json.Marshal(map[string]interface{}{
+		"trigger": map[string]interface{}{"id": t.ID, "name": t.Name},
+		"event":   json.RawMessage(eventJSON),
+	})
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(
+		`INSERT INTO trigger_deliveries (trigger_id, operation_type, status, payload, created_at, next_attempt_at)
+		 VALUES (?, ?, ?, ?, ?, ?)`,
+		t.ID, operationType, models.DeliveryPending, string(payload), now, now,
+	); err != nil {
+		return err
+	}
+	res, err := tx.Exec(
+		`UPDATE triggers SET resume_token = ?, last_event_at = ? WHERE id = ? AND updated_at = ?`,
+		resumeToken, now, t.ID, t.UpdatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errTriggerUpdated
+	}
+	return tx.Commit()
+}
+
+// sendDueDeliveries sends the pending deliveries whose next attempt is due, and prunes
+// the delivery log.
+func sendDueDeliveries() {
+	now := time.Now().UTC()
+	if _, err := database.DB.Exec(
+		`DELETE FROM trigger_deliveries WHERE status != ? AND created_at < ?`,
+		models.DeliveryPending, now.Add(-deliveryLogRetention),
+	); err != nil {
+		log.Printf("triggers: failed to prune the delivery log: %v", err)
+	}
+
+	rows, err := database.DB.Query(
+		`SELECT `+triggerDeliveryColumns+` FROM trigger_deliveries
+		 WHERE status = ? AND next_attempt_at <= ? ORDER BY id LIMIT 50`,
+		models.DeliveryPending, now,
+	)
+	if err != nil {
+		log.Printf("triggers: failed to load due deliveries: %v", err)
+		return
+	}
+	var due []*models.TriggerDelivery
+	for rows.Next() {
+		d, err := scanTriggerDelivery(rows)
+		if err != nil {
+			log.Printf("triggers: %v", err)
+			continue
+		}
+		due = append(due, d)
+	}
+	rows.Close()
+
+	for _, d := range due {
+		responseStatus, err := sendDelivery(d)
+		d.Attempts++
+		now := time.Now().UTC()
+		if err == nil {
+			database.DB.Exec(
+				`UPDATE trigger_deliveries SET status = ?, attempts = ?, response_status = ?, error = '',
+				 next_attempt_at = NULL, delivered_at = ? WHERE id = ?`,
+				models.DeliveryDelivered, d.Attempts, responseStatus, now, d.ID,
+			)
+			continue
+		}
+		status := models.DeliveryPending
+		var next interface{} = now.Add(deliveryBackoff << (d.Attempts - 1))
+		if d.Attempts >= maxDeliveryAttempts {
+			status, next = models.DeliveryFailed, nil
+		}
+		database.DB.Exec(
+			`UPDATE trigger_deliveries SET status = ?, attempts = ?, response_status = ?, error = ?,
+			 next_attempt_at = ? WHERE id = ?`,
+			status, d.Attempts, responseStatus, err.Error(), next, d.ID,
+		)
+	}
+}
+
+// sendDelivery POSTs a delivery to its trigger's URL. The body is signed with the
+// trigger's secret in the X-Monji-Signature header ("sha256=" and the hex HMAC-SHA256).
+// Any response other than 2xx is an error.
+func sendDelivery(d *models.TriggerDelivery) (int, error) {
+	var url, encryptedSecret string
+	err := database.DB.QueryRow(`SELECT url, secret FROM triggers WHERE id = ?`, d.TriggerID).Scan(&url, &encryptedSecret)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load trigger: %w", err)
+	}
+	secret, err := decrypt(encryptedSecret)
+	if err != nil {
+		return 0, fmt.Errorf("failed to decrypt secret: %w", err)
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(d.Payload)
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Monji-Webhook")
+	req.Header.Set("X-Monji-Trigger", strconv.Itoa(d.TriggerID))
+	req.Header.Set("X-Monji-Delivery", strconv.Itoa(d.ID))
+	req.Header.Set("X-Monji-Event", d.OperationType)
+	req.Header.Set("X-Monji-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, errors.New("unexpected response status " + resp.Status)
+	}
+	return resp.StatusCode, nil
+}
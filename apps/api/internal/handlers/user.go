@@ -8,6 +8,7 @@ import (
 	"strings"
 
 	"monji/internal/database"
+	"monji/internal/middleware"
 	"monji/internal/models"
 
 	"github.com/gin-gonic/gin"
@@ -29,13 +30,20 @@ func CreateUser(c *gin.Context) {
 		return
 	}
 
-	// Check if the calling user is admin or superadmin
 	callingUserRaw, _ := c.Get("user")
 	callingUser := callingUserRaw.(models.User)
 
-	// If caller is admin (NOT superadmin), then they cannot create a superadmin
-	if callingUser.Role == "admin" && req.Role == "superadmin" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Admin cannot create superadmin users"})
+	// The role must exist, and the caller cannot create users more privileged than themselves
+	role, err := loadRole(req.Role)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role: " + req.Role})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !canHandleRole(callingUser, role) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You cannot create users with the role " + req.Role})
 		return
 	}
 
@@ -116,18 +124,33 @@ func UpdateUser(c *gin.Context) {
 		return
 	}
 
-	// If calling user is admin and the target is superadmin => forbidden
-	if callingUser.Role == "admin" && existingRole == "superadmin" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot edit superadmin user"})
+	// The caller cannot edit users more privileged than themselves
+	existing, err := loadUserRole(existingRole)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-
-	// If calling user is admin and they are trying to set the user’s role to superadmin => forbidden
-	if callingUser.Role == "admin" && req.Role != nil && *req.Role == "superadmin" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Admin cannot grant superadmin role"})
+	if !canHandleRole(callingUser, existing) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot edit a user with the role " + existingRole})
 		return
 	}
 
+	// ...nor grant them a role more privileged than their own
+	if req.Role != nil {
+		role, err := loadRole(*req.Role)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role: " + *req.Role})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !canHandleRole(callingUser, role) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You cannot grant the role " + *req.Role})
+			return
+		}
+	}
+
 	// Build the update query dynamically.
 	query := "UPDATE users SET "
 	var params []interface{}
@@ -224,9 +247,14 @@ func DeleteUser(c *gin.Context) {
 		return
 	}
 
-	// If caller is admin and the target is superadmin => forbid
-	if callingUser.Role == "admin" && targetRole == "superadmin" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot delete superadmin user"})
+	// The caller cannot delete users more privileged than themselves
+	target, err := loadUserRole(targetRole)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !canHandleRole(callingUser, target) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot delete a user with the role " + targetRole})
 		return
 	}
 
@@ -235,6 +263,19 @@ func DeleteUser(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	if _, err := database.DB.Exec("DELETE FROM group_members WHERE user_id = ?", id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := database.DB.Exec("DELETE FROM unmasked_grants WHERE user_id = ?", id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	// Shared queries stay with their team.
+	if _, err := database.DB.Exec("DELETE FROM saved_queries WHERE owner_id = ? AND visibility = ?", id, models.QueryPrivate); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 	rowsAffected, err := res.RowsAffected()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -311,20 +352,23 @@ type userPermissions struct {
 }
 
 type envPerm struct {
-	EnvironmentID   int    `json:"environment_id"`
-	EnvironmentName string `json:"environment_name"`
-	Permission      string `json:"permission"`
+	EnvironmentID   int                           `json:"environment_id"`
+	EnvironmentName string                        `json:"environment_name"`
+	Permission      string                        `json:"permission"` // effective permission
+	Sources         []middleware.PermissionSource `json:"sources"`
 }
 
 type dbPerm struct {
-	EnvironmentID   int    `json:"environment_id"`
-	EnvironmentName string `json:"environment_name"`
-	DBName          string `json:"db_name"`
-	Permission      string `json:"permission"`
+	EnvironmentID   int                           `json:"environment_id"`
+	EnvironmentName string                        `json:"environment_name"`
+	DBName          string                        `json:"db_name"`
+	Permission      string                        `json:"permission"` // effective permission
+	Sources         []middleware.PermissionSource `json:"sources"`
 }
 
 // fetchUserPermissions returns the environment-level and database-level permissions
-// for the given user.
+// for the given user: the effective permission on every target they have a grant on,
+// directly or through a group, along with the grants it comes from.
 func fetchUserPermissions(userID int) (*userPermissions, error) {
 	perms := &userPermissions{
 		Environments: []envPerm{},
@@ -333,39 +377,85 @@ func fetchUserPermissions(userID int) (*userPermissions, error) {
 
 	// 1) Environment-level
 	envRows, err := database.DB.Query(`
-		SELECT e.id, e.name, p.permission
-		  FROM user_env_permissions p
-		  JOIN environments e ON e.id = p.environment_id
-		 WHERE p.user_id = ?`, userID)
+		SELECT e.id, e.name
+		  FROM environments e
+		 WHERE e.id IN (
+			SELECT environment_id FROM user_env_permissions WHERE user_id = ?
+			UNION
+			SELECT p.environment_id FROM group_env_permissions p
+			  JOIN group_members m ON m.group_id = p.group_id
+			 WHERE m.user_id = ?)
+		 ORDER BY e.id`, userID, userID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch environment perms: %w", err)
 	}
-	defer envRows.Close()
-
+	var envs []envPerm
 	for envRows.Next() {
 		var ep envPerm
-		if err := envRows.Scan(&ep.EnvironmentID, &ep.EnvironmentName, &ep.Permission); err != nil {
+		if err := envRows.Scan(&ep.EnvironmentID, &ep.EnvironmentName); err != nil {
+			envRows.Close()
 			return nil, err
 		}
+		envs = append(envs, ep)
+	}
+	envRows.Close()
+	for _, ep := range envs {
+		ep.Sources, err = middleware.EnvPermissionSources(userID, ep.EnvironmentID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch environment perms: %w", err)
+		}
+		// Expired time-bound grants with nothing to fall back to give no access.
+		if len(ep.Sources) == 0 {
+			continue
+		}
+		ep.Permission = middleware.ResolvePermission(ep.Sources)
 		perms.Environments = append(perms.Environments, ep)
 	}
 
 	// 2) Database-level
 	dbRows, err := database.DB.Query(`
-		SELECT e.id, e.name, p.db_name, p.permission
-		  FROM user_db_permissions p
-		  JOIN environments e ON e.id = p.environment_id
-		 WHERE p.user_id = ?`, userID)
+		SELECT e.id, e.name, k.db_name
+		  FROM (
+			SELECT environment_id, db_name FROM user_db_permissions WHERE user_id = ?
+			UNION
+			SELECT p.environment_id, p.db_name FROM group_db_permissions p
+			  JOIN group_members m ON m.group_id = p.group_id
+			 WHERE m.user_id = ?) k
+		  JOIN environments e ON e.id = k.environment_id
+		 ORDER BY e.id, k.db_name`, userID, userID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch db perms: %w", err)
 	}
-	defer dbRows.Close()
-
+	var dbs []dbPerm
 	for dbRows.Next() {
 		var dp dbPerm
-		if err := dbRows.Scan(&dp.EnvironmentID, &dp.EnvironmentName, &dp.DBName, &dp.Permission); err != nil {
+		if err := dbRows.Scan(&dp.EnvironmentID, &dp.EnvironmentName, &dp.DBName); err != nil {
+			dbRows.Close()
 			return nil, err
 		}
+		dbs = append(dbs, dp)
+	}
+	dbRows.Close()
+	grantsByEnv := map[int]*middleware.DBGrants{}
+	for _, dp := range dbs {
+		grants, ok := grantsByEnv[dp.EnvironmentID]
+		if !ok {
+			grants, err = middleware.LoadDBGrants(models.User{ID: userID}, dp.EnvironmentID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to fetch db perms: %w", err)
+			}
+			grantsByEnv[dp.EnvironmentID] = grants
+		}
+		// A pattern grant is listed as is; an exact name shows every grant that matches it.
+		if middleware.IsDBNamePattern(dp.DBName) {
+			dp.Sources = grants.PatternSources(dp.DBName)
+		} else {
+			dp.Sources = grants.Sources(dp.DBName)
+		}
+		if len(dp.Sources) == 0 {
+			continue
+		}
+		dp.Permission = middleware.ResolvePermission(dp.Sources)
 		perms.Databases = append(perms.Databases, dp)
 	}
 
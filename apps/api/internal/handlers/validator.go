@@ -0,0 +1,522 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"monji/internal/middleware"
+	"monji/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Validation levels and actions accepted by collMod. Mongo applies "strict" and "error"
+// when a collection has a validator and does not set them.
+var (
+	validationLevels  = []string{"off", "strict", "moderate"}
+	validationActions = []string{"error", "warn"}
+)
+
+// maxDryRunExamples is the number of failing documents returned by a validator dry run.
+const maxDryRunExamples = 5
+
+// collectionValidatorRequest is the body of UpdateCollectionValidator. Omitted fields are
+// left unchanged; an empty validator ({}) removes validation.
+type collectionValidatorRequest struct {
+	Validator        json.RawMessage `json:"validator"`
+	ValidationLevel  string          `json:"validationLevel"`
+	ValidationAction string          `json:"validationAction"`
+}
+
+// parseValidator decodes a validator given as extended JSON.
+func parseValidator(raw json.RawMessage) (bson.D, error) {
+	var validator bson.D
+	if err := bson.UnmarshalExtJSON(raw, false, &validator); err != nil {
+		return nil, errors.New("validator must be a document: " + err.Error())
+	}
+	if validator == nil {
+		validator = bson.D{}
+	}
+	return validator, nil
+}
+
+// draftObjectSchema returns the $jsonSchema of the embedded documents found under prefix
+// ("" for the top-level document). A field is required when it was present in each of
+// the instances documents.
+func (a *schemaAnalysis) draftObjectSchema(prefix string, instances int) bson.M {
+	properties := bson.M{}
+	var required []string
+	for path, f := range a.fields {
+		if !strings.HasPrefix(path, prefix) {
+			continue
+		}
+		name := strings.TrimPrefix(path, prefix)
+		if strings.Contains(name, ".") || strings.HasSuffix(name, arrayElementSuffix) {
+			continue // deeper, or the elements of an array
+		}
+		properties[name] = a.draftFieldSchema(f)
+		if f.Count >= instances {
+			required = append(required, name)
+		}
+	}
+	schema := bson.M{"bsonType": "object", "properties": properties}
+	if len(required) > 0 {
+		sort.Strings(required)
+		schema["required"] = required
+	}
+	return schema
+}
+
+// draftFieldSchema returns the $jsonSchema of a field: the BSON types it was seen with, and
+// the schema of its embedded documents and array elements.
+func (a *schemaAnalysis) draftFieldSchema(f *fieldStats) bson.M {
+	types := make([]string, 0, len(f.Types))
+	for t := range f.Types {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	schema := bson.M{}
+	if len(types) == 1 {
+		schema["bsonType"] = types[0]
+	} else {
+		schema["bsonType"] = types
+	}
+	if n := f.Types["object"]; n > 0 {
+		object := a.draftObjectSchema(f.Path+".", n)
+		schema["properties"] = object["properties"]
+		if required, ok := object["required"]; ok {
+			schema["required"] = required
+		}
+	}
+	if f.Types["array"] > 0 {
+		if elems := a.fields[f.Path+arrayElementSuffix]; elems != nil {
+			schema["items"] = a.draftFieldSchema(elems)
+		}
+	}
+	return schema
+}
+
+// draftValidator returns a $jsonSchema validator accepting every sampled document.
+func (a *schemaAnalysis) draftValidator() bson.M {
+	return bson.M{"$jsonSchema": a.draftObjectSchema("", a.sampled)}
+}
+
+// GetCollectionValidator returns the validator of a collection, with its validation level
+// and action. The validator is relaxed extended JSON; it is null when the collection has none.
+func GetCollectionValidator(c *gin.Context) {
+	envID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid environment ID"})
+		return
+	}
+	dbName := c.Param("dbName")
+	collName := c.Param("collName")
+	if !requireVisibleCollections(c, collName) {
+		return
+	}
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+	hasDBRead, err := middleware.HasDBPermission(currentUser, envID, dbName, "read")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !hasDBRead {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No permission to read this collection"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	client, err := connectEnvironment(ctx, envID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to MongoDB: " + err.Error()})
+		return
+	}
+	defer client.Disconnect(ctx)
+
+	info, err := getCollectionInfo(ctx, client.Database(dbName), collName)
+	if err == errCollectionNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Collection not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read collection options: " + err.Error()})
+		return
+	}
+	respondCollectionValidator(c, dbName, collName, info.Options)
+}
+
+// respondCollectionValidator writes the validator settings found in collection options.
+func respondCollectionValidator(c *gin.Context, dbName, collName string, opts bson.M) {
+	var validator json.RawMessage
+	if v, ok := opts["validator"]; ok {
+		data, err := bson.MarshalExtJSON(v, false, false)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode validator: " + err.Error()})
+			return
+		}
+		validator = json.RawMessage(data)
+	}
+	level, _ := opts["validationLevel"].(string)
+	action, _ := opts["validationAction"].(string)
+	if validator != nil {
+		if level == "" {
+			level = "strict"
+		}
+		if action == "" {
+			action = "error"
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"database":         dbName,
+		"collection":       collName,
+		"validator":        validator,
+		"validationLevel":  level,
+		"validationAction": action,
+	})
+}
+
+// UpdateCollectionValidator sets the validator, validation level and/or validation action of
+// a collection with collMod. The validator is given as extended JSON, usually a $jsonSchema.
+func UpdateCollectionValidator(c *gin.Context) {
+	envID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid environment ID"})
+		return
+	}
+	dbName := c.Param("dbName")
+	collName := c.Param("collName")
+	if !requireVisibleCollections(c, collName) {
+		return
+	}
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+	hasDBWrite, err := middleware.HasDBPermission(currentUser, envID, dbName, "write")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !hasDBWrite {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No permission to write in this database"})
+		return
+	}
+
+	var req collectionValidatorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	cmd := bson.D{{Key: "collMod", Value: collName}}
+	if len(req.Validator) > 0 {
+		validator, err := parseValidator(req.Validator)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		cmd = append(cmd, bson.E{Key: "validator", Value: validator})
+	}
+	if req.ValidationLevel != "" {
+		if !containsString(validationLevels, req.ValidationLevel) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "validationLevel must be one of: " + strings.Join(validationLevels, ", ")})
+			return
+		}
+		cmd = append(cmd, bson.E{Key: "validationLevel", Value: req.ValidationLevel})
+	}
+	if req.ValidationAction != "" {
+		if !containsString(validationActions, req.ValidationAction) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "validationAction must be one of: " + strings.Join(validationActions, ", ")})
+			return
+		}
+		cmd = append(cmd, bson.E{Key: "validationAction", Value: req.ValidationAction})
+	}
+	if len(cmd) == 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "validator, validationLevel or validationAction is required"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	client, err := connectEnvironment(ctx, envID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to MongoDB: " + err.Error()})
+		return
+	}
+	defer client.Disconnect(ctx)
+
+	db := client.Database(dbName)
+	if err := db.RunCommand(ctx, cmd).Err(); err != nil {
+		var cmdErr mongo.CommandError
+		if errors.As(err, &cmdErr) {
+			// An invalid validator, or a collection that does not exist.
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to update validator: " + err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update validator: " + err.Error()})
+		return
+	}
+	info, err := getCollectionInfo(ctx, db, collName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read collection options: " + err.Error()})
+		return
+	}
+	respondCollectionValidator(c, dbName, collName, info.Options)
+}
+
+// GetCollectionValidatorDraft proposes a $jsonSchema validator inferred from a random sample
+// of the collection's documents (see GetCollectionSchema): every field with the BSON types
+// it was seen with, required when present in all sampled documents. It is a starting
+// point to review, not something to apply blindly: a sample may miss rare fields and types.
+// Query params: sampleSize (default 1000, at most 10000).
+func GetCollectionValidatorDraft(c *gin.Context) {
+	envID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid environment ID"})
+		return
+	}
+	dbName := c.Param("dbName")
+	collName := c.Param("collName")
+	if !requireVisibleCollections(c, collName) {
+		return
+	}
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+	hasDBRead, err := middleware.HasDBPermission(currentUser, envID, dbName, "read")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !hasDBRead {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No permission to read this collection"})
+		return
+	}
+	sampleSize := parseSampleSize(c)
+	if sampleSize == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	client, err := connectEnvironment(ctx, envID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to MongoDB: " + err.Error()})
+		return
+	}
+	defer client.Disconnect(ctx)
+
+	analysis, err := inferSchema(ctx, client.Database(dbName).Collection(collName), sampleSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to analyse documents: " + err.Error()})
+		return
+	}
+	if analysis.sampled == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The collection has no documents to infer a validator from"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"database":   dbName,
+		"collection": collName,
+		"sampleSize": analysis.sampled,
+		"validator":  analysis.draftValidator(),
+	})
+}
+
+// validatorFilterable reports whether a validator only constrains fields that are not
+// masked for the user (see redactor.Filterable), so that which documents it rejects
+// reveals nothing of the masked values.
+func validatorFilterable(r *redactor, validator interface{}) bool {
+	if r == nil {
+		return true
+	}
+	doc, ok := asDocument(validator)
+	if !ok {
+		return false
+	}
+	for key, v := range doc {
+		switch key {
+		case "$jsonSchema":
+			if !jsonSchemaFilterable(r, v, nil) {
+				return false
+			}
+		case "$and", "$or", "$nor":
+			clauses, ok := v.(bson.A)
+			if !ok {
+				return false
+			}
+			for _, clause := range clauses {
+				if !validatorFilterable(r, clause) {
+					return false
+				}
+			}
+		default:
+			if !r.Filterable(bson.M{key: v}, nil) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// jsonSchemaFilterable is validatorFilterable for the $jsonSchema of the documents at p.
+func jsonSchemaFilterable(r *redactor, schema interface{}, p []string) bool {
+	doc, ok := asDocument(schema)
+	if !ok {
+		return false
+	}
+	for key, v := range doc {
+		switch {
+		case key == "properties":
+			properties, ok := asDocument(v)
+			if !ok {
+				return false
+			}
+			for name, sub := range properties {
+				if !jsonSchemaFilterable(r, sub, append(p[:len(p):len(p)], name)) {
+					return false
+				}
+			}
+		case key == "items":
+			items, isList := v.(bson.A)
+			if !isList {
+				items = bson.A{v}
+			}
+			for _, sub := range items {
+				if !jsonSchemaFilterable(r, sub, p) {
+					return false
+				}
+			}
+		case key == "required":
+			// Removed fields must not show through their presence.
+			names, _ := v.(bson.A)
+			for _, name := range names {
+				if s, ok := name.(string); !ok || r.MayRemove(append(p[:len(p):len(p)], s)) {
+					return false
+				}
+			}
+		case key == "bsonType" || key == "type":
+			// Masked values read as strings, whatever their type.
+			if r.MasksWhole(p) {
+				return false
+			}
+		case key == "title" || key == "description":
+		default:
+			if r.MasksPath(p) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// DryRunCollectionValidator counts the existing documents of a collection that a proposed
+// validator would reject, and returns a few of them (masked like documents are). Nothing is
+// changed: Mongo only validates existing documents when they are next updated.
+// Users reading the collection masked cannot dry-run validators on redacted fields.
+func DryRunCollectionValidator(c *gin.Context) {
+	envID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid environment ID"})
+		return
+	}
+	dbName := c.Param("dbName")
+	collName := c.Param("collName")
+	if !requireVisibleCollections(c, collName) {
+		return
+	}
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+	hasDBRead, err := middleware.HasDBPermission(currentUser, envID, dbName, "read")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !hasDBRead {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No permission to read this collection"})
+		return
+	}
+
+	var req struct {
+		Validator json.RawMessage `json:"validator"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.Validator) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "validator is required"})
+		return
+	}
+	validator, err := parseValidator(req.Validator)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	redact, err := documentRedactor(currentUser, envID, dbName, collName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !validatorFilterable(redact, validator) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "The validator constrains redacted fields; dry-running it requires an unmasked grant"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	client, err := connectEnvironment(ctx, envID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to MongoDB: " + err.Error()})
+		return
+	}
+	defer client.Disconnect(ctx)
+	if !requireReadableCollection(ctx, c, currentUser, envID, client.Database(dbName), collName) {
+		return
+	}
+
+	coll := client.Database(dbName).Collection(collName)
+	failingFilter := bson.D{{Key: "$nor", Value: bson.A{validator}}}
+	failing, err := coll.CountDocuments(ctx, failingFilter)
+	if err != nil {
+		var cmdErr mongo.CommandError
+		if errors.As(err, &cmdErr) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid validator: " + err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count documents: " + err.Error()})
+		return
+	}
+	total, err := coll.EstimatedDocumentCount(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count documents: " + err.Error()})
+		return
+	}
+	examples := []bson.M{}
+	if failing > 0 {
+		cursor, err := coll.Find(ctx, failingFilter, options.Find().SetLimit(maxDryRunExamples))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find documents: " + err.Error()})
+			return
+		}
+		if err := cursor.All(ctx, &examples); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode documents: " + err.Error()})
+			return
+		}
+		redact.Documents(examples)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"database":   dbName,
+		"collection": collName,
+		"total":      total,
+		"failing":    failing,
+		"examples":   examples,
+	})
+}
@@ -0,0 +1,395 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"monji/internal/middleware"
+	"monji/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// errViewReadOnly is returned when writing documents to a view.
+var errViewReadOnly = errors.New("views are read-only")
+
+// checkWritableCollection returns errViewReadOnly if name is a view. A missing collection
+// is writable: inserting creates it.
+func checkWritableCollection(ctx context.Context, db *mongo.Database, name string) error {
+	info, err := getCollectionInfo(ctx, db, name)
+	if err == errCollectionNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Type == "view" {
+		return errViewReadOnly
+	}
+	return nil
+}
+
+// requireWritableCollection refuses document writes to a view.
+// It writes the error response itself and returns false when the write must not run.
+func requireWritableCollection(ctx context.Context, c *gin.Context, db *mongo.Database, name string) bool {
+	err := checkWritableCollection(ctx, db, name)
+	if err == errViewReadOnly {
+		c.JSON(http.StatusBadRequest, gin.H{"error": name + " is a view: views are read-only"})
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read collection options: " + err.Error()})
+		return false
+	}
+	return true
+}
+
+// viewRequest is the body of CreateView and UpdateView. The pipeline is extended JSON.
+type viewRequest struct {
+	Name      string          `json:"name"`
+	ViewOn    string          `json:"viewOn"`
+	Pipeline  json.RawMessage `json:"pipeline"`
+	Collation bson.M          `json:"collation"`
+}
+
+// parsePipeline decodes an aggregation pipeline given as extended JSON. Write stages
+// ($out, $merge) are refused: views and saved queries are read-only.
+func parsePipeline(raw json.RawMessage) (bson.A, error) {
+	if len(raw) == 0 {
+		return bson.A{}, nil
+	}
+	var wrapper struct {
+		Pipeline []bson.D `bson:"pipeline"`
+	}
+	doc := append(append([]byte(`{"pipeline":`), raw...), '}')
+	if err := bson.UnmarshalExtJSON(doc, false, &wrapper); err != nil {
+		return nil, errors.New("pipeline must be an array of stages: " + err.Error())
+	}
+	pipeline := bson.A{}
+	for _, stage := range wrapper.Pipeline {
+		if len(stage) != 1 {
+			return nil, errors.New("each pipeline stage must have exactly one operator")
+		}
+		if stage[0].Key == "$out" || stage[0].Key == "$merge" {
+			return nil, fmt.Errorf("%s is not allowed in a read-only pipeline", stage[0].Key)
+		}
+		pipeline = append(pipeline, stage)
+	}
+	return pipeline, nil
+}
+
+// pipelineSources returns the collections a pipeline reads besides its input:
+// the targets of $lookup, $graphLookup and $unionWith, nested pipelines included.
+func pipelineSources(pipeline bson.A) []string {
+	var sources []string
+	for _, s := range pipeline {
+		stage, ok := s.(bson.D)
+		if !ok || len(stage) != 1 {
+			continue
+		}
+		spec, _ := stage[0].Value.(bson.D)
+		switch stage[0].Key {
+		case "$lookup", "$graphLookup":
+			if from, ok := spec.Map()["from"].(string); ok {
+				sources = append(sources, from)
+			}
+			if nested, ok := spec.Map()["pipeline"].(bson.A); ok {
+				sources = append(sources, pipelineSources(nested)...)
+			}
+		case "$unionWith":
+			if coll, ok := stage[0].Value.(string); ok {
+				sources = append(sources, coll)
+				continue
+			}
+			if coll, ok := spec.Map()["coll"].(string); ok {
+				sources = append(sources, coll)
+			}
+			if nested, ok := spec.Map()["pipeline"].(bson.A); ok {
+				sources = append(sources, pipelineSources(nested)...)
+			}
+		case "$facet":
+			for _, e := range spec {
+				if nested, ok := e.Value.(bson.A); ok {
+					sources = append(sources, pipelineSources(nested)...)
+				}
+			}
+		}
+	}
+	return sources
+}
+
+// viewBaseCollections returns the collections that names read in the end: names that are
+// not views, and for views the base collections of their source and of the collections
+// their pipeline reads, following views defined on other views.
+func viewBaseCollections(ctx context.Context, db *mongo.Database, names []string) ([]string, error) {
+	infos, err := listCollectionInfos(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	views := map[string]bson.M{}
+	for _, info := range infos {
+		if info.Type == "view" {
+			views[info.Name] = info.Options
+		}
+	}
+	var bases []string
+	seen := map[string]bool{}
+	for len(names) > 0 {
+		name := names[0]
+		names = names[1:]
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		options, isView := views[name]
+		if !isView {
+			bases = append(bases, name)
+			continue
+		}
+		// Decoded again for the pipeline stages to be bson.D, as pipelineSources expects.
+		var def struct {
+			ViewOn   string `bson:"viewOn"`
+			Pipeline bson.A `bson:"pipeline"`
+		}
+		raw, err := bson.Marshal(options)
+		if err != nil {
+			return nil, err
+		}
+		if err := bson.Unmarshal(raw, &def); err != nil {
+			return nil, err
+		}
+		names = append(append(names, def.ViewOn), pipelineSources(def.Pipeline)...)
+	}
+	return bases, nil
+}
+
+// requireUnmaskedView refuses to define or read a view over collections that redaction
+// rules apply to, unless the user may see their data unmasked: a pipeline can rename or
+// reshape fields and so escape the rules, which match the view by its own name.
+// sources are what the view reads; views among them are followed down to their collections.
+// It writes the error response itself and returns false when the view is refused.
+func requireUnmaskedView(ctx context.Context, c *gin.Context, user models.User, envID int, db *mongo.Database, sources []string, action string) bool {
+	bases, err := viewBaseCollections(ctx, db, sources)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read collection options: " + err.Error()})
+		return false
+	}
+	redacted, err := hasRedactionRules(envID, db.Name(), bases)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if !redacted {
+		return true
+	}
+	unmasked, err := middleware.CanViewUnmasked(user, envID, db.Name())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if !unmasked {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This view reads collections with redacted fields; " + action + " it requires an unmasked grant"})
+		return false
+	}
+	return true
+}
+
+// requireReadableCollection refuses to read documents through a view the user may not
+// read (see requireUnmaskedView). Collections are left to documentRedactor.
+// It writes the error response itself and returns false when the read must not run.
+func requireReadableCollection(ctx context.Context, c *gin.Context, user models.User, envID int, db *mongo.Database, name string) bool {
+	info, err := getCollectionInfo(ctx, db, name)
+	if err == errCollectionNotFound {
+		return true
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read collection options: " + err.Error()})
+		return false
+	}
+	if info.Type != "view" {
+		return true
+	}
+	return requireUnmaskedView(ctx, c, user, envID, db, []string{name}, "reading")
+}
+
+// CreateView creates a read-only view of a collection (or of another view) through an
+// aggregation pipeline.
+// Body: { "name": "...", "viewOn": "...", "pipeline": [...], "collation": {...} }
+func CreateView(c *gin.Context) {
+	envID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid environment ID"})
+		return
+	}
+	dbName := c.Param("dbName")
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+	hasDBWrite, err := middleware.HasDBPermission(currentUser, envID, dbName, "write")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !hasDBWrite {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No permission to write in this database"})
+		return
+	}
+
+	var req viewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Name == "" || req.ViewOn == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Both name and viewOn are required"})
+		return
+	}
+	pipeline, err := parsePipeline(req.Pipeline)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !requireVisibleCollections(c, append(pipelineSources(pipeline), req.Name, req.ViewOn)...) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	client, err := connectEnvironment(ctx, envID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to MongoDB: " + err.Error()})
+		return
+	}
+	defer client.Disconnect(ctx)
+	sources := append(pipelineSources(pipeline), req.ViewOn)
+	if !requireUnmaskedView(ctx, c, currentUser, envID, client.Database(dbName), sources, "defining") {
+		return
+	}
+
+	cmd := bson.D{
+		{Key: "create", Value: req.Name},
+		{Key: "viewOn", Value: req.ViewOn},
+		{Key: "pipeline", Value: pipeline},
+	}
+	if req.Collation != nil {
+		cmd = append(cmd, bson.E{Key: "collation", Value: req.Collation})
+	}
+	if err := client.Database(dbName).RunCommand(ctx, cmd).Err(); err != nil {
+		if isNamespaceExists(err) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Collection already exists"})
+			return
+		}
+		var cmdErr mongo.CommandError
+		if errors.As(err, &cmdErr) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to create view: " + err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create view: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "View created successfully",
+		"database": dbName,
+		"view":     req.Name,
+	})
+}
+
+// UpdateView replaces the source and pipeline of a view with collMod.
+// Body: { "viewOn": "...", "pipeline": [...] }; viewOn defaults to the current source.
+func UpdateView(c *gin.Context) {
+	envID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid environment ID"})
+		return
+	}
+	dbName := c.Param("dbName")
+	viewName := c.Param("viewName")
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+	hasDBWrite, err := middleware.HasDBPermission(currentUser, envID, dbName, "write")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !hasDBWrite {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No permission to write in this database"})
+		return
+	}
+
+	var req viewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.Pipeline) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "pipeline is required"})
+		return
+	}
+	pipeline, err := parsePipeline(req.Pipeline)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !requireVisibleCollections(c, append(pipelineSources(pipeline), viewName, req.ViewOn)...) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	client, err := connectEnvironment(ctx, envID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to MongoDB: " + err.Error()})
+		return
+	}
+	defer client.Disconnect(ctx)
+
+	db := client.Database(dbName)
+	info, err := getCollectionInfo(ctx, db, viewName)
+	if err == errCollectionNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "View not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read collection options: " + err.Error()})
+		return
+	}
+	if info.Type != "view" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": viewName + " is not a view"})
+		return
+	}
+	if req.ViewOn == "" {
+		req.ViewOn, _ = info.Options["viewOn"].(string)
+	}
+	if !requireUnmaskedView(ctx, c, currentUser, envID, db, append(pipelineSources(pipeline), req.ViewOn), "defining") {
+		return
+	}
+
+	cmd := bson.D{
+		{Key: "collMod", Value: viewName},
+		{Key: "viewOn", Value: req.ViewOn},
+		{Key: "pipeline", Value: pipeline},
+	}
+	if err := db.RunCommand(ctx, cmd).Err(); err != nil {
+		var cmdErr mongo.CommandError
+		if errors.As(err, &cmdErr) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to update view: " + err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update view: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "View updated successfully",
+		"database": dbName,
+		"view":     viewName,
+		"viewOn":   req.ViewOn,
+	})
+}
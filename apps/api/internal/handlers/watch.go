@@ -0,0 +1,271 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"monji/internal/middleware"
+	"monji/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MaxWatchStreams is the number of change streams a user may have open at once.
+// It is set from the configuration at startup.
+var MaxWatchStreams = 3
+
+// watchKeepAlive is how often a stream re-checks the user's permission, and how long it
+// may stay idle before sending a comment so that proxies keep the connection open.
+const watchKeepAlive = 15 * time.Second
+
+var (
+	watchStreamsMu sync.Mutex
+	watchStreams   = map[int]int{} // user ID -> open streams
+)
+
+// acquireWatchStream reserves one of the user's streams; it returns false if they are all in use.
+func acquireWatchStream(userID int) bool {
+	watchStreamsMu.Lock()
+	defer watchStreamsMu.Unlock()
+	if watchStreams[userID] >= MaxWatchStreams {
+		return false
+	}
+	watchStreams[userID]++
+	return true
+}
+
+func releaseWatchStream(userID int) {
+	watchStreamsMu.Lock()
+	defer watchStreamsMu.Unlock()
+	if watchStreams[userID]--; watchStreams[userID] <= 0 {
+		delete(watchStreams, userID)
+	}
+}
+
+// redactChangeEvent masks the document images and updated fields of a change event.
+func redactChangeEvent(r *redactor, event bson.M) {
+	if r == nil {
+		return
+	}
+	for _, key := range []string{"fullDocument", "fullDocumentBeforeChange"} {
+		if doc, ok := asDocument(event[key]); ok {
+			r.Document(doc)
+			event[key] = doc
+		}
+	}
+	if desc, ok := asDocument(event["updateDescription"]); ok {
+		if fields, ok := asDocument(desc["updatedFields"]); ok {
+			r.Fields(fields)
+			desc["updatedFields"] = fields
+		}
+		event["updateDescription"] = desc
+	}
+}
+
+// changeEventPath maps a key of a change event filter to the path of the document data
+// it compares. Keys on the event itself (operationType, ns...) are not document data.
+func changeEventPath(key string) ([]string, bool) {
+	p := strings.Split(key, ".")
+	switch p[0] {
+	case "fullDocument", "fullDocumentBeforeChange", "documentKey":
+		return p[1:], true
+	case "updateDescription":
+		if len(p) == 1 {
+			return nil, true
+		}
+		if p[1] == "updatedFields" {
+			return p[2:], true
+		}
+	}
+	return nil, false
+}
+
+// WatchChanges streams the changes of a database, or of one of its collections, as
+// Server-Sent Events. Each "change" event carries the change event as relaxed extended
+// JSON, and its resume token as the event ID: a client reconnecting with the
+// Last-Event-ID header (or the resumeAfter query param) resumes after it.
+// Query params:
+//   - match: extended JSON filter on the change events, e.g. {"operationType": "insert"};
+//     users reading the data masked cannot filter on redacted fields;
+//   - fullDocument: "updateLookup" to include the current document in update events.
+//
+// The stream ends with an "error" event if it fails, or if the user loses read permission.
+func WatchChanges(c *gin.Context) {
+	envID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid environment ID"})
+		return
+	}
+	dbName := c.Param("dbName")
+	collName := c.Param("collName") // empty when watching the whole database
+	if !requireVisibleCollections(c, collName) {
+		return
+	}
+	currentUserRaw, _ := c.Get("user")
+	currentUser := currentUserRaw.(models.User)
+	hasDBRead, err := middleware.HasDBPermission(currentUser, envID, dbName, "read")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !hasDBRead {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No permission to read this database"})
+		return
+	}
+
+	pipeline := mongo.Pipeline{}
+	if collName == "" {
+		pipeline = append(pipeline, visibleChangesStage())
+	}
+	if m := c.Query("match"); m != "" {
+		var match bson.D
+		if err := bson.UnmarshalExtJSON([]byte(m), false, &match); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid match filter: " + err.Error()})
+			return
+		}
+		// Which events match must not reveal masked values.
+		redact, err := documentRedactor(currentUser, envID, dbName, collName)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !redact.Filterable(match, changeEventPath) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "The match filter reads redacted fields, which requires an unmasked grant"})
+			return
+		}
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: match}})
+	}
+	// getMore waits at most a second for changes, for the keep-alive to run while idle.
+	opts := options.ChangeStream().SetMaxAwaitTime(time.Second)
+	switch c.Query("fullDocument") {
+	case "":
+	case "updateLookup":
+		opts.SetFullDocument(options.UpdateLookup)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "fullDocument must be 'updateLookup'"})
+		return
+	}
+	resumeAfter := c.GetHeader("Last-Event-ID")
+	if resumeAfter == "" {
+		resumeAfter = c.Query("resumeAfter")
+	}
+	if resumeAfter != "" {
+		var token bson.M
+		if err := bson.UnmarshalExtJSON([]byte(resumeAfter), false, &token); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid resume token: " + err.Error()})
+			return
+		}
+		opts.SetResumeAfter(token)
+	}
+
+	if !acquireWatchStream(currentUser.ID) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": fmt.Sprintf("You already have %d change streams open", MaxWatchStreams)})
+		return
+	}
+	defer releaseWatchStream(currentUser.ID)
+
+	// The request context ends when the client disconnects.
+	ctx := c.Request.Context()
+	connectCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+	client, err := connectEnvironment(connectCtx, envID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to MongoDB: " + err.Error()})
+		return
+	}
+	defer client.Disconnect(context.Background())
+	var stream *mongo.ChangeStream
+	if collName != "" {
+		stream, err = client.Database(dbName).Collection(collName).Watch(ctx, pipeline, opts)
+	} else {
+		stream, err = client.Database(dbName).Watch(ctx, pipeline, opts)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open change stream: " + err.Error()})
+		return
+	}
+	defer stream.Close(context.Background())
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	sendError := func(msg string) {
+		data, _ := json.Marshal(gin.H{"error": msg})
+		fmt.Fprintf(c.Writer, "event: error\ndata: %s\n\n", data)
+		c.Writer.Flush()
+	}
+	// Redaction rules depend on the collection, which varies when watching a database.
+	redactors := map[string]*redactor{}
+	lastCheck, lastWrite := time.Now(), time.Now()
+	for {
+		if time.Since(lastCheck) >= watchKeepAlive {
+			hasDBRead, err := middleware.HasDBPermission(currentUser, envID, dbName, "read")
+			if err != nil || !hasDBRead {
+				sendError("No permission to read this database")
+				return
+			}
+			// Pick up rule changes too.
+			redactors = map[string]*redactor{}
+			lastCheck = time.Now()
+		}
+		if time.Since(lastWrite) >= watchKeepAlive {
+			fmt.Fprint(c.Writer, ": keep-alive\n\n")
+			c.Writer.Flush()
+			lastWrite = time.Now()
+		}
+		if !stream.TryNext(ctx) {
+			if err := stream.Err(); err != nil {
+				if ctx.Err() == nil {
+					sendError("Change stream failed: " + err.Error())
+				}
+				return
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+		var event bson.M
+		if err := stream.Decode(&event); err != nil {
+			sendError("Failed to decode change event: " + err.Error())
+			return
+		}
+		ns, _ := asDocument(event["ns"])
+		coll, _ := ns["coll"].(string)
+		r, cached := redactors[coll]
+		if !cached {
+			if r, err = documentRedactor(currentUser, envID, dbName, coll); err != nil {
+				sendError(err.Error())
+				return
+			}
+			redactors[coll] = r
+		}
+		redactChangeEvent(r, event)
+		data, err := bson.MarshalExtJSON(event, false, false)
+		if err != nil {
+			sendError("Failed to encode change event: " + err.Error())
+			return
+		}
+		token, err := bson.MarshalExtJSON(stream.ResumeToken(), false, false)
+		if err != nil {
+			sendError("Failed to encode resume token: " + err.Error())
+			return
+		}
+		fmt.Fprintf(c.Writer, "id: %s\nevent: change\ndata: %s\n\n", token, data)
+		c.Writer.Flush()
+		lastWrite = time.Now()
+	}
+}
@@ -0,0 +1,380 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"monji/internal/database"
+	"monji/internal/models"
+)
+
+// Handler executes a job of a given type. It must honour ctx cancellation
+// and may report progress and checkpoints through the given Task.
+// The returned value is stored as the job result (JSON-encoded).
+type Handler func(ctx context.Context, task *Task) (interface{}, error)
+
+// ErrNotFound is returned when a job does not exist.
+var ErrNotFound = errors.New("job not found")
+
+// ErrFinished is returned when trying to cancel a job that already finished.
+var ErrFinished = errors.New("job already finished")
+
+// ErrNotRetryable is returned when retrying a job that did not fail or was not cancelled.
+var ErrNotRetryable = errors.New("only failed or cancelled jobs can be retried")
+
+var (
+	handlersMu sync.RWMutex
+	handlers   = map[string]Handler{}
+
+	runningMu sync.Mutex
+	running   = map[int]context.CancelFunc{}
+
+	// wake is used to notify workers that a new job was submitted.
+	wake = make(chan struct{}, 1)
+	// claimMu serializes job claiming between workers.
+	claimMu sync.Mutex
+)
+
+// pollInterval is how often idle workers look for pending jobs.
+const pollInterval = 5 * time.Second
+
+// Register associates a job type with its handler.
+// It is meant to be called from init() functions.
+func Register(jobType string, h Handler) {
+	handlersMu.Lock()
+	defer handlersMu.Unlock()
+	handlers[jobType] = h
+}
+
+// Registered returns true if a handler exists for the given job type.
+func Registered(jobType string) bool {
+	handlersMu.RLock()
+	defer handlersMu.RUnlock()
+	_, ok := handlers[jobType]
+	return ok
+}
+
+// Submit stores a new pending job and wakes up a worker.
+func Submit(jobType string, envID int, userID int, params interface{}) (int, error) {
+	if !Registered(jobType) {
+		return 0, fmt.Errorf("unknown job type: %s", jobType)
+	}
+	paramsJSON, err := json.Marshal(params)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode job params: %w", err)
+	}
+	res, err := database.DB.Exec(
+		`INSERT INTO jobs (type, status, environment_id, params, created_by, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?)`,
+		jobType, models.JobPending, envID, string(paramsJSON), userID, time.Now().UTC(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	notify()
+	return int(id), nil
+}
+
+// Cancel requests cancellation of a job.
+// Pending jobs are cancelled immediately; running jobs have their context cancelled
+// and are marked as cancelled once their handler returns.
+func Cancel(id int) error {
+	job, err := Get(id)
+	if err != nil {
+		return err
+	}
+	if job.Finished() {
+		return ErrFinished
+	}
+
+	res, err := database.DB.Exec(
+		`UPDATE jobs SET status = ?, cancel_requested = 1, finished_at = ? WHERE id = ? AND status = ?`,
+		models.JobCancelled, time.Now().UTC(), id, models.JobPending,
+	)
+	if err != nil {
+		return err
+	}
+	if rows, _ := res.RowsAffected(); rows > 0 {
+		return nil
+	}
+
+	// The job is running: flag it and cancel its context.
+	if _, err := database.DB.Exec(`UPDATE jobs SET cancel_requested = 1 WHERE id = ?`, id); err != nil {
+		return err
+	}
+	runningMu.Lock()
+	cancel, ok := running[id]
+	runningMu.Unlock()
+	if ok {
+		cancel()
+	}
+	return nil
+}
+
+// Retry puts a failed or cancelled job back in the queue.
+// Its checkpoint is kept, so handlers that support it resume where they stopped.
+func Retry(id int) error {
+	res, err := database.DB.Exec(
+		`UPDATE jobs SET status = ?, cancel_requested = 0, error = '', result = NULL, finished_at = NULL
+		 WHERE id = ? AND status IN (?, ?)`,
+		models.JobPending, id, models.JobFailed, models.JobCancelled,
+	)
+	if err != nil {
+		return err
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		if _, err := Get(id); err != nil {
+			return err
+		}
+		return ErrNotRetryable
+	}
+	notify()
+	return nil
+}
+
+// Get returns a single job by ID.
+func Get(id int) (*models.Job, error) {
+	row := database.DB.QueryRow(`SELECT `+jobColumns+` FROM jobs WHERE id = ?`, id)
+	job, err := scanJob(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return job, err
+}
+
+// List returns the most recent jobs, newest first.
+// If userID is not zero, only jobs created by that user are returned.
+func List(userID int, status string, limit int) ([]models.Job, error) {
+	query := `SELECT ` + jobColumns + ` FROM jobs WHERE 1 = 1`
+	var params []interface{}
+	if userID != 0 {
+		query += ` AND created_by = ?`
+		params = append(params, userID)
+	}
+	if status != "" {
+		query += ` AND status = ?`
+		params = append(params, status)
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	params = append(params, limit)
+
+	rows, err := database.DB.Query(query, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []models.Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, *job)
+	}
+	return jobs, rows.Err()
+}
+
+// Start requeues jobs interrupted by a previous shutdown and launches the workers.
+// Workers stop when ctx is cancelled.
+func Start(ctx context.Context, workers int) {
+	// Jobs still marked as running were interrupted by a restart.
+	// Handlers are expected to resume from their checkpoint.
+	res, err := database.DB.Exec(
+		`UPDATE jobs SET status = ?, progress_message = 'Requeued after restart' WHERE status = ?`,
+		models.JobPending, models.JobRunning,
+	)
+	if err != nil {
+		log.Printf("Failed to requeue interrupted jobs: %v", err)
+	} else if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("Requeued %d interrupted job(s)", n)
+	}
+
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		go worker(ctx)
+	}
+}
+
+func notify() {
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+func worker(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		for {
+			job, err := claim()
+			if err != nil {
+				log.Printf("Failed to claim job: %v", err)
+				break
+			}
+			if job == nil {
+				break
+			}
+			execute(ctx, job)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// claim atomically moves the oldest pending job to running.
+func claim() (*models.Job, error) {
+	claimMu.Lock()
+	defer claimMu.Unlock()
+
+	var id int
+	err := database.DB.QueryRow(
+		`SELECT id FROM jobs WHERE status = ? ORDER BY id LIMIT 1`, models.JobPending,
+	).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	_, err = database.DB.Exec(
+		`UPDATE jobs SET status = ?, started_at = COALESCE(started_at, ?), error = '' WHERE id = ?`,
+		models.JobRunning, time.Now().UTC(), id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return Get(id)
+}
+
+func execute(parent context.Context, job *models.Job) {
+	handlersMu.RLock()
+	h, ok := handlers[job.Type]
+	handlersMu.RUnlock()
+	if !ok {
+		finish(job.ID, models.JobFailed, nil, fmt.Sprintf("unknown job type: %s", job.Type))
+		return
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+	runningMu.Lock()
+	running[job.ID] = cancel
+	runningMu.Unlock()
+	defer func() {
+		runningMu.Lock()
+		delete(running, job.ID)
+		runningMu.Unlock()
+		cancel()
+	}()
+
+	// A cancellation may have been requested between claim and now: Cancel only reaches
+	// jobs in running, so re-read the flag once the job is registered there.
+	var cancelRequested bool
+	err := database.DB.QueryRow(`SELECT cancel_requested FROM jobs WHERE id = ?`, job.ID).Scan(&cancelRequested)
+	if err != nil {
+		log.Printf("Failed to read job %d cancellation: %v", job.ID, err)
+	}
+	if cancelRequested {
+		cancel()
+	}
+
+	result, err := runHandler(ctx, h, &Task{Job: *job})
+
+	// Shutting down: leave the job as running so it is requeued on restart.
+	if parent.Err() != nil {
+		return
+	}
+
+	var current models.Job
+	if j, getErr := Get(job.ID); getErr == nil {
+		current = *j
+	}
+	switch {
+	case current.CancelRequested:
+		finish(job.ID, models.JobCancelled, result, "")
+	case err != nil:
+		finish(job.ID, models.JobFailed, result, err.Error())
+	default:
+		finish(job.ID, models.JobSucceeded, result, "")
+	}
+}
+
+// runHandler calls h and converts panics into errors so a faulty job
+// cannot take down the worker.
+func runHandler(ctx context.Context, h Handler, task *Task) (result interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return h(ctx, task)
+}
+
+func finish(id int, status string, result interface{}, errMsg string) {
+	var resultJSON interface{}
+	if result != nil {
+		b, err := json.Marshal(result)
+		if err != nil {
+			errMsg = "failed to encode job result: " + err.Error()
+			status = models.JobFailed
+		} else {
+			resultJSON = string(b)
+		}
+	}
+	_, err := database.DB.Exec(
+		`UPDATE jobs SET status = ?, result = ?, error = ?,
+			progress = CASE WHEN ? = ? THEN 100 ELSE progress END, finished_at = ? WHERE id = ?`,
+		status, resultJSON, errMsg, status, models.JobSucceeded, time.Now().UTC(), id,
+	)
+	if err != nil {
+		log.Printf("Failed to record job %d completion: %v", id, err)
+	}
+}
+
+const jobColumns = `id, type, status, COALESCE(environment_id, 0), COALESCE(params, ''), progress, progress_message,
+	COALESCE(result, ''), error, cancel_requested, created_by, created_at, started_at, finished_at`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanJob(s scanner) (*models.Job, error) {
+	var job models.Job
+	var params, result string
+	var startedAt, finishedAt sql.NullTime
+	err := s.Scan(&job.ID, &job.Type, &job.Status, &job.EnvironmentID, &params, &job.Progress, &job.ProgressMessage,
+		&result, &job.Error, &job.CancelRequested, &job.CreatedBy, &job.CreatedAt, &startedAt, &finishedAt)
+	if err != nil {
+		return nil, err
+	}
+	if params != "" {
+		job.Params = json.RawMessage(params)
+	}
+	if result != "" {
+		job.Result = json.RawMessage(result)
+	}
+	if startedAt.Valid {
+		job.StartedAt = &startedAt.Time
+	}
+	if finishedAt.Valid {
+		job.FinishedAt = &finishedAt.Time
+	}
+	return &job, nil
+}
@@ -0,0 +1,77 @@
+package jobs
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+
+	"monji/internal/database"
+	"monji/internal/models"
+)
+
+// Task is the handle given to a running job handler.
+type Task struct {
+	Job models.Job
+}
+
+// DecodeParams decodes the job parameters into v.
+func (t *Task) DecodeParams(v interface{}) error {
+	if len(t.Job.Params) == 0 {
+		return nil
+	}
+	return json.Unmarshal(t.Job.Params, v)
+}
+
+// SetProgress records the job progress (0-100) and a short status message.
+// Failures are only logged: progress is informative and must not fail the job.
+func (t *Task) SetProgress(percent int, message string) {
+	if percent < 0 {
+		percent = 0
+	}
+	if percent > 100 {
+		percent = 100
+	}
+	t.Job.Progress = percent
+	t.Job.ProgressMessage = message
+	if _, err := database.DB.Exec(
+		`UPDATE jobs SET progress = ?, progress_message = ? WHERE id = ?`,
+		percent, message, t.Job.ID,
+	); err != nil {
+		log.Printf("Failed to update progress of job %d: %v", t.Job.ID, err)
+	}
+}
+
+// SaveCheckpoint persists v so the job can resume after a restart.
+func (t *Task) SaveCheckpoint(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = database.DB.Exec(`UPDATE jobs SET checkpoint = ? WHERE id = ?`, string(b), t.Job.ID)
+	return err
+}
+
+// SaveCheckpointTx is SaveCheckpoint within tx, for the checkpoint to be saved together
+// with the writes it records.
+func (t *Task) SaveCheckpointTx(tx *sql.Tx, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE jobs SET checkpoint = ? WHERE id = ?`, string(b), t.Job.ID)
+	return err
+}
+
+// LoadCheckpoint decodes the last saved checkpoint into v.
+// It returns false if no checkpoint was saved yet.
+func (t *Task) LoadCheckpoint(v interface{}) (bool, error) {
+	var checkpoint sql.NullString
+	err := database.DB.QueryRow(`SELECT checkpoint FROM jobs WHERE id = ?`, t.Job.ID).Scan(&checkpoint)
+	if err != nil {
+		return false, err
+	}
+	if !checkpoint.Valid || checkpoint.String == "" {
+		return false, nil
+	}
+	return true, json.Unmarshal([]byte(checkpoint.String), v)
+}
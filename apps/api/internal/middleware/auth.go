@@ -51,9 +51,13 @@ func AuthMiddleware() gin.HandlerFunc {
 				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
 				return
 			}
-			// Log the loaded user for debugging.
-			fmt.Printf("AuthMiddleware: loaded user %+v\n", user)
 			user.Password = ""
+			caps, err := RoleCapabilities(user.Role)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to load role: " + err.Error()})
+				return
+			}
+			user.Capabilities = caps
 			c.Set("user", user)
 			c.Next()
 		} else {
@@ -62,26 +66,3 @@ func AuthMiddleware() gin.HandlerFunc {
 		}
 	}
 }
-
-// AdminMiddleware ensures the user is an admin or superadmin.
-func AdminMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		user, exists := c.Get("user")
-		if !exists {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - user not in context"})
-			return
-		}
-		usr, ok := user.(models.User)
-		if !ok {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - user type assertion failed"})
-			return
-		}
-		// Log the role for debugging.
-		fmt.Printf("AdminMiddleware: user role = %s\n", usr.Role)
-		if usr.Role != "admin" && usr.Role != "superadmin" {
-			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Forbidden - Admins Only"})
-			return
-		}
-		c.Next()
-	}
-}
@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"monji/internal/database"
+	"monji/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RoleCapabilities returns the capabilities of a role; an unknown role has none.
+func RoleCapabilities(role string) ([]string, error) {
+	var capsJSON string
+	err := database.DB.QueryRow(`SELECT capabilities FROM roles WHERE name = ?`, role).Scan(&capsJSON)
+	if err == sql.ErrNoRows {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	caps := []string{}
+	if err := json.Unmarshal([]byte(capsJSON), &caps); err != nil {
+		return nil, err
+	}
+	return caps, nil
+}
+
+// HasCapability returns true if the user's role grants the capability.
+// The user's capabilities must have been loaded (see AuthMiddleware).
+func HasCapability(user models.User, capability string) bool {
+	for _, c := range user.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireCapability ensures the user's role grants the capability.
+func RequireCapability(capability string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, exists := c.Get("user")
+		if !exists {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - user not in context"})
+			return
+		}
+		usr, ok := user.(models.User)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized - user type assertion failed"})
+			return
+		}
+		if !HasCapability(usr, capability) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Forbidden - requires the " + capability + " capability"})
+			return
+		}
+		c.Next()
+	}
+}
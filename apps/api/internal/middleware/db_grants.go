@@ -0,0 +1,178 @@
+package middleware
+
+import (
+	"database/sql"
+	"path"
+	"strings"
+	"time"
+
+	"monji/internal/database"
+	"monji/internal/models"
+)
+
+// IsDBNamePattern returns true if a database grant names its databases with a glob
+// pattern ("*", "?" or "[...]", as in path.Match) rather than an exact name.
+func IsDBNamePattern(dbName string) bool {
+	return strings.ContainsAny(dbName, "*?[")
+}
+
+// ValidDBNamePattern returns true if dbName is an exact name or a well-formed pattern.
+func ValidDBNamePattern(dbName string) bool {
+	_, err := path.Match(dbName, "")
+	return err == nil
+}
+
+// patternSpecificity ranks the grants matching a database: an exact grant ranks
+// above every pattern, and a pattern ranks by its number of literal characters,
+// so "tenant_00*" is more specific than "tenant_*", itself more specific than "*".
+func patternSpecificity(pattern string) int {
+	if !IsDBNamePattern(pattern) {
+		return int(^uint(0) >> 1)
+	}
+	n := 0
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '*', '?':
+		case '[':
+			for i < len(pattern) && pattern[i] != ']' {
+				i++
+			}
+		case '\\':
+			i++
+			n++
+		default:
+			n++
+		}
+	}
+	return n
+}
+
+type dbGrant struct {
+	dbName string // exact name or pattern
+	source PermissionSource
+}
+
+// DBGrants holds every database grant of a user in an environment, their own and
+// their groups', to resolve their permission on many databases with a single load.
+//
+// When several grants match a database, only the most specific ones apply (see
+// patternSpecificity); they are merged with ResolvePermission. An exact grant thus
+// overrides a pattern, whether it is more or less permissive, except a deny: a matching
+// deny always applies, however broad its pattern.
+type DBGrants struct {
+	admin  bool
+	grants []dbGrant
+}
+
+// LoadDBGrants loads the database grants of a user in an environment.
+func LoadDBGrants(user models.User, envID int) (*DBGrants, error) {
+	if IsAdmin(user) {
+		return &DBGrants{admin: true}, nil
+	}
+	return loadDBGrants(user.ID, envID)
+}
+
+func loadDBGrants(userID, envID int) (*DBGrants, error) {
+	g := &DBGrants{}
+
+	now := time.Now().UTC()
+	rows, err := database.DB.Query(
+		`SELECT db_name, `+EffectivePermissionSQL("")+`, expires_at FROM user_db_permissions
+		 WHERE user_id = ? AND environment_id = ?`,
+		now, userID, envID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		grant := dbGrant{source: PermissionSource{Type: "user"}}
+		var expiresAt sql.NullTime
+		if err := rows.Scan(&grant.dbName, &grant.source.Permission, &expiresAt); err != nil {
+			return nil, err
+		}
+		if grant.source.Permission == "" {
+			continue
+		}
+		if expiresAt.Valid && expiresAt.Time.After(now) {
+			grant.source.ExpiresAt = &expiresAt.Time
+		}
+		g.grants = append(g.grants, grant)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	groupRows, err := database.DB.Query(
+		`SELECT p.db_name, g.id, g.name, p.permission
+		   FROM group_db_permissions p
+		   JOIN groups g ON g.id = p.group_id
+		   JOIN group_members m ON m.group_id = p.group_id
+		  WHERE m.user_id = ? AND p.environment_id = ?
+		  ORDER BY g.id`,
+		userID, envID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer groupRows.Close()
+	for groupRows.Next() {
+		grant := dbGrant{source: PermissionSource{Type: "group"}}
+		if err := groupRows.Scan(&grant.dbName, &grant.source.GroupID, &grant.source.GroupName, &grant.source.Permission); err != nil {
+			return nil, err
+		}
+		g.grants = append(g.grants, grant)
+	}
+	return g, groupRows.Err()
+}
+
+// Sources returns the grants matching a database, the less specific ones marked as
+// overridden. Denials are never overridden.
+func (g *DBGrants) Sources(dbName string) []PermissionSource {
+	var sources []PermissionSource
+	var ranks []int
+	best := -1
+	for _, grant := range g.grants {
+		src := grant.source
+		if IsDBNamePattern(grant.dbName) {
+			if ok, _ := path.Match(grant.dbName, dbName); !ok {
+				continue
+			}
+			src.Pattern = grant.dbName
+		} else if grant.dbName != dbName {
+			continue
+		}
+		rank := patternSpecificity(grant.dbName)
+		if rank > best {
+			best = rank
+		}
+		sources = append(sources, src)
+		ranks = append(ranks, rank)
+	}
+	for i := range sources {
+		sources[i].Overridden = ranks[i] < best && sources[i].Permission != PermissionDeny
+	}
+	return sources
+}
+
+// PatternSources returns the grants given with exactly this database name or pattern,
+// to describe a pattern grant itself rather than the databases it matches.
+func (g *DBGrants) PatternSources(pattern string) []PermissionSource {
+	var sources []PermissionSource
+	for _, grant := range g.grants {
+		if grant.dbName == pattern {
+			src := grant.source
+			src.Pattern = pattern
+			sources = append(sources, src)
+		}
+	}
+	return sources
+}
+
+// Permission returns the effective permission on a database (see ResolvePermission).
+func (g *DBGrants) Permission(dbName string) string {
+	if g.admin {
+		return "readAndWrite"
+	}
+	return ResolvePermission(g.Sources(dbName))
+}
@@ -0,0 +1,93 @@
+package middleware
+
+import "testing"
+
+func TestPatternSpecificity(t *testing.T) {
+	exact := patternSpecificity("tenant_0001")
+	tests := []struct {
+		more, less string
+	}{
+		{"tenant_0001", "tenant_00*"},
+		{"tenant_00*", "tenant_*"},
+		{"tenant_*", "*"},
+		{"tenant_?", "tenant*"},
+		{"tenant_[01]*", "tenant*"},
+		{`shop\*`, "shop*"},
+	}
+	for _, tt := range tests {
+		if m, l := patternSpecificity(tt.more), patternSpecificity(tt.less); m <= l {
+			t.Errorf("patternSpecificity(%q) = %d, want more than patternSpecificity(%q) = %d", tt.more, m, tt.less, l)
+		}
+	}
+	for _, p := range []string{"*", "tenant_*", "[a-z]*", `shop\*`} {
+		if got := patternSpecificity(p); got >= exact {
+			t.Errorf("patternSpecificity(%q) = %d, want less than an exact name (%d)", p, got, exact)
+		}
+	}
+	if got := patternSpecificity("*"); got != 0 {
+		t.Errorf(`patternSpecificity("*") = %d, want 0`, got)
+	}
+}
+
+func TestResolvePermission(t *testing.T) {
+	tests := []struct {
+		name    string
+		sources []PermissionSource
+		want    string
+	}{
+		{"no grant", nil, "none"},
+		{"read only", []PermissionSource{{Permission: "readOnly"}}, "readOnly"},
+		{"most permissive wins", []PermissionSource{{Permission: "readOnly"}, {Permission: "readAndWrite"}, {Permission: "readOnly"}}, "readAndWrite"},
+		{"deny wins", []PermissionSource{{Permission: "readAndWrite"}, {Permission: PermissionDeny}}, PermissionDeny},
+		{"overridden ignored", []PermissionSource{{Permission: "readAndWrite", Overridden: true}, {Permission: "readOnly"}}, "readOnly"},
+		{"only overridden", []PermissionSource{{Permission: "readAndWrite", Overridden: true}}, "none"},
+	}
+	for _, tt := range tests {
+		if got := ResolvePermission(tt.sources); got != tt.want {
+			t.Errorf("%s: ResolvePermission() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDBGrantsPermission(t *testing.T) {
+	grant := func(dbName, permission string) dbGrant {
+		return dbGrant{dbName: dbName, source: PermissionSource{Type: "user", Permission: permission}}
+	}
+	g := &DBGrants{grants: []dbGrant{
+		grant("*", "readOnly"),
+		grant("tenant_*", "readAndWrite"),
+		grant("tenant_00*", "readOnly"),
+		grant("tenant_0002", "readAndWrite"),
+		grant("shop*", PermissionDeny),
+		grant("shop_main", "readAndWrite"),
+		{dbName: "tenant_01*", source: PermissionSource{Type: "group", Permission: PermissionDeny}},
+	}}
+	tests := []struct {
+		dbName string
+		want   string
+	}{
+		{"other", "readOnly"},
+		{"tenant_x", "readAndWrite"},
+		{"tenant_0001", "readOnly"},     // the more specific pattern restricts
+		{"tenant_0002", "readAndWrite"}, // an exact grant overrides every pattern
+		{"shop_main", PermissionDeny},   // a deny applies however broad its pattern
+		{"shop", PermissionDeny},
+		{"tenant_0100", PermissionDeny},
+	}
+	for _, tt := range tests {
+		if got := g.Permission(tt.dbName); got != tt.want {
+			t.Errorf("Permission(%q) = %q, want %q", tt.dbName, got, tt.want)
+		}
+	}
+
+	if got := (&DBGrants{admin: true, grants: g.grants}).Permission("shop_main"); got != "readAndWrite" {
+		t.Errorf("admin Permission() = %q, want readAndWrite", got)
+	}
+
+	for _, src := range g.Sources("tenant_0002") {
+		wantOverridden := src.Pattern != ""
+		if src.Overridden != wantOverridden {
+			t.Errorf("Sources(tenant_0002): grant %q overridden = %v, want %v", src.Pattern, src.Overridden, wantOverridden)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package middleware
+
+import (
+	"fmt"
+	"strings"
+
+	"monji/internal/models"
+)
+
+// PermissionStep is one level of a permission check, from the user's role down
+// to the requested collection.
+type PermissionStep struct {
+	Level      string             `json:"level"`      // "role", "environment", "database", "collection" or "redaction"
+	Permission string             `json:"permission"` // permission resolved at this level
+	Detail     string             `json:"detail"`
+	Sources    []PermissionSource `json:"sources,omitempty"`
+	Decisive   bool               `json:"decisive,omitempty"` // this step settled the effective permission
+}
+
+// PermissionExplanation is the effective permission of a user on an environment,
+// a database or a collection, with the chain of rules that produced it.
+type PermissionExplanation struct {
+	UserID        int              `json:"user_id"`
+	Role          string           `json:"role"`
+	Capabilities  []string         `json:"capabilities"`
+	EnvironmentID int              `json:"environment_id"`
+	DBName        string           `json:"db_name,omitempty"`
+	Collection    string           `json:"collection,omitempty"`
+	Permission    string           `json:"permission"` // "none", "readOnly" or "readAndWrite"
+	Masked        bool             `json:"masked"`     // redacted fields are masked (see ExplainRedaction)
+	Steps         []PermissionStep `json:"steps"`
+}
+
+// ExplainPermission resolves the permission of a user on an environment, and on one of
+// its databases and collections when dbName/collName are set, and records each step of
+// the decision. The decision is the one HasEnvPermission and HasDBPermission take.
+// The user's capabilities must have been loaded (see RoleCapabilities).
+func ExplainPermission(user models.User, envID int, dbName, collName string) (*PermissionExplanation, error) {
+	d, err := decideAccess(user, envID, dbName)
+	if err != nil {
+		return nil, err
+	}
+	ex := &PermissionExplanation{
+		UserID:        user.ID,
+		Role:          user.Role,
+		Capabilities:  user.Capabilities,
+		EnvironmentID: envID,
+		DBName:        dbName,
+		Collection:    collName,
+		Permission:    d.permission,
+	}
+
+	if d.admin {
+		ex.Steps = append(ex.Steps, PermissionStep{
+			Level:      "role",
+			Permission: "readAndWrite",
+			Detail:     fmt.Sprintf("Role %q has the %s capability: full access to every environment and database", user.Role, models.CapFullDataAccess),
+			Decisive:   true,
+		})
+		return ex, nil
+	}
+	ex.Steps = append(ex.Steps, PermissionStep{
+		Level:      "role",
+		Permission: "none",
+		Detail:     fmt.Sprintf("Role %q lacks the %s capability: access comes only from grants", user.Role, models.CapFullDataAccess),
+	})
+
+	envStep := PermissionStep{
+		Level:      "environment",
+		Permission: d.envPerm,
+		Detail:     explainSources("environment", d.envSources, d.envPerm),
+		Sources:    d.envSources,
+	}
+	if !d.dbChecked {
+		if dbName != "" {
+			envStep.Detail += "; database access requires read access to the environment"
+		}
+		envStep.Decisive = true
+		ex.Steps = append(ex.Steps, envStep)
+		return ex, nil
+	}
+	ex.Steps = append(ex.Steps, envStep)
+
+	ex.Steps = append(ex.Steps, PermissionStep{
+		Level:      "database",
+		Permission: d.dbPerm,
+		Detail:     explainSources("database", d.dbSources, d.dbPerm),
+		Sources:    d.dbSources,
+		Decisive:   collName == "",
+	})
+
+	if collName != "" {
+		ex.Steps = append(ex.Steps, PermissionStep{
+			Level:      "collection",
+			Permission: ex.Permission,
+			Detail:     "Collections have no grants of their own: the database permission applies",
+			Decisive:   true,
+		})
+	}
+	return ex, nil
+}
+
+// explainSources describes how grants resolved to perm (see ResolvePermission).
+func explainSources(level string, sources []PermissionSource, perm string) string {
+	var active []PermissionSource
+	overridden := 0
+	for _, src := range sources {
+		if src.Overridden {
+			overridden++
+			continue
+		}
+		active = append(active, src)
+	}
+	if len(active) == 0 {
+		return "No " + level + " grant"
+	}
+
+	var from []string
+	for _, src := range active {
+		if src.Permission == perm {
+			from = append(from, describeSource(src))
+		}
+	}
+	var detail string
+	if perm == PermissionDeny {
+		detail = "Denied by " + strings.Join(from, ", ") + ", which overrides any other grant"
+	} else {
+		detail = perm + " granted by " + strings.Join(from, ", ")
+		if len(active) > 1 {
+			detail += fmt.Sprintf(" (most permissive of %d grants)", len(active))
+		}
+	}
+	if overridden > 0 {
+		detail += fmt.Sprintf("; %d less specific grant(s) overridden", overridden)
+	}
+	return detail
+}
+
+func describeSource(src PermissionSource) string {
+	var s string
+	if src.Type == "group" {
+		s = fmt.Sprintf("group %q", src.GroupName)
+	} else {
+		s = "the user's own grant"
+	}
+	if src.Pattern != "" {
+		s += fmt.Sprintf(" on pattern %q", src.Pattern)
+	}
+	if src.ExpiresAt != nil {
+		s += " until " + src.ExpiresAt.Format("2006-01-02T15:04:05Z07:00")
+	}
+	return s
+}
@@ -2,119 +2,311 @@ package middleware
 
 import (
 	"database/sql"
-	"errors"
 	"fmt"
+	"time"
 
 	"monji/internal/database"
 	"monji/internal/models"
 )
 
-// IsAdmin returns true if user's role is "admin" or "superadmin".
+// IsAdmin returns true if the user's role has full data access: such users bypass
+// environment and database grants.
 func IsAdmin(user models.User) bool {
-	return user.Role == "admin" || user.Role == "superadmin"
+	return HasCapability(user, models.CapFullDataAccess)
 }
 
 // IsSuperAdmin returns true if user's role is "superadmin".
 func IsSuperAdmin(user models.User) bool {
-	return user.Role == "superadmin"
+	return user.Role == models.RoleSuperAdmin
 }
 
-// HasEnvPermission checks if the given user has the required environment permission.
-//
-// required can be "read" or "write".
-// - If user is admin/superadmin, return true immediately.
-// - Otherwise look at user_env_permissions table.
-//
-//	If required == "read", we accept both "readOnly" or "readAndWrite" stored in DB.
-//	If required == "write", we accept only "readAndWrite" stored in DB.
-func HasEnvPermission(user models.User, envID int, required string) (bool, error) {
-	// admin or superadmin => automatically pass
-	if IsAdmin(user) {
-		return true, nil
+// EffectivePermissionSQL returns the SQL expression of the permission a grant row
+// currently gives, for the permissions table aliased as alias ("" for no alias).
+// A time-bound grant gives its permission until expires_at, then falls back to the
+// permission it replaced (revert_permission, "" for none).
+// The expression takes the current time (UTC) as its single parameter.
+func EffectivePermissionSQL(alias string) string {
+	if alias != "" {
+		alias += "."
 	}
+	return "CASE WHEN " + alias + "expires_at IS NULL OR " + alias + "expires_at > ? THEN " +
+		alias + "permission ELSE " + alias + "revert_permission END"
+}
+
+// PermissionDeny is an explicit denial: it overrides every other grant on the same target.
+const PermissionDeny = "deny"
 
-	// normal user => check user_env_permissions
-	row := database.DB.QueryRow(
-		`SELECT permission FROM user_env_permissions WHERE user_id = ? AND environment_id = ?`,
-		user.ID, envID,
+// PermissionSource is one grant contributing to a user's permission on an
+// environment or a database: the user's own grant or the grant of one of their groups.
+type PermissionSource struct {
+	Type       string     `json:"type"` // "user" or "group"
+	GroupID    int        `json:"group_id,omitempty"`
+	GroupName  string     `json:"group_name,omitempty"`
+	Permission string     `json:"permission"` // "readOnly", "readAndWrite" or "deny"
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	Pattern    string     `json:"pattern,omitempty"`    // database grants given by a pattern, e.g. "tenant_*"
+	Overridden bool       `json:"overridden,omitempty"` // a more specific database grant applies instead (never for a deny)
+}
+
+// EnvPermissionSources returns the grants of a user on an environment:
+// their own (unless expired with nothing to fall back to) and those of their groups.
+func EnvPermissionSources(userID, envID int) ([]PermissionSource, error) {
+	return permissionSources(
+		`SELECT `+EffectivePermissionSQL("")+`, expires_at FROM user_env_permissions
+		 WHERE user_id = ? AND environment_id = ?`,
+		`SELECT g.id, g.name, p.permission
+		   FROM group_env_permissions p
+		   JOIN groups g ON g.id = p.group_id
+		   JOIN group_members m ON m.group_id = p.group_id
+		  WHERE m.user_id = ? AND p.environment_id = ?
+		  ORDER BY g.id`,
+		userID, envID,
 	)
+}
 
+// DBPermissionSources returns the grants of a user on a database, like EnvPermissionSources.
+// Database grants may name the database with a glob pattern (see DBGrants).
+func DBPermissionSources(userID, envID int, dbName string) ([]PermissionSource, error) {
+	grants, err := loadDBGrants(userID, envID)
+	if err != nil {
+		return nil, err
+	}
+	return grants.Sources(dbName), nil
+}
+
+func permissionSources(userQuery, groupQuery string, params ...interface{}) ([]PermissionSource, error) {
+	var sources []PermissionSource
+
+	now := time.Now().UTC()
 	var perm string
-	err := row.Scan(&perm)
+	var expiresAt sql.NullTime
+	err := database.DB.QueryRow(userQuery, append([]interface{}{now}, params...)...).Scan(&perm, &expiresAt)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if err == nil && perm != "" {
+		src := PermissionSource{Type: "user", Permission: perm}
+		if expiresAt.Valid && expiresAt.Time.After(now) {
+			src.ExpiresAt = &expiresAt.Time
+		}
+		sources = append(sources, src)
+	}
+
+	rows, err := database.DB.Query(groupQuery, params...)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			// no permission row => no access
-			return false, nil
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		src := PermissionSource{Type: "group"}
+		if err := rows.Scan(&src.GroupID, &src.GroupName, &src.Permission); err != nil {
+			return nil, err
 		}
-		return false, err
+		sources = append(sources, src)
 	}
+	return sources, rows.Err()
+}
 
-	switch required {
-	case "read":
-		// "readOnly" or "readAndWrite" are acceptable
-		if perm == "readOnly" || perm == "readAndWrite" {
-			return true, nil
+// ResolvePermission merges grants: a "deny" wins, otherwise the most permissive grant wins.
+// Overridden grants are ignored.
+// It returns "deny", "readAndWrite", "readOnly" or "none" when there is no grant.
+func ResolvePermission(sources []PermissionSource) string {
+	resolved := "none"
+	for _, src := range sources {
+		if src.Overridden {
+			continue
 		}
-		return false, nil
-	case "write":
-		// must be "readAndWrite" to proceed
-		if perm == "readAndWrite" {
-			return true, nil
+		switch src.Permission {
+		case PermissionDeny:
+			return PermissionDeny
+		case "readAndWrite":
+			resolved = "readAndWrite"
+		case "readOnly":
+			if resolved == "none" {
+				resolved = "readOnly"
+			}
 		}
-		return false, nil
-	default:
-		return false, errors.New("invalid required permission type")
 	}
+	return resolved
 }
 
-// HasDBPermission checks if the user has the required database permission.
-//
-// required can be "read" or "write".
-//   - If user is admin/superadmin, return true immediately.
-//   - Otherwise, user must have at least read permission on the environment AND
-//     must have at least the required permission in user_db_permissions for that db.
-func HasDBPermission(user models.User, envID int, dbName string, required string) (bool, error) {
-	if IsAdmin(user) {
-		return true, nil
-	}
+// EnvPermissions returns the effective permission of a user on every environment they
+// hold a grant on, own or through a group (see ResolvePermission), loading all their
+// environment grants at once. Environments without a grant are left out.
+// Admins get no entry: use EnvPermission for them.
+func EnvPermissions(user models.User) (map[int]string, error) {
+	sources := map[int][]PermissionSource{}
 
-	// must have environment read permission as a baseline
-	hasEnvRead, err := HasEnvPermission(user, envID, "read")
+	now := time.Now().UTC()
+	rows, err := database.DB.Query(
+		`SELECT environment_id, `+EffectivePermissionSQL("")+`, expires_at FROM user_env_permissions WHERE user_id = ?`,
+		now, user.ID,
+	)
 	if err != nil {
-		return false, err
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var envID int
+		var expiresAt sql.NullTime
+		src := PermissionSource{Type: "user"}
+		if err := rows.Scan(&envID, &src.Permission, &expiresAt); err != nil {
+			return nil, err
+		}
+		if src.Permission == "" {
+			continue
+		}
+		if expiresAt.Valid && expiresAt.Time.After(now) {
+			src.ExpiresAt = &expiresAt.Time
+		}
+		sources[envID] = append(sources[envID], src)
 	}
-	if !hasEnvRead {
-		return false, nil
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 
-	// Now check user_db_permissions
-	row := database.DB.QueryRow(
-		`SELECT permission FROM user_db_permissions WHERE user_id = ? AND environment_id = ? AND db_name = ?`,
-		user.ID, envID, dbName,
+	groupRows, err := database.DB.Query(
+		`SELECT p.environment_id, g.id, g.name, p.permission
+		   FROM group_env_permissions p
+		   JOIN groups g ON g.id = p.group_id
+		   JOIN group_members m ON m.group_id = p.group_id
+		  WHERE m.user_id = ?
+		  ORDER BY g.id`,
+		user.ID,
 	)
-
-	var perm string
-	err = row.Scan(&perm)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			// no permission => no access
-			return false, nil
+		return nil, err
+	}
+	defer groupRows.Close()
+	for groupRows.Next() {
+		var envID int
+		src := PermissionSource{Type: "group"}
+		if err := groupRows.Scan(&envID, &src.GroupID, &src.GroupName, &src.Permission); err != nil {
+			return nil, err
 		}
-		return false, err
+		sources[envID] = append(sources[envID], src)
+	}
+	if err := groupRows.Err(); err != nil {
+		return nil, err
+	}
+
+	perms := make(map[int]string, len(sources))
+	for envID, srcs := range sources {
+		perms[envID] = ResolvePermission(srcs)
+	}
+	return perms, nil
+}
+
+// accessDecision is the resolution of a user's permission on an environment and, when
+// a database is named, on that database. HasEnvPermission, HasDBPermission and
+// ExplainPermission all derive from it, so that an explanation matches the decision.
+type accessDecision struct {
+	admin      bool
+	envSources []PermissionSource
+	envPerm    string // resolved environment permission (see ResolvePermission)
+	dbChecked  bool   // the database grants were resolved: the environment is readable
+	dbSources  []PermissionSource
+	dbPerm     string
+	permission string // effective access: "none", "readOnly" or "readAndWrite"
+}
+
+// decideAccess resolves the permission of a user on an environment, and on one of its
+// databases when dbName is set: database access requires read access to the environment.
+func decideAccess(user models.User, envID int, dbName string) (*accessDecision, error) {
+	if IsAdmin(user) {
+		return &accessDecision{admin: true, envPerm: "readAndWrite", dbPerm: "readAndWrite", permission: "readAndWrite"}, nil
+	}
+	d := &accessDecision{}
+	var err error
+	if d.envSources, err = EnvPermissionSources(user.ID, envID); err != nil {
+		return nil, err
+	}
+	d.envPerm = ResolvePermission(d.envSources)
+	if dbName == "" {
+		d.permission = effectiveOf(d.envPerm)
+		return d, nil
+	}
+	if d.envPerm != "readOnly" && d.envPerm != "readAndWrite" {
+		d.permission = "none"
+		return d, nil
+	}
+	grants, err := loadDBGrants(user.ID, envID)
+	if err != nil {
+		return nil, err
 	}
+	d.dbChecked = true
+	d.dbSources = grants.Sources(dbName)
+	d.dbPerm = ResolvePermission(d.dbSources)
+	d.permission = effectiveOf(d.dbPerm)
+	return d, nil
+}
 
+// allows returns true if the decided permission gives the required access ("read" or "write").
+func (d *accessDecision) allows(required string) (bool, error) {
 	switch required {
 	case "read":
-		if perm == "readOnly" || perm == "readAndWrite" {
-			return true, nil
-		}
-		return false, nil
+		return d.permission == "readOnly" || d.permission == "readAndWrite", nil
 	case "write":
-		if perm == "readAndWrite" {
-			return true, nil
-		}
-		return false, nil
+		return d.permission == "readAndWrite", nil
 	default:
-		return false, fmt.Errorf("invalid required DB permission type: %s", required)
+		return false, fmt.Errorf("invalid required permission type: %s", required)
+	}
+}
+
+// effectiveOf maps a resolved permission to the access it gives.
+func effectiveOf(perm string) string {
+	if perm == PermissionDeny {
+		return "none"
+	}
+	return perm
+}
+
+// EnvPermission returns the effective permission of a user on an environment
+// (see ResolvePermission). Admins and superadmins always get "readAndWrite".
+func EnvPermission(user models.User, envID int) (string, error) {
+	d, err := decideAccess(user, envID, "")
+	if err != nil {
+		return "", err
+	}
+	return d.envPerm, nil
+}
+
+// DBPermission returns the effective permission of a user on a database, not
+// taking the environment permission into account (see HasDBPermission).
+func DBPermission(user models.User, envID int, dbName string) (string, error) {
+	grants, err := LoadDBGrants(user, envID)
+	if err != nil {
+		return "", err
+	}
+	return grants.Permission(dbName), nil
+}
+
+// HasEnvPermission checks if the given user has the required environment permission.
+//
+// required can be "read" or "write".
+// - If user is admin/superadmin, return true immediately.
+// - Otherwise merge the user's own grant with the grants of their groups (see ResolvePermission).
+//
+//	If required == "read", we accept both "readOnly" or "readAndWrite".
+//	If required == "write", we accept only "readAndWrite".
+func HasEnvPermission(user models.User, envID int, required string) (bool, error) {
+	d, err := decideAccess(user, envID, "")
+	if err != nil {
+		return false, err
+	}
+	return d.allows(required)
+}
+
+// HasDBPermission checks if the user has the required database permission.
+//
+// required can be "read" or "write".
+//   - If user is admin/superadmin, return true immediately.
+//   - Otherwise, user must have at least read permission on the environment AND
+//     must have at least the required permission on that db, merging user and group grants.
+func HasDBPermission(user models.User, envID int, dbName string, required string) (bool, error) {
+	d, err := decideAccess(user, envID, dbName)
+	if err != nil {
+		return false, err
 	}
+	return d.allows(required)
 }
@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"fmt"
+	"path"
+
+	"monji/internal/database"
+	"monji/internal/models"
+)
+
+// CanViewUnmasked returns true if the user sees the documents of a database without
+// redaction: through the view_unmasked capability, or an unmasked grant of their own
+// or of one of their groups whose database pattern matches dbName.
+func CanViewUnmasked(user models.User, envID int, dbName string) (bool, error) {
+	source, err := unmaskedSource(user, envID, dbName)
+	return source != "", err
+}
+
+// unmaskedSource describes what lets the user see a database unmasked (see
+// CanViewUnmasked), or returns "" if the user sees it masked.
+func unmaskedSource(user models.User, envID int, dbName string) (string, error) {
+	if HasCapability(user, models.CapViewUnmasked) {
+		return fmt.Sprintf("Role %q has the %s capability", user.Role, models.CapViewUnmasked), nil
+	}
+	rows, err := database.DB.Query(
+		`SELECT u.db_name, u.group_id, COALESCE(g.name, '') FROM unmasked_grants u
+		   LEFT JOIN groups g ON g.id = u.group_id
+		 WHERE u.environment_id = ? AND (u.user_id = ?
+		        OR u.group_id IN (SELECT group_id FROM group_members WHERE user_id = ?))
+		 ORDER BY u.id`,
+		envID, user.ID, user.ID,
+	)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var pattern, groupName string
+		var groupID int
+		if err := rows.Scan(&pattern, &groupID, &groupName); err != nil {
+			return "", err
+		}
+		if ok, _ := path.Match(pattern, dbName); !ok {
+			continue
+		}
+		if groupID != 0 {
+			return fmt.Sprintf("Unmasked grant of group %q on %q", groupName, pattern), nil
+		}
+		return fmt.Sprintf("The user's own unmasked grant on %q", pattern), nil
+	}
+	return "", rows.Err()
+}
+
+// ExplainRedaction adds to an explanation whether the user reads the documents of its
+// database masked, given the number of redaction rules applying to them. It adds
+// nothing when the user cannot read the database.
+func ExplainRedaction(user models.User, ex *PermissionExplanation, rules int) error {
+	if ex.DBName == "" || ex.Permission == "none" {
+		return nil
+	}
+	step := PermissionStep{Level: "redaction", Permission: "unmasked"}
+	if rules == 0 {
+		step.Detail = "No redaction rule applies"
+		ex.Steps = append(ex.Steps, step)
+		return nil
+	}
+	source, err := unmaskedSource(user, ex.EnvironmentID, ex.DBName)
+	if err != nil {
+		return err
+	}
+	if source != "" {
+		step.Detail = fmt.Sprintf("%d redaction rule(s) apply, lifted by: %s", rules, source)
+	} else {
+		ex.Masked = true
+		step.Permission = "masked"
+		step.Detail = fmt.Sprintf("%d redaction rule(s) apply and the user has no unmasked grant: redacted fields are masked", rules)
+	}
+	ex.Steps = append(ex.Steps, step)
+	return nil
+}
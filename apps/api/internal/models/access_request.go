@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// Access request statuses.
+const (
+	AccessRequestPending   = "pending"
+	AccessRequestApproved  = "approved"
+	AccessRequestRejected  = "rejected"
+	AccessRequestCancelled = "cancelled"
+)
+
+// AccessRequest is a user's request for temporary access to an environment or one of
+// its databases. Once an admin approves it, the user gets a time-bound grant.
+type AccessRequest struct {
+	ID              int        `json:"id"`
+	UserID          int        `json:"user_id"`
+	EnvironmentID   int        `json:"environment_id"`
+	DBName          string     `json:"db_name,omitempty"` // empty for environment-level access
+	Permission      string     `json:"permission"`        // "readOnly" or "readAndWrite"
+	DurationMinutes int        `json:"duration_minutes"`
+	Reason          string     `json:"reason,omitempty"`
+	Status          string     `json:"status"` // "pending", "approved", "rejected", "cancelled"
+	ReviewedBy      int        `json:"reviewed_by,omitempty"`
+	ReviewComment   string     `json:"review_comment,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	ReviewedAt      *time.Time `json:"reviewed_at,omitempty"`
+	ExpiresAt       *time.Time `json:"expires_at,omitempty"` // end of the granted access
+}
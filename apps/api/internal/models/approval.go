@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// Destructive approval statuses.
+const (
+	ApprovalPending  = "pending"
+	ApprovalApproved = "approved"
+	ApprovalRejected = "rejected"
+	ApprovalUsed     = "used"
+)
+
+// DestructiveApproval is a request to run a destructive operation on an environment
+// protected with the "approval" level. Another admin must approve it, then the
+// requester retries the operation with the approval ID.
+type DestructiveApproval struct {
+	ID            int        `json:"id"`
+	EnvironmentID int        `json:"environment_id"`
+	Action        string     `json:"action"` // e.g. "dropDatabase"
+	Target        string     `json:"target"` // e.g. "app" or "app.orders"
+	RequestedBy   int        `json:"requested_by"`
+	Status        string     `json:"status"` // "pending", "approved", "rejected", "used"
+	ReviewedBy    int        `json:"reviewed_by,omitempty"`
+	Comment       string     `json:"comment,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	ReviewedAt    *time.Time `json:"reviewed_at,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"` // an approval must be used before it expires
+}
@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// Backup statuses.
+const (
+	BackupPending   = "pending"
+	BackupCompleted = "completed"
+	BackupFailed    = "failed"
+)
+
+// Backup represents a logical backup (mongodump archive) stored on local disk.
+type Backup struct {
+	ID            int        `json:"id"`
+	EnvironmentID int        `json:"environment_id"`
+	DBName        string     `json:"db_name"`
+	Collections   []string   `json:"collections"` // empty means the whole database
+	Path          string     `json:"path"`
+	SizeBytes     int64      `json:"size_bytes"`
+	Compression   string     `json:"compression"` // "gzip" or "none"
+	Status        string     `json:"status"`      // "pending", "completed", "failed"
+	Error         string     `json:"error,omitempty"`
+	JobID         int        `json:"job_id"`
+	PolicyID      int        `json:"policy_id,omitempty"` // 0 for on-demand backups
+	CreatedBy     int        `json:"created_by"`
+	CreatedAt     time.Time  `json:"created_at"`
+	CompletedAt   *time.Time `json:"completed_at,omitempty"`
+}
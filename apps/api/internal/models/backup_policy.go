@@ -0,0 +1,46 @@
+package models
+
+import "time"
+
+// Backup policy run statuses.
+const (
+	PolicyRunPending   = "pending" // recorded, backup job not submitted yet
+	PolicyRunRunning   = "running"
+	PolicyRunSucceeded = "succeeded"
+	PolicyRunFailed    = "failed"
+	PolicyRunSkipped   = "skipped"
+)
+
+// BackupPolicy schedules recurring backups of a database.
+type BackupPolicy struct {
+	ID             int        `json:"id"`
+	Name           string     `json:"name"`
+	EnvironmentID  int        `json:"environment_id"`
+	DBName         string     `json:"db_name"`
+	Collections    []string   `json:"collections"` // empty means the whole database
+	Schedule       string     `json:"schedule"`    // cron expression, evaluated in UTC
+	TargetPath     string     `json:"target_path"` // empty means the default backup directory
+	RetentionCount int        `json:"retention_count"`
+	RetentionDays  int        `json:"retention_days"`
+	Compression    string     `json:"compression"` // "gzip" or "none"
+	Enabled        bool       `json:"enabled"`
+	CreatedBy      int        `json:"created_by"`
+	CreatedAt      time.Time  `json:"created_at"`
+	LastRunAt      *time.Time `json:"last_run_at,omitempty"`
+	NextRunAt      *time.Time `json:"next_run_at,omitempty"`
+	// DeletedAt is set when the policy is deleted; its run history is kept.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// BackupPolicyRun records one scheduled (or manually triggered) execution of a policy.
+type BackupPolicyRun struct {
+	ID         int        `json:"id"`
+	PolicyID   int        `json:"policy_id"`
+	BackupID   int        `json:"backup_id,omitempty"`
+	JobID      int        `json:"job_id,omitempty"`
+	Status     string     `json:"status"` // "pending", "running", "succeeded", "failed", "skipped"
+	Error      string     `json:"error,omitempty"`
+	Pruned     int        `json:"pruned"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
@@ -0,0 +1,59 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Change request kinds.
+const (
+	ChangeInsertDocument = "insertDocument"
+	ChangeUpdateDocument = "updateDocument"
+	ChangeDeleteDocument = "deleteDocument"
+	ChangeUpdateMany     = "updateMany"
+	ChangeCreateIndex    = "createIndex"
+	ChangeDropIndex      = "dropIndex"
+)
+
+// Change request statuses.
+const (
+	ChangeRequestPending   = "pending"
+	ChangeRequestApplying  = "applying" // approved, being applied
+	ChangeRequestRejected  = "rejected"
+	ChangeRequestCancelled = "cancelled"
+	ChangeRequestApplied   = "applied"
+	ChangeRequestFailed    = "failed"
+)
+
+// ChangeRequest is a write proposed by a user, applied by Monji once another
+// user holding write permission on the database approves it.
+type ChangeRequest struct {
+	ID             int             `json:"id"`
+	EnvironmentID  int             `json:"environment_id"`
+	DBName         string          `json:"db_name"`
+	CollectionName string          `json:"collection_name"`
+	Kind           string          `json:"kind"`
+	Title          string          `json:"title"`
+	Description    string          `json:"description,omitempty"`
+	Payload        json.RawMessage `json:"payload"`                 // extended JSON, depends on Kind
+	BaseDocument   json.RawMessage `json:"base_document,omitempty"` // the target document when the request was made
+	Status         string          `json:"status"`                  // "pending", "rejected", "cancelled", "applied", "failed"
+	RequestedBy    int             `json:"requested_by"`
+	ReviewedBy     int             `json:"reviewed_by,omitempty"`
+	ReviewComment  string          `json:"review_comment,omitempty"`
+	Result         json.RawMessage `json:"result,omitempty"`
+	Error          string          `json:"error,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+	AppliedAt      *time.Time      `json:"applied_at,omitempty"`
+}
+
+// ChangeRequestEvent records a step of the lifecycle of a change request.
+type ChangeRequestEvent struct {
+	ID              int       `json:"id"`
+	ChangeRequestID int       `json:"change_request_id"`
+	Event           string    `json:"event"` // "created", "approved", "rejected", "cancelled", "applied", "failed"
+	UserID          int       `json:"user_id"`
+	Details         string    `json:"details,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+}
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// Operations recorded in a document's history.
+const (
+	RevisionUpdate = "update"
+	RevisionDelete = "delete"
+	RevisionRevert = "revert"
+)
+
+// DocumentRevision is the version of a document before a write made through Monji.
+// The revision's document itself is stored as canonical extended JSON.
+type DocumentRevision struct {
+	ID             int       `json:"id"`
+	EnvironmentID  int       `json:"environment_id"`
+	DBName         string    `json:"db_name"`
+	CollectionName string    `json:"collection_name"`
+	DocumentID     string    `json:"document_id"`
+	Operation      string    `json:"operation"` // the write that replaced this version: "update", "delete", "revert"
+	UserID         int       `json:"user_id"`
+	CreatedAt      time.Time `json:"created_at"`
+}
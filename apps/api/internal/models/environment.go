@@ -1,9 +1,17 @@
 package models
 
+// Environment protection levels.
+const (
+	ProtectionNone     = "none"     // destructive operations run immediately
+	ProtectionConfirm  = "confirm"  // destructive operations must name their target
+	ProtectionApproval = "approval" // destructive operations must name their target and be approved by another admin
+)
+
 // Environment represents a MongoDB environment configuration.
 type Environment struct {
 	ID               int    `json:"id"`
 	Name             string `json:"name"`
 	ConnectionString string `json:"connection_string"`
 	CreatedBy        int    `json:"created_by"`
+	ProtectionLevel  string `json:"protection_level"` // "none", "confirm" or "approval"
 }
@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// Group is a team of users. Its members inherit the group's environment and database
+// permissions, merged with their own grants (see middleware.ResolvePermission).
+type Group struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	MemberCount int       `json:"member_count"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// GroupEnvPermission represents a group's permission on a specific environment.
+type GroupEnvPermission struct {
+	EnvironmentID   int    `json:"environment_id"`
+	EnvironmentName string `json:"environment_name"`
+	Permission      string `json:"permission"` // "readOnly", "readAndWrite", "deny"
+}
+
+// GroupDBPermission represents a group's permission on a specific database within an environment.
+type GroupDBPermission struct {
+	EnvironmentID   int    `json:"environment_id"`
+	EnvironmentName string `json:"environment_name"`
+	DBName          string `json:"db_name"`
+	Permission      string `json:"permission"` // "readOnly", "readAndWrite", "deny"
+}
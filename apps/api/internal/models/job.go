@@ -0,0 +1,38 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Job statuses.
+const (
+	JobPending   = "pending"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// Job represents a long-running operation executed in the background.
+type Job struct {
+	ID              int             `json:"id"`
+	Type            string          `json:"type"`
+	Status          string          `json:"status"` // "pending", "running", "succeeded", "failed", "cancelled"
+	EnvironmentID   int             `json:"environment_id,omitempty"`
+	Params          json.RawMessage `json:"params,omitempty"`
+	Progress        int             `json:"progress"` // 0-100
+	ProgressMessage string          `json:"progress_message,omitempty"`
+	Result          json.RawMessage `json:"result,omitempty"`
+	Error           string          `json:"error,omitempty"`
+	CancelRequested bool            `json:"cancel_requested"`
+	CreatedBy       int             `json:"created_by"`
+	CreatedAt       time.Time       `json:"created_at"`
+	StartedAt       *time.Time      `json:"started_at,omitempty"`
+	FinishedAt      *time.Time      `json:"finished_at,omitempty"`
+}
+
+// Finished returns true if the job reached a terminal status.
+func (j Job) Finished() bool {
+	return j.Status == JobSucceeded || j.Status == JobFailed || j.Status == JobCancelled
+}
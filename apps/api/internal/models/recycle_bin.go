@@ -0,0 +1,43 @@
+package models
+
+import "time"
+
+// Recycle bin entry kinds.
+const (
+	RecycleCollection = "collection"
+	RecycleDatabase   = "database"
+)
+
+// Recycle bin entry statuses.
+const (
+	RecyclePending   = "pending" // the database snapshot is being written
+	RecycleTrashed   = "trashed"
+	RecycleRestoring = "restoring" // a restore is running
+	RecycleRestored  = "restored"
+	RecyclePurged    = "purged"
+	RecycleFailed    = "failed"
+)
+
+// RecycleBinEntry is a soft-dropped collection or database that can be restored until it expires.
+// Collections are renamed into a quarantine namespace (TrashName); databases are
+// snapshotted to an archive file (ArchivePath) before being dropped.
+type RecycleBinEntry struct {
+	ID             int        `json:"id"`
+	Kind           string     `json:"kind"` // "collection" or "database"
+	EnvironmentID  int        `json:"environment_id"`
+	DBName         string     `json:"db_name"`
+	CollectionName string     `json:"collection_name,omitempty"`
+	CollectionType string     `json:"collection_type,omitempty"` // "collection" or "view"
+	TrashName      string     `json:"trash_name,omitempty"`
+	ViewOptions    string     `json:"-"` // extended JSON of the view definition
+	ArchivePath    string     `json:"archive_path,omitempty"`
+	SizeBytes      int64      `json:"size_bytes"`
+	Status         string     `json:"status"` // "pending", "trashed", "restoring", "restored", "purged", "failed"
+	Error          string     `json:"error,omitempty"`
+	JobID          int        `json:"job_id,omitempty"`
+	DroppedBy      int        `json:"dropped_by"`
+	DroppedAt      time.Time  `json:"dropped_at"`
+	ExpiresAt      time.Time  `json:"expires_at"`
+	RestoredAt     *time.Time `json:"restored_at,omitempty"`
+	PurgedAt       *time.Time `json:"purged_at,omitempty"`
+}
@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// Masking styles of a redaction rule.
+const (
+	RedactHash    = "hash"    // replaced by a short keyed SHA-256 digest, equal values stay equal
+	RedactPartial = "partial" // only the first and last characters are kept
+	RedactRemove  = "remove"  // the field is removed from the document
+)
+
+// RedactionRule masks sensitive fields of the documents read by users without an
+// unmasked grant. DBName and Collection are glob patterns ("*" for any). A rule
+// selects values by Field (a dotted path, each segment a glob pattern), by ValueRegex
+// (string values matching it), or both.
+type RedactionRule struct {
+	ID            int       `json:"id"`
+	EnvironmentID int       `json:"environment_id"`
+	DBName        string    `json:"db_name"`
+	Collection    string    `json:"collection"`
+	Field         string    `json:"field,omitempty"`
+	ValueRegex    string    `json:"value_regex,omitempty"`
+	Style         string    `json:"style"`
+	Description   string    `json:"description,omitempty"`
+	CreatedBy     int       `json:"created_by"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// UnmaskedGrant lets a user, or the members of a group, see the databases of an
+// environment matching DBName without redaction.
+type UnmaskedGrant struct {
+	ID            int       `json:"id"`
+	UserID        int       `json:"user_id,omitempty"`
+	GroupID       int       `json:"group_id,omitempty"`
+	EnvironmentID int       `json:"environment_id"`
+	DBName        string    `json:"db_name"`
+	CreatedBy     int       `json:"created_by"`
+	CreatedAt     time.Time `json:"created_at"`
+}
@@ -0,0 +1,57 @@
+package models
+
+import "time"
+
+// Capabilities that a Monji role can grant.
+const (
+	// CapFullDataAccess gives read and write access to every environment and database
+	// without grants (what "admin" used to mean).
+	CapFullDataAccess = "full_data_access"
+	// CapManageEnvironments allows creating, updating and deleting environments, including
+	// their protection level and backup policies, reviewing destructive operations and
+	// using the recycle bin.
+	CapManageEnvironments = "manage_environments"
+	// CapManageUsers allows managing Monji users, roles, groups and grants.
+	CapManageUsers = "manage_users"
+	// CapManageMongoUsers allows managing MongoDB users on the databases the user can write to.
+	CapManageMongoUsers = "manage_mongo_users"
+	// CapDropDatabases allows dropping databases and collections the user can write to,
+	// and purging the recycle bin.
+	CapDropDatabases = "drop_databases"
+	// CapExportData allows backing up the databases the user can write to, and downloading
+	// and restoring the backups of the databases the user can read.
+	CapExportData = "export_data"
+	// CapViewAuditLog allows viewing every user's jobs, approvals and access requests.
+	CapViewAuditLog = "view_audit_log"
+	// CapViewUnmasked allows seeing documents without redaction, as an unmasked grant
+	// on every environment would.
+	CapViewUnmasked = "view_unmasked"
+)
+
+// AllCapabilities lists every capability, in display order.
+var AllCapabilities = []string{
+	CapFullDataAccess,
+	CapManageEnvironments,
+	CapManageUsers,
+	CapManageMongoUsers,
+	CapDropDatabases,
+	CapExportData,
+	CapViewAuditLog,
+	CapViewUnmasked,
+}
+
+// Built-in roles. They cannot be edited or deleted.
+const (
+	RoleSuperAdmin = "superadmin"
+	RoleAdmin      = "admin"
+	RoleUser       = "user"
+)
+
+// Role is a named set of capabilities assigned to Monji users.
+type Role struct {
+	Name         string    `json:"name"`
+	Description  string    `json:"description,omitempty"`
+	Capabilities []string  `json:"capabilities"`
+	BuiltIn      bool      `json:"built_in"`
+	CreatedAt    time.Time `json:"created_at"`
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Visibilities of a saved query.
+const (
+	QueryPrivate = "private" // only its owner
+	QueryTeam    = "team"    // the members of its group
+	QueryGlobal  = "global"  // every user
+)
+
+// SavedQuery is a named find (filter, sort, projection) or aggregation pipeline on a
+// collection. Running it re-checks the caller's permission on the collection.
+type SavedQuery struct {
+	ID             int             `json:"id"`
+	Name           string          `json:"name"`
+	Description    string          `json:"description"`
+	EnvironmentID  int             `json:"environment_id"`
+	DBName         string          `json:"db_name"`
+	CollectionName string          `json:"collection_name"`
+	Filter         json.RawMessage `json:"filter,omitempty"`     // extended JSON
+	Sort           json.RawMessage `json:"sort,omitempty"`       // extended JSON
+	Projection     json.RawMessage `json:"projection,omitempty"` // extended JSON
+	Pipeline       json.RawMessage `json:"pipeline,omitempty"`   // extended JSON, instead of filter/sort/projection
+	Visibility     string          `json:"visibility"`           // "private", "team", "global"
+	GroupID        int             `json:"group_id,omitempty"`   // team queries only
+	OwnerID        int             `json:"owner_id"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Webhook delivery statuses.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Trigger watches a database or collection and POSTs its change events to a URL.
+// Deliveries are signed with an HMAC-SHA256 of the body, keyed with the trigger's secret.
+type Trigger struct {
+	ID             int             `json:"id"`
+	Name           string          `json:"name"`
+	EnvironmentID  int             `json:"environment_id"`
+	DBName         string          `json:"db_name"`
+	Collection     string          `json:"collection,omitempty"` // empty means the whole database
+	OperationTypes []string        `json:"operation_types"`      // "insert", "update", "replace", "delete"
+	Filter         json.RawMessage `json:"filter,omitempty"`     // extended JSON filter on the change events
+	URL            string          `json:"url"`
+	Enabled        bool            `json:"enabled"`
+	LastError      string          `json:"last_error,omitempty"`
+	LastEventAt    *time.Time      `json:"last_event_at,omitempty"`
+	CreatedBy      int             `json:"created_by"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+}
+
+// TriggerDelivery is one change event sent (or to be sent) by a trigger.
+type TriggerDelivery struct {
+	ID             int             `json:"id"`
+	TriggerID      int             `json:"trigger_id"`
+	OperationType  string          `json:"operation_type"`
+	Status         string          `json:"status"` // "pending", "delivered", "failed"
+	Attempts       int             `json:"attempts"`
+	ResponseStatus int             `json:"response_status,omitempty"`
+	Error          string          `json:"error,omitempty"`
+	Payload        json.RawMessage `json:"payload,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at,omitempty"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+}
@@ -9,4 +9,6 @@ type User struct {
 	Company   string `json:"company,omitempty"`
 	Password  string `json:"password,omitempty"`
 	Role      string `json:"role"`
+	// Capabilities of the user's role, loaded by AuthMiddleware.
+	Capabilities []string `json:"capabilities,omitempty"`
 }
@@ -0,0 +1,24 @@
+package routes
+
+import (
+	"monji/internal/handlers"
+	"monji/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterAccessRequestRoutes sets up the endpoints of the self-service flow to request
+// temporary access to an environment or a database.
+// Users can create, see and cancel their own requests; only users with the manage_users
+// capability can approve or reject them.
+func RegisterAccessRequestRoutes(rg *gin.RouterGroup) {
+	accessGroup := rg.Group("/access-requests")
+	accessGroup.Use(middleware.AuthMiddleware())
+
+	accessGroup.POST("", handlers.CreateAccessRequest)
+	accessGroup.GET("", handlers.ListAccessRequests)
+	accessGroup.GET("/:requestId", handlers.GetAccessRequest)
+	accessGroup.POST("/:requestId/approve", handlers.ApproveAccessRequest)
+	accessGroup.POST("/:requestId/reject", handlers.RejectAccessRequest)
+	accessGroup.POST("/:requestId/cancel", handlers.CancelAccessRequest)
+}
@@ -0,0 +1,22 @@
+package routes
+
+import (
+	"monji/internal/handlers"
+	"monji/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterApprovalRoutes sets up the endpoints to review the destructive operations
+// requested on environments protected with the "approval" level.
+// Users can see their own requests; only users with the manage_environments capability can
+// approve or reject them.
+func RegisterApprovalRoutes(rg *gin.RouterGroup) {
+	approvalGroup := rg.Group("/approvals")
+	approvalGroup.Use(middleware.AuthMiddleware())
+
+	approvalGroup.GET("", handlers.ListApprovals)
+	approvalGroup.GET("/:approvalId", handlers.GetApproval)
+	approvalGroup.POST("/:approvalId/approve", handlers.ApproveApproval)
+	approvalGroup.POST("/:approvalId/reject", handlers.RejectApproval)
+}
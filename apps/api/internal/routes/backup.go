@@ -0,0 +1,26 @@
+package routes
+
+import (
+	"monji/internal/handlers"
+	"monji/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterBackupRoutes sets up the endpoints to create, list, download and restore backups.
+// The handlers check read/write permission on the backed-up and target databases.
+func RegisterBackupRoutes(rg *gin.RouterGroup) {
+	envGroup := rg.Group("/environments/:id")
+	envGroup.Use(middleware.AuthMiddleware())
+
+	envGroup.GET("/backups", handlers.ListBackups)
+	envGroup.POST("/databases/:dbName/backups", handlers.CreateBackup)
+
+	backupGroup := rg.Group("/backups")
+	backupGroup.Use(middleware.AuthMiddleware())
+
+	backupGroup.GET("/:backupId", handlers.GetBackup)
+	backupGroup.GET("/:backupId/download", handlers.DownloadBackup)
+	backupGroup.DELETE("/:backupId", handlers.DeleteBackup)
+	backupGroup.POST("/:backupId/restore", handlers.RestoreBackup)
+}
@@ -0,0 +1,24 @@
+package routes
+
+import (
+	"monji/internal/handlers"
+	"monji/internal/middleware"
+	"monji/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterBackupPolicyRoutes sets up the endpoints to manage scheduled backup policies.
+// Only users with the manage_environments capability can manage policies.
+func RegisterBackupPolicyRoutes(rg *gin.RouterGroup) {
+	policyGroup := rg.Group("/backup-policies")
+	policyGroup.Use(middleware.AuthMiddleware(), middleware.RequireCapability(models.CapManageEnvironments))
+
+	policyGroup.GET("", handlers.ListBackupPolicies)
+	policyGroup.POST("", handlers.CreateBackupPolicy)
+	policyGroup.GET("/:policyId", handlers.GetBackupPolicy)
+	policyGroup.PUT("/:policyId", handlers.UpdateBackupPolicy)
+	policyGroup.DELETE("/:policyId", handlers.DeleteBackupPolicy)
+	policyGroup.GET("/:policyId/runs", handlers.ListBackupPolicyRuns)
+	policyGroup.POST("/:policyId/run", handlers.RunBackupPolicy)
+}
@@ -0,0 +1,28 @@
+package routes
+
+import (
+	"monji/internal/handlers"
+	"monji/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterChangeRequestRoutes sets up the four-eyes change request workflow:
+// users propose writes on a collection, another user with write permission reviews
+// the diff and approves (Monji applies the write) or rejects them.
+func RegisterChangeRequestRoutes(rg *gin.RouterGroup) {
+	collGroup := rg.Group("/environments/:id/databases/:dbName/collections/:collName")
+	collGroup.Use(middleware.AuthMiddleware())
+
+	collGroup.POST("/change-requests", handlers.CreateChangeRequest)
+
+	crGroup := rg.Group("/change-requests")
+	crGroup.Use(middleware.AuthMiddleware())
+
+	crGroup.GET("", handlers.ListChangeRequests)
+	crGroup.GET("/:crId", handlers.GetChangeRequest)
+	crGroup.GET("/:crId/diff", handlers.GetChangeRequestDiff)
+	crGroup.POST("/:crId/approve", handlers.ApproveChangeRequest)
+	crGroup.POST("/:crId/reject", handlers.RejectChangeRequest)
+	crGroup.POST("/:crId/cancel", handlers.CancelChangeRequest)
+}
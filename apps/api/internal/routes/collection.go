@@ -18,4 +18,10 @@ func RegisterCollectionRoutes(rg *gin.RouterGroup) {
 	collGroup.POST("", handlers.CreateCollection)
 	collGroup.PUT("/:collName", handlers.EditCollection)
 	collGroup.DELETE("/:collName", handlers.DeleteCollection)
+	collGroup.GET("/:collName/watch", handlers.WatchChanges)
+	collGroup.GET("/:collName/schema", handlers.GetCollectionSchema)
+	collGroup.GET("/:collName/validator", handlers.GetCollectionValidator)
+	collGroup.PUT("/:collName/validator", handlers.UpdateCollectionValidator)
+	collGroup.GET("/:collName/validator/draft", handlers.GetCollectionValidatorDraft)
+	collGroup.POST("/:collName/validator/dry-run", handlers.DryRunCollectionValidator)
 }
@@ -18,4 +18,7 @@ func RegisterDatabaseRoutes(rg *gin.RouterGroup) {
 	dbGroup.GET("/:dbName", handlers.GetDatabaseDetails)
 	dbGroup.PUT("/:dbName", handlers.EditDatabase)
 	dbGroup.DELETE("/:dbName", handlers.DeleteDatabase)
+	dbGroup.GET("/:dbName/watch", handlers.WatchChanges)
+	dbGroup.POST("/:dbName/views", handlers.CreateView)
+	dbGroup.PUT("/:dbName/views/:viewName", handlers.UpdateView)
 }
@@ -17,4 +17,7 @@ func RegisterDocumentRoutes(rg *gin.RouterGroup) {
 	docGroup.PUT("/:docID", handlers.UpdateDocument)
 	docGroup.DELETE("/:docID", handlers.DeleteDocument)
 	docGroup.GET("/:docID", handlers.GetDocument)
+	docGroup.GET("/:docID/history", handlers.GetDocumentHistory)
+	docGroup.GET("/:docID/history/:revisionId", handlers.GetDocumentRevision)
+	docGroup.POST("/:docID/history/:revisionId/revert", handlers.RevertDocument)
 }
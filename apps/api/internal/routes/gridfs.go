@@ -0,0 +1,21 @@
+package routes
+
+import (
+	"monji/internal/handlers"
+	"monji/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterGridFSRoutes sets up the GridFS bucket browser.
+// The handlers check read/write permission on the database.
+func RegisterGridFSRoutes(rg *gin.RouterGroup) {
+	gridfsGroup := rg.Group("/environments/:id/databases/:dbName/gridfs")
+	gridfsGroup.Use(middleware.AuthMiddleware())
+
+	gridfsGroup.GET("", handlers.ListGridFSBuckets)
+	gridfsGroup.GET("/:bucket/files", handlers.ListGridFSFiles)
+	gridfsGroup.POST("/:bucket/files", handlers.UploadGridFSFile)
+	gridfsGroup.GET("/:bucket/files/:fileId", handlers.DownloadGridFSFile)
+	gridfsGroup.DELETE("/:bucket/files/:fileId", handlers.DeleteGridFSFile)
+}
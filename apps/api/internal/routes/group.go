@@ -0,0 +1,29 @@
+package routes
+
+import (
+	"monji/internal/handlers"
+	"monji/internal/middleware"
+	"monji/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterGroupRoutes sets up the endpoints to manage groups, their members and
+// the environment/db permissions the members inherit.
+// These endpoints require the manage_users capability.
+func RegisterGroupRoutes(rg *gin.RouterGroup) {
+	groupGroup := rg.Group("/groups")
+	groupGroup.Use(middleware.AuthMiddleware(), middleware.RequireCapability(models.CapManageUsers))
+
+	groupGroup.GET("", handlers.ListGroups)
+	groupGroup.POST("", handlers.CreateGroup)
+	groupGroup.GET("/:groupId", handlers.GetGroup)
+	groupGroup.PUT("/:groupId", handlers.UpdateGroup)
+	groupGroup.DELETE("/:groupId", handlers.DeleteGroup)
+
+	groupGroup.POST("/:groupId/members", handlers.AddGroupMembers)
+	groupGroup.DELETE("/:groupId/members/:userId", handlers.RemoveGroupMember)
+
+	groupGroup.POST("/:groupId/environments/:envId/permissions", handlers.SetGroupEnvironmentPermission)
+	groupGroup.POST("/:groupId/environments/:envId/databases/:dbName/permissions", handlers.SetGroupDBPermission)
+}
@@ -0,0 +1,20 @@
+package routes
+
+import (
+	"monji/internal/handlers"
+	"monji/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterJobRoutes sets up the endpoints to poll, cancel and retry background jobs.
+// The handlers restrict users without the view_audit_log capability to their own jobs.
+func RegisterJobRoutes(rg *gin.RouterGroup) {
+	jobGroup := rg.Group("/jobs")
+	jobGroup.Use(middleware.AuthMiddleware())
+
+	jobGroup.GET("", handlers.ListJobs)
+	jobGroup.GET("/:jobId", handlers.GetJob)
+	jobGroup.POST("/:jobId/cancel", handlers.CancelJob)
+	jobGroup.POST("/:jobId/retry", handlers.RetryJob)
+}
@@ -3,18 +3,19 @@ package routes
 import (
 	"monji/internal/handlers"
 	"monji/internal/middleware"
+	"monji/internal/models"
 
 	"github.com/gin-gonic/gin"
 )
 
-// RegisterPermissionsRoutes allows an admin or superadmin to set environment/db permissions for normal users.
+// RegisterPermissionsRoutes allows users with the manage_users capability to set environment/db permissions.
 //
 // POST /users/:userId/environments/:envId/permissions
 // POST /users/:userId/environments/:envId/databases/:dbName/permissions
 func RegisterPermissionsRoutes(rg *gin.RouterGroup) {
-	// Only admin or superadmin can change user permissions
+	// Only users with the manage_users capability can change permissions
 	adminGroup := rg.Group("/users/:userId")
-	adminGroup.Use(middleware.AuthMiddleware(), middleware.AdminMiddleware())
+	adminGroup.Use(middleware.AuthMiddleware(), middleware.RequireCapability(models.CapManageUsers))
 
 	// environment-level
 	adminGroup.POST("/environments/:envId/permissions", handlers.SetUserEnvironmentPermission)
@@ -0,0 +1,22 @@
+package routes
+
+import (
+	"monji/internal/handlers"
+	"monji/internal/middleware"
+	"monji/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterRecycleBinRoutes sets up the endpoints to list, restore and purge
+// soft-dropped collections and databases. They require the manage_environments capability;
+// purging also requires drop_databases.
+func RegisterRecycleBinRoutes(rg *gin.RouterGroup) {
+	recycleGroup := rg.Group("/recycle-bin")
+	recycleGroup.Use(middleware.AuthMiddleware(), middleware.RequireCapability(models.CapManageEnvironments))
+
+	recycleGroup.GET("", handlers.ListRecycleBin)
+	recycleGroup.GET("/:entryId", handlers.GetRecycleBinEntry)
+	recycleGroup.POST("/:entryId/restore", handlers.RestoreRecycleBinEntry)
+	recycleGroup.DELETE("/:entryId", handlers.PurgeRecycleBinEntry)
+}
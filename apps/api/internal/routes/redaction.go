@@ -0,0 +1,30 @@
+package routes
+
+import (
+	"monji/internal/handlers"
+	"monji/internal/middleware"
+	"monji/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterRedactionRoutes sets up the endpoints to manage redaction rules, which
+// require the manage_environments capability, and unmasked grants, which are
+// grants and thus require the manage_users capability.
+func RegisterRedactionRoutes(rg *gin.RouterGroup) {
+	ruleGroup := rg.Group("/redaction-rules")
+	ruleGroup.Use(middleware.AuthMiddleware(), middleware.RequireCapability(models.CapManageEnvironments))
+
+	ruleGroup.GET("", handlers.ListRedactionRules)
+	ruleGroup.POST("", handlers.CreateRedactionRule)
+	ruleGroup.GET("/:ruleId", handlers.GetRedactionRule)
+	ruleGroup.PUT("/:ruleId", handlers.UpdateRedactionRule)
+	ruleGroup.DELETE("/:ruleId", handlers.DeleteRedactionRule)
+
+	grantGroup := rg.Group("/unmasked-grants")
+	grantGroup.Use(middleware.AuthMiddleware(), middleware.RequireCapability(models.CapManageUsers))
+
+	grantGroup.GET("", handlers.ListUnmaskedGrants)
+	grantGroup.POST("", handlers.CreateUnmaskedGrant)
+	grantGroup.DELETE("/:grantId", handlers.DeleteUnmaskedGrant)
+}
@@ -0,0 +1,22 @@
+package routes
+
+import (
+	"monji/internal/handlers"
+	"monji/internal/middleware"
+	"monji/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterRoleRoutes sets up the endpoints to manage Monji roles and their capabilities.
+// These endpoints require the manage_users capability.
+func RegisterRoleRoutes(rg *gin.RouterGroup) {
+	roleGroup := rg.Group("/roles")
+	roleGroup.Use(middleware.AuthMiddleware(), middleware.RequireCapability(models.CapManageUsers))
+
+	roleGroup.GET("", handlers.ListRoles)
+	roleGroup.POST("", handlers.CreateRole)
+	roleGroup.GET("/:name", handlers.GetRole)
+	roleGroup.PUT("/:name", handlers.UpdateRole)
+	roleGroup.DELETE("/:name", handlers.DeleteRole)
+}
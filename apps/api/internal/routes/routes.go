@@ -21,9 +21,22 @@ func SetupRoutes(cfg *config.Config) *gin.Engine {
 	RegisterCollectionRoutes(api)
 	RegisterDocumentRoutes(api)
 	RegisterMongoUserRoutes(api)
-	RegisterUserRoutes(api)        // userGroup still has AdminMiddleware
-	RegisterPermissionsRoutes(api) // presumably also admin only
+	RegisterUserRoutes(api)        // userGroup requires the manage_users capability
+	RegisterPermissionsRoutes(api) // also manage_users
 	RegisterWhoAmIRoute(api)
+	RegisterJobRoutes(api)
+	RegisterBackupRoutes(api)
+	RegisterBackupPolicyRoutes(api)
+	RegisterRecycleBinRoutes(api)
+	RegisterApprovalRoutes(api)
+	RegisterChangeRequestRoutes(api)
+	RegisterAccessRequestRoutes(api)
+	RegisterGroupRoutes(api)
+	RegisterRoleRoutes(api)
+	RegisterRedactionRoutes(api)
+	RegisterTriggerRoutes(api)
+	RegisterGridFSRoutes(api)
+	RegisterSavedQueryRoutes(api)
 
 	return router
 }
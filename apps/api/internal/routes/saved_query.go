@@ -0,0 +1,23 @@
+package routes
+
+import (
+	"monji/internal/handlers"
+	"monji/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterSavedQueryRoutes sets up the saved queries endpoints.
+// The handlers check who can see or change a query, and running one re-checks
+// the caller's read permission on its database.
+func RegisterSavedQueryRoutes(rg *gin.RouterGroup) {
+	queryGroup := rg.Group("/saved-queries")
+	queryGroup.Use(middleware.AuthMiddleware())
+
+	queryGroup.GET("", handlers.ListSavedQueries)
+	queryGroup.POST("", handlers.CreateSavedQuery)
+	queryGroup.GET("/:queryId", handlers.GetSavedQuery)
+	queryGroup.PUT("/:queryId", handlers.UpdateSavedQuery)
+	queryGroup.DELETE("/:queryId", handlers.DeleteSavedQuery)
+	queryGroup.POST("/:queryId/run", handlers.RunSavedQuery)
+}
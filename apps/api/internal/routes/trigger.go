@@ -0,0 +1,24 @@
+package routes
+
+import (
+	"monji/internal/handlers"
+	"monji/internal/middleware"
+	"monji/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterTriggerRoutes sets up the endpoints to manage webhook triggers and their deliveries.
+// Only users with the manage_environments capability can manage triggers.
+func RegisterTriggerRoutes(rg *gin.RouterGroup) {
+	triggerGroup := rg.Group("/triggers")
+	triggerGroup.Use(middleware.AuthMiddleware(), middleware.RequireCapability(models.CapManageEnvironments))
+
+	triggerGroup.GET("", handlers.ListTriggers)
+	triggerGroup.POST("", handlers.CreateTrigger)
+	triggerGroup.GET("/:triggerId", handlers.GetTrigger)
+	triggerGroup.PUT("/:triggerId", handlers.UpdateTrigger)
+	triggerGroup.DELETE("/:triggerId", handlers.DeleteTrigger)
+	triggerGroup.GET("/:triggerId/deliveries", handlers.ListTriggerDeliveries)
+	triggerGroup.POST("/:triggerId/deliveries/:deliveryId/redeliver", handlers.RedeliverTriggerDelivery)
+}
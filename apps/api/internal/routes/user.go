@@ -3,19 +3,21 @@ package routes
 import (
 	"monji/internal/handlers"
 	"monji/internal/middleware"
+	"monji/internal/models"
 
 	"github.com/gin-gonic/gin"
 )
 
 // RegisterUserRoutes sets up the endpoints for user CRUD operations.
-// These endpoints are protected by Auth and Admin middleware (only admin & superadmin).
+// These endpoints require the manage_users capability.
 func RegisterUserRoutes(rg *gin.RouterGroup) {
 	userGroup := rg.Group("/users")
-	userGroup.Use(middleware.AuthMiddleware(), middleware.AdminMiddleware())
+	userGroup.Use(middleware.AuthMiddleware(), middleware.RequireCapability(models.CapManageUsers))
 
 	userGroup.POST("", handlers.CreateUser)
 	userGroup.PUT("/:id", handlers.UpdateUser)
 	userGroup.DELETE("/:id", handlers.DeleteUser)
 	userGroup.GET("", handlers.ListUsers)
 	userGroup.GET("/:id", handlers.GetUser)
+	userGroup.GET("/:id/permissions/explain", handlers.ExplainUserPermission)
 }
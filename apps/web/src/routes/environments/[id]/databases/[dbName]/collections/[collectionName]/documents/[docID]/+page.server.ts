@@ -33,6 +33,8 @@ export const load: PageServerLoad = async ({ params, cookies, fetch }) => {
     user: userData.user,
     environments: envData.environments || [],
     document: docData.document,
+    // Sent back in If-Match when saving, so that concurrent edits are not overwritten.
+    etag: docData.etag ?? docRes.headers.get('ETag') ?? '',
     database: docData.database,
     collection: docData.collection,
     currentEnvironmentId: id,
@@ -53,6 +55,10 @@ export const actions: Actions = {
     if (typeof jsonData !== 'string') {
       return fail(400, { error: 'Invalid document data' });
     }
+    const etag = formData.get('etag');
+    if (typeof etag !== 'string' || etag === '') {
+      return fail(400, { error: 'Missing document version, reload the page' });
+    }
 
     // Validate JSON format.
     try {
@@ -68,16 +74,21 @@ export const actions: Actions = {
         method: 'PUT',
         headers: {
           'Content-Type': 'application/json',
+          'If-Match': etag,
           Authorization: `Bearer ${token}`
         },
         // Pass the raw JSON string as expected by your API.
         body: jsonData
       }
     );
+    if (res.status === 412) {
+      return fail(412, { error: 'The document was changed by someone else, reload the page to edit it' });
+    }
     if (!res.ok) {
-      return fail(400, { error: 'Failed to update document' });
+      const body = await res.json().catch(() => ({}));
+      return fail(res.status, { error: body.error ?? 'Failed to update document' });
     }
     const result = await res.json();
-    return { success: true, result };
+    return { success: true, result, etag: result.etag };
   }
 };
\ No newline at end of file